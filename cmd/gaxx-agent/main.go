@@ -2,51 +2,123 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/3cpo-dev/gaxx/internal/agent"
 	"github.com/3cpo-dev/gaxx/internal/telemetry"
+	"github.com/rs/zerolog"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
+	// Commands run under resource limits are started through this binary.
+	agent.RunLimitShim()
+
+	// --version lets installers check the binary runs on this machine
+	// before enabling the service.
+	showVersion := flag.Bool("version", false, "Print the version and exit")
+	addr := flag.String("addr", envOr("GAXX_AGENT_ADDR", ":8088"), "Agent API listen address (env GAXX_AGENT_ADDR)")
+	monitorAddr := flag.String("monitor-addr", envOr("GAXX_AGENT_MONITOR_ADDR", ":9091"), `Monitoring listen address, or "off" (env GAXX_AGENT_MONITOR_ADDR)`)
+	accessLog := flag.String("access-log", envOr("GAXX_AGENT_ACCESS_LOG", "stderr"), `Access log destination: stderr, stdout, off or a file path (env GAXX_AGENT_ACCESS_LOG)`)
+	shareDir := flag.String("share-dir", envOr("GAXX_AGENT_SHARE_DIR", agent.DefaultShareDir), `Directory served to peer nodes on /v0/files, or "off" (env GAXX_AGENT_SHARE_DIR)`)
+	spoolDir := flag.String("spool-dir", envOr("GAXX_AGENT_SPOOL_DIR", ""), "Directory for spooled exec output served on /v0/output; defaults to one under the temp dir (env GAXX_AGENT_SPOOL_DIR)")
+	pprofAddr := flag.String("pprof-addr", envOr("GAXX_AGENT_PPROF_ADDR", "off"), `Profiling listen address, e.g. ":6060"; off by default (env GAXX_AGENT_PPROF_ADDR)`)
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(version)
+		return
+	}
+
+	// GAXX_LOG_LEVEL sets the log level (debug, info, warn, error, fatal).
+	if v := os.Getenv("GAXX_LOG_LEVEL"); v != "" {
+		level, err := zerolog.ParseLevel(v)
+		if err != nil || level == zerolog.NoLevel {
+			fmt.Fprintf(os.Stderr, "invalid GAXX_LOG_LEVEL %q\n", v)
+			os.Exit(2)
+		}
+		zerolog.SetGlobalLevel(level)
+	}
+
+	agent.EnsurePath()
+
 	// Initialize telemetry for agent
 	telemetry.InitGlobal(true, "")
 	defer telemetry.Shutdown()
 
 	// Start performance monitoring
 	collector := telemetry.GetGlobal()
+	// GAXX_MAX_LABEL_VALUES overrides the metric label cardinality cap.
+	if v := os.Getenv("GAXX_MAX_LABEL_VALUES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid GAXX_MAX_LABEL_VALUES %q: %v\n", v, err)
+			os.Exit(2)
+		}
+		collector.SetMaxLabelValues(n)
+	}
 	perfMon := telemetry.NewPerformanceMonitor(collector, true)
 	defer perfMon.Shutdown()
 
-	// Start profiling server in background
-	profiler := telemetry.NewPerformanceProfiler(true, ":6060")
-	defer profiler.Shutdown()
-	go func() {
-		if err := profiler.Start(); err != nil && err.Error() != "http: Server closed" {
-			fmt.Fprintf(os.Stderr, "Profiler server failed: %v\n", err)
+	// Profiling is opt-in: it exposes heap and goroutine data and lets
+	// callers run CPU profiles, so it sits behind the same token as exec
+	// and is never served without one.
+	if !disabled(*pprofAddr) {
+		if os.Getenv("GAXX_AGENT_TOKEN") == "" {
+			fmt.Fprintln(os.Stderr, "--pprof-addr requires GAXX_AGENT_TOKEN; refusing to serve profiling unauthenticated")
+			os.Exit(2)
 		}
-	}()
+		profiler := telemetry.NewPerformanceProfiler(true, *pprofAddr)
+		profiler.Protect(agent.RequireToken)
+		defer profiler.Shutdown()
+		go func() {
+			if err := profiler.Start(); err != nil && err.Error() != "http: Server closed" {
+				fmt.Fprintf(os.Stderr, "Profiler server failed: %v\n", err)
+			}
+		}()
+	}
 
 	// Start monitoring server on a different port
-	go startAgentMonitoring(":9091", collector, perfMon)
+	if !disabled(*monitorAddr) {
+		go startAgentMonitoring(*monitorAddr, collector, perfMon)
+	}
 
-	addr := ":8088"
-	srv := &agent.Server{Version: "dev"}
+	accessLogW, closeAccessLog, err := agent.OpenAccessLog(*accessLog)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer closeAccessLog()
+	srv := &agent.Server{
+		Version:   version,
+		MaxOutput: agent.MaxOutputFromEnv(),
+		AccessLog: accessLogW,
+		Policy:    agent.CommandPolicyFromEnv(),
+		Limits:    agent.LimitsFromEnv(),
+		SpoolDir:  *spoolDir,
+		MaxSpool:  agent.MaxSpoolFromEnv(),
+	}
+	if !disabled(*shareDir) {
+		srv.ShareDir = *shareDir
+	}
 
 	// Record agent startup
 	telemetry.CounterGlobal("gaxx_agent_starts", 1, map[string]string{
 		"component": "agent",
-		"version":   "dev",
+		"version":   version,
 	})
 
 	go func() {
-		if err := srv.ListenAndServe(addr); err != nil {
+		if err := srv.ListenAndServe(*addr); err != nil {
 			telemetry.CounterGlobal("gaxx_agent_errors", 1, map[string]string{
-				"error":     err.Error(),
+				"error":     telemetry.ErrorClass(err),
 				"component": "agent",
 			})
 			fmt.Fprintln(os.Stderr, err)
@@ -54,9 +126,13 @@ func main() {
 		}
 	}()
 
-	fmt.Fprintf(os.Stdout, "gaxx-agent listening on %s\n", addr)
-	fmt.Fprintf(os.Stdout, "gaxx-agent monitoring on :9091\n")
-	fmt.Fprintf(os.Stdout, "gaxx-agent profiling on :6060\n")
+	fmt.Fprintf(os.Stdout, "gaxx-agent listening on %s\n", *addr)
+	if !disabled(*monitorAddr) {
+		fmt.Fprintf(os.Stdout, "gaxx-agent monitoring on %s\n", *monitorAddr)
+	}
+	if !disabled(*pprofAddr) {
+		fmt.Fprintf(os.Stdout, "gaxx-agent profiling on %s\n", *pprofAddr)
+	}
 
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
@@ -95,3 +171,16 @@ func startAgentMonitoring(addr string, collector *telemetry.Collector, perfMon *
 		fmt.Fprintf(os.Stderr, "Agent monitoring server failed: %v\n", err)
 	}
 }
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// disabled reports whether a listen address turns its server off.
+func disabled(addr string) bool {
+	return addr == "" || addr == "off"
+}
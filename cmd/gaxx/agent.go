@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// agentInstallScript checks that the binary staged as gaxx-agent in the
+// directory given as $0 runs, then installs it and the unit read from stdin,
+// using sudo unless already root, and removes the directory.
+const agentInstallScript = `set -e
+STAGE="$0"
+trap 'rm -rf "$STAGE"' EXIT
+SUDO=sudo
+[ "$(id -u)" -eq 0 ] && SUDO=
+chmod 0755 "$STAGE/gaxx-agent"
+"$STAGE/gaxx-agent" --version >/dev/null
+$SUDO install -m 0755 "$STAGE/gaxx-agent" /usr/local/bin/gaxx-agent
+$SUDO tee /etc/systemd/system/gaxx-agent.service >/dev/null
+$SUDO systemctl daemon-reload
+$SUDO systemctl enable gaxx-agent
+$SUDO systemctl restart gaxx-agent`
+
+func newAgentCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Manage gaxx-agent on fleet nodes",
+	}
+	cmd.AddCommand(newAgentInstallCmd())
+	return cmd
+}
+
+func newAgentInstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install --name <fleet>",
+		Short: "Install gaxx-agent on fleet nodes over SSH",
+		Long: `Upload gaxx-agent to every node over SFTP, install it as a systemd service
+and start it. This is what cloud-init does for cloud fleets; use it for
+localssh hosts or to upgrade the agent.
+
+The binary is chosen per node from the OS and architecture reported by
+uname. --binary may contain {{.OS}} and {{.Arch}} placeholders; by default
+gaxx-agent-{os}-{arch} next to the gaxx executable is used, or gaxx-agent
+itself when the node matches this machine's platform.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			binary, _ := cmd.Flags().GetString("binary")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodeName != "" {
+				n, err := findNode(nodes, nodeName)
+				if err != nil {
+					return err
+				}
+				nodes = []providers.Node{n}
+			}
+
+			cfg := client.Config()
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			failed := 0
+			for _, n := range nodes {
+				wg.Add(1)
+				go func(n providers.Node) {
+					defer wg.Done()
+					platform, err := installAgent(ctx, cfg, n, binary)
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						failed++
+						fmt.Printf("[%s] ❌ %v\n", n.Name, err)
+						return
+					}
+					fmt.Printf("[%s] ✅ gaxx-agent installed (%s)\n", n.Name, platform)
+				}(n)
+			}
+			wg.Wait()
+
+			if failed > 0 {
+				return fmt.Errorf("agent install failed on %d of %d nodes", failed, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("node", "", "Only install on this node")
+	cmd.Flags().String("binary", "", "Local agent binary; {{.OS}} and {{.Arch}} are replaced per node")
+
+	return cmd
+}
+
+// installAgent uploads and enables gaxx-agent on one node, returning the
+// node's platform as os/arch.
+func installAgent(ctx context.Context, cfg providers.Config, node providers.Node, binary string) (string, error) {
+	out, err := core.ExecViaSSH(ctx, cfg, node, agent.ExecRequest{Command: "uname", Args: []string{"-sm"}, Timeout: 30})
+	if err != nil {
+		return "", fmt.Errorf("detect platform: %w", err)
+	}
+	goos, goarch, err := parseUname(out.Stdout)
+	if err != nil {
+		return "", err
+	}
+	platform := goos + "/" + goarch
+	local, err := agentBinaryFor(binary, goos, goarch)
+	if err != nil {
+		return platform, err
+	}
+
+	// Stage the binary in a fresh directory only the SSH user can write to:
+	// a fixed path under /tmp could be replaced by another local user
+	// between the upload and the root install.
+	out, err = core.ExecViaSSH(ctx, cfg, node, agent.ExecRequest{Command: "mktemp", Args: []string{"-d"}, Timeout: 30})
+	if err != nil {
+		return platform, fmt.Errorf("create staging directory: %w", err)
+	}
+	stage := strings.TrimSpace(out.Stdout)
+	if out.ExitCode != 0 || !strings.HasPrefix(stage, "/") {
+		return platform, fmt.Errorf("create staging directory: mktemp exited with code %d: %s", out.ExitCode, strings.TrimSpace(out.Stderr))
+	}
+	if err := api.NewFileTransfer(cfg).Upload(ctx, node, local, stage+"/gaxx-agent"); err != nil {
+		_, _ = core.ExecViaSSH(ctx, cfg, node, agent.ExecRequest{Command: "rm", Args: []string{"-rf", stage}, Timeout: 30})
+		return platform, err
+	}
+	resp, err := core.ExecViaSSH(ctx, cfg, node, agent.ExecRequest{
+		Command: "sh",
+		Args:    []string{"-c", agentInstallScript, stage},
+		Input:   providers.AgentSystemdUnit(node.SSHUser),
+		Timeout: 120,
+	})
+	if err != nil {
+		return platform, fmt.Errorf("install: %w", err)
+	}
+	if resp.ExitCode != 0 {
+		return platform, fmt.Errorf("install exited with code %d: %s", resp.ExitCode, strings.TrimSpace(resp.Stderr))
+	}
+	return platform, nil
+}
+
+// parseUname maps `uname -sm` output to Go's GOOS/GOARCH names.
+func parseUname(out string) (goos, goarch string, err error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected uname output %q", strings.TrimSpace(out))
+	}
+	goos = strings.ToLower(fields[0])
+	switch fields[1] {
+	case "x86_64", "amd64":
+		goarch = "amd64"
+	case "aarch64", "arm64":
+		goarch = "arm64"
+	case "armv7l", "armv6l":
+		goarch = "arm"
+	case "i386", "i686":
+		goarch = "386"
+	default:
+		return "", "", fmt.Errorf("unsupported architecture %q", fields[1])
+	}
+	return goos, goarch, nil
+}
+
+// agentBinaryFor resolves the local agent binary for a platform.
+func agentBinaryFor(binary, goos, goarch string) (string, error) {
+	if binary != "" {
+		path := strings.NewReplacer("{{.OS}}", goos, "{{.Arch}}", goarch).Replace(binary)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("agent binary: %w", err)
+		}
+		return path, nil
+	}
+
+	dir := "."
+	if exe, err := os.Executable(); err == nil {
+		dir = filepath.Dir(exe)
+	}
+	candidates := []string{filepath.Join(dir, fmt.Sprintf("gaxx-agent-%s-%s", goos, goarch))}
+	if goos == runtime.GOOS && goarch == runtime.GOARCH {
+		candidates = append(candidates, filepath.Join(dir, "gaxx-agent"))
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("no gaxx-agent binary for %s/%s in %s (build one with `make agents` or pass --binary)", goos, goarch, dir)
+}
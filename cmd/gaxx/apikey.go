@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/internal/controller"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/spf13/cobra"
+)
+
+func newAPIKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apikey",
+		Short: "Manage API keys for gaxx serve",
+		Long: `API keys authenticate clients of gaxx serve. Each has a scope: read lists
+fleets, agent status and runs; execute also starts and cancels runs; admin
+also spawns and deletes fleets. Only a hash of each key is stored, so a key
+is shown once, when it is created.`,
+	}
+	cmd.AddCommand(newAPIKeyCreateCmd(), newAPIKeyListCmd(), newAPIKeyRevokeCmd())
+	return cmd
+}
+
+// openStore opens the store named by the config.
+func openStore(cmd *cobra.Command) (*core.Store, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return core.OpenStore(cfg.Store.Path)
+}
+
+func newAPIKeyCreateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create <name> [--scope read|execute|admin]",
+		Short: "Create an API key and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scopeFlag, _ := cmd.Flags().GetString("scope")
+			scope, err := controller.ParseScope(scopeFlag)
+			if err != nil {
+				return err
+			}
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			token, key, err := store.CreateAPIKey(context.Background(), args[0], string(scope))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "🔑 Created %s key %q; it won't be shown again:\n", key.Scope, key.Name)
+			fmt.Println(token)
+			return nil
+		},
+	}
+	cmd.Flags().String("scope", string(controller.ScopeRead), "What the key may do: read, execute or admin")
+	return cmd
+}
+
+func newAPIKeyListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List API keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			keys, err := store.APIKeys(context.Background())
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				fmt.Println("No API keys; create one with gaxx apikey create <name>")
+				return nil
+			}
+			fmt.Printf("%-20s %-8s %-17s %s\n", "NAME", "SCOPE", "CREATED", "LAST USED")
+			fmt.Println(strings.Repeat("-", 64))
+			for _, k := range keys {
+				used := "never"
+				if !k.LastUsedAt.IsZero() {
+					used = k.LastUsedAt.Local().Format("2006-01-02 15:04")
+				}
+				fmt.Printf("%-20s %-8s %-17s %s\n", k.Name, k.Scope, k.CreatedAt.Local().Format("2006-01-02 15:04"), used)
+			}
+			return nil
+		},
+	}
+}
+
+func newAPIKeyRevokeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <name>",
+		Short: "Revoke an API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore(cmd)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			if err := store.RevokeAPIKey(context.Background(), args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("🗑  Revoked API key %q\n", args[0])
+			return nil
+		},
+	}
+}
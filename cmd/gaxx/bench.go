@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/spf13/cobra"
+)
+
+func newBenchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench --name <fleet>",
+		Short: "Benchmark fleet nodes and rank them",
+		Long: `Run the same CPU (hashing) and IO (synced write) micro-benchmark on every
+node through its agent, record the scores in the local store and print the
+nodes fastest first. The score is the geometric mean of the two
+throughputs; WEIGHT is the score relative to the fastest node. With
+--recorded, print the last recorded ranking without benchmarking.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			recorded, _ := cmd.Flags().GetBool("recorded")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+			defer cancel()
+
+			store, err := core.OpenStore(client.Config().Store.Path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			if recorded {
+				benches, err := store.Benchmarks(ctx, name)
+				if err != nil {
+					return err
+				}
+				if len(benches) == 0 {
+					return fmt.Errorf("no benchmarks recorded for fleet %s (run gaxx bench --name %s)", name, name)
+				}
+				printBenchmarks(benches)
+				return nil
+			}
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodes, err = selectNodes(cmd, nodes); err != nil {
+				return err
+			}
+			fmt.Printf("⏱️  Benchmarking %d nodes...\n", len(nodes))
+			var measured []core.NodeBenchmark
+			var failed []string
+			now := time.Now()
+			for _, r := range client.Bench(ctx, nodes) {
+				if r.Err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", r.Node.Name, r.Err))
+					continue
+				}
+				measured = append(measured, core.NodeBenchmark{Node: r.Node.Name, IP: r.Node.DialIP(), CPU: r.CPU, IO: r.IO, Score: r.Score, MeasuredAt: now})
+			}
+			if len(measured) > 0 {
+				if err := store.RecordBenchmarks(ctx, name, measured); err != nil {
+					return err
+				}
+				benches, err := store.Benchmarks(ctx, name)
+				if err != nil {
+					return err
+				}
+				printBenchmarks(benches)
+			}
+			if len(failed) > 0 {
+				for _, f := range failed {
+					fmt.Fprintf(os.Stderr, "❌ %s\n", f)
+				}
+				return fmt.Errorf("%d of %d nodes failed the benchmark", len(failed), len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("select", "", "Only benchmark nodes matching an expression, e.g. 'tag.role==scanner'")
+	cmd.Flags().Duration("timeout", 5*time.Minute, "Timeout for each benchmark step")
+	cmd.Flags().Bool("recorded", false, "Print the last recorded ranking instead of benchmarking")
+
+	return cmd
+}
+
+// printBenchmarks prints benches, which are sorted fastest first, with each
+// node's weight relative to the fastest.
+func printBenchmarks(benches []core.NodeBenchmark) {
+	fmt.Printf("%-4s %-20s %-16s %10s %10s %10s %7s  %s\n", "RANK", "NODE", "IP", "CPU MB/s", "IO MB/s", "SCORE", "WEIGHT", "MEASURED")
+	fmt.Println(strings.Repeat("-", 100))
+	best := benches[0].Score
+	for i, b := range benches {
+		fmt.Printf("%-4d %-20s %-16s %10.1f %10.1f %10.1f %7.2f  %s\n", i+1, b.Node, b.IP, b.CPU, b.IO, b.Score, b.Score/best, b.MeasuredAt.Local().Format(time.DateTime))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/doctor"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+func newDoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor [--name <fleet>]",
+		Short: "Diagnose setup problems and agent installations",
+		Long: `Check the setup as gaxx selftest does, printing a checklist with a fix for
+each failure: the config parses, the SSH key loads, known_hosts is writable,
+the local store opens, the provider accepts the token and, with --name, the
+fleet's agents answer and their clocks agree with this machine's.
+
+With --name, then SSH into every node and check how its agent is installed:
+whether /usr/local/bin/gaxx-agent exists and runs, whether the gaxx-agent
+systemd service is active, and whether anything listens on the agent port
+(ss -ltn). The agent is then heartbeated from here, which tells a firewalled
+port from a stopped agent. Prints a table, then for each node with a problem
+what is wrong and the last lines of its journal. Exits non-zero if any check
+or node has a problem.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			port, _ := cmd.Flags().GetInt("agent-port")
+			lines, _ := cmd.Flags().GetInt("lines")
+
+			checks := runSelfChecks(cmd, name)
+			failedChecks := printSelfChecks(checks)
+			if name == "" {
+				if failedChecks > 0 {
+					return fmt.Errorf("%d of %d checks failed", failedChecks, len(checks))
+				}
+				return nil
+			}
+			fmt.Println()
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			if port == 0 {
+				port = client.Config().Defaults.AgentPort
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodeName != "" {
+				node, err := findNode(nodes, nodeName)
+				if err != nil {
+					return err
+				}
+				nodes = []providers.Node{node}
+			}
+
+			script := doctor.Script(port, lines)
+			reports := make([]doctor.Report, len(nodes))
+			var wg sync.WaitGroup
+			for i, n := range nodes {
+				wg.Add(1)
+				go func(i int, n providers.Node) {
+					defer wg.Done()
+					var out bytes.Buffer
+					if err := streamRemote(ctx, client, n, script, &out, &out); err != nil {
+						reports[i].SSHErr = err
+						return
+					}
+					reports[i] = doctor.ParseOutput(out.String())
+					_, reports[i].HeartbeatErr = client.AgentConn().Heartbeat(ctx, n)
+				}(i, n)
+			}
+			wg.Wait()
+
+			color := useColor(os.Stdout)
+			yes := func(ok bool, s string) string {
+				if ok {
+					return paint(color, s, colorGreen)
+				}
+				return paint(color, s, colorRed)
+			}
+			fmt.Printf("%-20s %-15s %-12s %-10s %-10s %s\n", "NAME", "IP", "BINARY", "SERVICE", "PORT", "REACHABLE")
+			fmt.Println(strings.Repeat("-", 80))
+			failed := 0
+			for i, n := range nodes {
+				r := reports[i]
+				if r.SSHErr != nil {
+					failed++
+					fmt.Printf("%-20s %-15s %s\n", n.Name, n.DialIP(), yes(false, "ssh failed"))
+					continue
+				}
+				binary := "missing"
+				switch {
+				case r.Version != "":
+					binary = r.Version
+				case r.Installed:
+					binary = "broken"
+				}
+				listening := "closed"
+				if r.Listening {
+					listening = "listening"
+				}
+				reachable := "no"
+				if r.HeartbeatErr == nil {
+					reachable = "yes"
+				}
+				if len(r.Problems(port)) > 0 {
+					failed++
+				}
+				// Pad before colouring so escape codes don't skew the columns.
+				fmt.Printf("%-20s %-15s %s %s %s %s\n", n.Name, n.DialIP(),
+					pad(yes(r.Version != "", binary), 12, color), pad(yes(r.Service == "active", r.Service), 10, color),
+					pad(yes(r.Listening, listening), 10, color), yes(r.HeartbeatErr == nil, reachable))
+			}
+
+			for i, n := range nodes {
+				problems := reports[i].Problems(port)
+				if len(problems) == 0 {
+					continue
+				}
+				fmt.Printf("\n[%s]\n", n.Name)
+				for _, p := range problems {
+					fmt.Printf("  ❌ %s\n", p)
+				}
+				if journal := strings.TrimSpace(reports[i].Journal); journal != "" {
+					fmt.Println("  journal:")
+					for _, line := range strings.Split(journal, "\n") {
+						fmt.Printf("    %s\n", line)
+					}
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d nodes have agent problems", failed, len(nodes))
+			}
+			if failedChecks > 0 {
+				return fmt.Errorf("%d of %d checks failed", failedChecks, len(checks))
+			}
+			fmt.Printf("\n✅ agents look healthy on all %d nodes\n", len(nodes))
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet whose agents to check")
+	cmd.Flags().String("node", "", "Only check this node")
+	cmd.Flags().Int("agent-port", 0, "Port the agent listens on (defaults to defaults.agent_port)")
+	cmd.Flags().IntP("lines", "n", 20, "Journal lines to show for nodes with problems")
+
+	return cmd
+}
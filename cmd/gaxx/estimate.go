@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+func newEstimateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "estimate [--provider <p>] [--size <size>] --count <n> --hours <h>",
+		Short: "Estimate what a fleet will cost",
+		Long: `Multiply a size's hourly price by the node count and hours. Prices come
+from the provider's API when refreshed with --refresh (and are then cached in
+the local store), otherwise from a built-in table of common sizes. The size
+defaults to the one spawn would use.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			size, _ := cmd.Flags().GetString("size")
+			count, _ := cmd.Flags().GetInt("count")
+			hours, _ := cmd.Flags().GetFloat64("hours")
+			refresh, _ := cmd.Flags().GetBool("refresh")
+			if count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
+			if hours <= 0 {
+				return fmt.Errorf("--hours must be positive")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			provider := client.ProviderName()
+			if size == "" {
+				size = client.Config().DefaultSize(provider)
+			}
+			if size == "" {
+				return fmt.Errorf("--size is required (no default size for %s)", provider)
+			}
+			store, err := core.OpenStore(client.Config().Store.Path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if refresh {
+				prices, err := client.Prices(ctx)
+				if err != nil {
+					return err
+				}
+				if err := store.SavePrices(ctx, provider, prices, time.Now()); err != nil {
+					return err
+				}
+				fmt.Printf("🔄 Refreshed %d %s prices\n", len(prices), provider)
+			}
+
+			hourly, source, err := lookupPrice(ctx, store, provider, size)
+			if err != nil {
+				return err
+			}
+			total := hourly * float64(count) * hours
+			fmt.Printf("💰 %d × %s on %s for %gh\n", count, size, provider, hours)
+			fmt.Printf("   $%.4f per node-hour (%s)\n", hourly, source)
+			fmt.Printf("   Total: $%.2f\n", total)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("size", "", "Instance size/plan (defaults to the provider's configured size)")
+	cmd.Flags().Int("count", 1, "Number of nodes")
+	cmd.Flags().Float64("hours", 1, "Hours the fleet will run")
+	cmd.Flags().Bool("refresh", false, "Fetch current prices from the provider API first")
+
+	return cmd
+}
+
+// lookupPrice returns the hourly price of size, preferring prices cached by
+// --refresh over the built-in table, and describes where it came from.
+func lookupPrice(ctx context.Context, store *core.Store, provider, size string) (float64, string, error) {
+	hourly, fetched, err := store.Price(ctx, provider, size)
+	if err == nil {
+		return hourly, fmt.Sprintf("%s API, fetched %s", provider, fetched.Local().Format("2006-01-02")), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", err
+	}
+	hourly, err = providers.BuiltinPrice(provider, size)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w; run gaxx estimate --refresh to fetch %s prices", err, provider)
+	}
+	return hourly, "built-in table; --refresh for current prices", nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+// parseKeyValues turns ["k=v", ...] into a map.
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	out := make(map[string]string, len(pairs))
+	for _, kv := range pairs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid key=value pair: %q", kv)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// interruptible returns a context that also ends at the first SIGINT or
+// SIGTERM. Ending a run's context stops its executions, killing their remote
+// processes, and reportRun still reports what finished. Default handling is
+// restored once the context ends, so a second signal exits at once.
+func interruptible(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// reportRun performs a run through the client, streaming events to reporter,
+// writing the results to sink and finishing with its summary. ctx is the
+// run's context; when a signal interrupted it (see interruptible), the
+// partial results are still written, recorded and summarized, and an error
+// is returned. So it is, for a non-zero exit, when any execution failed or
+// exited non-zero, unless ignoreFailures is set.
+func reportRun(ctx context.Context, client *api.Client, reporter runReporter, rec *runRecorder, sink *resultSink, ignoreFailures bool, run func() ([]api.NodeRunResult, error)) error {
+	start := time.Now()
+	client.Observer = reporter
+	results, err := run()
+	if p, ok := reporter.(*progressReporter); ok {
+		p.bar.Finish()
+	}
+	interrupted := errors.Is(ctx.Err(), context.Canceled)
+	if interrupted {
+		reporter.Notice("🛑 Interrupted: stopped the executions still running")
+	}
+	if len(results) > 0 {
+		if sinkErr := sink.Write(results); sinkErr != nil {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", sinkErr)
+			} else {
+				err = sinkErr
+			}
+		}
+	}
+	// The run's context may have expired; recording gets its own.
+	if recErr := rec.finish(context.Background(), results, err); recErr != nil {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", recErr)
+		} else {
+			err = recErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	summary := runSummary{Duration: time.Since(start), Interrupted: interrupted}
+	nodes := map[string]bool{}
+	for _, r := range results {
+		nodes[r.Node.Name] = true
+		if r.OK() {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	summary.Nodes = len(nodes)
+	reporter.Summary(summary)
+	if interrupted {
+		return fmt.Errorf("interrupted")
+	}
+	if client.FailFast && summary.Failed > 0 {
+		return fmt.Errorf("stopped by --fail-fast after a failure")
+	}
+	if summary.Failed > 0 && !ignoreFailures {
+		return fmt.Errorf("%d of %d executions failed", summary.Failed, summary.Succeeded+summary.Failed)
+	}
+	return nil
+}
+
+// executeSimpleCommand runs a command through the agent, reached with conn,
+// and returns its stdout.
+func executeSimpleCommand(ctx context.Context, conn *api.AgentConn, node providers.Node, command string, args ...string) (string, error) {
+	resp, err := conn.Exec(ctx, node, agent.ExecRequest{Command: command, Args: args, Timeout: 30})
+	if err != nil {
+		return "", err
+	}
+	if resp.ExitCode != 0 {
+		return resp.Stdout, fmt.Errorf("%s exited with code %d", command, resp.ExitCode)
+	}
+	return resp.Stdout, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// maxRecordOutput caps the stdout and stderr kept in each --format record.
+const maxRecordOutput = 1024
+
+// resultRecord is one execution, a node running one chunk, in --format
+// output.
+type resultRecord struct {
+	Node       string `json:"node"`
+	IP         string `json:"ip"`
+	Chunk      int    `json:"chunk"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Error      string `json:"error,omitempty"`
+}
+
+// resultFormats are the --format writers; add an entry to support another.
+var resultFormats = map[string]func(io.Writer, []resultRecord) error{
+	"csv":   writeCSVRecords,
+	"jsonl": writeJSONLRecords,
+}
+
+// resultSink writes a run's results in the format chosen with --format.
+type resultSink struct {
+	write func(io.Writer, []resultRecord) error
+	path  string
+}
+
+// newResultSink returns the sink for --format and --results-file, or nil
+// when --format is unset.
+func newResultSink(cmd *cobra.Command) (*resultSink, error) {
+	format, _ := cmd.Flags().GetString("format")
+	path, _ := cmd.Flags().GetString("results-file")
+	if format == "" {
+		if path != "" {
+			return nil, fmt.Errorf("--results-file needs --format")
+		}
+		return nil, nil
+	}
+	write, ok := resultFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown results format %q (want %s)", format, strings.Join(sortedFormats(), " or "))
+	}
+	return &resultSink{write: write, path: path}, nil
+}
+
+// toStdout reports whether results go to stdout, leaving progress for
+// stderr.
+func (s *resultSink) toStdout() bool { return s != nil && s.path == "" }
+
+// Write writes results to the results file, or stdout.
+func (s *resultSink) Write(results []api.NodeRunResult) error {
+	if s == nil {
+		return nil
+	}
+	records := make([]resultRecord, len(results))
+	for i, r := range results {
+		records[i] = resultRecord{
+			Node:       r.Node.Name,
+			IP:         r.Node.DialIP(),
+			Chunk:      r.Chunk,
+			ExitCode:   r.ExitCode,
+			DurationMS: r.Duration.Milliseconds(),
+			Stdout:     trimOutput(r.Stdout),
+			Stderr:     trimOutput(redact.String(r.Stderr)),
+		}
+		if r.Err != nil {
+			records[i].Error = redact.String(r.Err.Error())
+		}
+	}
+	if s.path == "" {
+		return s.write(os.Stdout, records)
+	}
+	f, err := os.Create(s.path)
+	if err != nil {
+		return fmt.Errorf("write results: %w", err)
+	}
+	if err := s.write(f, records); err != nil {
+		f.Close()
+		return fmt.Errorf("write results: %w", err)
+	}
+	return f.Close()
+}
+
+func writeCSVRecords(w io.Writer, records []resultRecord) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"node", "ip", "chunk", "exit_code", "duration_ms", "stdout", "stderr", "error"})
+	for _, r := range records {
+		_ = cw.Write([]string{r.Node, r.IP, strconv.Itoa(r.Chunk), strconv.Itoa(r.ExitCode),
+			strconv.FormatInt(r.DurationMS, 10), r.Stdout, r.Stderr, r.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONLRecords(w io.Writer, records []resultRecord) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimOutput strips surrounding whitespace and cuts s to maxRecordOutput
+// bytes without splitting a character.
+func trimOutput(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= maxRecordOutput {
+		return s
+	}
+	cut := maxRecordOutput
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+func sortedFormats() []string {
+	names := make([]string, 0, len(resultFormats))
+	for name := range resultFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+	"github.com/spf13/cobra"
+)
+
+// healthReport is the body of the agent monitoring server's /health.
+type healthReport struct {
+	Status        telemetry.HealthStatus  `json:"status"`
+	UptimeSeconds int64                   `json:"uptime_seconds"`
+	Checks        []telemetry.HealthCheck `json:"checks"`
+}
+
+// nodeHealth is what gaxx health learned about one node.
+type nodeHealth struct {
+	hb        agent.HeartbeatResponse
+	hbErr     error
+	report    healthReport
+	healthErr error
+}
+
+// healthy reports whether the agent answered and no check is unhealthy.
+func (h nodeHealth) healthy() bool {
+	return h.hbErr == nil && h.healthErr == nil && h.report.Status != telemetry.HealthStatusUnhealthy
+}
+
+func newHealthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "health --name <fleet>",
+		Short: "Check agent health across a fleet",
+		Long: `Heartbeat every node's agent and fetch its monitoring /health, then print
+up/down, version, uptime, clock skew, circuit breaker and health-check status
+per node, warning about clocks more than 30s off this machine's. Exits non-zero if any node is down, its monitoring endpoint is unreachable,
+or a check is unhealthy, so it can gate CI and cron jobs.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			monitorPort, _ := cmd.Flags().GetInt("monitor-port")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+
+			results := make([]nodeHealth, len(nodes))
+			var wg sync.WaitGroup
+			for i, n := range nodes {
+				wg.Add(1)
+				go func(i int, n providers.Node) {
+					defer wg.Done()
+					var h nodeHealth
+					h.hb, h.hbErr = client.AgentConn().Heartbeat(ctx, n)
+					h.report, h.healthErr = fetchHealth(ctx, n, monitorPort)
+					results[i] = h
+				}(i, n)
+			}
+			wg.Wait()
+
+			breakers := nodeBreakers(client)
+			color := useColor(os.Stdout)
+			fmt.Printf("%-20s %-15s %-6s %-10s %-10s %-8s %-10s %-12s %s\n", "NAME", "IP", "AGENT", "VERSION", "UPTIME", "CLOCK", "HEALTH", "BREAKER", "CHECKS")
+			fmt.Println(strings.Repeat("-", 112))
+			var skewed []string
+			unhealthy := 0
+			for i, n := range nodes {
+				h := results[i]
+				if !h.healthy() {
+					unhealthy++
+				}
+				state, version := paint(color, "up", colorGreen), h.hb.Version
+				clock := paint(color, formatSkew(h.hb.ClockSkew), colorGreen)
+				if h.hb.ClockSkew.Abs() > core.MaxClockSkew {
+					clock = paint(color, formatSkew(h.hb.ClockSkew), colorRed)
+					skewed = append(skewed, n.Name)
+				}
+				if h.hbErr != nil {
+					state, version, clock = paint(color, "down", colorRed), "-", "-"
+				}
+				uptime, status, checks := "-", paint(color, "unknown", colorRed), "-"
+				if h.healthErr == nil {
+					uptime = (time.Duration(h.report.UptimeSeconds) * time.Second).String()
+					status = paintStatus(color, h.report.Status)
+					checks = formatChecks(color, h.report.Checks)
+				} else if h.hbErr == nil {
+					checks = h.healthErr.Error()
+				}
+				breaker := paint(color, breakerLabel(breakers[n.Name]), colorGreen)
+				switch breakers[n.Name].State {
+				case core.BreakerOpen:
+					breaker = paint(color, breakerLabel(breakers[n.Name]), colorRed)
+				case core.BreakerHalfOpen:
+					breaker = paint(color, breakerLabel(breakers[n.Name]), colorYellow)
+				}
+				// Pad before colouring so escape codes don't skew the columns.
+				fmt.Printf("%-20s %-15s %s %-10s %-10s %s %s %s %s\n", n.Name, n.DialIP(),
+					pad(state, 6, color), version, uptime, pad(clock, 8, color), pad(status, 10, color), pad(breaker, 12, color), checks)
+			}
+			if len(skewed) > 0 {
+				fmt.Printf("\n⚠️  clocks more than %v off this machine's on %s; sync them with NTP, since skew breaks TLS and timeouts\n", core.MaxClockSkew, strings.Join(skewed, ", "))
+			}
+
+			if unhealthy > 0 {
+				return fmt.Errorf("%d of %d nodes unhealthy", unhealthy, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().Int("monitor-port", 9091, "Port of the agent's monitoring server")
+
+	return cmd
+}
+
+// healthClient is shared by fetchHealth so that polling reuses connections.
+var healthClient = &http.Client{Timeout: 5 * time.Second}
+
+// fetchHealth reads a node agent's monitoring /health. The endpoint answers
+// 503 when unhealthy, so the body is decoded whatever the status.
+func fetchHealth(ctx context.Context, node providers.Node, port int) (healthReport, error) {
+	var report healthReport
+	url := "http://" + providers.HostPort(node.DialIP(), port) + "/health"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return report, err
+	}
+	resp, err := healthClient.Do(req)
+	if err != nil {
+		return report, fmt.Errorf("monitoring unreachable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return report, fmt.Errorf("health returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return report, fmt.Errorf("decode health: %w", err)
+	}
+	return report, nil
+}
+
+// formatChecks renders checks as "name=status" pairs sorted by name.
+func formatChecks(color bool, checks []telemetry.HealthCheck) string {
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	parts := make([]string, len(checks))
+	for i, c := range checks {
+		parts[i] = c.Name + "=" + paintStatus(color, c.Status)
+	}
+	return strings.Join(parts, " ")
+}
+
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// formatSkew shows how far a clock is ahead (+) or behind (-).
+func formatSkew(d time.Duration) string {
+	if d < 0 {
+		return "-" + (-d).Round(time.Second).String()
+	}
+	return "+" + d.Round(time.Second).String()
+}
+
+// useColor reports whether f is a terminal and neither --no-color nor
+// NO_COLOR is set.
+func useColor(f *os.File) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func paint(color bool, s, code string) string {
+	if !color {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func paintStatus(color bool, s telemetry.HealthStatus) string {
+	switch s {
+	case telemetry.HealthStatusHealthy:
+		return paint(color, string(s), colorGreen)
+	case telemetry.HealthStatusDegraded:
+		return paint(color, string(s), colorYellow)
+	}
+	return paint(color, string(s), colorRed)
+}
+
+// pad left-aligns a possibly coloured string to width visible columns.
+func pad(s string, width int, color bool) string {
+	visible := len(s)
+	if color && strings.HasPrefix(s, "\033[") {
+		visible -= len("\033[00m") + len("\033[0m")
+	}
+	if visible >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-visible)
+}
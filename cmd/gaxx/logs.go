@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func newLogsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs --name <fleet> [--node <node>] [--file <path>] [--follow]",
+		Short: "Show agent or command logs from fleet nodes",
+		Long: `Print the last lines of the gaxx-agent journal (one access log entry per
+request, with each command and its exit code) from every node, or of a remote
+file such as a scan's output with --file. Lines are prefixed with the node
+name. With --follow, keep streaming new lines until interrupted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			file, _ := cmd.Flags().GetString("file")
+			lines, _ := cmd.Flags().GetInt("lines")
+			follow, _ := cmd.Flags().GetBool("follow")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodeName != "" {
+				node, err := findNode(nodes, nodeName)
+				if err != nil {
+					return err
+				}
+				nodes = []providers.Node{node}
+			}
+
+			command := logsCommand(file, lines, follow)
+			var mu sync.Mutex
+			errs := make([]error, len(nodes))
+			var wg sync.WaitGroup
+			for i, n := range nodes {
+				wg.Add(1)
+				go func(i int, n providers.Node) {
+					defer wg.Done()
+					stdout := &prefixWriter{prefix: "[" + n.Name + "] ", w: os.Stdout, mu: &mu}
+					stderr := &prefixWriter{prefix: "[" + n.Name + "] ", w: os.Stderr, mu: &mu}
+					errs[i] = streamRemote(ctx, client, n, command, stdout, stderr)
+					stdout.Flush()
+					stderr.Flush()
+				}(i, n)
+			}
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			failed := 0
+			for i, err := range errs {
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "[%s] ❌ %v\n", nodes[i].Name, err)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d nodes failed", failed, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("node", "", "Only show this node")
+	cmd.Flags().String("file", "", "Remote file to tail instead of the agent journal")
+	cmd.Flags().IntP("lines", "n", 50, "Number of past lines to show per node")
+	cmd.Flags().BoolP("follow", "f", false, "Keep streaming new lines")
+
+	return cmd
+}
+
+// logsCommand returns the remote command that prints (and with follow,
+// streams) the last lines of file, or of the agent's journal when file is
+// empty. The journal is read with sudo when the login user isn't root.
+func logsCommand(file string, lines int, follow bool) string {
+	n := strconv.Itoa(lines)
+	if file != "" {
+		args := []string{"-n", n}
+		if follow {
+			args = append(args, "-F")
+		}
+		return core.ShellJoin("tail", append(args, file)...)
+	}
+	journal := core.ShellJoin("journalctl", "-u", "gaxx-agent", "--no-pager", "-o", "cat", "-n", n)
+	if follow {
+		journal += " -f"
+	}
+	return `SUDO=sudo; [ "$(id -u)" -eq 0 ] && SUDO=; exec $SUDO ` + journal
+}
+
+// streamRemote runs command on node over SSH, copying its output until it
+// exits or ctx ends.
+func streamRemote(ctx context.Context, client *api.Client, node providers.Node, command string, stdout, stderr io.Writer) error {
+	c, err := core.SSHClientFor(client.Config(), node)
+	if err != nil {
+		return err
+	}
+	cli, err := gssh.Dial(ctx, c)
+	if err != nil {
+		return fmt.Errorf("ssh dial: %w", err)
+	}
+	defer cli.Close()
+	session, err := gssh.NewSession(ctx, cli)
+	if err != nil {
+		return fmt.Errorf("ssh session: %w", err)
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { cli.Close() })
+	defer stop()
+	if err := session.Wait(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// prefixWriter writes each complete line to w with prefix, holding mu so
+// lines from concurrent nodes don't interleave.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a trailing partial line, if any.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+}
@@ -1,25 +1,40 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
-	"time"
+	"runtime"
+	"runtime/debug"
 
-	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+// commit and buildDate are set with -ldflags "-X main.commit=... -X
+// main.buildDate=..."; otherwise the VCS stamps go build embeds are used.
 var (
 	version   = "2.0.0"
 	commit    = ""
-	buildDate = "8/9/2025"
+	buildDate = ""
+)
+
+// quiet and noColor are --quiet and --no-color, set before any command
+// runs.
+var (
+	quiet   bool
+	noColor bool
 )
 
 func main() {
 	if err := newRootCmd().Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		// Cobra skips PersistentPostRun when a command fails, so flush here;
+		// metrics from failed runs are the ones most worth exporting.
+		flushTelemetry()
+		fmt.Fprintf(os.Stderr, "Error: %s\n", redact.String(err.Error()))
 		os.Exit(1)
 	}
 }
@@ -32,294 +47,174 @@ func newRootCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
 		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			quiet, _ = cmd.Flags().GetBool("quiet")
+			noColor, _ = cmd.Flags().GetBool("no-color")
+			level, _ := cmd.Flags().GetString("log")
+			if quiet && !cmd.Flags().Changed("log") {
+				level = "error"
+			}
+			if err := setLogLevel(level); err != nil {
+				return err
+			}
+			setLogOutput()
+			initTelemetry(cmd)
+			return nil
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			flushTelemetry()
+		},
 		SilenceUsage:  true,
 		SilenceErrors: true,
 	}
 
 	cmd.PersistentFlags().StringP("log", "l", "info", "Set log level. Available: debug, info, warn, error, fatal")
 	cmd.PersistentFlags().String("config", "", "config file")
+	cmd.PersistentFlags().BoolP("quiet", "q", false, "Print only errors, failed nodes and run summaries (also sets --log error)")
+	cmd.PersistentFlags().Bool("no-color", false, "Disable colored output (also when NO_COLOR is set)")
+	cmd.PersistentFlags().Bool("no-cache", false, "List nodes from the provider even if a recent listing is cached (store.node_cache_seconds)")
+	cmd.PersistentFlags().Bool("agent-tls", false, "Reach agents over https (agent.tls.enabled)")
+	cmd.PersistentFlags().Bool("prefer-ipv6", false, "Reach nodes that have both address families over IPv6 (defaults.prefer_ipv6)")
 	cmd.PersistentFlags().String("proxy", "", "HTTP Proxy (Useful for debugging. Example: http://127.0.0.1:8080)")
 
+	cmd.AddCommand(newInitCmd())
 	cmd.AddCommand(newSpawnCmd())
 	cmd.AddCommand(newRunCmd())
+	cmd.AddCommand(newScanCmd())
 	cmd.AddCommand(newListCmd())
 	cmd.AddCommand(newDeleteCmd())
-	cmd.AddCommand(newMetricsCmd())
+	cmd.AddCommand(newReapCmd())
+	cmd.AddCommand(newScpCmd())
+	cmd.AddCommand(newCollectCmd())
+	cmd.AddCommand(newCpBetweenCmd())
+	cmd.AddCommand(newSSHCmd())
+	cmd.AddCommand(newStatusCmd())
+	cmd.AddCommand(newHealthCmd())
+	cmd.AddCommand(newTopCmd())
+	cmd.AddCommand(newBenchCmd())
+	cmd.AddCommand(newLogsCmd())
+	cmd.AddCommand(newProxyCmd())
+	cmd.AddCommand(newDoctorCmd())
+	cmd.AddCommand(newResultsCmd())
+	cmd.AddCommand(newModuleCmd())
+	cmd.AddCommand(newAgentCmd())
+	cmd.AddCommand(newEstimateCmd())
+	cmd.AddCommand(newServeCmd())
+	cmd.AddCommand(newAPIKeyCmd())
+	cmd.AddCommand(newSelftestCmd())
 	cmd.AddCommand(newVersionCmd())
 
 	return cmd
 }
 
-func newSpawnCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "spawn",
-		Short: "Create a fleet of instances",
-		Long:  "Create a fleet of cloud instances for distributed task execution.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			provider, _ := cmd.Flags().GetString("provider")
-			count, _ := cmd.Flags().GetInt("count")
-			name, _ := cmd.Flags().GetString("name")
-
-			if name == "" {
-				return fmt.Errorf("fleet name is required")
-			}
-
-			config, err := core.LoadConfig("")
-			if err != nil {
-				return fmt.Errorf("load config: %w", err)
-			}
-
-			var p core.Provider
-			switch provider {
-			case "linode":
-				if config.Token == "" {
-					return fmt.Errorf("LINODE_TOKEN environment variable is required")
-				}
-				p = core.NewLinodeProvider(config.Token)
-			case "vultr":
-				if config.Token == "" {
-					return fmt.Errorf("VULTR_API_KEY environment variable is required")
-				}
-				p = core.NewVultrProvider(config.Token)
-			default:
-				return fmt.Errorf("unsupported provider: %s (supported: linode, vultr)", provider)
-			}
-
-			gaxx := core.NewGaxx(config, p)
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-			defer cancel()
-
-			fmt.Printf("🚀 Creating fleet '%s' with %d instances using %s...\n", name, count, provider)
-			instances, err := gaxx.SpawnFleet(ctx, name, count)
-			if err != nil {
-				return fmt.Errorf("spawn fleet: %w", err)
-			}
-
-			fmt.Printf("✅ Created fleet '%s' with %d instances:\n", name, len(instances))
-			for _, inst := range instances {
-				fmt.Printf("  %s: %s\n", inst.Name, inst.IP)
-			}
-			return nil
-		},
+// setLogLevel sets zerolog's global level from a --log value.
+func setLogLevel(name string) error {
+	switch name {
+	case "debug", "info", "warn", "error", "fatal":
+	default:
+		return fmt.Errorf("invalid log level %q (want debug, info, warn, error or fatal)", name)
 	}
-
-	cmd.Flags().String("provider", "linode", "Cloud provider (linode, vultr)")
-	cmd.Flags().Int("count", 1, "Number of instances to create")
-	cmd.Flags().String("name", "", "Fleet name (required)")
-
-	return cmd
+	level, err := zerolog.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
 }
 
-func newRunCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "run",
-		Short: "Execute command on fleet",
-		Long:  "Execute a command across all instances in a fleet.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name, _ := cmd.Flags().GetString("name")
-			command, _ := cmd.Flags().GetString("command")
-
-			if name == "" {
-				return fmt.Errorf("fleet name is required")
-			}
-			if command == "" {
-				return fmt.Errorf("command is required")
-			}
-
-			config, err := core.LoadConfig("")
-			if err != nil {
-				return fmt.Errorf("load config: %w", err)
-			}
-
-			// Use Linode as default
-			p := core.NewLinodeProvider(config.Token)
-			gaxx := core.NewGaxx(config, p)
-
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-			defer cancel()
-
-			fmt.Printf("📋 Listing instances for fleet '%s'...\n", name)
-			instances, err := gaxx.ListInstances(ctx, name)
-			if err != nil {
-				return fmt.Errorf("list instances: %w", err)
-			}
-
-			if len(instances) == 0 {
-				return fmt.Errorf("no instances found for fleet '%s'", name)
-			}
-
-			task := core.Task{
-				Command: command,
-				Args:    args,
-			}
-
-			fmt.Printf("⚡ Executing command on %d instances...\n", len(instances))
-			start := time.Now()
-			err = gaxx.ExecuteTasks(ctx, instances, []core.Task{task})
-			duration := time.Since(start)
-
-			if err != nil {
-				return fmt.Errorf("execute tasks: %w", err)
-			}
-
-			fmt.Printf("✅ Command completed in %v across %d instances\n", duration, len(instances))
-			return nil
-		},
+// setLogOutput writes logs for people, colored unless disabled, when stderr
+// is a terminal, and leaves them as JSON lines for log capture otherwise.
+func setLogOutput() {
+	if isTerminal(os.Stderr) {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, NoColor: !useColor(os.Stderr)})
 	}
-
-	cmd.Flags().String("name", "", "Fleet name (required)")
-	cmd.Flags().String("command", "", "Command to execute (required)")
-
-	return cmd
 }
 
-func newListCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "ls [fleet-name]",
-		Short: "List instances",
-		Long:  "List all instances or instances in a specific fleet.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := ""
-			if len(args) > 0 {
-				name = args[0]
-			}
-
-			config, err := core.LoadConfig("")
-			if err != nil {
-				return fmt.Errorf("load config: %w", err)
-			}
-
-			// Use Linode as default provider
-			p := core.NewLinodeProvider(config.Token)
-			gaxx := core.NewGaxx(config, p)
-
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			instances, err := gaxx.ListInstances(ctx, name)
-			if err != nil {
-				return fmt.Errorf("list instances: %w", err)
-			}
-
-			if len(instances) == 0 {
-				if name != "" {
-					fmt.Printf("No instances found for fleet '%s'\n", name)
-				} else {
-					fmt.Println("No instances found")
-				}
-				return nil
-			}
-
-			fmt.Printf("%-20s %-15s %-10s %-8s\n", "NAME", "IP", "ID", "USER")
-			fmt.Println(strings.Repeat("-", 55))
-			for _, inst := range instances {
-				fmt.Printf("%-20s %-15s %-10s %-8s\n", inst.Name, inst.IP, inst.ID, inst.User)
-			}
-			return nil
-		},
+// initTelemetry sets up the global collector from the telemetry section of
+// the config. A config that fails to load is left for the command itself
+// to report.
+func initTelemetry(cmd *cobra.Command) {
+	cfg, err := loadConfig(cmd)
+	if err != nil || !cfg.Telemetry.Enabled {
+		return
+	}
+	telemetry.InitGlobal(true, cfg.Telemetry.OTLPEndpoint)
+	collector := telemetry.GetGlobal()
+	collector.SetPushgatewayURL(cfg.Telemetry.PushgatewayURL)
+	if cfg.Telemetry.MaxLabelValues != 0 {
+		collector.SetMaxLabelValues(cfg.Telemetry.MaxLabelValues)
 	}
-
-	return cmd
 }
 
-func newDeleteCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "delete [fleet-name]",
-		Short: "Delete fleet",
-		Long:  "Delete all instances in a fleet or all instances if no fleet specified.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := ""
-			if len(args) > 0 {
-				name = args[0]
-			}
-
-			config, err := core.LoadConfig("")
-			if err != nil {
-				return fmt.Errorf("load config: %w", err)
-			}
-
-			// Use Linode as default provider for now
-			p := core.NewLinodeProvider(config.Token)
-			gaxx := core.NewGaxx(config, p)
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-			defer cancel()
-
-			if name != "" {
-				fmt.Printf("🗑️  Deleting fleet '%s'...\n", name)
-			} else {
-				fmt.Println("🗑️  Deleting all instances...")
-			}
-
-			if err := gaxx.DeleteFleet(ctx, name); err != nil {
-				return fmt.Errorf("delete fleet: %w", err)
-			}
-
-			if name != "" {
-				fmt.Printf("✅ Deleted fleet '%s'\n", name)
-			} else {
-				fmt.Println("✅ Deleted all instances")
-			}
-			return nil
-		},
+// flushTelemetry exports whatever the run recorded before the process exits.
+func flushTelemetry() {
+	if err := telemetry.Shutdown(); err != nil {
+		log.Warn().Err(err).Msg("Failed to export telemetry")
 	}
-
-	return cmd
 }
 
-func newMetricsCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "metrics",
-		Short: "Show performance metrics",
-		Long:  "Display current performance metrics for the simplified Gaxx instance.",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			config, err := core.LoadConfig("")
-			if err != nil {
-				return fmt.Errorf("load config: %w", err)
-			}
-
-			// Create a temporary instance to get metrics
-			p := core.NewLinodeProvider(config.Token)
-			gaxx := core.NewGaxx(config, p)
-
-			requests, errors, duration := gaxx.GetMetrics()
-
-			fmt.Println("📊 Gaxx Performance Metrics")
-			fmt.Println(strings.Repeat("-", 40))
-			fmt.Printf("Total Requests: %d\n", requests)
-			fmt.Printf("Total Errors:   %d\n", errors)
-			fmt.Printf("Total Duration: %v\n", duration)
-
-			if requests > 0 {
-				avgDuration := duration / time.Duration(requests)
-				errorRate := float64(errors) / float64(requests) * 100
-				fmt.Printf("Avg Duration:   %v\n", avgDuration)
-				fmt.Printf("Error Rate:     %.2f%%\n", errorRate)
-			}
+// buildInfo is what gaxx version reports.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
 
-			return nil
-		},
+// currentBuildInfo fills in what ldflags didn't set from the build's VCS
+// stamps (absent in go run and test binaries).
+func currentBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, Date: buildDate, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
 	}
-
-	return cmd
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
 }
 
 func newVersionCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "version",
+		Use:   "version [--json]",
 		Short: "Show version information",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("Gaxx v%s\n", version)
-			fmt.Printf("Build Date: %s\n", buildDate)
-
-			if commit != "" {
-				fmt.Printf("Commit: %s\n", commit)
+			info := currentBuildInfo()
+			if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+				return json.NewEncoder(os.Stdout).Encode(info)
+			}
+			fmt.Printf("Gaxx v%s\n", info.Version)
+			if info.Date != "" {
+				fmt.Printf("Build Date: %s\n", info.Date)
+			}
+			if info.Commit != "" {
+				dirty := ""
+				if info.Modified {
+					dirty = " (modified)"
+				}
+				fmt.Printf("Commit: %s%s\n", info.Commit, dirty)
 			}
-			fmt.Println("Architecture: High-performance core")
-			fmt.Println("Performance: Optimized for speed and reliability")
+			fmt.Printf("Go: %s %s/%s\n", info.GoVersion, info.OS, info.Arch)
 			return nil
 		},
 	}
+	cmd.Flags().Bool("json", false, "Print version, commit, date, Go version and platform as JSON")
 
 	return cmd
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func newModuleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "module",
+		Aliases: []string{"modules"},
+		Short:   "List, show and validate task modules",
+		Long: `Task modules can be given to run and scan --module by name instead of by
+path. Names are looked up as <name>.yaml in each directory of modules.path
+(default ~/.config/gaxx/modules), then among the modules bundled with gaxx;
+drop a YAML file in the modules directory to install one. Without a
+subcommand, list the modules.`,
+		Args: cobra.NoArgs,
+		RunE: listModules,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List installed and bundled modules",
+		Args:  cobra.NoArgs,
+		RunE:  listModules,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show <name|file>",
+		Short: "Print a module's spec",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			data, source, err := api.ReadModule(cfg, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "# %s\n", source)
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate <name|file>",
+		Short: "Check a module for errors",
+		Long: `Check a module's fields, chunk_size, templates, ${var} references and
+requires, printing each problem with its line. Modules are also validated
+whenever run or scan loads them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			data, source, err := api.ReadModule(cfg, args[0])
+			if err != nil {
+				return err
+			}
+			if source == api.BundledSource {
+				source = args[0] + " (bundled)"
+			}
+			spec, err := api.ValidateModule(data, source)
+			var merr *api.ModuleError
+			if errors.As(err, &merr) {
+				for _, issue := range merr.Issues {
+					fmt.Printf("%s: %s\n", source, issue)
+				}
+				return fmt.Errorf("module %s has %d problem(s)", source, len(merr.Issues))
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: ok\n", source)
+			if vars := spec.Variables(); len(vars) > 0 {
+				fmt.Printf("Variables: %s\n", strings.Join(vars, ", "))
+			}
+			return nil
+		},
+	})
+	return cmd
+}
+
+func listModules(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	mods, err := api.ListModules(cfg)
+	if err != nil {
+		return err
+	}
+	if len(mods) == 0 {
+		fmt.Println("No modules found")
+		return nil
+	}
+	fmt.Printf("%-20s %-40s %s\n", "NAME", "SOURCE", "DESCRIPTION")
+	for _, m := range mods {
+		fmt.Printf("%-20s %-40s %s\n", m.Name, m.Source, m.Description)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// runSummary totals a finished run.
+type runSummary struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Nodes     int `json:"nodes"`
+	// Interrupted is set when a signal stopped the run early.
+	Interrupted bool          `json:"interrupted,omitempty"`
+	Duration    time.Duration `json:"-"`
+}
+
+// runReporter presents a run: lifecycle events as they happen, then a summary.
+type runReporter interface {
+	api.Observer
+	Notice(format string, args ...any)
+	Summary(runSummary)
+}
+
+// newRunReporter returns the reporter selected by --output, or by
+// --template and --template-footer. It writes to stderr when sink takes
+// stdout for the results.
+func newRunReporter(cmd *cobra.Command, sink *resultSink) (runReporter, error) {
+	output, _ := cmd.Flags().GetString("output")
+	tmpl, _ := cmd.Flags().GetString("template")
+	footer, _ := cmd.Flags().GetString("template-footer")
+	var w io.Writer = os.Stdout
+	if sink.toStdout() {
+		w = os.Stderr
+	}
+	if tmpl != "" || footer != "" {
+		if cmd.Flags().Changed("output") {
+			return nil, fmt.Errorf("--template cannot be combined with --output")
+		}
+		return newTemplateObserver(w, tmpl, footer)
+	}
+	switch output {
+	case "", "text":
+		console := &consoleObserver{w: w, quiet: quiet}
+		console.errorsOnly, _ = cmd.Flags().GetBool("errors-only")
+		console.maxErrorLines, _ = cmd.Flags().GetInt("max-error-lines")
+		if w == os.Stdout {
+			if bar := newProgressBar(os.Stdout, "done"); bar != nil {
+				console.w = bar
+				return &progressReporter{runReporter: console, bar: bar}, nil
+			}
+		}
+		return console, nil
+	case "json":
+		return &jsonObserver{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want text or json)", output)
+	}
+}
+
+// consoleObserver prints each node's output, prefixed with the node name,
+// once it finishes. Secrets in stderr and errors are masked. With
+// errorsOnly it skips nodes that succeeded, and with quiet also notices.
+// maxErrorLines, if positive, caps the stderr lines printed per node.
+type consoleObserver struct {
+	api.NopObserver
+	w             io.Writer
+	quiet         bool
+	errorsOnly    bool
+	maxErrorLines int
+}
+
+func (o *consoleObserver) NodeFinished(ev api.NodeEvent) {
+	r := ev.Result
+	if (o.quiet || o.errorsOnly) && r.OK() {
+		return
+	}
+	prefix := fmt.Sprintf("[%s]", r.Node.Name)
+	if r.Err != nil {
+		fmt.Fprintf(o.w, "%s ❌ %s\n", prefix, redact.String(r.Err.Error()))
+		return
+	}
+	for _, line := range outputLines(r.Stdout) {
+		fmt.Fprintf(o.w, "%s %s\n", prefix, line)
+	}
+	stderr := outputLines(redact.String(r.Stderr))
+	if o.maxErrorLines > 0 && len(stderr) > o.maxErrorLines {
+		for _, line := range stderr[:o.maxErrorLines] {
+			fmt.Fprintf(o.w, "%s %s\n", prefix, line)
+		}
+		fmt.Fprintf(o.w, "%s ... %d more lines of stderr\n", prefix, len(stderr)-o.maxErrorLines)
+	} else {
+		for _, line := range stderr {
+			fmt.Fprintf(o.w, "%s %s\n", prefix, line)
+		}
+	}
+	if r.OutputFile != "" {
+		size := int64(0)
+		if info, err := os.Stat(r.OutputFile); err == nil {
+			size = info.Size()
+		}
+		fmt.Fprintf(o.w, "%s output saved to %s (%s)\n", prefix, r.OutputFile, formatBytes(size))
+	}
+	if r.Truncated {
+		fmt.Fprintf(o.w, "%s ⚠️  output truncated at the agent's size limit\n", prefix)
+	}
+	if r.LimitExceeded != "" {
+		fmt.Fprintf(o.w, "%s ⚠️  killed: %s limit exceeded\n", prefix, r.LimitExceeded)
+	}
+	switch {
+	case r.ExitCode != 0 && r.Step != "":
+		fmt.Fprintf(o.w, "%s ❌ step %s: exit code %d\n", prefix, r.Step, r.ExitCode)
+	case r.ExitCode != 0:
+		fmt.Fprintf(o.w, "%s ❌ exit code %d\n", prefix, r.ExitCode)
+	}
+}
+
+// outputLines splits output into its non-empty lines.
+func outputLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (o *consoleObserver) NodeStraggler(ev api.NodeEvent) {
+	if o.quiet {
+		return
+	}
+	st := ev.Straggler
+	fmt.Fprintf(o.w, "[%s] 🐢 chunk %d has run %v, over the median of %v", ev.Node.Name, ev.Chunk,
+		st.Elapsed.Round(time.Second), st.Median.Round(time.Millisecond))
+	if st.Backup != "" {
+		fmt.Fprintf(o.w, "; also running it on %s", st.Backup)
+	}
+	fmt.Fprintln(o.w)
+}
+
+func (o *consoleObserver) Notice(format string, args ...any) {
+	if !o.quiet {
+		fmt.Fprintf(o.w, format+"\n", args...)
+	}
+}
+
+func (o *consoleObserver) Summary(s runSummary) {
+	partial := ""
+	if s.Interrupted {
+		partial = " before it was interrupted"
+	}
+	fmt.Fprintf(o.w, "\n✅ %d succeeded, ❌ %d failed across %d nodes in %v%s\n", s.Succeeded, s.Failed, s.Nodes, s.Duration.Round(time.Millisecond), partial)
+}
+
+// jsonObserver writes every event as a JSON line, for other tools to consume.
+type jsonObserver struct {
+	enc *json.Encoder
+}
+
+func (o *jsonObserver) write(ev api.NodeEvent) { _ = o.enc.Encode(ev) }
+
+func (o *jsonObserver) NodeStarted(ev api.NodeEvent)   { o.write(ev) }
+func (o *jsonObserver) NodeOutput(ev api.NodeEvent)    { o.write(ev) }
+func (o *jsonObserver) ChunkFailed(ev api.NodeEvent)   { o.write(ev) }
+func (o *jsonObserver) NodeFinished(ev api.NodeEvent)  { o.write(ev) }
+func (o *jsonObserver) NodeStraggler(ev api.NodeEvent) { o.write(ev) }
+
+// Notice goes to stderr so stdout stays pure JSON lines.
+func (o *jsonObserver) Notice(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (o *jsonObserver) Summary(s runSummary) {
+	_ = o.enc.Encode(struct {
+		Event string `json:"event"`
+		runSummary
+		DurationMS int64 `json:"duration_ms"`
+	}{Event: "summary", runSummary: s, DurationMS: s.Duration.Milliseconds()})
+}
+
+// templateResult is what --template formats for each finished node.
+type templateResult struct {
+	NodeName   string
+	IP         string
+	Chunk      int
+	ExitCode   int
+	DurationMs int64
+	Stdout     string
+	Stderr     string
+	Error      string
+	OutputFile string
+	Step       string
+	OK         bool
+}
+
+// templateSummary is what --template-footer formats once the run ends.
+type templateSummary struct {
+	Succeeded   int
+	Failed      int
+	Nodes       int
+	Interrupted bool
+	DurationMs  int64
+}
+
+// templateFuncs are the functions --template and --template-footer can use
+// besides text/template's own.
+var templateFuncs = template.FuncMap{
+	"trim": strings.TrimSpace,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// templateObserver formats each finished node, and then the summary, with
+// Go templates; a line that doesn't end in a newline gets one. Secrets in
+// stderr and errors are masked. Notices go to stderr so stdout holds only
+// the report.
+type templateObserver struct {
+	api.NopObserver
+	w              io.Writer
+	result, footer *template.Template
+}
+
+// newTemplateObserver parses the templates and tries them on empty values,
+// so a misspelled field fails before the run rather than on every node.
+// Either may be empty to print nothing at that point.
+func newTemplateObserver(w io.Writer, result, footer string) (*templateObserver, error) {
+	o := &templateObserver{w: w}
+	for _, t := range []struct {
+		flag, text string
+		dst        **template.Template
+		zero       any
+	}{
+		{"template", result, &o.result, templateResult{}},
+		{"template-footer", footer, &o.footer, templateSummary{}},
+	} {
+		if t.text == "" {
+			continue
+		}
+		tmpl, err := template.New(t.flag).Funcs(templateFuncs).Parse(t.text)
+		if err == nil {
+			err = tmpl.Execute(io.Discard, t.zero)
+		}
+		if err != nil {
+			// Named after the flag, so errors read "template: template-footer:1: ...".
+			return nil, err
+		}
+		*t.dst = tmpl
+	}
+	return o, nil
+}
+
+func (o *templateObserver) execute(t *template.Template, data any) {
+	if t == nil {
+		return
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return
+	}
+	out := b.String()
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	fmt.Fprint(o.w, out)
+}
+
+func (o *templateObserver) NodeFinished(ev api.NodeEvent) {
+	r := ev.Result
+	res := templateResult{
+		NodeName:   r.Node.Name,
+		IP:         r.Node.DialIP(),
+		Chunk:      r.Chunk,
+		ExitCode:   r.ExitCode,
+		DurationMs: r.Duration.Milliseconds(),
+		Stdout:     r.Stdout,
+		Stderr:     redact.String(r.Stderr),
+		OutputFile: r.OutputFile,
+		Step:       r.Step,
+		OK:         r.OK(),
+	}
+	if r.Err != nil {
+		res.Error = redact.String(r.Err.Error())
+	}
+	o.execute(o.result, res)
+}
+
+func (o *templateObserver) Notice(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (o *templateObserver) Summary(s runSummary) {
+	o.execute(o.footer, templateSummary{
+		Succeeded:   s.Succeeded,
+		Failed:      s.Failed,
+		Nodes:       s.Nodes,
+		Interrupted: s.Interrupted,
+		DurationMs:  s.Duration.Milliseconds(),
+	})
+}
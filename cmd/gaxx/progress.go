@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+// spinnerFrames animate the progress bar while nothing completes.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressBarWidth is the number of cells in the bar.
+const progressBarWidth = 30
+
+// progressBar keeps a "⠙ [=====>     ] 12/40 nodes  ETA 1m20s" line at the
+// bottom of a terminal, redrawn as work completes and by a ticker that
+// turns the spinner. Writes through it print above the bar. A nil
+// *progressBar is a no-op, and writes through it go straight to the
+// terminal.
+type progressBar struct {
+	out  *os.File
+	unit string
+
+	mu      sync.Mutex
+	total   int
+	done    int
+	frame   int
+	start   time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newProgressBar returns a bar drawn on out counting unit, or nil when out
+// is not a terminal or --quiet is set.
+func newProgressBar(out *os.File, unit string) *progressBar {
+	if quiet || !isTerminal(out) {
+		return nil
+	}
+	return &progressBar{out: out, unit: unit}
+}
+
+// SetTotal sets how many units there are, starting the bar the first time.
+func (b *progressBar) SetTotal(total int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total = total
+	if b.stop != nil || total <= 0 {
+		return
+	}
+	b.start = time.Now()
+	b.stop, b.stopped = make(chan struct{}), make(chan struct{})
+	go b.spin()
+	b.draw()
+}
+
+// Set records that done units have completed.
+func (b *progressBar) Set(done int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = done
+	if b.stop != nil {
+		b.draw()
+	}
+}
+
+// Add records n more completed units.
+func (b *progressBar) Add(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	done := b.done + n
+	b.mu.Unlock()
+	b.Set(done)
+}
+
+// Finish stops the bar and erases it.
+func (b *progressBar) Finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	stop := b.stop
+	b.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-b.stopped
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stop = nil
+	fmt.Fprint(b.out, "\r\033[K")
+}
+
+// Write prints p above the bar.
+func (b *progressBar) Write(p []byte) (int, error) {
+	if b == nil {
+		return os.Stdout.Write(p)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop == nil {
+		return b.out.Write(p)
+	}
+	fmt.Fprint(b.out, "\r\033[K")
+	n, err := b.out.Write(p)
+	b.draw()
+	return n, err
+}
+
+func (b *progressBar) spin() {
+	defer close(b.stopped)
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+			b.mu.Lock()
+			b.frame++
+			b.draw()
+			b.mu.Unlock()
+		}
+	}
+}
+
+// draw redraws the bar line; b.mu must be held.
+func (b *progressBar) draw() {
+	done, total := min(b.done, b.total), b.total
+	filled := progressBarWidth * done / total
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	eta := "ETA --"
+	if done > 0 && done < total {
+		elapsed := time.Since(b.start)
+		eta = "ETA " + (elapsed * time.Duration(total-done) / time.Duration(done)).Round(time.Second).String()
+	}
+	fmt.Fprintf(b.out, "\r\033[K%s [%s] %d/%d %s  %s", spinnerFrames[b.frame%len(spinnerFrames)], bar, done, total, b.unit, eta)
+}
+
+// progressReporter advances a progress bar as a run's executions finish;
+// the reporter it wraps writes through the bar. reportRun erases the bar
+// when the run returns.
+type progressReporter struct {
+	runReporter
+	bar *progressBar
+}
+
+func (r *progressReporter) NodeStarted(ev api.NodeEvent) {
+	r.bar.SetTotal(ev.Chunks)
+	r.runReporter.NodeStarted(ev)
+}
+
+func (r *progressReporter) NodeFinished(ev api.NodeEvent) {
+	r.bar.SetTotal(ev.Chunks)
+	r.runReporter.NodeFinished(ev)
+	r.bar.Add(1)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/internal/proxy"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/spf13/cobra"
+	xssh "golang.org/x/crypto/ssh"
+)
+
+func newProxyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy --name <fleet> [--listen :1080] [--strategy roundrobin|random]",
+		Short: "Serve a local SOCKS5/HTTP proxy that rotates through fleet nodes",
+		Long: `Open an SSH connection to every node in the fleet and serve a proxy on
+--listen that sends each connection out through one of them, as ssh -D would
+for a single node, so traffic leaves from the fleet's addresses in turn.
+
+The port speaks SOCKS5 (no authentication) and HTTP proxy requests, both
+CONNECT and plain http:// URLs, so it works with curl --proxy, proxychains
+and most scanners. --strategy roundrobin takes the nodes in turn and random
+picks one per connection. A node whose SSH connection drops is redialed on
+its next turn, and connections meanwhile go to the next node. Runs until
+interrupted.
+
+By default the proxy only listens on localhost; listening on another
+address lets anyone who can reach it use the fleet.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			listen, _ := cmd.Flags().GetString("listen")
+			strategyFlag, _ := cmd.Flags().GetString("strategy")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			strategy, err := proxy.ParseStrategy(strategyFlag)
+			if err != nil {
+				return err
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodes, err = selectNodes(cmd, nodes); err != nil {
+				return err
+			}
+
+			upstreams := make([]*sshUpstream, len(nodes))
+			errs := make([]error, len(nodes))
+			var wg sync.WaitGroup
+			for i, n := range nodes {
+				wg.Add(1)
+				go func(i int, n providers.Node) {
+					defer wg.Done()
+					c, err := core.SSHClientFor(client.Config(), n)
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					upstreams[i] = &sshUpstream{node: n, ssh: c}
+					_, errs[i] = upstreams[i].connect(ctx)
+				}(i, n)
+			}
+			wg.Wait()
+			srv := &proxy.Server{Strategy: strategy}
+			for i, u := range upstreams {
+				if errs[i] != nil {
+					fmt.Fprintf(os.Stderr, "[%s] ❌ %v\n", nodes[i].Name, errs[i])
+					continue
+				}
+				defer u.Close()
+				srv.Upstreams = append(srv.Upstreams, u)
+			}
+			if len(srv.Upstreams) == 0 {
+				return fmt.Errorf("could not connect to any of the %d nodes", len(nodes))
+			}
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Proxying SOCKS5 and HTTP on %s through %d of %d nodes (%s); Ctrl-C to stop\n",
+				ln.Addr(), len(srv.Upstreams), len(nodes), strategy)
+			return srv.Serve(ctx, ln)
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("select", "", "Only use nodes matching this selector (see run --select)")
+	cmd.Flags().String("listen", "127.0.0.1:1080", "Address to serve the proxy on")
+	cmd.Flags().String("strategy", string(proxy.RoundRobin), "How to pick a node per connection: roundrobin or random")
+
+	return cmd
+}
+
+// sshUpstream sends proxied connections out through a node over SSH,
+// redialing the node when its connection has dropped.
+type sshUpstream struct {
+	node providers.Node
+	ssh  *gssh.Client
+
+	mu  sync.Mutex
+	cli *xssh.Client
+}
+
+func (u *sshUpstream) Name() string { return u.node.Name }
+
+// connect returns the node's SSH connection, dialing it if there is none.
+func (u *sshUpstream) connect(ctx context.Context) (*xssh.Client, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cli == nil {
+		cli, err := gssh.Dial(ctx, u.ssh)
+		if err != nil {
+			return nil, fmt.Errorf("ssh dial: %w", err)
+		}
+		u.cli = cli
+	}
+	return u.cli, nil
+}
+
+// drop forgets cli so the next connection redials, unless another
+// connection already has.
+func (u *sshUpstream) drop(cli *xssh.Client) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cli == cli {
+		u.cli = nil
+	}
+	cli.Close()
+}
+
+func (u *sshUpstream) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	cli, err := u.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", proxy.ErrUnavailable, err)
+	}
+	conn, err := cli.DialContext(ctx, network, addr)
+	// The node answering that it couldn't open the channel means the
+	// target is unreachable from it; anything else means the connection
+	// to the node is gone.
+	var refused *xssh.OpenChannelError
+	if err != nil && !errors.As(err, &refused) && ctx.Err() == nil {
+		u.drop(cli)
+		return nil, fmt.Errorf("%w: %v", proxy.ErrUnavailable, err)
+	}
+	return conn, err
+}
+
+// Close closes the node's SSH connection.
+func (u *sshUpstream) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cli != nil {
+		u.cli.Close()
+		u.cli = nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func newReapCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reap [--provider <p>] [--dry-run]",
+		Short: "Delete instances whose spawn --ttl has passed",
+		Long: `Delete every instance tagged by spawn --ttl whose expiry has passed,
+on --provider or else on every cloud provider with a token configured.
+Untagged instances are never touched. Run it from cron to stop forgotten
+fleets from running up a bill; --dry-run only lists what would go.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			names := []string{client.Provider}
+			if client.Provider == "" {
+				names = reapProviders(client.Config())
+				if len(names) == 0 {
+					return fmt.Errorf("no cloud provider token configured; pass --provider")
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+			now := time.Now()
+			reaped, failed := 0, 0
+			for _, name := range names {
+				c := *client
+				c.Provider = name
+				expired, err := c.Expired(ctx, now)
+				if err != nil {
+					fmt.Printf("❌ %s: %v\n", name, err)
+					failed++
+					continue
+				}
+				if len(expired) == 0 {
+					continue
+				}
+				fmt.Printf("⏰ %d expired instances on %s:\n", len(expired), name)
+				for _, n := range expired {
+					at, _ := api.NodeExpiry(n)
+					fmt.Printf("  %s (%s), expired %s\n", n.Name, n.DialIP(), at.Local().Format("2006-01-02 15:04"))
+				}
+				if dryRun {
+					reaped += len(expired)
+					continue
+				}
+				if err := c.DeleteNodes(ctx, expired); err != nil {
+					fmt.Printf("❌ %s: %v\n", name, err)
+					failed++
+					continue
+				}
+				reaped += len(expired)
+			}
+
+			switch {
+			case reaped == 0 && failed == 0:
+				fmt.Println("No expired instances")
+			case dryRun:
+				fmt.Printf("Dry run: %d instances would be deleted\n", reaped)
+			case reaped > 0:
+				fmt.Printf("🗑️  Deleted %d expired instances\n", reaped)
+			}
+			if failed > 0 {
+				return fmt.Errorf("reap failed on %d of %d providers", failed, len(names))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Only reap this provider (default: every provider with a token)")
+	cmd.Flags().Bool("dry-run", false, "List expired instances without deleting them")
+
+	return cmd
+}
+
+// reapProviders returns the cloud providers with credentials configured.
+func reapProviders(cfg api.Config) []string {
+	var names []string
+	if cfg.Providers.Linode.Token != "" {
+		names = append(names, "linode")
+	}
+	if cfg.Providers.Vultr.Token != "" {
+		names = append(names, "vultr")
+	}
+	return names
+}
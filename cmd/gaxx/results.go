@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/controller"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// runRecorder saves a run and its per-node results in the store. A nil
+// recorder records nothing.
+type runRecorder struct {
+	store *core.Store
+	id    int64
+}
+
+// startRecording records the start of a run when --record is set and
+// returns nil otherwise. module names the task module, or the command line
+// of an ad-hoc task.
+func startRecording(ctx context.Context, cmd *cobra.Command, client *api.Client, fleet string, task api.TaskSpec, reporter runReporter) (*runRecorder, error) {
+	if record, _ := cmd.Flags().GetBool("record"); !record {
+		return nil, nil
+	}
+	module, _ := cmd.Flags().GetString("module")
+	if module == "" {
+		module = redact.String(strings.Join(append([]string{task.Command}, redact.Args(task.Args)...), " "))
+	}
+	store, err := core.OpenStore(client.Config().Store.Path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := store.BeginRun(ctx, fleet, module, time.Now())
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	reporter.Notice("📒 Recording as run %d", id)
+	return &runRecorder{store: store, id: id}, nil
+}
+
+// finish stores the results and final status of the run and closes the
+// store. runErr is the error, if any, that stopped the run.
+func (r *runRecorder) finish(ctx context.Context, results []api.NodeRunResult, runErr error) error {
+	if r == nil {
+		return nil
+	}
+	defer r.store.Close()
+	return controller.FinishRun(ctx, r.store, r.id, results, runErr)
+}
+
+func newResultsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "results [run-id]",
+		Short: "Query recorded run results",
+		Long: `Without a run ID, list the most recent runs recorded with run --record or
+scan --record. With one, show that run's per-node results: exit code,
+duration, output size and the first lines of output. Filter with --node,
+--failed and --grep (which searches the recorded first lines only).`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				return err
+			}
+			output, _ := cmd.Flags().GetString("output")
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output format %q (want text or json)", output)
+			}
+			store, err := core.OpenStore(cfg.Store.Path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			ctx := context.Background()
+
+			if len(args) == 0 {
+				limit, _ := cmd.Flags().GetInt("limit")
+				runs, err := store.Runs(ctx, limit)
+				if err != nil {
+					return err
+				}
+				if output == "json" {
+					return encodeLines(runs)
+				}
+				printRuns(runs)
+				return nil
+			}
+
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run id %q", args[0])
+			}
+			run, err := store.Run(ctx, id)
+			if err != nil {
+				return err
+			}
+			var filter core.ResultFilter
+			filter.Node, _ = cmd.Flags().GetString("node")
+			filter.Failed, _ = cmd.Flags().GetBool("failed")
+			filter.Contains, _ = cmd.Flags().GetString("grep")
+			results, err := store.Results(ctx, id, filter)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return encodeLines(results)
+			}
+			showOutput, _ := cmd.Flags().GetBool("show-output")
+			printResults(run, results, showOutput)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("node", "", "Only show results from this node")
+	cmd.Flags().Bool("failed", false, "Only show executions that failed")
+	cmd.Flags().String("grep", "", "Only show results whose recorded output contains this text")
+	cmd.Flags().Bool("show-output", false, "Print the recorded first lines of each result's output")
+	cmd.Flags().Int("limit", 20, "Number of runs to list")
+	cmd.Flags().StringP("output", "o", "text", "Output format: text or json (one object per line)")
+
+	return cmd
+}
+
+func encodeLines[T any](items []T) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, it := range items {
+		if err := enc.Encode(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printRuns(runs []core.Run) {
+	if len(runs) == 0 {
+		fmt.Println("No recorded runs (use run --record or scan --record)")
+		return
+	}
+	fmt.Printf("%-6s %-15s %-10s %-20s %-10s %s\n", "ID", "FLEET", "STATUS", "STARTED", "DURATION", "MODULE")
+	fmt.Println(strings.Repeat("-", 90))
+	for _, r := range runs {
+		fmt.Printf("%-6d %-15s %-10s %-20s %-10s %s\n", r.ID, r.Fleet, r.Status,
+			r.StartedAt.Local().Format("2006-01-02 15:04:05"), runDuration(r.StartedAt, r.FinishedAt), r.Module)
+	}
+}
+
+func printResults(run core.Run, results []core.NodeResult, showOutput bool) {
+	fmt.Printf("Run %d on fleet '%s': %s (%s, %s)\n\n", run.ID, run.Fleet, run.Module, run.Status,
+		run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("%-20s %-6s %-5s %-10s %-10s %s\n", "NODE", "CHUNK", "EXIT", "DURATION", "OUTPUT", "NOTE")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, r := range results {
+		note := r.Error
+		switch {
+		case r.LimitExceeded != "":
+			note = r.LimitExceeded + " limit exceeded"
+		case r.Truncated && note == "":
+			note = "output truncated"
+		}
+		fmt.Printf("%-20s %-6d %-5d %-10s %-10s %s\n", r.Node, r.Chunk, r.ExitCode,
+			runDuration(r.StartedAt, r.FinishedAt), fmt.Sprintf("%d lines", r.OutputLines), note)
+		if showOutput && r.OutputHead != "" {
+			for _, line := range strings.Split(strings.TrimRight(r.OutputHead, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+	if len(results) == 0 {
+		fmt.Println("No matching results")
+	}
+}
+
+func runDuration(start, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return "-"
+	}
+	return end.Sub(start).Round(time.Millisecond).String()
+}
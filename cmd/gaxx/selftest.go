@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/spf13/cobra"
+)
+
+// selfCheck is one line of the selftest checklist. hint says how to fix a
+// failure.
+type selfCheck struct {
+	name   string
+	detail string
+	err    error
+	hint   string
+}
+
+func newSelftestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "selftest [--name <fleet>]",
+		Short: "Check config, SSH key, provider access and agents",
+		Long: `Check that the controller is set up: the config parses, the SSH key loads,
+known_hosts is writable, the local store opens and the provider API accepts
+the token (by listing instances). With --name, also heartbeat every agent in
+the fleet and compare their clocks with this machine's. Prints a checklist
+with a hint for each failure and exits non-zero if any check fails.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			checks := runSelfChecks(cmd, name)
+			if failed := printSelfChecks(checks); failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet whose agents to heartbeat")
+
+	return cmd
+}
+
+// printSelfChecks prints the checklist, with the hint under each failure,
+// and returns how many checks failed.
+func printSelfChecks(checks []selfCheck) int {
+	color := useColor(os.Stdout)
+	failed := 0
+	for _, c := range checks {
+		state, detail := paint(color, "PASS", colorGreen), c.detail
+		if c.err != nil {
+			failed++
+			state, detail = paint(color, "FAIL", colorRed), c.err.Error()
+		}
+		fmt.Printf("%s %-11s %s\n", pad(state, 4, color), c.name, detail)
+		if c.err != nil && c.hint != "" {
+			fmt.Printf("     %-11s → %s\n", "", c.hint)
+		}
+	}
+	return failed
+}
+
+// runSelfChecks runs the checks in order, stopping after the config check
+// fails since the others depend on it.
+func runSelfChecks(cmd *cobra.Command, fleet string) []selfCheck {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		path = providers.DefaultConfigPath()
+	}
+	config := selfCheck{name: "config", detail: path, hint: "fix the YAML, or run gaxx init to write a starter config"}
+	if _, err := os.Stat(providers.ExpandHome(path)); errors.Is(err, os.ErrNotExist) {
+		config.detail = path + " not found, using defaults"
+	}
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		config.err = err
+		return []selfCheck{config}
+	}
+	checks := []selfCheck{config}
+
+	key := selfCheck{name: "ssh key", detail: cfg.SSHKeyPath(), hint: "run gaxx init to generate a key, or set ssh.key_dir"}
+	if _, err := gssh.LoadPrivateKeySigner(cfg.SSHKeyPath()); err != nil {
+		key.err = err
+	}
+	checks = append(checks, key)
+
+	knownHosts := selfCheck{name: "known_hosts", detail: cfg.SSH.KnownHosts, hint: "check ssh.known_hosts points to a file you can write; new nodes' host keys are added to it"}
+	if err := gssh.EnsureKnownHostsFile(cfg.SSH.KnownHosts); err != nil {
+		knownHosts.err = err
+	} else if f, err := os.OpenFile(cfg.SSH.KnownHosts, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		knownHosts.err = err
+	} else {
+		f.Close()
+	}
+	checks = append(checks, knownHosts)
+
+	store := selfCheck{name: "store", detail: cfg.Store.Path, hint: "check store.path points to a writable location"}
+	if s, err := core.OpenStore(cfg.Store.Path); err != nil {
+		store.err = err
+	} else {
+		s.Close()
+	}
+	checks = append(checks, store)
+
+	client, err := newClient(cmd)
+	if err != nil {
+		return append(checks, selfCheck{name: "provider", err: err, hint: "check providers.plugins"})
+	}
+	provider := selfCheck{name: "provider", hint: fmt.Sprintf("check the providers.%s token (or its environment variable) and network access", client.ProviderName())}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	nodes, err := client.ListNodes(ctx, fleet)
+	if err != nil {
+		provider.err = err
+		return append(checks, provider)
+	}
+	provider.detail = fmt.Sprintf("%s: %d nodes", client.ProviderName(), len(nodes))
+	if fleet != "" {
+		provider.detail = fmt.Sprintf("%s: %d nodes in fleet '%s'", client.ProviderName(), len(nodes), fleet)
+	}
+	checks = append(checks, provider)
+	if fleet == "" {
+		return checks
+	}
+
+	agents := selfCheck{name: "agents", hint: fmt.Sprintf("check the agent with gaxx logs --name %s and that its port (defaults.agent_port, 8088 unless set) is reachable and GAXX_AGENT_TOKEN matches", fleet)}
+	if len(nodes) == 0 {
+		agents.err = client.ExplainEmptyFleet(ctx, fleet)
+		agents.hint = "spawn the fleet with gaxx spawn, or check the name"
+		return append(checks, agents)
+	}
+	var down []string
+	clock := selfCheck{name: "clock", hint: "sync clocks with NTP (timedatectl set-ntp true); skew breaks TLS, token expiry and run timings"}
+	var worst time.Duration
+	var worstNode string
+	for _, v := range client.AgentVersions(ctx, nodes) {
+		if v.Err != nil {
+			down = append(down, fmt.Sprintf("%s (%v)", v.Node.Name, v.Err))
+			continue
+		}
+		if skew := v.Heartbeat.ClockSkew; skew.Abs() >= worst.Abs() {
+			worst, worstNode = skew, v.Node.Name
+		}
+	}
+	if len(down) > 0 {
+		agents.err = fmt.Errorf("%d of %d agents down: %s", len(down), len(nodes), strings.Join(down, ", "))
+	} else {
+		agents.detail = fmt.Sprintf("%d of %d agents up", len(nodes), len(nodes))
+	}
+	checks = append(checks, agents)
+	if len(down) == len(nodes) {
+		return checks
+	}
+	clock.detail = fmt.Sprintf("agents within %v of this machine", worst.Abs().Round(time.Millisecond))
+	if worst.Abs() > core.MaxClockSkew {
+		clock.err = fmt.Errorf("%s is %v off this machine's clock", worstNode, worst.Round(time.Second))
+	}
+	return append(checks, clock)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/controller"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve [--listen <addr>]",
+		Short: "Serve a REST API for fleets and runs",
+		Long: `Run gaxx as a shared controller: a REST API under /v0/ to spawn, list,
+check and delete fleets and to start runs and scans, which execute in the
+background and are recorded in the local store (see gaxx results).
+
+Clients authenticate with a Bearer token or X-Auth-Token header carrying an
+API key from gaxx apikey create, whose scope limits what it may do (read,
+execute or admin), or the --token / GAXX_API_TOKEN token, which has admin
+scope. serve refuses to start with neither.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, _ := cmd.Flags().GetString("listen")
+			token, _ := cmd.Flags().GetString("token")
+			runTimeout, _ := cmd.Flags().GetDuration("run-timeout")
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := core.OpenStore(client.Config().Store.Path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			if token == "" {
+				keys, err := store.APIKeys(context.Background())
+				if err != nil {
+					return err
+				}
+				if len(keys) == 0 {
+					return fmt.Errorf("no API keys or token; create a key with gaxx apikey create <name> --scope admin, or set --token")
+				}
+			}
+			s := &controller.Server{Client: client, Store: store, Token: token, RunTimeout: runTimeout}
+			srv := &http.Server{Addr: listen, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			errc := make(chan error, 1)
+			go func() { errc <- srv.ListenAndServe() }()
+			fmt.Printf("🛰  Serving the gaxx API on http://%s/v0/\n", listen)
+
+			select {
+			case err := <-errc:
+				return err
+			case <-ctx.Done():
+			}
+			fmt.Println("Shutting down; cancelling running runs...")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			err = srv.Shutdown(shutdownCtx)
+			s.Stop()
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().String("listen", "127.0.0.1:8090", "Address to serve the API on")
+	cmd.Flags().String("token", envOr("GAXX_API_TOKEN", ""), "Token accepted with admin scope in addition to API keys (default $GAXX_API_TOKEN)")
+	cmd.Flags().String("provider", "", "Default cloud provider; requests may override it")
+	cmd.Flags().String("concurrency", "10", "Maximum concurrent executions per run, or auto to adapt to how the nodes cope")
+	cmd.Flags().Int("max-concurrency", api.DefaultMaxConcurrency, "Upper bound for --concurrency auto")
+	cmd.Flags().Duration("timeout", 0, "Per-execution timeout (defaults to defaults.timeout_seconds)")
+	cmd.Flags().String("exec-mode", "", "How commands reach nodes: agent, ssh or auto (defaults to defaults.exec_mode)")
+	cmd.Flags().Duration("run-timeout", controller.DefaultRunTimeout, "Maximum duration of a run or scan")
+
+	return cmd
+}
@@ -0,0 +1,1312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig loads the config named by the root --config flag.
+func loadConfig(cmd *cobra.Command) (providers.Config, error) {
+	path, _ := cmd.Flags().GetString("config")
+	cfg, err := providers.LoadConfig(path)
+	if err != nil {
+		return cfg, fmt.Errorf("load config: %w", err)
+	}
+	if agentTLS, _ := cmd.Flags().GetBool("agent-tls"); agentTLS {
+		cfg.Agent.TLS.Enabled = true
+	}
+	if preferIPv6, _ := cmd.Flags().GetBool("prefer-ipv6"); preferIPv6 {
+		cfg.Defaults.PreferIPv6 = true
+	}
+	return cfg, nil
+}
+
+// newClient builds an SDK client from --config and, where the command
+// defines them, --provider, --concurrency, --max-concurrency, --timeout,
+// --straggler-threshold, --speculate, --fail-fast, --schedule, --exec-mode
+// and --output-dir.
+func newClient(cmd *cobra.Command) (*api.Client, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if mode, _ := cmd.Flags().GetString("exec-mode"); mode != "" {
+		cfg.Defaults.ExecMode = mode
+	}
+	if _, err := api.ParseExecMode(cfg.Defaults.ExecMode); err != nil {
+		return nil, err
+	}
+	if err := api.LoadProviderPlugins(cfg.Providers.Plugins); err != nil {
+		return nil, err
+	}
+	client := api.NewClient(cfg)
+	if err := client.AgentConn().Err(); err != nil {
+		return nil, err
+	}
+	if noCache, _ := cmd.Flags().GetBool("no-cache"); !noCache && cfg.Store.NodeCacheSeconds > 0 {
+		client.NodeCache = storeNodeCache{path: cfg.Store.Path}
+		client.NodeCacheTTL = time.Duration(cfg.Store.NodeCacheSeconds) * time.Second
+	}
+	client.Provider, _ = cmd.Flags().GetString("provider")
+	if s, _ := cmd.Flags().GetString("concurrency"); s != "" {
+		if client.Concurrency, err = api.ParseConcurrency(s); err != nil {
+			return nil, err
+		}
+	}
+	client.MaxConcurrency, _ = cmd.Flags().GetInt("max-concurrency")
+	client.StragglerThreshold, _ = cmd.Flags().GetFloat64("straggler-threshold")
+	client.Speculate, _ = cmd.Flags().GetBool("speculate")
+	client.FailFast, _ = cmd.Flags().GetBool("fail-fast")
+	schedule, _ := cmd.Flags().GetString("schedule")
+	if client.Schedule, err = api.ParseSchedule(schedule); err != nil {
+		return nil, err
+	}
+	client.Timeout, _ = cmd.Flags().GetDuration("timeout")
+	client.OutputDir, _ = cmd.Flags().GetString("output-dir")
+	if client.Breakers != nil {
+		client.Breakers.Store = storeBreakers{path: cfg.Store.Path}
+	}
+	return client, nil
+}
+
+// storeBreakers keeps circuit breaker state in the local store, so a node
+// that one command found broken is skipped by the next.
+type storeBreakers struct{ path string }
+
+func (b storeBreakers) NodeBreakers(ctx context.Context) ([]core.NodeBreaker, error) {
+	store, err := core.OpenStore(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.NodeBreakers(ctx)
+}
+
+func (b storeBreakers) SaveNodeBreaker(ctx context.Context, nb core.NodeBreaker) error {
+	store, err := core.OpenStore(b.path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.SaveNodeBreaker(ctx, nb)
+}
+
+// nodeBreakers returns the state of each node's circuit breaker by name;
+// nodes missing from it are closed.
+func nodeBreakers(client *api.Client) map[string]core.NodeBreaker {
+	states := map[string]core.NodeBreaker{}
+	if client.Breakers != nil {
+		for _, nb := range client.Breakers.States() {
+			states[nb.Node] = nb
+		}
+	}
+	return states
+}
+
+// breakerLabel describes a breaker for the status and health tables.
+func breakerLabel(nb core.NodeBreaker) string {
+	switch {
+	case nb.State == "" || nb.State == core.BreakerClosed:
+		return string(core.BreakerClosed)
+	case nb.State == core.BreakerOpen && time.Now().Before(nb.Until):
+		return fmt.Sprintf("open %s", time.Until(nb.Until).Round(time.Second))
+	case nb.State == core.BreakerOpen:
+		return "probe due"
+	}
+	return string(nb.State)
+}
+
+// storeNodeCache keeps node listings in the local store, opening it for
+// each call so commands that never list nodes don't touch it.
+type storeNodeCache struct{ path string }
+
+func (c storeNodeCache) CachedNodes(ctx context.Context, provider, fleet string, maxAge time.Duration) ([]api.Node, bool, error) {
+	store, err := core.OpenStore(c.path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer store.Close()
+	return store.CachedNodes(ctx, provider, fleet, maxAge)
+}
+
+func (c storeNodeCache) CacheNodes(ctx context.Context, provider, fleet string, nodes []api.Node) error {
+	store, err := core.OpenStore(c.path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.CacheNodes(ctx, provider, fleet, nodes)
+}
+
+func (c storeNodeCache) InvalidateNodes(ctx context.Context, provider, fleet string) error {
+	store, err := core.OpenStore(c.path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.InvalidateNodes(ctx, provider, fleet)
+}
+
+// fleetNodes lists the nodes of a fleet, failing with a hint about the
+// provider's other fleets if there are none.
+func fleetNodes(ctx context.Context, client *api.Client, name string) ([]providers.Node, error) {
+	return client.RequireNodes(ctx, name)
+}
+
+// selectNodes narrows nodes to those matching --select.
+func selectNodes(cmd *cobra.Command, nodes []providers.Node) ([]providers.Node, error) {
+	expr, _ := cmd.Flags().GetString("select")
+	selected, err := api.SelectNodes(nodes, expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no nodes match --select %q", expr)
+	}
+	return selected, nil
+}
+
+// findNode returns the node with the given name.
+func findNode(nodes []providers.Node, name string) (providers.Node, error) {
+	return providers.FindNode(nodes, "", name)
+}
+
+func newInitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create a config file and SSH key",
+		Long:  "Write a starter config file and generate the controller's SSH keypair.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			force, _ := cmd.Flags().GetBool("force")
+			path, _ := cmd.Flags().GetString("config")
+			if path == "" {
+				path = providers.DefaultConfigPath()
+			}
+			path = providers.ExpandHome(path)
+
+			if _, err := os.Stat(path); err == nil && !force {
+				return fmt.Errorf("config %s already exists (use --force to overwrite)", path)
+			}
+
+			cfg := providers.DefaultConfig()
+			cfg.Providers.Linode.Region = "us-east"
+			cfg.Providers.Linode.Type = "g6-nanode-1"
+			cfg.Providers.Linode.Image = "linode/ubuntu22.04"
+			cfg.Providers.Vultr.Region = "ewr"
+			cfg.Providers.Vultr.Plan = "vc2-1c-1gb"
+			cfg.Providers.Vultr.OSID = "477"
+			data, err := yaml.Marshal(cfg)
+			if err != nil {
+				return fmt.Errorf("marshal config: %w", err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return fmt.Errorf("create config dir: %w", err)
+			}
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			fmt.Printf("✅ Wrote config to %s\n", path)
+
+			keyPath := cfg.SSHKeyPath()
+			if _, err := os.Stat(keyPath); err == nil && !force {
+				fmt.Printf("🔑 Using existing SSH key %s\n", keyPath)
+			} else {
+				if err := os.MkdirAll(cfg.SSH.KeyDir, 0700); err != nil {
+					return fmt.Errorf("create key dir: %w", err)
+				}
+				if _, err := gssh.GenerateEd25519Keypair(keyPath); err != nil {
+					return fmt.Errorf("generate ssh key: %w", err)
+				}
+				fmt.Printf("🔑 Generated SSH key %s\n", keyPath)
+			}
+			if err := gssh.EnsureKnownHostsFile(cfg.SSH.KnownHosts); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("force", false, "Overwrite an existing config and SSH key")
+
+	return cmd
+}
+
+func newSpawnCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spawn",
+		Short: "Create a fleet of instances",
+		Long: `Create a fleet of cloud instances for distributed task execution. With
+--ttl the instances are tagged to expire, and gaxx reap deletes them once
+they have.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			count, _ := cmd.Flags().GetInt("count")
+			name, _ := cmd.Flags().GetString("name")
+			topUp, _ := cmd.Flags().GetBool("top-up")
+			force, _ := cmd.Flags().GetBool("force")
+			keepPartial, _ := cmd.Flags().GetBool("keep-partial")
+			region, _ := cmd.Flags().GetString("region")
+			size, _ := cmd.Flags().GetString("size")
+			image, _ := cmd.Flags().GetString("image")
+			spot, _ := cmd.Flags().GetBool("spot")
+			maxPrice, _ := cmd.Flags().GetFloat64("max-price")
+			ttl, _ := cmd.Flags().GetDuration("ttl")
+			agentSum, _ := cmd.Flags().GetString("agent-sha256")
+
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			if topUp && force {
+				return fmt.Errorf("--top-up and --force are mutually exclusive")
+			}
+			if maxPrice < 0 {
+				return fmt.Errorf("--max-price must not be negative")
+			}
+			if maxPrice > 0 && !spot {
+				return fmt.Errorf("--max-price requires --spot")
+			}
+			if ttl < 0 {
+				return fmt.Errorf("--ttl must not be negative")
+			}
+			var tags []string
+			if ttl > 0 {
+				tags = append(tags, providers.ExpiryTag(time.Now().Add(ttl)))
+			}
+			policy := providers.SpawnRefuse
+			if topUp {
+				policy = providers.SpawnTopUp
+			} else if force {
+				policy = providers.SpawnForce
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Ctrl-C cancels the spawn; CreateFleet then rolls back (or, with
+			// --keep-partial, reports) the instances it already created.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+			defer cancel()
+
+			fmt.Printf("🚀 Spawning %d instances in fleet '%s' using %s...\n", count, name, client.ProviderName())
+			bar := newProgressBar(os.Stdout, "nodes ready")
+			bar.SetTotal(count)
+			fleet, err := client.Spawn(ctx, providers.CreateFleetRequest{
+				Name:        name,
+				Count:       count,
+				Region:      region,
+				Image:       image,
+				Size:        size,
+				KeepPartial: keepPartial,
+				Spot:        spot,
+				MaxPrice:    maxPrice,
+				Tags:        tags,
+				AgentSHA256: agentSum,
+				Progress: func(ready, total int) {
+					bar.SetTotal(total)
+					bar.Set(ready)
+				},
+			}, policy)
+			bar.Finish()
+			if err != nil {
+				if ctx.Err() != nil {
+					fmt.Println("⚠️  Spawn interrupted")
+				}
+				var perr *providers.PartialFleetError
+				if errors.As(err, &perr) {
+					if len(perr.Removed) > 0 {
+						fmt.Println("🗑️  Rolled back:")
+						for _, n := range perr.Removed {
+							fmt.Printf("  %s (id %s)\n", n.Name, n.ID)
+						}
+					}
+					if len(perr.Survivors) > 0 {
+						fmt.Println("⚠️  Nodes still running after the failure (delete them manually if unwanted):")
+						for _, n := range perr.Survivors {
+							fmt.Printf("  %s: %s (id %s)\n", n.Name, n.DialIP(), n.ID)
+						}
+					}
+				}
+				return fmt.Errorf("spawn fleet: %w", err)
+			}
+
+			if len(fleet.Nodes) == 0 {
+				fmt.Printf("✅ Fleet '%s' already has %d or more nodes; nothing to create\n", name, count)
+				return nil
+			}
+			fmt.Printf("✅ Created %d instances in fleet '%s':\n", len(fleet.Nodes), name)
+			for _, n := range fleet.Nodes {
+				fmt.Printf("  %s: %s\n", n.Name, n.DialIP())
+			}
+			if ttl > 0 {
+				fmt.Printf("⏳ Expires at %s; gaxx reap deletes it after that\n", time.Now().Add(ttl).Local().Format("2006-01-02 15:04"))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider (linode, vultr, localssh); defaults to providers.default")
+	cmd.Flags().Int("count", 1, "Number of instances to create")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("region", "", "Region (defaults to provider config)")
+	cmd.Flags().String("size", "", "Instance size/plan (defaults to provider config)")
+	cmd.Flags().String("image", "", "Image/OS (defaults to provider config)")
+	cmd.Flags().Bool("top-up", false, "If the fleet exists, only create enough instances to reach --count")
+	cmd.Flags().Bool("force", false, "If the fleet exists, add --count more instances anyway")
+	cmd.Flags().Bool("keep-partial", false, "Keep already-created instances if a later create fails")
+	cmd.Flags().Bool("spot", false, "Use spot/preemptible instances (fails on providers without them)")
+	cmd.Flags().Float64("max-price", 0, "Maximum hourly spot price in USD (requires --spot)")
+	cmd.Flags().Duration("ttl", 0, "Tag the instances to expire after this long (e.g. 6h) for gaxx reap")
+	cmd.Flags().String("agent-sha256", "", "SHA-256 the downloaded agent must match before it is installed (defaults to agent.sha256)")
+
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ls [fleet-name]",
+		Short: "List instances",
+		Long:  "List all instances or instances in a specific fleet.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			nodes, err := client.ListNodes(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			if len(nodes) == 0 {
+				if name != "" {
+					fmt.Printf("No instances found for fleet '%s'\n", name)
+				} else {
+					fmt.Println("No instances found")
+				}
+				return nil
+			}
+
+			fmt.Printf("%-20s %-15s %-26s %-12s %-8s\n", "NAME", "IP", "IPV6", "ID", "USER")
+			fmt.Println(strings.Repeat("-", 85))
+			for _, n := range nodes {
+				ip, ipv6 := n.IP, n.IPv6
+				if ip == "" {
+					ip = "-"
+				}
+				if ipv6 == "" {
+					ipv6 = "-"
+				}
+				fmt.Printf("%-20s %-15s %-26s %-12s %-8s\n", n.Name, ip, ipv6, n.ID, n.SSHUser)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name")
+
+	return cmd
+}
+
+func newDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete [fleet-name]",
+		Aliases: []string{"destroy"},
+		Short:   "Delete fleet",
+		Long: `Delete all instances in a fleet. Deleting every instance in the account
+requires --all. The instances to be deleted are listed and must be confirmed
+by typing the fleet name (or "yes" with --all) unless --confirm is given.
+
+Before deleting, each node's agent is drained: it stops accepting new work
+and in-flight commands get up to --drain-timeout to finish.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, _ := cmd.Flags().GetBool("all")
+			confirmed, _ := cmd.Flags().GetBool("confirm")
+			drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			switch {
+			case name == "" && !all:
+				return fmt.Errorf("fleet name is required (use --all to delete every instance)")
+			case name != "" && all:
+				return fmt.Errorf("--all cannot be combined with a fleet name")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute+drainTimeout)
+			defer cancel()
+
+			nodes, err := client.ListNodes(ctx, name)
+			if err != nil {
+				return err
+			}
+			if name != "" {
+				// Confirming "web" must not also delete "webserver-1".
+				nodes = providers.FleetNodes(nodes, name)
+			}
+			if len(nodes) == 0 {
+				if name != "" {
+					fmt.Printf("No instances found for fleet '%s'\n", name)
+				} else {
+					fmt.Println("No instances found")
+				}
+				return nil
+			}
+
+			fmt.Printf("The following %d instances will be deleted:\n", len(nodes))
+			for _, n := range nodes {
+				fmt.Printf("  %s (%s)\n", n.Name, n.DialIP())
+			}
+
+			if !confirmed {
+				want := name
+				if all {
+					want = "yes"
+				}
+				ok, err := confirmDestructive(cmd.InOrStdin(), cmd.OutOrStdout(), want)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("delete aborted")
+				}
+			}
+
+			if drainTimeout > 0 {
+				fmt.Printf("⏳ Draining %d instances (up to %v)...\n", len(nodes), drainTimeout)
+				for _, d := range client.Drain(ctx, nodes, drainTimeout) {
+					switch {
+					case d.Err != nil:
+						fmt.Printf("[%s] ⚠️  not drained: %v\n", d.Node.Name, d.Err)
+					case d.Jobs > 0:
+						fmt.Printf("[%s] ⚠️  %d commands still running at the drain timeout\n", d.Node.Name, d.Jobs)
+					}
+				}
+			}
+
+			if name != "" {
+				fmt.Printf("🗑️  Deleting fleet '%s'...\n", name)
+			} else {
+				fmt.Println("🗑️  Deleting all instances...")
+			}
+
+			if all {
+				err = client.DeleteAll(ctx)
+			} else {
+				// Delete the nodes that were confirmed, not whatever the
+				// provider now lists under the name.
+				err = client.DeleteNodes(ctx, nodes)
+			}
+			if err != nil {
+				var derr *providers.DeleteFleetError
+				if errors.As(err, &derr) {
+					fmt.Println("⚠️  Instances still running (retry the delete or remove them manually):")
+					for i, n := range derr.Failed {
+						fmt.Printf("  %s (id %s): %v\n", n.Name, n.ID, derr.Errs[i])
+					}
+					return fmt.Errorf("%d of %d instances failed to delete", len(derr.Failed), derr.Total)
+				}
+				return err
+			}
+
+			if name != "" {
+				fmt.Printf("✅ Deleted fleet '%s'\n", name)
+			} else {
+				fmt.Println("✅ Deleted all instances")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().Bool("all", false, "Delete every instance in the account, not just one fleet")
+	cmd.Flags().Bool("confirm", false, "Skip the interactive confirmation (for automation)")
+	cmd.Flags().Duration("drain-timeout", 2*time.Minute, "How long to wait for running commands to finish before deleting (0 skips draining)")
+
+	return cmd
+}
+
+// confirmDestructive prompts once for want (or "yes") and reports whether the
+// user typed it. Anything else, including EOF, is a refusal.
+func confirmDestructive(in io.Reader, out io.Writer, want string) (bool, error) {
+	fmt.Fprintf(out, "Type %q to confirm: ", want)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("read confirmation: %w", err)
+	}
+	answer := strings.TrimSpace(line)
+	return answer == want || answer == "yes", nil
+}
+
+// confirmSampleNodes is how many nodes confirmRun lists.
+const confirmSampleNodes = 5
+
+// confirmRun describes the command, or steps, about to run and the nodes
+// it runs on, then asks whether to go ahead; with yes the answer is given.
+// Anything but y or yes, including EOF, is a refusal.
+func confirmRun(in io.Reader, out io.Writer, task api.TaskSpec, nodes []api.Node, yes bool) (bool, error) {
+	commandLine := func(t api.TaskSpec) string {
+		command := strings.Join(append([]string{t.Command}, redact.Args(t.Args)...), " ")
+		if t.Shell || task.Shell {
+			command = "sh -c " + strconv.Quote(command)
+		}
+		return command
+	}
+	fmt.Fprintf(out, "About to run on %d nodes:\n", len(nodes))
+	if len(task.Steps) == 0 {
+		fmt.Fprintf(out, "  %s\n", commandLine(task))
+	}
+	for i, s := range task.Steps {
+		fmt.Fprintf(out, "  %d. %s: %s\n", i+1, s.Name, commandLine(s))
+	}
+	for i, n := range nodes {
+		if i == confirmSampleNodes {
+			fmt.Fprintf(out, "  ... and %d more\n", len(nodes)-i)
+			break
+		}
+		fmt.Fprintf(out, "  %s (%s)\n", n.Name, n.DialIP())
+	}
+	if yes {
+		return true, nil
+	}
+	fmt.Fprint(out, "Proceed? [y/N] ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// addTaskFlags registers the flags shared by run and scan.
+func addTaskFlags(cmd *cobra.Command) {
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("module", "", "Task module name (see gaxx module list), YAML file, or https:// or repo:// URL (pin with #sha256=<hex>)")
+	cmd.Flags().String("select", "", "Only use nodes matching an expression, e.g. 'tag.role==scanner && index<5'")
+	cmd.Flags().StringSlice("inputs", nil, "Input files (or literal items) to chunk across nodes")
+	cmd.Flags().StringArray("var", nil, "Module variable as key=value, substituted for ${key}")
+	cmd.Flags().StringArray("env", nil, "Environment variable as key=value")
+	cmd.Flags().String("env-file", "", "Dotenv file of environment variables (--env takes precedence)")
+	cmd.Flags().String("chunk-size", "", "Items per chunk, or auto to size chunks from the inputs and nodes (overrides the module)")
+	cmd.Flags().Int("chunks-per-node", 0, "Chunks per node with --chunk-size auto (default 4)")
+	cmd.Flags().String("concurrency", "10", "Maximum concurrent executions, or auto to adapt to how the nodes cope")
+	cmd.Flags().Int("max-concurrency", api.DefaultMaxConcurrency, "Upper bound for --concurrency auto")
+	cmd.Flags().Duration("timeout", 0, "Per-execution timeout (defaults to defaults.timeout_seconds)")
+	cmd.Flags().String("exec-mode", "", "How commands reach nodes: agent, ssh or auto (defaults to defaults.exec_mode)")
+	cmd.Flags().Float64("straggler-threshold", 0, "Flag chunks running this many times longer than the median finished chunk (0 disables)")
+	cmd.Flags().Bool("speculate", false, "Also start stragglers on the fastest idle node and keep whichever finishes first")
+	cmd.Flags().Bool("fail-fast", false, "Stop the run at the first node that fails or exits non-zero, cancelling the rest")
+	cmd.Flags().String("schedule", "static", "How chunks are spread over nodes: static (round-robin up front) or dynamic (each node takes the next chunk when it finishes one)")
+	cmd.Flags().Bool("ignore-failures", false, "Exit zero even when nodes fail or exit non-zero")
+	cmd.Flags().StringP("output", "o", "text", "Output format: text or json (one event per line)")
+	cmd.Flags().Bool("errors-only", false, "Print only failed nodes, not the output of those that succeeded")
+	cmd.Flags().Int("max-error-lines", 0, "Print at most this many stderr lines per node; 0 prints all (full output stays in --output-dir and --format files)")
+	cmd.Flags().String("template", "", "Go template printed for each finished node, e.g. '{{.NodeName}},{{.ExitCode}},{{.DurationMs}}' (fields: NodeName, IP, Chunk, ExitCode, DurationMs, Stdout, Stderr, Error, OutputFile, Step, OK; funcs: trim, json)")
+	cmd.Flags().String("template-footer", "", "Go template printed after the run (fields: Succeeded, Failed, Nodes, Interrupted, DurationMs)")
+	cmd.Flags().String("format", "", "Also write per-node results as csv or jsonl, to stdout (moving progress to stderr) or --results-file")
+	cmd.Flags().String("results-file", "", "File for --format results instead of stdout")
+	cmd.Flags().String("output-dir", "", "Save each chunk's output to <dir>/<node>-<chunk>.out instead of printing it; agents spool it to disk, so it is not cut at their in-memory limit")
+	cmd.Flags().Bool("shell", false, "Run the command line through sh -c so pipes and redirects work")
+	cmd.Flags().Bool("record", false, "Save per-node results in the local store (query with gaxx results)")
+	addVersionFlags(cmd)
+}
+
+// taskFromFlags builds the task for run/scan from --module or positional args.
+func taskFromFlags(cmd *cobra.Command, args []string, vars map[string]string) (api.TaskSpec, error) {
+	modulePath, _ := cmd.Flags().GetString("module")
+	inputs, _ := cmd.Flags().GetStringSlice("inputs")
+	envPairs, _ := cmd.Flags().GetStringArray("env")
+	envFile, _ := cmd.Flags().GetString("env-file")
+	chunkSize, _ := cmd.Flags().GetString("chunk-size")
+	chunksPerNode, _ := cmd.Flags().GetInt("chunks-per-node")
+
+	var task api.TaskSpec
+	switch {
+	case modulePath != "":
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			return task, err
+		}
+		spec, err := api.FindTaskModule(cfg, modulePath)
+		if err != nil {
+			return task, err
+		}
+		task = spec
+	case len(args) > 0:
+		task = api.TaskSpec{Name: "adhoc", Command: args[0], Args: args[1:]}
+	default:
+		return task, fmt.Errorf("a command (after --) or --module is required")
+	}
+
+	if shell, _ := cmd.Flags().GetBool("shell"); shell {
+		task.Shell = true
+	}
+	if len(inputs) > 0 {
+		task.Inputs = inputs
+	}
+	if chunkSize != "" {
+		size, err := api.ParseChunkSize(chunkSize)
+		if err != nil {
+			return task, err
+		}
+		if size != 0 {
+			task.ChunkSize = size
+		}
+	}
+	if chunksPerNode > 0 {
+		task.ChunksPerNode = chunksPerNode
+	}
+	env, err := parseKeyValues(envPairs)
+	if err != nil {
+		return task, err
+	}
+	task = task.Render(vars)
+	if err := task.CheckInputs(); err != nil {
+		return task, err
+	}
+	// Env file values are taken literally rather than rendered, so secrets
+	// containing "${" are passed through intact.
+	if envFile != "" {
+		fileEnv, err := core.LoadSecretsEnv(envFile)
+		if err != nil {
+			return task, err
+		}
+		for k, v := range fileEnv {
+			task.Env[k] = v
+		}
+	}
+	for k, v := range env {
+		task.Env[k] = v
+	}
+	return task, nil
+}
+
+func newRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run --name <fleet> [--module name|file|url] [-- command args...]",
+		Short: "Execute command on fleet",
+		Long: `Execute a command or task module across all instances in a fleet, via the
+agent with SSH fallback. Ctrl-C (or SIGTERM) stops the executions still
+running, killing their remote processes, and summarizes the partial results;
+a second Ctrl-C exits at once. The exit status is non-zero when any node
+failed or exited non-zero, unless --ignore-failures is given. Scan behaves
+the same way.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			varPairs, _ := cmd.Flags().GetStringArray("var")
+			vars, err := parseKeyValues(varPairs)
+			if err != nil {
+				return err
+			}
+			task, err := taskFromFlags(cmd, args, vars)
+			if err != nil {
+				return err
+			}
+			interactive, _ := cmd.Flags().GetBool("interactive")
+			yes, _ := cmd.Flags().GetBool("yes")
+			if interactive && !yes && !isTerminal(os.Stdin) {
+				return fmt.Errorf("--interactive needs --yes when stdin is not a terminal")
+			}
+			sink, err := newResultSink(cmd)
+			if err != nil {
+				return err
+			}
+			reporter, err := newRunReporter(cmd, sink)
+			if err != nil {
+				return err
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodes, err = selectNodes(cmd, nodes); err != nil {
+				return err
+			}
+			if err := checkAgentVersions(ctx, cmd, client, name, nodes, reporter.Notice); err != nil {
+				return err
+			}
+			if interactive {
+				ok, err := confirmRun(cmd.InOrStdin(), cmd.ErrOrStderr(), task, nodes, yes)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("run aborted")
+				}
+			}
+			rec, err := startRecording(ctx, cmd, client, name, task, reporter)
+			if err != nil {
+				return err
+			}
+
+			// Signals are only caught from here, so Ctrl-C still quits
+			// the --interactive prompt at once.
+			ctx, stop := interruptible(ctx)
+			defer stop()
+			reporter.Notice("⚡ Running '%s' on %d nodes...", task.Name, len(nodes))
+			ignoreFailures, _ := cmd.Flags().GetBool("ignore-failures")
+			return reportRun(ctx, client, reporter, rec, sink, ignoreFailures, func() ([]api.NodeRunResult, error) {
+				return client.RunNodes(ctx, nodes, &task)
+			})
+		},
+	}
+
+	addTaskFlags(cmd)
+	cmd.Flags().BoolP("interactive", "i", false, "Show the command and nodes and ask before running")
+	cmd.Flags().Bool("yes", false, "Answer the --interactive prompt with yes (required without a terminal)")
+
+	return cmd
+}
+
+func newScanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scan --name <fleet> --module <name|file|url>",
+		Short: "Run a scan module across a fleet",
+		Long: `Upload support files (wordlists, resolvers, ...) to every node, then run a
+task module with its inputs chunked across the fleet. Uploaded files are
+placed in ` + api.RemoteFilesDir + ` and can be referenced from the module as ${files}.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runScanCommand(cmd, args)
+		},
+	}
+
+	addTaskFlags(cmd)
+	cmd.Flags().StringSlice("upload", nil, "Files to upload to every node before scanning")
+
+	return cmd
+}
+
+// runScanCommand implements scan: upload support files, then run the module.
+func runScanCommand(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	modulePath, _ := cmd.Flags().GetString("module")
+	uploads, _ := cmd.Flags().GetStringSlice("upload")
+	if name == "" {
+		return fmt.Errorf("fleet name is required")
+	}
+	if modulePath == "" {
+		return fmt.Errorf("--module is required")
+	}
+
+	varPairs, _ := cmd.Flags().GetStringArray("var")
+	vars, err := parseKeyValues(varPairs)
+	if err != nil {
+		return err
+	}
+	task, err := taskFromFlags(cmd, args, vars)
+	if err != nil {
+		return err
+	}
+	sink, err := newResultSink(cmd)
+	if err != nil {
+		return err
+	}
+	reporter, err := newRunReporter(cmd, sink)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+
+	nodes, err := fleetNodes(ctx, client, name)
+	if err != nil {
+		return err
+	}
+	if nodes, err = selectNodes(cmd, nodes); err != nil {
+		return err
+	}
+	if err := checkAgentVersions(ctx, cmd, client, name, nodes, reporter.Notice); err != nil {
+		return err
+	}
+	plan, err := client.PlanUploads(ctx, nodes, uploads)
+	if err != nil {
+		return err
+	}
+	for _, u := range plan.Uploads {
+		if u.UpToDate {
+			reporter.Notice("[%s] %s skipped (up to date)", u.Node.Name, filepath.Base(u.Local))
+		}
+	}
+	if pending := plan.Pending(); len(pending) > 0 {
+		reporter.Notice("📤 Uploading %d files (%s) to %d nodes...", len(pending), formatBytes(plan.Bytes()), len(nodes))
+	}
+	rec, err := startRecording(ctx, cmd, client, name, task, reporter)
+	if err != nil {
+		return err
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	reporter.Notice("🔍 Scanning with '%s' on fleet '%s'...", task.Name, name)
+	ignoreFailures, _ := cmd.Flags().GetBool("ignore-failures")
+	return reportRun(ctx, client, reporter, rec, sink, ignoreFailures, func() ([]api.NodeRunResult, error) {
+		return client.ScanPlanned(ctx, plan, &task)
+	})
+}
+
+func newScpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scp --name <fleet> <local-path> <remote-path>",
+		Short: "Copy a file to fleet nodes",
+		Long:  "Upload a local file to every node in a fleet, or to a single node with --node.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			client.ResumeTransfers, _ = cmd.Flags().GetBool("resume")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodeName != "" {
+				n, err := findNode(nodes, nodeName)
+				if err != nil {
+					return err
+				}
+				nodes = []providers.Node{n}
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			failed := 0
+			for _, n := range nodes {
+				wg.Add(1)
+				go func(n providers.Node) {
+					defer wg.Done()
+					err := client.Upload(ctx, n, args[0], args[1])
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						failed++
+						fmt.Printf("[%s] ❌ %v\n", n.Name, err)
+						return
+					}
+					fmt.Printf("[%s] ✅ %s -> %s\n", n.Name, args[0], args[1])
+				}(n)
+			}
+			wg.Wait()
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d uploads failed", failed, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("node", "", "Only copy to this node")
+	cmd.Flags().Bool("resume", false, "Continue a partial remote file from an earlier upload")
+
+	return cmd
+}
+
+func newCollectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "collect --name <fleet> --remote <path> [--local <dir>]",
+		Short: "Download result files from fleet nodes",
+		Long: `Download a remote file, directory or glob pattern from every node in a fleet
+into per-node subdirectories of --local. With --merge, text files collected
+from all nodes are also concatenated into <local>/merged.txt.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			remote, _ := cmd.Flags().GetString("remote")
+			local, _ := cmd.Flags().GetString("local")
+			merge, _ := cmd.Flags().GetBool("merge")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			if remote == "" {
+				return fmt.Errorf("--remote is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			client.ResumeTransfers, _ = cmd.Flags().GetBool("resume")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			if nodeName != "" {
+				n, err := findNode(nodes, nodeName)
+				if err != nil {
+					return err
+				}
+				nodes = []providers.Node{n}
+			}
+
+			failed := 0
+			var collected []string
+			for _, r := range client.Collect(ctx, nodes, remote, local) {
+				if r.Err != nil {
+					failed++
+					fmt.Printf("[%s] ❌ %v\n", r.Node.Name, r.Err)
+					continue
+				}
+				fmt.Printf("[%s] ✅ %d files -> %s\n", r.Node.Name, len(r.Files), filepath.Join(local, r.Node.Name))
+				collected = append(collected, r.Files...)
+			}
+
+			if merge && len(collected) > 0 {
+				out := filepath.Join(local, "merged.txt")
+				n, err := mergeTextFiles(out, collected)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("📎 Merged %d text files into %s\n", n, out)
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d nodes failed to collect", failed, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("node", "", "Only collect from this node")
+	cmd.Flags().String("remote", "", "Remote file, directory or glob pattern (required)")
+	cmd.Flags().String("local", "./results", "Local directory; each node gets a subdirectory")
+	cmd.Flags().Bool("merge", false, "Concatenate collected text files into <local>/merged.txt")
+	cmd.Flags().Bool("resume", false, "Continue partial local files from an earlier collect")
+
+	return cmd
+}
+
+func newCpBetweenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cp-between --name <fleet> <local-path> <remote-path>",
+		Short: "Copy a file to a fleet node-to-node",
+		Long: `Upload a local file once, to a seed node, then have the nodes copy it from
+each other through their agents, each copy serving up to --fanout more per
+round. The remote path must be under /tmp/gaxx, which agents share with peers.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			seedName, _ := cmd.Flags().GetString("seed")
+			fanout, _ := cmd.Flags().GetInt("fanout")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+			seed := 0
+			if seedName != "" {
+				n, err := findNode(nodes, seedName)
+				if err != nil {
+					return err
+				}
+				for i := range nodes {
+					if nodes[i].Name == n.Name {
+						seed = i
+					}
+				}
+			}
+
+			fmt.Printf("📤 Uploading %s to seed %s, then peer-to-peer to %d nodes...\n", args[0], nodes[seed].Name, len(nodes)-1)
+			results, err := client.Distribute(ctx, nodes, seed, args[0], args[1], fanout)
+			if err != nil {
+				return err
+			}
+			failed := 0
+			for _, r := range results {
+				from := "controller"
+				if r.Source != "" {
+					from = r.Source
+				}
+				if r.Err != nil {
+					failed++
+					fmt.Printf("[%s] ❌ %v\n", r.Node.Name, r.Err)
+					continue
+				}
+				fmt.Printf("[%s] ✅ from %s\n", r.Node.Name, from)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d nodes failed to receive the file", failed, len(nodes))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("seed", "", "Node to upload to first; defaults to the first node")
+	cmd.Flags().Int("fanout", api.DefaultFanout, "Peers each node serves per round")
+
+	return cmd
+}
+
+// mergeTextFiles concatenates files into dst, skipping any that look binary
+// (a NUL byte in the first 8 KiB), and returns how many were merged.
+func mergeTextFiles(dst string, files []string) (int, error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, fmt.Errorf("create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	merged := 0
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return merged, err
+		}
+		head := data
+		if len(head) > 8192 {
+			head = head[:8192]
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			continue
+		}
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		if _, err := out.Write(data); err != nil {
+			return merged, fmt.Errorf("write %s: %w", dst, err)
+		}
+		merged++
+	}
+	return merged, out.Close()
+}
+
+func newSSHCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ssh --name <fleet> --node <node> [-- command args...]",
+		Short: "Open a shell or run a command on one node",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			nodeName, _ := cmd.Flags().GetString("node")
+			if name == "" || nodeName == "" {
+				return fmt.Errorf("--name and --node are required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			node, err := client.GetNode(ctx, name, nodeName)
+			if err != nil {
+				return err
+			}
+
+			c, err := core.SSHClientFor(client.Config(), node)
+			if err != nil {
+				return err
+			}
+			cli, err := gssh.Dial(ctx, c)
+			if err != nil {
+				return fmt.Errorf("ssh dial: %w", err)
+			}
+			defer cli.Close()
+			session, err := gssh.NewSession(ctx, cli)
+			if err != nil {
+				return fmt.Errorf("ssh session: %w", err)
+			}
+			defer session.Close()
+
+			session.Stdin = os.Stdin
+			session.Stdout = os.Stdout
+			session.Stderr = os.Stderr
+			if len(args) > 0 {
+				return session.Run(core.ShellJoin(args[0], args[1:]...))
+			}
+			if err := session.RequestPty(envOr("TERM", "xterm"), 40, 120, nil); err != nil {
+				return fmt.Errorf("request pty: %w", err)
+			}
+			if err := session.Shell(); err != nil {
+				return fmt.Errorf("start shell: %w", err)
+			}
+			return session.Wait()
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("node", "", "Node name (required)")
+
+	return cmd
+}
+
+func newStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status --name <fleet>",
+		Short: "Show agent status for a fleet",
+		Long:  "Heartbeat the gaxx-agent on every node in a fleet and report which are reachable, which run a different version from the rest, how long they have been up and how many commands they are running, and which have an open circuit breaker.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+
+			versions := client.AgentVersions(ctx, nodes)
+			recordAgentVersions(ctx, client, name, versions)
+			expected, _ := cmd.Flags().GetString("expect-version")
+			want, skewed := api.VersionSkew(versions, expected)
+			skew := make(map[string]bool, len(skewed))
+			for _, v := range skewed {
+				skew[v.Node.Name] = true
+			}
+
+			breakers := nodeBreakers(client)
+			fmt.Printf("%-20s %-15s %-8s %-16s %-10s %-5s %s\n", "NAME", "IP", "AGENT", "VERSION", "UPTIME", "JOBS", "BREAKER")
+			fmt.Println(strings.Repeat("-", 89))
+			for _, v := range versions {
+				state, version, uptime, jobs := "up", v.Version, "-", "-"
+				if v.Err != nil {
+					state, version = "down", "-"
+				} else {
+					jobs = strconv.Itoa(v.Heartbeat.ActiveJobs)
+					// Agents that predate uptime in heartbeats report none.
+					if v.Heartbeat.UptimeSeconds > 0 {
+						uptime = (time.Duration(v.Heartbeat.UptimeSeconds) * time.Second).String()
+					}
+				}
+				if skew[v.Node.Name] {
+					version += " (skew)"
+				}
+				fmt.Printf("%-20s %-15s %-8s %-16s %-10s %-5s %s\n", v.Node.Name, v.Node.DialIP(), state, version, uptime, jobs, breakerLabel(breakers[v.Node.Name]))
+			}
+			if len(skewed) > 0 {
+				fmt.Printf("\n⚠️  %d of %d agents are not at version %s\n", len(skewed), len(nodes), want)
+				if strict, _ := cmd.Flags().GetBool("strict-version"); strict {
+					return fmt.Errorf("agent version skew")
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	addVersionFlags(cmd)
+
+	return cmd
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func newTopCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "top --name <fleet>",
+		Short: "Show the busiest nodes in a fleet",
+		Long: `Poll every node's agent for CPU, memory, load and running commands and
+show them sorted, busiest first, refreshing until interrupted. Use it during
+large scans to spot CPU- or memory-bound nodes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			sortBy, _ := cmd.Flags().GetString("sort")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			iterations, _ := cmd.Flags().GetInt("iterations")
+			if name == "" {
+				return fmt.Errorf("fleet name is required")
+			}
+			less, err := sysInfoOrder(sortBy)
+			if err != nil {
+				return err
+			}
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive")
+			}
+
+			client, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			nodes, err := fleetNodes(ctx, client, name)
+			if err != nil {
+				return err
+			}
+
+			redraw := useColor(os.Stdout)
+			for i := 0; iterations <= 0 || i < iterations; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-time.After(interval):
+					}
+				}
+				pollCtx, cancel := context.WithTimeout(ctx, interval+5*time.Second)
+				infos := client.SysInfo(pollCtx, nodes)
+				cancel()
+				if ctx.Err() != nil {
+					return nil
+				}
+				sort.SliceStable(infos, func(i, j int) bool { return less(infos[i], infos[j]) })
+				if redraw {
+					fmt.Print("\033[H\033[2J")
+				}
+				printTop(name, sortBy, infos)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("provider", "", "Cloud provider; defaults to providers.default")
+	cmd.Flags().String("name", "", "Fleet name (required)")
+	cmd.Flags().String("sort", "cpu", "Sort by cpu, mem or load")
+	cmd.Flags().Duration("interval", 2*time.Second, "Time between refreshes")
+	cmd.Flags().Int("iterations", 0, "Refresh this many times, then exit (0 runs until interrupted)")
+
+	return cmd
+}
+
+// sysInfoOrder returns the busiest-first ordering for --sort. Unreachable
+// nodes sort last.
+func sysInfoOrder(sortBy string) (func(a, b api.NodeSysInfo) bool, error) {
+	var key func(api.SysInfoResponse) float64
+	switch sortBy {
+	case "cpu":
+		key = func(s api.SysInfoResponse) float64 { return s.CPUPercent }
+	case "mem":
+		key = func(s api.SysInfoResponse) float64 { return s.MemPercent }
+	case "load":
+		key = func(s api.SysInfoResponse) float64 { return s.Load1 }
+	default:
+		return nil, fmt.Errorf("unknown sort %q (want cpu, mem or load)", sortBy)
+	}
+	return func(a, b api.NodeSysInfo) bool {
+		if (a.Err == nil) != (b.Err == nil) {
+			return a.Err == nil
+		}
+		return key(a.Info) > key(b.Info)
+	}, nil
+}
+
+func printTop(fleet, sortBy string, infos []api.NodeSysInfo) {
+	fmt.Printf("gaxx top - %s - %d nodes - sorted by %s - %s\n\n", fleet, len(infos), sortBy, time.Now().Format("15:04:05"))
+	fmt.Printf("%-20s %-15s %6s %6s %6s %5s  %s\n", "NAME", "IP", "CPU%", "MEM%", "LOAD", "JOBS", "CURRENT JOB")
+	fmt.Println(strings.Repeat("-", 90))
+	for _, s := range infos {
+		if s.Err != nil {
+			fmt.Printf("%-20s %-15s %6s %6s %6s %5s  %v\n", s.Node.Name, s.Node.DialIP(), "-", "-", "-", "-", s.Err)
+			continue
+		}
+		fmt.Printf("%-20s %-15s %6.1f %6.1f %6.2f %5d  %s\n", s.Node.Name, s.Node.DialIP(),
+			s.Info.CPUPercent, s.Info.MemPercent, s.Info.Load1, len(s.Info.Jobs), currentJob(s.Info.Jobs))
+	}
+}
+
+// currentJob describes the oldest running command, truncated to fit a line.
+func currentJob(jobs []api.RunningJob) string {
+	if len(jobs) == 0 {
+		return "-"
+	}
+	j := jobs[0]
+	line := strings.TrimSpace(j.Command + " " + strings.Join(j.Args, " "))
+	if len(line) > 40 {
+		line = line[:37] + "..."
+	}
+	return fmt.Sprintf("%s (%s)", line, time.Since(j.StartedAt).Round(time.Second))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// addVersionFlags adds the agent version skew flags.
+func addVersionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("expect-version", "", "Agent version nodes should run (default: the fleet's most common version)")
+	cmd.Flags().Bool("strict-version", false, "Refuse to run when any agent's version differs")
+}
+
+// checkAgentVersions heartbeats every node, records the versions in the
+// store and warns through notice about agents that differ from
+// --expect-version. With --strict-version, skew is an error.
+func checkAgentVersions(ctx context.Context, cmd *cobra.Command, client *api.Client, fleet string, nodes []api.Node, notice func(string, ...any)) error {
+	expected, _ := cmd.Flags().GetString("expect-version")
+	strict, _ := cmd.Flags().GetBool("strict-version")
+
+	versions := client.AgentVersions(ctx, nodes)
+	recordAgentVersions(ctx, client, fleet, versions)
+	want, skewed := api.VersionSkew(versions, expected)
+	if len(skewed) == 0 {
+		return nil
+	}
+	for _, v := range skewed {
+		notice("⚠️  [%s] agent version %s, expected %s", v.Node.Name, v.Version, want)
+	}
+	if strict {
+		return fmt.Errorf("%d of %d agents are not at version %s", len(skewed), len(nodes), want)
+	}
+	return nil
+}
+
+// recordAgentVersions saves the versions of the agents that answered. The
+// store is bookkeeping, so failures are reported but not returned.
+func recordAgentVersions(ctx context.Context, client *api.Client, fleet string, versions []api.AgentVersion) {
+	var seen []core.NodeVersion
+	now := time.Now()
+	for _, v := range versions {
+		if v.Err == nil {
+			seen = append(seen, core.NodeVersion{Node: v.Node.Name, IP: v.Node.DialIP(), Version: v.Version, SeenAt: now})
+		}
+	}
+	if len(seen) == 0 {
+		return
+	}
+	store, err := core.OpenStore(client.Config().Store.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return
+	}
+	defer store.Close()
+	if err := store.RecordAgentVersions(ctx, fleet, seen); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
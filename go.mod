@@ -3,10 +3,13 @@ module github.com/3cpo-dev/gaxx
 go 1.21
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/pkg/sftp v1.13.9
 	github.com/rs/zerolog v1.33.0
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,5 +18,4 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.28.0 // indirect
 )
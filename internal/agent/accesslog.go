@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/rs/zerolog"
+)
+
+// OpenAccessLog resolves an access log destination: "stderr" (or empty),
+// "stdout", "off", or a file path opened for appending. The returned close
+// function is always safe to call.
+func OpenAccessLog(dest string) (io.Writer, func() error, error) {
+	noop := func() error { return nil }
+	switch dest {
+	case "", "stderr":
+		return os.Stderr, noop, nil
+	case "stdout":
+		return os.Stdout, noop, nil
+	case "off":
+		return nil, noop, nil
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, noop, fmt.Errorf("open access log: %w", err)
+	}
+	return f, f.Close, nil
+}
+
+type accessKey struct{}
+
+// accessEntry carries the fields handlers add to the request's access log
+// line.
+type accessEntry struct {
+	command  string
+	args     []string
+	exitCode *int
+}
+
+// noteCommand records the command line of an exec request for the access
+// log. It is a no-op when access logging is off.
+func noteCommand(r *http.Request, req ExecRequest) {
+	if e, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+		e.command = req.Command
+		e.args = req.Args
+	}
+}
+
+// noteExec records the command line and exit code of an exec request.
+func noteExec(r *http.Request, req ExecRequest, exitCode int) {
+	noteCommand(r, req)
+	if e, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+		e.exitCode = &exitCode
+	}
+}
+
+// statusRecorder captures the status code a handler writes.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses.
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// AccessLogMiddleware writes one JSON line per request to w with the remote
+// address, endpoint, status and duration, plus the command (with secrets
+// redacted) and exit code for exec requests. A nil w disables logging.
+func AccessLogMiddleware(w io.Writer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if w == nil {
+			return next
+		}
+		logger := zerolog.New(w).With().Timestamp().Logger()
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			entry := &accessEntry{}
+			rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+
+			ev := logger.Info().
+				Str("remote_addr", r.RemoteAddr).
+				Str("method", r.Method).
+				Str("endpoint", r.URL.Path).
+				Int("status", rec.status).
+				Dur("duration", time.Since(start))
+			if subject := r.Header.Get("X-Client-Subject"); subject != "" {
+				ev = ev.Str("client_subject", subject)
+			}
+			if entry.command != "" {
+				ev = ev.Str("command", entry.command).Strs("args", redact.Args(entry.args))
+			}
+			if entry.exitCode != nil {
+				ev = ev.Int("exit_code", *entry.exitCode)
+			}
+			ev.Msg("access")
+		})
+	}
+}
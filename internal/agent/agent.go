@@ -2,11 +2,16 @@ package agent
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/3cpo-dev/gaxx/internal/telemetry"
@@ -14,7 +19,51 @@ import (
 
 type Server struct {
 	Version string
-	srv     *http.Server
+	// MaxOutput caps the bytes of output returned per exec; 0 means
+	// DefaultMaxOutput.
+	MaxOutput int
+	// AccessLog receives one JSON line per request; nil disables it.
+	AccessLog io.Writer
+	// Policy limits which commands exec may run; nil allows any.
+	Policy *CommandPolicy
+	// Limits apply to every command; requests may tighten them.
+	Limits ResourceLimits
+	// ShareDir is served to peers on /v0/files; empty disables it.
+	ShareDir string
+	// SpoolDir holds output of exec requests that ask for it to be
+	// spooled, served on /v0/output; empty means a directory under the
+	// system temp dir. MaxSpool caps each exec's spooled output; 0 means
+	// DefaultMaxSpool.
+	SpoolDir string
+	MaxSpool int64
+	srv      *http.Server
+	jobs     jobTracker
+	draining atomic.Bool
+}
+
+// agentStart is when the agent process started, for heartbeat uptime.
+var agentStart = time.Now()
+
+// RequireToken wraps next with the agent's optional token auth: when
+// GAXX_AGENT_TOKEN is set, requests must carry it as a Bearer token or in
+// X-Auth-Token.
+func RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
+			bearer, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !(isBearer && tokenMatches(bearer, tok)) && !tokenMatches(r.Header.Get("X-Auth-Token"), tok) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenMatches compares in constant time, so response timing doesn't give
+// the token away a byte at a time.
+func tokenMatches(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
 }
 
 // Routes for the server
@@ -29,7 +78,15 @@ func (s *Server) routes(mux *http.ServeMux) {
 			"endpoint":  "heartbeat",
 		})
 
-		h := HeartbeatResponse{Time: time.Now(), Host: r.Host, Version: s.Version}
+		h := HeartbeatResponse{
+			Time:          time.Now(),
+			Host:          r.Host,
+			Version:       s.Version,
+			UptimeSeconds: int64(time.Since(agentStart).Seconds()),
+			GoVersion:     runtime.Version(),
+			Goroutines:    runtime.NumGoroutine(),
+			ActiveJobs:    s.jobs.count(),
+		}
 		_ = json.NewEncoder(w).Encode(h)
 
 		telemetry.TimerGlobal("gaxx_agent_request_duration", time.Since(start), map[string]string{
@@ -38,20 +95,24 @@ func (s *Server) routes(mux *http.ServeMux) {
 			"status":    "200",
 		})
 	})
-	mux.HandleFunc("/v0/exec", func(w http.ResponseWriter, r *http.Request) {
-		// Optional token-based auth via env var
-		if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
-			auth := r.Header.Get("Authorization")
-			x := r.Header.Get("X-Auth-Token")
-			if auth != "Bearer "+tok && x != tok {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
-
+	mux.Handle("/v0/files", RequireToken(http.HandlerFunc(s.serveShared)))
+	mux.Handle("/v0/sysinfo", RequireToken(http.HandlerFunc(s.serveSysInfo)))
+	mux.Handle("/v0/drain", RequireToken(http.HandlerFunc(s.serveDrain)))
+	mux.Handle("/v0/output/", RequireToken(http.HandlerFunc(s.serveOutput)))
+	mux.Handle("/v0/exec", RequireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestStart := time.Now()
 		defer r.Body.Close()
 
+		if s.draining.Load() {
+			telemetry.CounterGlobal("gaxx_agent_exec_errors", 1, map[string]string{
+				"component": "agent",
+				"endpoint":  "exec",
+				"error":     "draining",
+			})
+			http.Error(w, "agent is draining", http.StatusServiceUnavailable)
+			return
+		}
+
 		var req ExecRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			telemetry.CounterGlobal("gaxx_agent_exec_errors", 1, map[string]string{
@@ -63,6 +124,17 @@ func (s *Server) routes(mux *http.ServeMux) {
 			return
 		}
 
+		if err := s.Policy.CheckRequest(&req); err != nil {
+			telemetry.CounterGlobal("gaxx_agent_exec_errors", 1, map[string]string{
+				"component": "agent",
+				"endpoint":  "exec",
+				"error":     "command_forbidden",
+			})
+			noteCommand(r, req)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		// Record exec request
 		telemetry.CounterGlobal("gaxx_agent_exec_requests", 1, map[string]string{
 			"component": "agent",
@@ -70,6 +142,8 @@ func (s *Server) routes(mux *http.ServeMux) {
 			"command":   req.Command,
 		})
 
+		defer s.jobs.begin(req)()
+
 		ctx := r.Context()
 		if req.Timeout > 0 {
 			var cancel context.CancelFunc
@@ -81,15 +155,75 @@ func (s *Server) routes(mux *http.ServeMux) {
 		if req.WorkDir != "" {
 			cmd.Dir = req.WorkDir
 		}
-		if len(req.Env) > 0 {
-			cmd.Env = append(cmd.Env, req.Env...)
+		cmd.Env = execEnv(req)
+		if req.Input != "" {
+			cmd.Stdin = strings.NewReader(req.Input)
 		}
 
+		maxOutput := s.MaxOutput
+		if maxOutput <= 0 {
+			maxOutput = DefaultMaxOutput
+		}
+		out := &LimitedBuffer{Max: maxOutput}
+		cmd.Stdout = out
+		cmd.Stderr = out
+		var stream *frameWriter
+		if req.Stream && !req.Spool {
+			stream = newFrameWriter(w, maxOutput)
+			// One writer for both, so exec copies them in one goroutine.
+			cmd.Stdout = io.MultiWriter(out, stream)
+			cmd.Stderr = cmd.Stdout
+		}
+		var spool *spoolFile
+		var spoolID string
+		if req.Spool {
+			var err error
+			if spool, spoolID, err = s.newSpool(); err != nil {
+				telemetry.CounterGlobal("gaxx_agent_exec_errors", 1, map[string]string{
+					"component": "agent",
+					"endpoint":  "exec",
+					"error":     "spool",
+				})
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer spool.Close()
+			cmd.Stdout = spool
+			cmd.Stderr = spool
+		}
+
+		limits := s.Limits.Tighten(req.Limits)
+		if !limits.IsZero() {
+			if lerr := limitCommand(cmd, limits); lerr != nil {
+				telemetry.CounterGlobal("gaxx_agent_exec_errors", 1, map[string]string{
+					"component": "agent",
+					"endpoint":  "exec",
+					"error":     "apply_limits",
+				})
+				http.Error(w, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		execStart := time.Now()
-		out, err := cmd.CombinedOutput()
+		err := cmd.Start()
+		if err == nil {
+			if stream != nil {
+				stream.start()
+			}
+			err = cmd.Wait()
+		}
 		execDuration := time.Since(execStart)
 
-		resp := ExecResponse{Stdout: string(out), Stderr: "", Duration: execDuration.Milliseconds()}
+		resp := ExecResponse{Stdout: out.String(), Stderr: "", Duration: execDuration.Milliseconds(), Truncated: out.Truncated()}
+		if spool != nil {
+			if serr := spool.Close(); serr != nil && err == nil {
+				err = fmt.Errorf("spool output: %w", serr)
+			}
+			resp.OutputID, resp.OutputSize, resp.Truncated = spoolID, spool.Len(), spool.Truncated()
+		}
+		if ctx.Err() == nil {
+			resp.LimitExceeded = LimitForSignal(exitSignal(cmd), limits)
+		}
 		status := "success"
 
 		if err != nil {
@@ -110,8 +244,24 @@ func (s *Server) routes(mux *http.ServeMux) {
 		}
 
 		telemetry.TimerGlobal("gaxx_agent_exec_duration", execDuration, labels)
+		// Quantiles are tracked per endpoint; per-command windows would grow
+		// without bound.
+		telemetry.SummaryGlobal("gaxx_agent_exec_latency_ms", float64(execDuration.Milliseconds()), map[string]string{
+			"component": "agent",
+			"endpoint":  "exec",
+		})
 		telemetry.TimerGlobal("gaxx_agent_request_duration", time.Since(requestStart), labels)
-		telemetry.HistogramGlobal("gaxx_agent_exec_output_size", float64(len(out)), labels)
+		telemetry.HistogramGlobal("gaxx_agent_exec_output_size", float64(out.Len()), labels)
+		if resp.Truncated {
+			telemetry.CounterGlobal("gaxx_agent_exec_output_truncated", 1, labels)
+		}
+		if resp.LimitExceeded != "" {
+			telemetry.CounterGlobal("gaxx_agent_exec_limit_exceeded", 1, map[string]string{
+				"component": "agent",
+				"endpoint":  "exec",
+				"limit":     resp.LimitExceeded,
+			})
+		}
 
 		if status == "success" {
 			telemetry.CounterGlobal("gaxx_agent_exec_successful", 1, labels)
@@ -119,8 +269,13 @@ func (s *Server) routes(mux *http.ServeMux) {
 			telemetry.CounterGlobal("gaxx_agent_exec_failed", 1, labels)
 		}
 
-		_ = json.NewEncoder(w).Encode(resp)
-	})
+		noteExec(r, req, resp.ExitCode)
+		if stream != nil {
+			stream.finish(resp)
+			return
+		}
+		_ = writeJSON(w, r, resp)
+	})))
 }
 
 // ListenAndServe starts the server with optional TLS/mTLS
@@ -135,7 +290,7 @@ func (s *Server) ListenAndServe(addr string) error {
 	// Fallback to plain HTTP
 	mux := http.NewServeMux()
 	s.routes(mux)
-	s.srv = &http.Server{Addr: addr, Handler: mux}
+	s.srv = &http.Server{Addr: addr, Handler: AccessLogMiddleware(s.AccessLog)(mux)}
 	return s.srv.ListenAndServe()
 }
 
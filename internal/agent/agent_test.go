@@ -3,13 +3,25 @@ package agent
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 // TestHeartbeat tests the heartbeat endpoint
 func TestHeartbeat(t *testing.T) {
+	defer func(t time.Time) { agentStart = t }(agentStart)
+	agentStart = time.Now().Add(-time.Hour)
 	srv := &Server{Version: "test"}
 	mux := http.NewServeMux()
 	srv.routes(mux)
@@ -26,6 +38,14 @@ func TestHeartbeat(t *testing.T) {
 	if resp.Version != "test" {
 		t.Fatalf("version mismatch")
 	}
+	if resp.GoVersion != runtime.Version() || resp.Goroutines < 1 || resp.UptimeSeconds < 3600 || resp.ActiveJobs != 0 {
+		t.Fatalf("runtime fields = %+v", resp)
+	}
+	for _, field := range []string{`"uptime_seconds"`, `"go_version"`, `"goroutines"`, `"active_jobs"`} {
+		if !strings.Contains(rr.Body.String(), field) {
+			t.Errorf("heartbeat JSON lacks %s: %s", field, rr.Body.String())
+		}
+	}
 }
 
 // TestExec tests the exec endpoint
@@ -51,3 +71,555 @@ func TestExec(t *testing.T) {
 		t.Fatalf("expected stdout")
 	}
 }
+
+// TestExecEnv tests that commands inherit the agent's environment, with
+// the request's on top, unless the request says otherwise, and always get
+// a PATH.
+func TestExecEnv(t *testing.T) {
+	t.Setenv("GAXX_TEST_INHERITED", "agent")
+	t.Setenv("GAXX_AGENT_TOKEN", "s3cret")
+	t.Setenv("GAXX_AGENT_TLS_KEY", "/etc/gaxx/agent.key")
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	run := func(req ExecRequest) string {
+		t.Helper()
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		hreq := httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body))
+		hreq.Header.Set("X-Auth-Token", "s3cret")
+		mux.ServeHTTP(rr, hreq)
+		var resp ExecResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.ExitCode != 0 {
+			t.Fatalf("exec: %v %+v", err, resp)
+		}
+		return strings.TrimSpace(resp.Stdout)
+	}
+	script := []string{"-c", `echo "$GAXX_TEST_INHERITED,$GAXX_TEST_GIVEN,$PATH"`}
+	// The agent's token and TLS settings must not leak to commands.
+	if got := run(ExecRequest{Command: "sh", Args: []string{"-c", "env | grep ^GAXX_AGENT_ || true"}}); got != "" {
+		t.Errorf("agent settings inherited: %q", got)
+	}
+	path := os.Getenv("PATH")
+
+	if got, want := run(ExecRequest{Command: "sh", Args: script, Env: []string{"GAXX_TEST_GIVEN=req"}}), "agent,req,"+path; got != want {
+		t.Errorf("inherited env = %q, want %q", got, want)
+	}
+	inherit := false
+	if got, want := run(ExecRequest{Command: "sh", Args: script, Env: []string{"GAXX_TEST_GIVEN=req"}, InheritEnv: &inherit}), ",req,"+DefaultPath; got != want {
+		t.Errorf("bare env = %q, want %q", got, want)
+	}
+}
+
+// TestExecCompressed tests that large exec responses are gzipped for
+// clients that accept it and decoded transparently by Go's client.
+func TestExecCompressed(t *testing.T) {
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	body, _ := json.Marshal(ExecRequest{Command: "sh", Args: []string{"-c", "yes scan-result | head -n 500"}})
+
+	httpResp, err := http.Post(ts.URL+"/v0/exec", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	var resp ExecResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !httpResp.Uncompressed || resp.Stdout != strings.Repeat("scan-result\n", 500) {
+		t.Errorf("uncompressed=%v stdout=%d bytes", httpResp.Uncompressed, len(resp.Stdout))
+	}
+
+	for accept, want := range map[string]string{"": "", "gzip;q=0, deflate": "deflate", "br, GZIP": "gzip"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body))
+		req.Header.Set("Accept-Encoding", accept)
+		mux.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", accept, got, want)
+		}
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader([]byte(`{"command":"true"}`)))
+	req.Header.Set("Accept-Encoding", "gzip")
+	mux.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("small response encoded as %q", got)
+	}
+}
+
+// TestExecStream tests that a streamed exec sends output while the command
+// is still running, through the access log, and ends with the result.
+func TestExecStream(t *testing.T) {
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	ts := httptest.NewServer(AccessLogMiddleware(io.Discard)(mux))
+	defer ts.Close()
+	release := filepath.Join(t.TempDir(), "release")
+	script := `echo one; while [ ! -e "$0" ]; do sleep 0.05; done; echo two >&2; exit 3`
+	body, _ := json.Marshal(ExecRequest{Command: "sh", Args: []string{"-c", script, release}, Timeout: 10, Stream: true})
+
+	httpResp, err := http.Post(ts.URL+"/v0/exec", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if ct := httpResp.Header.Get("Content-Type"); ct != StreamContentType {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	dec := json.NewDecoder(httpResp.Body)
+	var frame ExecFrame
+	// The command waits for this frame to be read before it finishes.
+	if err := dec.Decode(&frame); err != nil || frame.Output != "one\n" || frame.Result != nil {
+		t.Fatalf("first frame = %+v, %v", frame, err)
+	}
+	if err := os.WriteFile(release, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	for frame.Result == nil {
+		frame = ExecFrame{}
+		if err := dec.Decode(&frame); err != nil {
+			t.Fatalf("read frames: %v (output so far %q)", err, out)
+		}
+		out += frame.Output
+	}
+	if out != "two\n" || frame.Result.ExitCode != 3 || frame.Result.Stdout != "" {
+		t.Errorf("output %q, result %+v", out, frame.Result)
+	}
+}
+
+// TestFrameWriterUTF8 tests that a multibyte rune split across writes, or
+// across the start of the response, reaches the client whole
+func TestFrameWriterUTF8(t *testing.T) {
+	rr := httptest.NewRecorder()
+	f := newFrameWriter(rr, 1<<20)
+	f.Write([]byte("caf\xc3"))
+	f.start()
+	f.Write([]byte("\xa9 \xe2\x82"))
+	f.Write([]byte("\xac 1\xf0\x9f"))
+	f.Write([]byte("\x98\x80"))
+	f.finish(ExecResponse{ExitCode: 0})
+
+	var out string
+	dec := json.NewDecoder(rr.Body)
+	for {
+		var frame ExecFrame
+		if err := dec.Decode(&frame); err != nil {
+			t.Fatalf("decode: %v (output so far %q)", err, out)
+		}
+		if strings.ContainsRune(frame.Output, utf8.RuneError) {
+			t.Errorf("frame %q has a replacement character", frame.Output)
+		}
+		out += frame.Output
+		if frame.Result != nil {
+			break
+		}
+	}
+	if out != "café € 1😀" {
+		t.Errorf("output = %q", out)
+	}
+}
+
+// TestRequireToken tests the token check shared by exec and profiling
+func TestRequireToken(t *testing.T) {
+	h := RequireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Setenv("GAXX_AGENT_TOKEN", "s3cret")
+	for _, tc := range []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"Authorization", "Bearer s3cre", http.StatusUnauthorized},
+		{"Authorization", "s3cret", http.StatusUnauthorized},
+		{"X-Auth-Token", "", http.StatusUnauthorized},
+		{"Authorization", "Bearer s3cret", http.StatusOK},
+		{"X-Auth-Token", "s3cret", http.StatusOK},
+	} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s %q: status %d, want %d", tc.header, tc.value, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestExecTruncatesOutput tests that output past MaxOutput is cut and flagged
+func TestExecTruncatesOutput(t *testing.T) {
+	srv := &Server{Version: "test", MaxOutput: 8}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	body, _ := json.Marshal(ExecRequest{Command: "echo", Args: []string{"0123456789abcdef"}})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body))
+	mux.ServeHTTP(rr, req)
+	var resp ExecResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Stdout != "01234567" || !resp.Truncated || resp.ExitCode != 0 {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+// TestExecSpool tests that spooled output is written to disk, capped at
+// MaxSpool, and served in ranges on /v0/output until deleted.
+func TestExecSpool(t *testing.T) {
+	srv := &Server{Version: "test", SpoolDir: t.TempDir(), MaxSpool: 12, MaxOutput: 4}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	body, _ := json.Marshal(ExecRequest{Command: "echo", Args: []string{"0123456789abcdef"}, Spool: true})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+	var resp ExecResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Stdout != "" || resp.OutputID == "" || resp.OutputSize != 12 || !resp.Truncated {
+		t.Fatalf("got %+v", resp)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v0/output/"+resp.OutputID, nil)
+	req.Header.Set("Range", "bytes=4-9")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "456789" {
+		t.Fatalf("range: status %d, body %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v0/output/"+resp.OutputID, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", rr.Code)
+	}
+	for _, id := range []string{resp.OutputID, "0123456789abcdef0123456789abcdeg", "0123"} {
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v0/output/"+id, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", id, rr.Code)
+		}
+	}
+}
+
+// TestAccessLog tests that exec requests are logged with command and exit code
+func TestAccessLog(t *testing.T) {
+	var logBuf bytes.Buffer
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	h := AccessLogMiddleware(&logBuf)(mux)
+	body, _ := json.Marshal(ExecRequest{Command: "sh", Args: []string{"-c", "API_TOKEN=hunter2; exit 3"}})
+	req := httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if strings.Contains(logBuf.String(), "hunter2") {
+		t.Errorf("access log leaks the token: %s", logBuf.String())
+	}
+
+	var entry struct {
+		RemoteAddr string   `json:"remote_addr"`
+		Endpoint   string   `json:"endpoint"`
+		Status     int      `json:"status"`
+		Command    string   `json:"command"`
+		Args       []string `json:"args"`
+		ExitCode   *int     `json:"exit_code"`
+	}
+	if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal %q: %v", logBuf.String(), err)
+	}
+	if entry.Endpoint != "/v0/exec" || entry.Command != "sh" || len(entry.Args) != 2 || entry.Args[1] != "API_TOKEN=***; exit 3" ||
+		entry.ExitCode == nil || *entry.ExitCode != 3 || entry.RemoteAddr == "" || entry.Status != 200 {
+		t.Fatalf("got %+v", entry)
+	}
+}
+
+// TestCommandPolicy tests that exec enforces the allow and deny lists
+func TestCommandPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy  CommandPolicy
+		command string
+		want    int
+	}{
+		{CommandPolicy{Allow: []string{"echo"}}, "echo", http.StatusOK},
+		{CommandPolicy{Allow: []string{"echo"}}, "true", http.StatusForbidden},
+		{CommandPolicy{Deny: []string{"true"}}, "echo", http.StatusOK},
+		{CommandPolicy{Deny: []string{"/no/such/dir/echo"}}, "echo", http.StatusForbidden},
+		{CommandPolicy{Allow: []string{"echo"}}, "no-such-binary", http.StatusForbidden},
+	} {
+		policy := tc.policy
+		srv := &Server{Version: "test", Policy: &policy}
+		mux := http.NewServeMux()
+		srv.routes(mux)
+		body, _ := json.Marshal(ExecRequest{Command: tc.command})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+		if rr.Code != tc.want {
+			t.Errorf("%+v %s: status %d, want %d", tc.policy, tc.command, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestCommandPolicyStaged tests that a chunked task's staging wrapper is
+// checked against the command it runs, not sh, and only when it stages
+// into the chunk directory.
+func TestCommandPolicyStaged(t *testing.T) {
+	policy := CommandPolicy{Allow: []string{"echo"}, Deny: []string{"sh"}}
+	srv := &Server{Version: "test", Policy: &policy}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	chunk := filepath.Join(ChunkDir, fmt.Sprintf("policy-test-%d.txt", os.Getpid()))
+	defer os.Remove(chunk)
+	for _, tc := range []struct {
+		args []string
+		env  []string
+		want int
+	}{
+		{[]string{"-c", StageScript, chunk, "echo", "staged"}, []string{"LANG=C"}, http.StatusOK},
+		{[]string{"-c", StageScript, chunk, "true"}, nil, http.StatusForbidden},
+		{[]string{"-c", StageScript, chunk, "sh", "-c", "echo x"}, nil, http.StatusForbidden},
+		{[]string{"-c", StageScript, "/etc/cron.d/x", "echo"}, nil, http.StatusForbidden},
+		{[]string{"-c", StageScript, ChunkDir + "/../../x", "echo"}, nil, http.StatusForbidden},
+		{[]string{"-c", "cat > \"$0\"; exec \"$@\"", chunk, "echo"}, nil, http.StatusForbidden},
+		// A staged library or binary must not be loaded through the
+		// environment in place of the allowlisted one.
+		{[]string{"-c", StageScript, chunk, "echo"}, []string{"LD_PRELOAD=" + chunk}, http.StatusForbidden},
+		{[]string{"-c", StageScript, chunk, "echo"}, []string{"PATH=" + ChunkDir}, http.StatusForbidden},
+		{[]string{"-c", StageScript, chunk, "echo"}, []string{"BASH_ENV=" + chunk}, http.StatusForbidden},
+	} {
+		body, _ := json.Marshal(ExecRequest{Command: "sh", Args: tc.args, Env: tc.env, Input: "a\n"})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+		if rr.Code != tc.want {
+			t.Errorf("%q %q: status %d, want %d", tc.args, tc.env, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestCheckRequestPinsStaged tests that a staged command runs as the binary
+// the policy checked, not whatever the wrapper's PATH finds.
+func TestCheckRequestPinsStaged(t *testing.T) {
+	echo, err := resolveCommand("echo", "")
+	if err != nil {
+		t.Skip("no echo on PATH")
+	}
+	policy := CommandPolicy{Allow: []string{"echo"}}
+	req := ExecRequest{Command: "sh", Args: []string{"-c", StageScript, ChunkDir + "/x", "echo", "hi"}}
+	if err := policy.CheckRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Args[3] != echo || req.Args[4] != "hi" {
+		t.Errorf("args = %q, want echo pinned to %s", req.Args, echo)
+	}
+	direct := ExecRequest{Command: "echo", Env: []string{"LD_LIBRARY_PATH=/tmp"}}
+	if err := policy.CheckRequest(&direct); err == nil || !strings.Contains(err.Error(), "LD_LIBRARY_PATH") {
+		t.Errorf("LD_LIBRARY_PATH: %v", err)
+	}
+	if err := (*CommandPolicy)(nil).CheckRequest(&direct); err != nil {
+		t.Errorf("nil policy: %v", err)
+	}
+}
+
+// TestMain lets the test binary stand in for gaxx-agent as the limit shim.
+func TestMain(m *testing.M) {
+	RunLimitShim()
+	os.Exit(m.Run())
+}
+
+// TestExecLimitsInherited tests that limits hold from the start of a
+// command and for the processes it starts
+func TestExecLimitsInherited(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are Linux-only")
+	}
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("no nice on PATH")
+	}
+	srv := &Server{Version: "test", Limits: ResourceLimits{CPUSeconds: 60}}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	body, _ := json.Marshal(ExecRequest{
+		Command: "sh",
+		Args:    []string{"-c", `ulimit -t; sh -c 'ulimit -v; nice'`},
+		Timeout: 10,
+		Limits:  &ResourceLimits{MemoryMB: 512, Nice: 7},
+	})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+	var resp ExecResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal %q: %v", rr.Body.String(), err)
+	}
+	if resp.ExitCode != 0 || resp.Stdout != "60\n524288\n7\n" {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+// TestExecCPULimit tests that a command over its CPU limit is killed and reported
+func TestExecCPULimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are Linux-only")
+	}
+	srv := &Server{Version: "test", Limits: ResourceLimits{CPUSeconds: 5}}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	body, _ := json.Marshal(ExecRequest{
+		Command: "sh",
+		Args:    []string{"-c", "while :; do :; done"},
+		Timeout: 30,
+		Limits:  &ResourceLimits{CPUSeconds: 1, Nice: 5},
+	})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+	var resp ExecResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal %q: %v", rr.Body.String(), err)
+	}
+	if resp.LimitExceeded != LimitCPU || resp.ExitCode == 0 {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+// TestLimitsTighten tests that requests can lower but not raise agent limits
+func TestLimitsTighten(t *testing.T) {
+	agentLimits := ResourceLimits{Nice: 5, MemoryMB: 512}
+	got := agentLimits.Tighten(&ResourceLimits{Nice: 1, MemoryMB: 4096, CPUSeconds: 60})
+	want := ResourceLimits{Nice: 5, MemoryMB: 512, CPUSeconds: 60}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got := agentLimits.Tighten(&ResourceLimits{MemoryMB: 128}); got.MemoryMB != 128 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+// TestServeShared tests that /v0/files serves only files under ShareDir
+func TestServeShared(t *testing.T) {
+	share := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(filepath.Join(share, "wordlist.txt"), []byte("admin\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outside, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(share, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Version: "test", ShareDir: share}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{filepath.Join(share, "wordlist.txt"), http.StatusOK},
+		{filepath.Join(share, "missing"), http.StatusForbidden},
+		{outside, http.StatusForbidden},
+		{filepath.Join(share, "escape"), http.StatusForbidden},
+		{filepath.Join(share, "..", filepath.Base(outside)), http.StatusForbidden},
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v0/files?path="+url.QueryEscape(tc.path), nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.path, rr.Code, tc.want)
+		}
+	}
+}
+
+func TestSysInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sysinfo reads /proc")
+	}
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+
+	body, _ := json.Marshal(ExecRequest{Command: "sleep", Args: []string{"2"}})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+	}()
+
+	var info SysInfoResponse
+	for i := 0; i < 8 && len(info.Jobs) == 0; i++ {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v0/sysinfo", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rr.Code, rr.Body)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(info.Jobs) != 1 || info.Jobs[0].Command != "sleep" {
+		t.Errorf("jobs = %+v, want the running sleep", info.Jobs)
+	}
+	if info.NumCPU == 0 || info.MemTotalMB == 0 || info.CPUPercent < 0 || info.CPUPercent > 100 {
+		t.Errorf("implausible sysinfo: %+v", info)
+	}
+
+	<-done
+	if jobs := srv.jobs.list(); len(jobs) != 0 {
+		t.Errorf("finished job still listed: %+v", jobs)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	srv := &Server{Version: "test"}
+	mux := http.NewServeMux()
+	srv.routes(mux)
+	drain := func(method string) DrainResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, "/v0/drain", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s /v0/drain: status %d", method, rr.Code)
+		}
+		var d DrainResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	exec := func(args ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ExecRequest{Command: "sleep", Args: args})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v0/exec", bytes.NewReader(body)))
+		return rr
+	}
+
+	done := make(chan int)
+	go func() { done <- exec("1").Code }()
+	for srv.jobs.count() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if d := drain(http.MethodPost); !d.Draining || d.Jobs != 1 {
+		t.Errorf("drain = %+v, want draining with 1 job", d)
+	}
+	if rr := exec("0"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("exec while draining: status %d, want 503", rr.Code)
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("in-flight exec: status %d, want 200", code)
+	}
+	if d := drain(http.MethodGet); !d.Draining || d.Jobs != 0 {
+		t.Errorf("after finish = %+v, want draining with 0 jobs", d)
+	}
+	if d := drain(http.MethodDelete); d.Draining {
+		t.Errorf("undrain = %+v", d)
+	}
+	if rr := exec("0"); rr.Code != http.StatusOK {
+		t.Errorf("exec after undrain: status %d", rr.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the smallest response body worth compressing.
+const minCompressSize = 1024
+
+// writeJSON encodes v as the response, compressed with gzip or deflate when
+// the request accepts one and the body is large enough to gain from it.
+// Go's HTTP client asks for gzip and decompresses it transparently, so
+// callers decode the response as before.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := ""
+	if body.Len() >= minCompressSize {
+		enc = responseEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	var cw io.WriteCloser
+	switch enc {
+	case "gzip":
+		cw = gzip.NewWriter(w)
+	case "deflate":
+		cw, _ = flate.NewWriter(w, flate.DefaultCompression)
+	default:
+		_, err := body.WriteTo(w)
+		return err
+	}
+	w.Header().Set("Content-Encoding", enc)
+	if _, err := body.WriteTo(cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// responseEncoding picks gzip or deflate from an Accept-Encoding header,
+// skipping codings refused with q=0, or returns "" for none.
+func responseEncoding(accept string) string {
+	ok := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+				continue
+			}
+		}
+		ok[name] = true
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok[enc] {
+			return enc
+		}
+	}
+	return ""
+}
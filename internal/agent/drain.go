@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// serveDrain handles /v0/drain: POST stops the agent accepting exec
+// requests, DELETE resumes, and either (or GET) reports how many commands
+// are still running so callers can wait for them before teardown.
+func (s *Server) serveDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if !s.draining.Swap(true) {
+			telemetry.CounterGlobal("gaxx_agent_drains", 1, map[string]string{
+				"component": "agent",
+				"endpoint":  "drain",
+			})
+		}
+	case http.MethodDelete:
+		s.draining.Store(false)
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(DrainResponse{Draining: s.draining.Load(), Jobs: s.jobs.count()})
+}
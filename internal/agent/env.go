@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"os"
+	"strings"
+)
+
+// DefaultPath is the PATH commands run with when none is inherited or
+// given, covering where package managers and make install put tools.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// EnsurePath sets the process's PATH to DefaultPath when it is empty, as it
+// can be under a bare init, so commands named without a path still resolve.
+func EnsurePath() {
+	if os.Getenv("PATH") == "" {
+		_ = os.Setenv("PATH", DefaultPath)
+	}
+}
+
+// agentEnvPrefix prefixes the agent's own settings, which include its token
+// and TLS key, and so are never passed on to the commands it runs.
+const agentEnvPrefix = "GAXX_AGENT_"
+
+// execEnv returns the environment a request's command runs with: the
+// agent's own, less its GAXX_AGENT_* settings, unless the request turns
+// InheritEnv off, overlaid with the request's Env, and with DefaultPath
+// when neither sets PATH.
+func execEnv(req ExecRequest) []string {
+	var env []string
+	if req.InheritsEnv() {
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, agentEnvPrefix) {
+				env = append(env, kv)
+			}
+		}
+	}
+	env = append(env, req.Env...)
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") && kv != "PATH=" {
+			// exec.Cmd uses the last value of a duplicated key, so a
+			// request's PATH wins over the inherited one.
+			return env
+		}
+	}
+	return append(env, "PATH="+DefaultPath)
+}
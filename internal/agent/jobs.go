@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobTracker records the exec requests in flight. The zero value is ready
+// to use.
+type jobTracker struct {
+	mu      sync.Mutex
+	next    int
+	running map[int]RunningJob
+}
+
+// begin records req as running and returns the function that ends it.
+func (t *jobTracker) begin(req ExecRequest) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running == nil {
+		t.running = make(map[int]RunningJob)
+	}
+	id := t.next
+	t.next++
+	t.running[id] = RunningJob{Command: req.Command, Args: req.Args, StartedAt: time.Now()}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.running, id)
+	}
+}
+
+// list returns the running jobs, oldest first.
+func (t *jobTracker) list() []RunningJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jobs := make([]RunningJob, 0, len(t.running))
+	for _, j := range t.running {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.Before(jobs[j].StartedAt) })
+	return jobs
+}
+
+// count returns the number of running jobs.
+func (t *jobTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.running)
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ResourceLimits caps what a command may consume. Zero fields are unlimited.
+type ResourceLimits struct {
+	// Nice is the scheduling niceness, 0 (normal) to 19 (lowest).
+	Nice int `json:"nice,omitempty" yaml:"nice"`
+	// MemoryMB caps the address space (RLIMIT_AS) in MiB.
+	MemoryMB int `json:"memory_mb,omitempty" yaml:"memory_mb"`
+	// CPUSeconds caps CPU time (RLIMIT_CPU).
+	CPUSeconds int `json:"cpu_seconds,omitempty" yaml:"cpu_seconds"`
+}
+
+// Limit names reported in ExecResponse.LimitExceeded.
+const (
+	LimitCPU    = "cpu"
+	LimitMemory = "memory"
+)
+
+// IsZero reports whether no limit is set.
+func (l ResourceLimits) IsZero() bool { return l == ResourceLimits{} }
+
+// LimitsFromEnv reads the agent-wide limits from GAXX_AGENT_NICE,
+// GAXX_AGENT_MAX_MEMORY_MB and GAXX_AGENT_MAX_CPU_SECONDS. Unset or invalid
+// values leave that limit off.
+func LimitsFromEnv() ResourceLimits {
+	get := func(key string) int {
+		n, err := strconv.Atoi(os.Getenv(key))
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	return ResourceLimits{
+		Nice:       get("GAXX_AGENT_NICE"),
+		MemoryMB:   get("GAXX_AGENT_MAX_MEMORY_MB"),
+		CPUSeconds: get("GAXX_AGENT_MAX_CPU_SECONDS"),
+	}
+}
+
+// Tighten combines agent-wide limits with a request's: a request may lower
+// a limit or the priority, but never raise them past the agent's.
+func (l ResourceLimits) Tighten(req *ResourceLimits) ResourceLimits {
+	if req == nil {
+		return l
+	}
+	lower := func(a, b int) int {
+		if a == 0 || (b > 0 && b < a) {
+			return b
+		}
+		return a
+	}
+	out := ResourceLimits{
+		Nice:       l.Nice,
+		MemoryMB:   lower(l.MemoryMB, req.MemoryMB),
+		CPUSeconds: lower(l.CPUSeconds, req.CPUSeconds),
+	}
+	if req.Nice > out.Nice {
+		out.Nice = req.Nice
+	}
+	if out.Nice > 19 {
+		out.Nice = 19
+	}
+	return out
+}
+
+// LimitForSignal names the limit that most likely killed a command with the
+// given signal ("XCPU", "KILL", ...), or returns "" if none applies.
+// RLIMIT_CPU sends SIGXCPU at the soft limit and SIGKILL at the hard one; an
+// exhausted address space usually ends in SIGSEGV or SIGABRT.
+func LimitForSignal(sig string, l ResourceLimits) string {
+	switch {
+	case l.CPUSeconds > 0 && (sig == "XCPU" || sig == "KILL"):
+		return LimitCPU
+	case l.MemoryMB > 0 && (sig == "SEGV" || sig == "ABRT" || sig == "KILL"):
+		return LimitMemory
+	}
+	return ""
+}
+
+// limitShimArg, as the first argument to the agent binary, starts it as the
+// shim that runs a command under resource limits (see RunLimitShim).
+const limitShimArg = "__exec-limited"
+
+// RunLimitShim must be called first thing in the agent's main. When the
+// agent started the process to run a command under limits, it sets them on
+// itself and execs the command in its place, so they hold from the
+// command's first instruction and are inherited by everything it starts;
+// it does not return then. Otherwise it does nothing.
+func RunLimitShim() {
+	if len(os.Args) < 2 || os.Args[1] != limitShimArg {
+		return
+	}
+	err := execLimited(os.Args[2:])
+	fmt.Fprintf(os.Stderr, "gaxx-agent: %v\n", err)
+	// 126 is what shells exit with for a command that could not be run.
+	os.Exit(126)
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitCommand makes cmd run under l. Go cannot set rlimits between fork
+// and exec, and setting them once the command runs leaves whatever it
+// starts first unlimited, so the agent binary is started in its place with
+// limitShimArg and execs it once the limits are set (see RunLimitShim).
+func limitCommand(cmd *exec.Cmd, l ResourceLimits) error {
+	if cmd.Err != nil {
+		// The command was not found; Start reports it.
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find agent binary: %w", err)
+	}
+	shim := []string{self, limitShimArg, strconv.Itoa(l.Nice), strconv.Itoa(l.MemoryMB), strconv.Itoa(l.CPUSeconds), cmd.Path}
+	cmd.Path, cmd.Args = self, append(shim, cmd.Args...)
+	return nil
+}
+
+// execLimited is the shim's side of limitCommand: args are the limits, the
+// path to run and its argv.
+func execLimited(args []string) error {
+	if len(args) < 5 {
+		return errors.New("limit shim: missing arguments")
+	}
+	var n [3]int
+	for i := range n {
+		v, err := strconv.Atoi(args[i])
+		if err != nil {
+			return fmt.Errorf("limit shim: %w", err)
+		}
+		n[i] = v
+	}
+	l := ResourceLimits{Nice: n[0], MemoryMB: n[1], CPUSeconds: n[2]}
+	// Everything exec needs is allocated before the address space is
+	// capped, which the Go runtime here is already likely to exceed.
+	path, err := syscall.BytePtrFromString(args[3])
+	if err != nil {
+		return err
+	}
+	argv, err := syscall.SlicePtrFromStrings(args[4:])
+	if err != nil {
+		return err
+	}
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		return err
+	}
+	// Linux keeps the nice value per thread: set it on the one that execs.
+	runtime.LockOSThread()
+	if l.Nice > 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, l.Nice); err != nil {
+			return fmt.Errorf("set nice: %w", err)
+		}
+	}
+	if l.CPUSeconds > 0 {
+		// A hard limit above the soft one lets SIGXCPU arrive first.
+		n := uint64(l.CPUSeconds)
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: n, Max: n + 5}); err != nil {
+			return fmt.Errorf("set cpu limit: %w", err)
+		}
+	}
+	if l.MemoryMB > 0 {
+		n := uint64(l.MemoryMB) << 20
+		if err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: n, Max: n}); err != nil {
+			return fmt.Errorf("set memory limit: %w", err)
+		}
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	return fmt.Errorf("exec %s: %w", args[3], errno)
+}
+
+// exitSignal returns the name, without "SIG", of the signal that ended the
+// command, or "" if it exited normally.
+func exitSignal(cmd *exec.Cmd) string {
+	if cmd.ProcessState == nil {
+		return ""
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	return strings.TrimPrefix(unix.SignalName(ws.Signal()), "SIG")
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errLimitsUnsupported = errors.New("resource limits are only supported on Linux")
+
+func limitCommand(cmd *exec.Cmd, l ResourceLimits) error { return errLimitsUnsupported }
+
+func execLimited(args []string) error { return errLimitsUnsupported }
+
+func exitSignal(cmd *exec.Cmd) string { return "" }
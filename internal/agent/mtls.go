@@ -104,8 +104,9 @@ func (s *Server) ListenAndServeTLS(addr string, config MTLSConfig) error {
 	mux := http.NewServeMux()
 	s.routes(mux)
 
-	// Wrap with mTLS middleware
-	handler := MTLSMiddleware(config.RequireAuth)(mux)
+	// Wrap with mTLS middleware, outside the access log so it can record
+	// the client certificate subject
+	handler := MTLSMiddleware(config.RequireAuth)(AccessLogMiddleware(s.AccessLog)(mux))
 
 	s.srv = &http.Server{
 		Addr:      addr,
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// DefaultMaxOutput is the default cap on captured command output.
+const DefaultMaxOutput = 10 << 20
+
+// MaxOutputFromEnv returns GAXX_AGENT_MAX_OUTPUT in bytes, or
+// DefaultMaxOutput when it is unset or not a positive integer.
+func MaxOutputFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("GAXX_AGENT_MAX_OUTPUT")); err == nil && n > 0 {
+		return n
+	}
+	return DefaultMaxOutput
+}
+
+// LimitedBuffer keeps the first Max bytes written to it and discards the
+// rest, so runaway output cannot exhaust memory. Writes never fail, so the
+// command keeps running rather than dying on a broken pipe.
+type LimitedBuffer struct {
+	Max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if room := b.Max - b.buf.Len(); len(p) > room {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *LimitedBuffer) String() string { return b.buf.String() }
+
+func (b *LimitedBuffer) Len() int { return b.buf.Len() }
+
+// Truncated reports whether any output was discarded.
+func (b *LimitedBuffer) Truncated() bool { return b.truncated }
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CommandPolicy restricts which binaries the exec endpoint may run. Entries
+// are command names, resolved through the agent's PATH, or absolute paths.
+// An allowlist admits only the exact binaries it names; a denylist blocks
+// any binary with a listed path or base name, wherever it lives.
+type CommandPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// CommandPolicyFromEnv builds a policy from the comma-separated
+// GAXX_AGENT_ALLOW_COMMANDS and GAXX_AGENT_DENY_COMMANDS, or returns nil
+// when neither is set.
+func CommandPolicyFromEnv() *CommandPolicy {
+	p := &CommandPolicy{
+		Allow: splitList(os.Getenv("GAXX_AGENT_ALLOW_COMMANDS")),
+		Deny:  splitList(os.Getenv("GAXX_AGENT_DENY_COMMANDS")),
+	}
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return nil
+	}
+	return p
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Check reports whether command, run from workDir, is permitted. A nil
+// policy permits everything.
+func (p *CommandPolicy) Check(command, workDir string) error {
+	if p == nil {
+		return nil
+	}
+	path, err := resolveCommand(command, workDir)
+	if err != nil {
+		return fmt.Errorf("command %q: %w", command, err)
+	}
+	for _, d := range p.Deny {
+		if filepath.Base(path) == filepath.Base(d) || samePath(path, d) {
+			return fmt.Errorf("command %q is denied by the agent's command policy", command)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, a := range p.Allow {
+		if samePath(path, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not in the agent's command allowlist", command)
+}
+
+// ChunkDir is where the controller stages a task's input chunks on a node.
+const ChunkDir = "/tmp/gaxx/chunks"
+
+// StageScript is the sh -c script the controller wraps a chunked task's
+// command in: run with a file under ChunkDir as $0 and the command and its
+// arguments after it, it writes stdin to the file and then execs the
+// command.
+const StageScript = `mkdir -p "$(dirname "$0")" && cat > "$0" && exec "$@"`
+
+// CheckRequest applies the policy to an exec request. Its Env may not set
+// variables that change which code a command loads or runs (see
+// unsafeEnvVar), and a StageScript wrapper's command, which the wrapper's
+// exec would look up on the command's PATH rather than the agent's, is
+// pinned to the binary that was checked.
+func (p *CommandPolicy) CheckRequest(req *ExecRequest) error {
+	if p == nil {
+		return nil
+	}
+	for _, kv := range req.Env {
+		if name, _, _ := strings.Cut(kv, "="); unsafeEnvVar(name) {
+			return fmt.Errorf("environment variable %s is not allowed by the agent's command policy", name)
+		}
+	}
+	if !staged(*req) {
+		return p.Check(req.Command, req.WorkDir)
+	}
+	if err := p.Check(req.Args[3], req.WorkDir); err != nil {
+		return err
+	}
+	path, err := resolveCommand(req.Args[3], req.WorkDir)
+	if err != nil {
+		return err
+	}
+	req.Args[3] = path
+	return nil
+}
+
+// staged reports whether req is a StageScript wrapper writing under
+// ChunkDir, whose policy check applies to the command it execs once its
+// input is staged, so that a policy need not admit sh for chunked tasks to
+// run.
+func staged(req ExecRequest) bool {
+	if req.Command != "sh" || len(req.Args) < 4 || req.Args[0] != "-c" || req.Args[1] != StageScript {
+		return false
+	}
+	rel, err := filepath.Rel(ChunkDir, req.Args[2])
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// unsafeEnvVar reports whether a request setting the environment variable
+// name could run code its command policy hasn't checked: by changing where
+// commands are looked up, preloading libraries, or running shell startup
+// files and functions.
+func unsafeEnvVar(name string) bool {
+	switch name {
+	case "PATH", "IFS", "ENV", "BASH_ENV", "CDPATH", "SHELLOPTS", "BASHOPTS", "PS4", "GCONV_PATH", "LOCPATH", "HOSTALIASES":
+		return true
+	}
+	return strings.HasPrefix(name, "LD_") || strings.HasPrefix(name, "DYLD_") || strings.HasPrefix(name, "BASH_FUNC_")
+}
+
+// resolveCommand returns the absolute, symlink-free path exec would run.
+func resolveCommand(command, workDir string) (string, error) {
+	if strings.Contains(command, "/") && !filepath.IsAbs(command) {
+		command = filepath.Join(workDir, command)
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// samePath reports whether path is the binary a policy entry names.
+func samePath(path, entry string) bool {
+	resolved, err := resolveCommand(entry, "")
+	return err == nil && resolved == path
+}
@@ -9,6 +9,16 @@ type HeartbeatResponse struct {
 	Time    time.Time `json:"time"`
 	Host    string    `json:"host"`
 	Version string    `json:"version"`
+	// UptimeSeconds is how long the agent has been running. Agents that
+	// predate it, and the fields below, leave them zero.
+	UptimeSeconds int64  `json:"uptime_seconds,omitempty"`
+	GoVersion     string `json:"go_version,omitempty"`
+	Goroutines    int    `json:"goroutines,omitempty"`
+	// ActiveJobs is the number of exec requests in flight.
+	ActiveJobs int `json:"active_jobs"`
+	// ClockSkew is not sent: the controller sets it to how far ahead of
+	// its own clock Time is (see core.ClockSkew).
+	ClockSkew time.Duration `json:"-"`
 }
 
 type ExecRequest struct {
@@ -18,11 +28,71 @@ type ExecRequest struct {
 	Timeout int      `json:"timeout_seconds"`
 	WorkDir string   `json:"work_dir"`
 	Input   string   `json:"input"`
+	// Limits may tighten, but not loosen, the agent's resource limits.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// Spool writes the output to a file on the node, up to the agent's
+	// spool limit, instead of returning it; the response's OutputID then
+	// names it on /v0/output/<id>. Agents that predate it ignore it and
+	// return the output as usual.
+	Spool bool `json:"spool,omitempty"`
+	// InheritEnv starts the command from the agent's environment, Env
+	// overriding it; false starts it from Env alone. Nil means true. Either
+	// way PATH falls back to DefaultPath.
+	InheritEnv *bool `json:"inherit_env,omitempty"`
+	// Stream sends the output as the command writes it, as ExecFrames of
+	// StreamContentType, instead of all at once when it exits. It is
+	// ignored with Spool, and by agents that predate it, which answer
+	// with a plain ExecResponse.
+	Stream bool `json:"stream,omitempty"`
 }
 
+// InheritsEnv reports whether the command starts from the agent's
+// environment.
+func (r ExecRequest) InheritsEnv() bool { return r.InheritEnv == nil || *r.InheritEnv }
+
 type ExecResponse struct {
 	ExitCode int    `json:"exit_code"`
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
 	Duration int64  `json:"duration_ms"`
+	// Truncated is set when output exceeded the agent's limit and was cut.
+	Truncated bool `json:"truncated,omitempty"`
+	// LimitExceeded names the resource limit (LimitCPU or LimitMemory) that
+	// killed the command, if any.
+	LimitExceeded string `json:"limit_exceeded,omitempty"`
+	// OutputID and OutputSize describe output spooled at the request's
+	// asking; Stdout is then empty.
+	OutputID   string `json:"output_id,omitempty"`
+	OutputSize int64  `json:"output_size,omitempty"`
+}
+
+// SysInfoResponse reports a node's resource usage and the commands it is
+// running.
+type SysInfoResponse struct {
+	Time   time.Time `json:"time"`
+	NumCPU int       `json:"num_cpu"`
+	// CPUPercent is busy time across all CPUs over a short sample.
+	CPUPercent float64 `json:"cpu_percent"`
+	// MemPercent is memory in use, excluding reclaimable caches.
+	MemPercent float64 `json:"mem_percent"`
+	MemTotalMB int64   `json:"mem_total_mb"`
+	Load1      float64 `json:"load1"`
+	Load5      float64 `json:"load5"`
+	Load15     float64 `json:"load15"`
+	// Jobs are the exec requests in flight, oldest first.
+	Jobs []RunningJob `json:"jobs"`
+}
+
+// RunningJob is an exec request the agent is running.
+type RunningJob struct {
+	Command   string    `json:"command"`
+	Args      []string  `json:"args"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// DrainResponse reports whether the agent is refusing new work and how many
+// commands it is still running.
+type DrainResponse struct {
+	Draining bool `json:"draining"`
+	Jobs     int  `json:"jobs"`
 }
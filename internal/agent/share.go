@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// DefaultShareDir is the directory whose files the agent serves to peers on
+// /v0/files. It holds what the controller uploads for tasks.
+const DefaultShareDir = "/tmp/gaxx"
+
+// serveShared serves GET /v0/files?path=<absolute path> for files under
+// s.ShareDir, so nodes can copy uploads from each other instead of each
+// receiving them from the controller. Range requests are honoured, letting
+// interrupted copies resume.
+func (s *Server) serveShared(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, ok := s.sharedPath(r.URL.Query().Get("path"))
+	if !ok {
+		telemetry.CounterGlobal("gaxx_agent_file_errors", 1, map[string]string{
+			"component": "agent",
+			"endpoint":  "files",
+			"error":     "forbidden_path",
+		})
+		http.Error(w, "path is not under the agent's share directory", http.StatusForbidden)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	telemetry.CounterGlobal("gaxx_agent_file_requests", 1, map[string]string{
+		"component": "agent",
+		"endpoint":  "files",
+	})
+	http.ServeFile(w, r, path)
+}
+
+// sharedPath resolves p, following symlinks, and reports whether it lies
+// inside the share directory.
+func (s *Server) sharedPath(p string) (string, bool) {
+	if s.ShareDir == "" || !filepath.IsAbs(p) {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(s.ShareDir)
+	if err != nil {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", false
+	}
+	return resolved, true
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// DefaultMaxSpool is the default cap on the output spooled for one exec.
+const DefaultMaxSpool = 4 << 30
+
+// spoolRetention is how long spooled output nobody deleted is kept.
+const spoolRetention = 24 * time.Hour
+
+// MaxSpoolFromEnv returns GAXX_AGENT_MAX_SPOOL in bytes, or DefaultMaxSpool
+// when it is unset or not a positive integer.
+func MaxSpoolFromEnv() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("GAXX_AGENT_MAX_SPOOL"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return DefaultMaxSpool
+}
+
+// spoolDir returns where spooled output is written.
+func (s *Server) spoolDir() string {
+	if s.SpoolDir != "" {
+		return s.SpoolDir
+	}
+	return filepath.Join(os.TempDir(), "gaxx-output")
+}
+
+// newSpool creates a file for an exec's output and returns it with its id,
+// first removing spooled output older than spoolRetention.
+func (s *Server) newSpool() (*spoolFile, string, error) {
+	dir := s.spoolDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, "", fmt.Errorf("create spool dir: %w", err)
+	}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > spoolRetention {
+				_ = os.Remove(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(b)
+	f, err := os.OpenFile(filepath.Join(dir, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, "", fmt.Errorf("create spool file: %w", err)
+	}
+	limit := s.MaxSpool
+	if limit <= 0 {
+		limit = DefaultMaxSpool
+	}
+	return &spoolFile{limit: limit, f: f}, id, nil
+}
+
+// spoolPath returns the file of spooled output id, rejecting ids that are
+// not ones newSpool makes.
+func (s *Server) spoolPath(id string) (string, bool) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 16 {
+		return "", false
+	}
+	return filepath.Join(s.spoolDir(), id), true
+}
+
+// serveOutput handles /v0/output/<id>: GET (with Range, to page through it)
+// returns an exec's spooled output and DELETE removes it.
+func (s *Server) serveOutput(w http.ResponseWriter, r *http.Request) {
+	path, ok := s.spoolPath(strings.TrimPrefix(r.URL.Path, "/v0/output/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		telemetry.CounterGlobal("gaxx_agent_output_requests", 1, map[string]string{
+			"component": "agent",
+			"endpoint":  "output",
+		})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", info.ModTime(), f)
+	case http.MethodDelete:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// spoolFile writes output to disk up to limit bytes and discards the rest,
+// like LimitedBuffer but without holding the output in memory.
+type spoolFile struct {
+	limit     int64
+	f         *os.File
+	n         int64
+	truncated bool
+	closed    bool
+	err       error
+}
+
+func (s *spoolFile) Write(p []byte) (int, error) {
+	want := len(p)
+	if room := s.limit - s.n; int64(len(p)) > room {
+		p = p[:max(room, 0)]
+		s.truncated = true
+	}
+	if len(p) > 0 && s.err == nil {
+		n, err := s.f.Write(p)
+		s.n += int64(n)
+		s.err = err
+	}
+	// Like LimitedBuffer, never fail the command's writes.
+	return want, nil
+}
+
+// Close closes the file, once, and returns the first write error, if any.
+func (s *spoolFile) Close() error {
+	if !s.closed {
+		s.closed = true
+		if err := s.f.Close(); s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// Len returns the bytes written.
+func (s *spoolFile) Len() int64 { return s.n }
+
+// Truncated reports whether any output was discarded.
+func (s *spoolFile) Truncated() bool { return s.truncated }
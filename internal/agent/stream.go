@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+// StreamContentType is the Content-Type of a streamed exec response: one
+// JSON ExecFrame per line.
+const StreamContentType = "application/x-ndjson"
+
+// ExecFrame is one line of a streamed exec response (see
+// ExecRequest.Stream): output as the command writes it, and last, once it
+// has exited, the result, whose Stdout is then empty.
+type ExecFrame struct {
+	Output string        `json:"output,omitempty"`
+	Result *ExecResponse `json:"result,omitempty"`
+}
+
+// frameWriter sends what a command writes as ExecFrames, up to left bytes.
+// Output written before start is held, so that nothing is sent until the
+// command has started and a failure to start can still be reported with a
+// plain error status. A UTF-8 sequence split across writes is also
+// held until it is whole, since each frame's Output is a JSON string.
+type frameWriter struct {
+	mu   sync.Mutex
+	w    http.ResponseWriter
+	enc  *json.Encoder
+	open bool
+	held bytes.Buffer
+	left int
+}
+
+func newFrameWriter(w http.ResponseWriter, max int) *frameWriter {
+	return &frameWriter{w: w, enc: json.NewEncoder(w), left: max}
+}
+
+// Write never fails, like LimitedBuffer's: a client that has gone away
+// cancels the request, and with it the command.
+func (f *frameWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(p)
+	if len(p) > f.left {
+		p = p[:f.left]
+	}
+	f.left -= len(p)
+	f.held.Write(p)
+	if f.open {
+		f.flush(false)
+	}
+	return n, nil
+}
+
+// start sends the response header and any output held so far.
+func (f *frameWriter) start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.startLocked()
+}
+
+func (f *frameWriter) startLocked() {
+	if f.open {
+		return
+	}
+	f.open = true
+	f.w.Header().Set("Content-Type", StreamContentType)
+	f.w.WriteHeader(http.StatusOK)
+	f.flush(false)
+}
+
+// finish sends the result, starting the response if need be.
+func (f *frameWriter) finish(resp ExecResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.startLocked()
+	f.flush(true)
+	resp.Stdout = ""
+	f.send(ExecFrame{Result: &resp})
+}
+
+// flush sends the held output, keeping back an incomplete UTF-8 sequence at
+// its end unless all is set.
+func (f *frameWriter) flush(all bool) {
+	b := f.held.Bytes()
+	n := len(b)
+	if !all {
+		n = wholeUTF8(b)
+	}
+	if n == 0 {
+		return
+	}
+	f.send(ExecFrame{Output: string(b[:n])})
+	f.held.Next(n)
+}
+
+// wholeUTF8 returns the length of p up to an incomplete UTF-8 sequence at
+// its end, or len(p) if there is none.
+func wholeUTF8(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+func (f *frameWriter) send(frame ExecFrame) {
+	_ = f.enc.Encode(frame)
+	_ = http.NewResponseController(f.w).Flush()
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// cpuSampleInterval is how long /v0/sysinfo measures CPU usage over.
+const cpuSampleInterval = 250 * time.Millisecond
+
+func (s *Server) serveSysInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info := SysInfoResponse{Time: time.Now(), NumCPU: runtime.NumCPU()}
+	if err := readSysInfo(r.Context(), &info); err != nil {
+		telemetry.CounterGlobal("gaxx_agent_sysinfo_errors", 1, map[string]string{
+			"component": "agent",
+			"endpoint":  "sysinfo",
+			"error":     telemetry.ErrorClass(err),
+		})
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	info.Jobs = s.jobs.list()
+	_ = writeJSON(w, r, info)
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readSysInfo fills in CPU, memory and load from /proc.
+func readSysInfo(ctx context.Context, info *SysInfoResponse) error {
+	busy0, total0, err := readCPUTimes()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(cpuSampleInterval):
+	}
+	busy1, total1, err := readCPUTimes()
+	if err != nil {
+		return err
+	}
+	if total1 > total0 {
+		info.CPUPercent = 100 * float64(busy1-busy0) / float64(total1-total0)
+	}
+
+	mem, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return fmt.Errorf("read meminfo: %w", err)
+	}
+	var totalKB, availKB int64
+	sc := bufio.NewScanner(bytes.NewReader(mem))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			totalKB = v
+		case "MemAvailable:":
+			availKB = v
+		}
+	}
+	info.MemTotalMB = totalKB / 1024
+	if totalKB > 0 {
+		info.MemPercent = 100 * float64(totalKB-availKB) / float64(totalKB)
+	}
+
+	load, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return fmt.Errorf("read loadavg: %w", err)
+	}
+	if _, err := fmt.Sscan(string(load), &info.Load1, &info.Load5, &info.Load15); err != nil {
+		return fmt.Errorf("parse loadavg: %w", err)
+	}
+	return nil
+}
+
+// readCPUTimes returns the busy and total jiffies of the aggregate "cpu"
+// line of /proc/stat. Idle and iowait count as not busy.
+func readCPUTimes() (busy, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, fmt.Errorf("read stat: %w", err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	var idle uint64
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse /proc/stat: %w", err)
+		}
+		// guest and guest_nice are already counted in user and nice.
+		if i >= 8 {
+			break
+		}
+		total += v
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return total - idle, total, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+func readSysInfo(ctx context.Context, info *SysInfoResponse) error {
+	return fmt.Errorf("sysinfo is not supported on %s", runtime.GOOS)
+}
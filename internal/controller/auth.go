@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+)
+
+// Scope is what an API key may do. Each scope includes the ones before it.
+type Scope string
+
+const (
+	// ScopeRead lists fleets, agent status and runs.
+	ScopeRead Scope = "read"
+	// ScopeExecute also starts and cancels runs and scans.
+	ScopeExecute Scope = "execute"
+	// ScopeAdmin also spawns and deletes fleets.
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeRank = map[Scope]int{ScopeRead: 1, ScopeExecute: 2, ScopeAdmin: 3}
+
+// ParseScope validates a scope name.
+func ParseScope(s string) (Scope, error) {
+	if _, ok := scopeRank[Scope(s)]; !ok {
+		return "", fmt.Errorf("unknown scope %q (want read, execute or admin)", s)
+	}
+	return Scope(s), nil
+}
+
+// Allows reports whether a key with scope s may do what need permits.
+func (s Scope) Allows(need Scope) bool { return scopeRank[s] >= scopeRank[need] }
+
+// requiredScope returns the scope a request needs: reads need read, runs
+// need execute, and creating or deleting fleets needs admin.
+func requiredScope(r *http.Request) Scope {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return ScopeRead
+	case strings.HasPrefix(r.URL.Path, "/v0/runs"):
+		return ScopeExecute
+	}
+	return ScopeAdmin
+}
+
+// requestToken returns the Bearer token or X-Auth-Token of r. Browsers
+// can't set headers on WebSockets, so upgrades may pass ?token= instead.
+func requestToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" && isWebSocket(r) {
+		return t
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-Auth-Token")
+}
+
+// requireAuth admits requests carrying the server's Token, which has admin
+// scope, or an API key from the store whose scope covers the request.
+// Everything else is refused, so a server without a token or keys serves
+// nothing.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		scope := ScopeAdmin
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			key, err := s.Store.AuthenticateAPIKey(r.Context(), token)
+			if errors.Is(err, core.ErrAPIKeyNotFound) {
+				writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			scope = Scope(key.Scope)
+		}
+		if need := requiredScope(r); !scope.Allows(need) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("this key has %s scope; %s %s needs %s", scope, r.Method, r.URL.Path, need))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
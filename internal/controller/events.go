@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+const (
+	// streamHistory is how many of a run's latest events a subscriber that
+	// connects mid-run is sent first.
+	streamHistory = 1000
+	// streamBuffer is how many events a subscriber may fall behind before
+	// it is disconnected.
+	streamBuffer = 256
+)
+
+// runEvent starts and ends every event stream: the run as it stands when
+// the stream opens, and as it finished.
+type runEvent struct {
+	Event string   `json:"event"`
+	Run   core.Run `json:"run"`
+}
+
+// runStream fans the events of a running run out to its subscribers.
+type runStream struct {
+	mu      sync.Mutex
+	history [][]byte
+	subs    map[chan []byte]struct{}
+	done    bool
+}
+
+func newRunStream(run core.Run) *runStream {
+	s := &runStream{subs: map[chan []byte]struct{}{}}
+	s.publish(runEvent{Event: "run", Run: run})
+	return s
+}
+
+// publish encodes v and sends it to every subscriber, dropping those too
+// far behind.
+func (s *runStream) publish(v any) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.history = append(s.history, msg)
+	if len(s.history) > streamHistory {
+		s.history = s.history[len(s.history)-streamHistory:]
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- msg:
+		default:
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// finish publishes the finished run and closes every subscription.
+func (s *runStream) finish(run core.Run) {
+	s.publish(runEvent{Event: "run", Run: run})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// subscribe returns the events so far and a channel of the ones to come,
+// which is closed when the run finishes or the subscriber falls behind.
+func (s *runStream) subscribe() ([][]byte, chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan []byte, streamBuffer)
+	if s.done {
+		close(ch)
+	} else {
+		s.subs[ch] = struct{}{}
+	}
+	return append([][]byte(nil), s.history...), ch
+}
+
+func (s *runStream) unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+func (s *runStream) finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// eventsFor returns a client whose events go to stream.
+func eventsFor(client *api.Client, stream *runStream) *api.Client {
+	c := *client
+	c.OnEvent = func(ev api.NodeEvent) { stream.publish(ev) }
+	return &c
+}
+
+// serveRunEvents streams run id over a WebSocket: a run event, the node
+// events (as run --output json writes them) and a final run event once it
+// finishes. A finished run sends just the final run event.
+func (s *Server) serveRunEvents(w http.ResponseWriter, r *http.Request, id int64) {
+	s.mu.Lock()
+	stream := s.streams[id]
+	s.mu.Unlock()
+	// Runs are recorded before their stream is dropped, so without a
+	// stream the store has the final state.
+	var final core.Run
+	if stream == nil {
+		run, err := s.Store.Run(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		final = run
+	}
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		var se *statusError
+		if errors.As(err, &se) {
+			writeError(w, se.status, err)
+		}
+		return
+	}
+	if stream == nil {
+		_ = ws.WriteJSON(runEvent{Event: "run", Run: final})
+		ws.Close(wsCloseNormal, "")
+		return
+	}
+
+	history, ch := stream.subscribe()
+	defer stream.unsubscribe(ch)
+	for _, msg := range history {
+		if ws.WriteText(msg) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				if stream.finished() {
+					ws.Close(wsCloseNormal, "")
+				} else {
+					ws.Close(wsClosePolicy, "too slow")
+				}
+				return
+			}
+			if ws.WriteText(msg) != nil {
+				return
+			}
+		case <-ws.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+// FinishRun stores the results of run id and sets its final status: failed
+// if runErr is set or any execution failed, succeeded otherwise.
+func FinishRun(ctx context.Context, store *core.Store, id int64, results []api.NodeRunResult, runErr error) error {
+	status := api.RunSucceeded
+	if runErr != nil {
+		status = api.RunFailed
+	}
+	recorded := make([]core.NodeResult, len(results))
+	for i, res := range results {
+		if !res.OK() {
+			status = api.RunFailed
+		}
+		nr := core.NodeResult{
+			Node:          res.Node.Name,
+			IP:            res.Node.DialIP(),
+			Chunk:         res.Chunk,
+			ExitCode:      res.ExitCode,
+			Truncated:     res.Truncated,
+			LimitExceeded: res.LimitExceeded,
+			StartedAt:     res.Started,
+			FinishedAt:    res.Finished,
+		}
+		if res.Err != nil {
+			nr.Error = redact.String(res.Err.Error())
+		}
+		nr.SummarizeOutput(res.Stdout + redact.String(res.Stderr))
+		recorded[i] = nr
+	}
+	if err := store.FinishRun(ctx, id, string(status), time.Now(), recorded); err != nil {
+		return fmt.Errorf("record run %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+// Package controller implements gaxx serve: a REST API over the same
+// orchestration as the CLI, with runs recorded in the local store so any
+// operator or CI job can start one and fetch its results later.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+// DefaultRunTimeout bounds a run or scan when Server.RunTimeout is zero.
+const DefaultRunTimeout = 2 * time.Hour
+
+// Server serves the controller API:
+//
+//	POST   /v0/fleets               spawn a fleet (api.SpawnRequest)
+//	GET    /v0/fleets/{name}        list the fleet's nodes
+//	GET    /v0/fleets/{name}/status heartbeat the fleet's agents
+//	DELETE /v0/fleets/{name}        delete the fleet
+//	POST   /v0/runs                 start a run or scan (api.RunRequest)
+//	GET    /v0/runs                 list recent runs
+//	GET    /v0/runs/{id}            a run and its results
+//	DELETE /v0/runs/{id}            cancel a running run
+//	GET    /v0/runs/{id}/events     stream the run's progress (WebSocket)
+//
+// Runs execute in the background; POST /v0/runs answers 202 with the run
+// and clients poll GET /v0/runs/{id} until its status is no longer running,
+// or follow its events.
+// Every request must authenticate; see requireAuth.
+type Server struct {
+	Client *api.Client
+	Store  *core.Store
+	// Token, if set, is accepted with admin scope alongside the API keys
+	// in Store. Either is sent as a Bearer token or in X-Auth-Token.
+	Token string
+	// RunTimeout bounds each run; 0 means DefaultRunTimeout.
+	RunTimeout time.Duration
+
+	mu      sync.Mutex
+	cancels map[int64]context.CancelFunc
+	streams map[int64]*runStream
+	runs    sync.WaitGroup
+}
+
+// Handler returns the API handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.routes(mux)
+	return s.requireAuth(mux)
+}
+
+func (s *Server) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/v0/fleets", s.serveFleets)
+	mux.HandleFunc("/v0/fleets/", s.serveFleet)
+	mux.HandleFunc("/v0/runs", s.serveRuns)
+	mux.HandleFunc("/v0/runs/", s.serveRun)
+}
+
+// Stop cancels every running run and waits until their results are
+// recorded.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.mu.Unlock()
+	s.runs.Wait()
+}
+
+// client returns a client using provider, or the server's default.
+func (s *Server) client(provider string) *api.Client {
+	if provider == "" {
+		return s.Client
+	}
+	c := *s.Client
+	c.Provider = provider
+	return &c
+}
+
+func (s *Server) serveFleets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var req api.SpawnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("fleet name is required"))
+		return
+	}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+			return
+		}
+		req.Tags = append(req.Tags, api.ExpiryTag(time.Now().Add(ttl)))
+	}
+	fleet, err := s.client(req.Provider).Spawn(r.Context(), req.CreateFleetRequest, req.Policy)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, fleet)
+}
+
+func (s *Server) serveFleet(w http.ResponseWriter, r *http.Request) {
+	name, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v0/fleets/"), "/")
+	if name == "" || (sub != "" && sub != "status") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	client := s.client(r.URL.Query().Get("provider"))
+	switch {
+	case r.Method == http.MethodGet && sub == "":
+		nodes, err := client.ListNodes(r.Context(), name)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, api.Fleet{Name: name, Nodes: nodes})
+	case r.Method == http.MethodGet && sub == "status":
+		nodes, err := client.ListNodes(r.Context(), name)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, agentStatuses(client.AgentVersions(r.Context(), nodes)))
+	case r.Method == http.MethodDelete && sub == "":
+		if err := client.Delete(r.Context(), name); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// agentStatus is one node in GET /v0/fleets/{name}/status.
+type agentStatus struct {
+	Node    string `json:"node"`
+	IP      string `json:"ip"`
+	Up      bool   `json:"up"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func agentStatuses(versions []api.AgentVersion) []agentStatus {
+	out := make([]agentStatus, len(versions))
+	for i, v := range versions {
+		out[i] = agentStatus{Node: v.Node.Name, IP: v.Node.DialIP(), Up: v.Err == nil, Version: v.Version}
+		if v.Err != nil {
+			out[i].Error = v.Err.Error()
+		}
+	}
+	return out
+}
+
+func (s *Server) serveRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			limit = n
+		}
+		runs, err := s.Store.Runs(r.Context(), limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, nonNil(runs))
+	case http.MethodPost:
+		var req api.RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+			return
+		}
+		run, err := s.startRun(r.Context(), req)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// startRun resolves the fleet's nodes, records the run and starts it in the
+// background.
+func (s *Server) startRun(ctx context.Context, req api.RunRequest) (core.Run, error) {
+	if req.Fleet == "" {
+		return core.Run{}, badRequest(errors.New("fleet is required"))
+	}
+	if req.Task.Command == "" && len(req.Task.Steps) == 0 {
+		return core.Run{}, badRequest(errors.New("task.command or task.steps is required"))
+	}
+	task := req.Task.Render(req.Vars)
+	if task.Name == "" {
+		task.Name = task.Command
+	}
+	client := s.client(req.Provider)
+	nodes, err := client.RequireNodes(ctx, req.Fleet)
+	var empty *api.EmptyFleetError
+	if errors.As(err, &empty) {
+		return core.Run{}, badRequest(err)
+	}
+	if err != nil {
+		return core.Run{}, err
+	}
+	if nodes, err = api.SelectNodes(nodes, req.Select); err != nil {
+		return core.Run{}, badRequest(err)
+	}
+	if len(nodes) == 0 {
+		return core.Run{}, badRequest(fmt.Errorf("no nodes of fleet %s match select %q", req.Fleet, req.Select))
+	}
+
+	started := time.Now()
+	id, err := s.Store.BeginRun(ctx, req.Fleet, redact.String(task.Name), started)
+	if err != nil {
+		return core.Run{}, err
+	}
+	timeout := s.RunTimeout
+	if timeout <= 0 {
+		timeout = DefaultRunTimeout
+	}
+	runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	s.mu.Lock()
+	if s.cancels == nil {
+		s.cancels = map[int64]context.CancelFunc{}
+	}
+	s.cancels[id] = cancel
+	run := core.Run{ID: id, Fleet: req.Fleet, Module: task.Name, Status: string(api.RunRunning), StartedAt: started}
+	stream := newRunStream(run)
+	if s.streams == nil {
+		s.streams = map[int64]*runStream{}
+	}
+	s.streams[id] = stream
+	s.mu.Unlock()
+	client = eventsFor(client, stream)
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.cancels, id)
+			s.mu.Unlock()
+			cancel()
+		}()
+		var results []api.NodeRunResult
+		var err error
+		if len(req.Uploads) > 0 {
+			var plan *api.UploadPlan
+			if plan, err = client.PlanUploads(runCtx, nodes, req.Uploads); err == nil {
+				results, err = client.ScanPlanned(runCtx, plan, &task)
+			}
+		} else {
+			results, err = client.RunNodes(runCtx, nodes, &task)
+		}
+		// The run's context may be cancelled; recording gets its own.
+		ctx := context.Background()
+		_ = FinishRun(ctx, s.Store, id, results, err)
+		final, err := s.Store.Run(ctx, id)
+		if err != nil {
+			final = run
+		}
+		stream.finish(final)
+		s.mu.Lock()
+		delete(s.streams, id)
+		s.mu.Unlock()
+	}()
+	return run, nil
+}
+
+// runDetail is the body of GET /v0/runs/{id}.
+type runDetail struct {
+	core.Run
+	Results []core.NodeResult `json:"results"`
+}
+
+func (s *Server) serveRun(w http.ResponseWriter, r *http.Request) {
+	idPart, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v0/runs/"), "/")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || (sub != "" && sub != "events") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if sub == "events" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		s.serveRunEvents(w, r, id)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		run, err := s.Store.Run(r.Context(), id)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		q := r.URL.Query()
+		filter := core.ResultFilter{Node: q.Get("node"), Failed: q.Get("failed") == "true", Contains: q.Get("grep")}
+		results, err := s.Store.Results(r.Context(), id, filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, runDetail{Run: run, Results: nonNil(results)})
+	case http.MethodDelete:
+		s.mu.Lock()
+		cancel, ok := s.cancels[id]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusConflict, fmt.Errorf("run %d is not running", id))
+			return
+		}
+		cancel()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// statusError carries the HTTP status for an error.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+func badRequest(err error) error { return &statusError{http.StatusBadRequest, err} }
+
+// errorStatus maps an error from the client or store to an HTTP status.
+func errorStatus(err error) int {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.status
+	case errors.Is(err, core.ErrRunNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, providers.ErrFleetExists):
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,388 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+func init() {
+	api.RegisterProvider("mem", func(api.Config) api.Provider { return &memProvider{} })
+}
+
+// memProvider keeps fleets in memory.
+type memProvider struct {
+	mu    sync.Mutex
+	nodes []api.Node
+}
+
+func (p *memProvider) Name() string { return "mem" }
+func (p *memProvider) CreateFleet(ctx context.Context, req api.CreateFleetRequest) (*api.Fleet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fleet := &api.Fleet{Name: req.Name}
+	for i := 0; i < req.Count; i++ {
+		n := api.Node{Name: fmt.Sprintf("%s-%d", req.Name, req.StartIndex+i), IP: fmt.Sprintf("10.0.0.%d", len(p.nodes)+1)}
+		p.nodes = append(p.nodes, n)
+		fleet.Nodes = append(fleet.Nodes, n)
+	}
+	return fleet, nil
+}
+func (p *memProvider) ListNodes(ctx context.Context, name string) ([]api.Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []api.Node
+	for _, n := range p.nodes {
+		if strings.HasPrefix(n.Name, name) {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+func (p *memProvider) DeleteFleet(ctx context.Context, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var kept []api.Node
+	for _, n := range p.nodes {
+		if !strings.HasPrefix(n.Name, name) {
+			kept = append(kept, n)
+		}
+	}
+	p.nodes = kept
+	return nil
+}
+
+// nodeExecutor fails on the second node of every fleet.
+type nodeExecutor struct{}
+
+func (nodeExecutor) Exec(ctx context.Context, node api.Node, req api.ExecRequest) (api.ExecResponse, error) {
+	if strings.HasSuffix(node.Name, "-2") {
+		return api.ExecResponse{Stdout: "no route\n", ExitCode: 2}, nil
+	}
+	return api.ExecResponse{Stdout: node.Name + " ok\n"}, nil
+}
+
+func TestServer(t *testing.T) {
+	var cfg api.Config
+	cfg.Providers.Default = "mem"
+	client := api.NewClient(cfg)
+	client.Executor = nodeExecutor{}
+	store, err := core.OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := &Server{Client: client, Store: store, Token: "secret"}
+	defer s.Stop()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	do := func(method, path string, body any, out any) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, ts.URL+path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatalf("%s %s: decode: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(ts.URL + "/v0/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", resp.StatusCode)
+	}
+
+	var fleet api.Fleet
+	if code := do("POST", "/v0/fleets", map[string]any{"name": "scan", "count": 3}, &fleet); code != http.StatusCreated || len(fleet.Nodes) != 3 {
+		t.Fatalf("spawn: %d %+v", code, fleet)
+	}
+	if code := do("POST", "/v0/fleets", map[string]any{"name": "scan", "count": 3}, nil); code != http.StatusConflict {
+		t.Errorf("spawn existing: status %d", code)
+	}
+	if code := do("GET", "/v0/fleets/scan", nil, &fleet); code != http.StatusOK || len(fleet.Nodes) != 3 {
+		t.Errorf("list: %d %+v", code, fleet)
+	}
+
+	var run core.Run
+	req := api.RunRequest{Fleet: "scan", Task: api.TaskSpec{Name: "probe", Command: "probe"}, Select: "index<=2"}
+	if code := do("POST", "/v0/runs", req, &run); code != http.StatusAccepted || run.Status != "running" {
+		t.Fatalf("run: %d %+v", code, run)
+	}
+	var detail runDetail
+	path := fmt.Sprintf("/v0/runs/%d", run.ID)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if code := do("GET", path, nil, &detail); code != http.StatusOK {
+			t.Fatalf("get run: %d", code)
+		}
+		if detail.Status != "running" || time.Now().After(deadline) {
+			break
+		}
+	}
+	if detail.Status != "failed" || len(detail.Results) != 2 || detail.Results[0].OutputHead != "scan-1 ok\n" {
+		t.Fatalf("run detail = %+v", detail)
+	}
+	if code := do("GET", path+"?failed=true", nil, &detail); code != http.StatusOK || len(detail.Results) != 1 || detail.Results[0].Node != "scan-2" {
+		t.Errorf("failed results = %+v", detail.Results)
+	}
+	if code := do("DELETE", path, nil, nil); code != http.StatusConflict {
+		t.Errorf("cancel finished run: status %d", code)
+	}
+
+	var runs []core.Run
+	if code := do("GET", "/v0/runs", nil, &runs); code != http.StatusOK || len(runs) != 1 || runs[0].ID != run.ID {
+		t.Errorf("runs = %d %+v", code, runs)
+	}
+	if code := do("GET", "/v0/runs/999", nil, nil); code != http.StatusNotFound {
+		t.Errorf("missing run: status %d", code)
+	}
+	if code := do("POST", "/v0/runs", api.RunRequest{Fleet: "scan", Task: api.TaskSpec{Command: "x"}, Select: "role=="}, nil); code != http.StatusBadRequest {
+		t.Errorf("bad selector: status %d", code)
+	}
+
+	if code := do("DELETE", "/v0/fleets/scan", nil, nil); code != http.StatusNoContent {
+		t.Errorf("delete: status %d", code)
+	}
+	if code := do("GET", "/v0/fleets/scan", nil, &fleet); code != http.StatusOK || len(fleet.Nodes) != 0 {
+		t.Errorf("after delete: %d %+v", code, fleet)
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	var cfg api.Config
+	cfg.Providers.Default = "mem"
+	client := api.NewClient(cfg)
+	client.Executor = nodeExecutor{}
+	store, err := core.OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := &Server{Client: client, Store: store}
+	defer s.Stop()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	tokens := map[Scope]string{}
+	for _, scope := range []Scope{ScopeRead, ScopeExecute, ScopeAdmin} {
+		token, _, err := store.CreateAPIKey(ctx, string(scope)+"-key", string(scope))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[scope] = token
+	}
+
+	do := func(token, method, path string, body any) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, ts.URL+path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Auth-Token", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	spawn := map[string]any{"name": "keys", "count": 2}
+	run := api.RunRequest{Fleet: "keys", Task: api.TaskSpec{Command: "probe"}}
+	tests := []struct {
+		token  string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"", "GET", "/v0/runs", nil, http.StatusUnauthorized},
+		{"gxk_unknown", "GET", "/v0/runs", nil, http.StatusUnauthorized},
+		{tokens[ScopeRead], "POST", "/v0/fleets", spawn, http.StatusForbidden},
+		{tokens[ScopeExecute], "POST", "/v0/fleets", spawn, http.StatusForbidden},
+		{tokens[ScopeAdmin], "POST", "/v0/fleets", spawn, http.StatusCreated},
+		{tokens[ScopeRead], "GET", "/v0/fleets/keys", nil, http.StatusOK},
+		{tokens[ScopeRead], "POST", "/v0/runs", run, http.StatusForbidden},
+		{tokens[ScopeExecute], "POST", "/v0/runs", run, http.StatusAccepted},
+		{tokens[ScopeRead], "GET", "/v0/runs", nil, http.StatusOK},
+		{tokens[ScopeExecute], "DELETE", "/v0/fleets/keys", nil, http.StatusForbidden},
+		{tokens[ScopeAdmin], "DELETE", "/v0/fleets/keys", nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if code := do(tt.token, tt.method, tt.path, tt.body); code != tt.want {
+			t.Errorf("%s %s with %.8q: status %d, want %d", tt.method, tt.path, tt.token, code, tt.want)
+		}
+	}
+
+	if err := store.RevokeAPIKey(ctx, "read-key"); err != nil {
+		t.Fatal(err)
+	}
+	if code := do(tokens[ScopeRead], "GET", "/v0/runs", nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d", code)
+	}
+}
+
+// gateExecutor holds every execution until release is closed.
+type gateExecutor struct{ release chan struct{} }
+
+func (g gateExecutor) Exec(ctx context.Context, node api.Node, req api.ExecRequest) (api.ExecResponse, error) {
+	<-g.release
+	return api.ExecResponse{Stdout: node.Name + " ok\n"}, nil
+}
+
+// dialEvents opens the WebSocket at path and returns a function reading its
+// text messages until the server closes it, when it returns nil.
+func dialEvents(t *testing.T, ts *httptest.Server, path string) func() map[string]any {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", path)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return func() map[string]any {
+		t.Helper()
+		var head [2]byte
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := int(head[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			_, _ = io.ReadFull(br, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		if head[0]&0x0F == wsClose {
+			return nil
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("message %q: %v", payload, err)
+		}
+		return msg
+	}
+}
+
+func TestServerRunEvents(t *testing.T) {
+	var cfg api.Config
+	cfg.Providers.Default = "mem"
+	client := api.NewClient(cfg)
+	gate := gateExecutor{release: make(chan struct{})}
+	client.Executor = gate
+	store, err := core.OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := &Server{Client: client, Store: store, Token: "secret"}
+	defer s.Stop()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	if _, err := client.Spawn(context.Background(), api.CreateFleetRequest{Name: "live", Count: 2}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(api.RunRequest{Fleet: "live", Task: api.TaskSpec{Command: "probe"}})
+	req, _ := http.NewRequest("POST", ts.URL+"/v0/runs", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run core.Run
+	_ = json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	path := fmt.Sprintf("/v0/runs/%d/events", run.ID)
+
+	resp, err = http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", resp.StatusCode)
+	}
+
+	next := dialEvents(t, ts, path+"?token=secret")
+	first := next()
+	if first["event"] != "run" || first["run"].(map[string]any)["status"] != "running" {
+		t.Fatalf("first event = %v", first)
+	}
+	close(gate.release)
+	phases := map[string]int{}
+	var last map[string]any
+	for msg := next(); msg != nil; msg = next() {
+		phases[msg["event"].(string)]++
+		last = msg
+	}
+	if phases["finished"] != 2 || phases["output"] != 2 {
+		t.Errorf("phases = %v", phases)
+	}
+	if last["event"] != "run" || last["run"].(map[string]any)["status"] != "succeeded" {
+		t.Errorf("last event = %v", last)
+	}
+
+	// A finished run sends only its final state.
+	next = dialEvents(t, ts, path+"?token=secret")
+	if msg := next(); msg["event"] != "run" || msg["run"].(map[string]any)["status"] != "succeeded" {
+		t.Errorf("finished run event = %v", msg)
+	}
+	if msg := next(); msg != nil {
+		t.Errorf("after final event: %v", msg)
+	}
+}
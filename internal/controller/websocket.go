@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the RFC 6455 constant hashed into the handshake.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes and close codes the controller uses.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+
+	wsCloseNormal = 1000
+	wsClosePolicy = 1008
+)
+
+// wsWriteTimeout bounds each frame write so a stalled client can't pin a
+// handler.
+const wsWriteTimeout = 10 * time.Second
+
+// wsConn is the server side of a WebSocket that only sends text messages;
+// the controller's streams are one-way. Frames from the client are read to
+// answer pings and to notice when it closes or goes away.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu   sync.Mutex // serializes writes
+	done chan struct{}
+	once sync.Once
+}
+
+// isWebSocket reports whether r asks to upgrade to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. Errors before the handshake carry an HTTP status.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !isWebSocket(r) {
+		return nil, &statusError{http.StatusUpgradeRequired, errors.New("expected a WebSocket upgrade")}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		return nil, badRequest(errors.New("unsupported WebSocket handshake"))
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, &statusError{http.StatusInternalServerError, errors.New("connection can't be upgraded")}
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, &statusError{http.StatusInternalServerError, err}
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &wsConn{conn: conn, br: rw.Reader, done: make(chan struct{})}
+	go c.readLoop()
+	return c, nil
+}
+
+// Done is closed once the client closes the connection or it fails.
+func (c *wsConn) Done() <-chan struct{} { return c.done }
+
+// WriteText sends msg as one text message.
+func (c *wsConn) WriteText(msg []byte) error { return c.writeFrame(wsText, msg) }
+
+// WriteJSON sends v encoded as JSON.
+func (c *wsConn) WriteJSON(v any) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteText(msg)
+}
+
+// Close sends a close frame with code and reason and closes the connection.
+func (c *wsConn) Close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	_ = c.writeFrame(wsClose, append(payload, reason...))
+	c.shutdown()
+}
+
+func (c *wsConn) shutdown() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writeFrame writes one unmasked, unfragmented frame, as servers send.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		c.shutdown()
+		return err
+	}
+	return nil
+}
+
+// readLoop consumes client frames until the client closes the connection.
+// Clients have nothing to say on these streams, so data frames are
+// discarded and anything but a small control frame ends the connection.
+func (c *wsConn) readLoop() {
+	defer c.shutdown()
+	var head [2]byte
+	for {
+		if _, err := io.ReadFull(c.br, head[:]); err != nil {
+			return
+		}
+		opcode := head[0] & 0x0F
+		masked := head[1]&0x80 != 0
+		n := uint64(head[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		// Clients must mask their frames, and control frames are short.
+		if !masked || n > 1<<16 {
+			c.Close(wsClosePolicy, "unexpected frame")
+			return
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch opcode {
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return
+		case wsPing:
+			_ = c.writeFrame(wsPong, payload)
+		}
+	}
+}
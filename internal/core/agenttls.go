@@ -0,0 +1,127 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// AgentConn is how a controller reaches agents: over plain http, or over
+// https with the TLS settings of its config, through one transport that
+// keeps idle connections to each node. Each client has its own, made by
+// NewAgentConn; a nil *AgentConn is plain http over a transport shared by
+// the process.
+type AgentConn struct {
+	scheme    string
+	transport *http.Transport
+	err       error
+}
+
+// plainAgentConn is what a nil *AgentConn uses.
+var plainAgentConn = &AgentConn{scheme: "http", transport: newAgentTransport()}
+
+// A fleet-wide run makes many calls to each agent, so idle connections are
+// kept per node for the next call instead of dialling every time.
+const (
+	agentMaxIdleConns        = 1024
+	agentMaxIdleConnsPerHost = 16
+	agentIdleConnTimeout     = 90 * time.Second
+)
+
+// newAgentTransport returns a transport for agent requests: the default
+// one, with TCP keepalives, tuned to keep connections to many nodes.
+// It asks agents for gzip and decompresses their responses transparently,
+// which shrinks large exec output on the wire.
+func newAgentTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DisableCompression = false
+	tr.MaxIdleConns = agentMaxIdleConns
+	tr.MaxIdleConnsPerHost = agentMaxIdleConnsPerHost
+	tr.IdleConnTimeout = agentIdleConnTimeout
+	return tr
+}
+
+// NewAgentConn returns how to reach agents under cfg.Agent.TLS: over https
+// when enabled, for agents started with GAXX_AGENT_TLS_CERT, and otherwise
+// plain http. Agents are verified against the CA bundle (or the system
+// roots) unless InsecureSkipVerify is set, and the client certificate, if
+// any, is presented to agents that require mTLS. If the certificates can't
+// be loaded, Err says why and every request through the conn fails with it.
+func NewAgentConn(cfg providers.Config) *AgentConn {
+	a := &AgentConn{scheme: "http", transport: newAgentTransport()}
+	if cfg.Agent.TLS.Enabled {
+		tlsCfg, err := agentTLSConfig(cfg)
+		if err != nil {
+			return &AgentConn{scheme: "https", err: err}
+		}
+		a.transport.TLSClientConfig = tlsCfg
+		a.scheme = "https"
+	}
+	return a
+}
+
+// Err reports why the conn's TLS settings could not be loaded, if they
+// could not.
+func (a *AgentConn) Err() error {
+	if a == nil {
+		return nil
+	}
+	return a.err
+}
+
+func (a *AgentConn) orPlain() *AgentConn {
+	if a == nil {
+		return plainAgentConn
+	}
+	return a
+}
+
+func agentTLSConfig(cfg providers.Config) (*tls.Config, error) {
+	t := cfg.Agent.TLS
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CACert != "" {
+		pem, err := os.ReadFile(t.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("agent tls: read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("agent tls: no certificates in %s", t.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return nil, errors.New("agent tls: client_cert and client_key must be set together")
+	}
+	if t.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("agent tls: load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// URL returns the URL of path (such as "/v0/exec") on the node's
+// gaxx-agent.
+func (a *AgentConn) URL(node providers.Node, path string) string {
+	return a.orPlain().scheme + "://" + node.AgentAddr() + path
+}
+
+// client returns an HTTP client for agent requests, or the conn's Err.
+// Clients differ only in their timeout; all share the conn's transport, and
+// so its connections.
+func (a *AgentConn) client(timeout time.Duration) (*http.Client, error) {
+	a = a.orPlain()
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &http.Client{Timeout: timeout, Transport: a.transport}, nil
+}
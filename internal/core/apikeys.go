@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrAPIKeyNotFound is returned for a token or key name the store doesn't
+// know.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// apiKeyPrefix starts every generated token so leaked keys are easy to
+// recognise and grep for.
+const apiKeyPrefix = "gxk_"
+
+// APIKey is a controller API key. Only a hash of its token is stored; the
+// token itself is shown once, when the key is created.
+type APIKey struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey generates a key named name with scope and returns its token.
+func (s *Store) CreateAPIKey(ctx context.Context, name, scope string) (string, APIKey, error) {
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", APIKey{}, err
+	}
+	token := apiKeyPrefix + hex.EncodeToString(raw[:])
+	key := APIKey{Name: name, Scope: scope, CreatedAt: time.Now().UTC()}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO api_keys (name, hash, scope, created_at) VALUES (?, ?, ?, ?)`,
+		name, hashAPIToken(token), scope, key.CreatedAt)
+	if err != nil {
+		var se sqlite3.Error
+		if errors.As(err, &se) && se.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return "", APIKey{}, fmt.Errorf("api key %q already exists", name)
+		}
+		return "", APIKey{}, fmt.Errorf("create api key: %w", err)
+	}
+	key.ID, _ = res.LastInsertId()
+	return token, key, nil
+}
+
+// AuthenticateAPIKey returns the key whose token is token and records its
+// use, or ErrAPIKeyNotFound.
+func (s *Store) AuthenticateAPIKey(ctx context.Context, token string) (APIKey, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, name, scope, created_at, last_used_at FROM api_keys WHERE hash = ?`, hashAPIToken(token))
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, err
+	}
+	key.LastUsedAt = time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, key.LastUsedAt, key.ID)
+	return key, err
+}
+
+// APIKeys lists the API keys by name.
+func (s *Store) APIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, scope, created_at, last_used_at FROM api_keys ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, key)
+	}
+	return out, rows.Err()
+}
+
+// RevokeAPIKey deletes the key named name.
+func (s *Store) RevokeAPIKey(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", name, ErrAPIKeyNotFound)
+	}
+	return nil
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var used sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Scope, &key.CreatedAt, &used); err != nil {
+		return key, err
+	}
+	key.LastUsedAt = used.Time
+	return key, nil
+}
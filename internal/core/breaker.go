@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// BreakerState is the state of a node's circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed sends the node work as usual.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen refuses work for the node until its cooldown ends.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets one probe through after the cooldown; its
+	// outcome closes or reopens the breaker.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrBreakerOpen is returned for work refused because the node's circuit
+// breaker is open.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// NodeBreaker is the circuit breaker state of one node. Failures counts
+// consecutive executions the node could not run; Until is when an open
+// breaker lets a probe through.
+type NodeBreaker struct {
+	Node     string
+	State    BreakerState
+	Failures int
+	Until    time.Time
+}
+
+// BreakerStore keeps breaker state between processes, so a node found
+// broken by one run is skipped by the next and gaxx status can show it.
+// *Store is one.
+type BreakerStore interface {
+	NodeBreakers(ctx context.Context) ([]NodeBreaker, error)
+	SaveNodeBreaker(ctx context.Context, b NodeBreaker) error
+}
+
+// CircuitBreakers tracks a breaker per node: Threshold consecutive failures
+// open it for Cooldown, after which a single probe decides whether it
+// closes again. It is safe for concurrent use.
+type CircuitBreakers struct {
+	Threshold int
+	Cooldown  time.Duration
+	// Store, if set, is read on first use and updated on every change.
+	Store BreakerStore
+
+	once    sync.Once
+	mu      sync.Mutex
+	nodes   map[string]*NodeBreaker
+	probing map[string]bool
+}
+
+// NewCircuitBreakers returns breakers that open after threshold
+// consecutive failures for cooldown.
+func NewCircuitBreakers(threshold int, cooldown time.Duration) *CircuitBreakers {
+	return &CircuitBreakers{Threshold: threshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreakers) load() {
+	b.once.Do(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.nodes, b.probing = map[string]*NodeBreaker{}, map[string]bool{}
+		if b.Store == nil {
+			return
+		}
+		// Without the saved state every breaker starts closed.
+		saved, _ := b.Store.NodeBreakers(context.Background())
+		for i := range saved {
+			if saved[i].State != BreakerClosed {
+				b.nodes[saved[i].Node] = &saved[i]
+			}
+		}
+	})
+}
+
+// Allow reports whether node may be sent work: nil when its breaker is
+// closed, or when it is the probe after the cooldown, and an error wrapping
+// ErrBreakerOpen otherwise. Every allowed execution must be recorded, or a
+// probe holds the breaker half-open.
+func (b *CircuitBreakers) Allow(node string) error {
+	b.load()
+	b.mu.Lock()
+	nb, ok := b.nodes[node]
+	if !ok || nb.State == BreakerClosed {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.probing[node] {
+		b.mu.Unlock()
+		return fmt.Errorf("node %s: %w (probe running)", node, ErrBreakerOpen)
+	}
+	if nb.State == BreakerOpen && time.Now().Before(nb.Until) {
+		until := nb.Until
+		b.mu.Unlock()
+		return fmt.Errorf("node %s: %w until %s", node, ErrBreakerOpen, until.Local().Format(time.TimeOnly))
+	}
+	nb.State = BreakerHalfOpen
+	b.probing[node] = true
+	saved := *nb
+	b.mu.Unlock()
+	b.save(saved)
+	return nil
+}
+
+// Record notes the outcome of an execution on node that Allow let through.
+func (b *CircuitBreakers) Record(node string, failed bool) {
+	b.load()
+	b.mu.Lock()
+	nb, ok := b.nodes[node]
+	if !ok {
+		if !failed {
+			b.mu.Unlock()
+			return
+		}
+		nb = &NodeBreaker{Node: node, State: BreakerClosed}
+		b.nodes[node] = nb
+	}
+	delete(b.probing, node)
+	before := *nb
+	switch {
+	case !failed:
+		nb.State, nb.Failures, nb.Until = BreakerClosed, 0, time.Time{}
+	case nb.State == BreakerHalfOpen:
+		nb.Failures++
+		nb.State, nb.Until = BreakerOpen, time.Now().Add(b.Cooldown)
+	default:
+		nb.Failures++
+		if nb.Failures >= b.Threshold {
+			nb.State, nb.Until = BreakerOpen, time.Now().Add(b.Cooldown)
+		}
+	}
+	saved := *nb
+	if nb.Failures == 0 {
+		delete(b.nodes, node)
+	}
+	b.mu.Unlock()
+	if saved != before {
+		b.save(saved)
+	}
+}
+
+// abandon ends an execution that was cancelled before it could tell
+// whether node works, leaving its breaker as it was.
+func (b *CircuitBreakers) abandon(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.probing, node)
+}
+
+func (b *CircuitBreakers) save(nb NodeBreaker) {
+	if b.Store != nil {
+		_ = b.Store.SaveNodeBreaker(context.Background(), nb)
+	}
+}
+
+// States returns the breakers of nodes that recently failed, by node name.
+func (b *CircuitBreakers) States() []NodeBreaker {
+	b.load()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]NodeBreaker, 0, len(b.nodes))
+	for _, nb := range b.nodes {
+		out = append(out, *nb)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
+
+// BreakerExecutor runs commands through Executor unless the node's breaker
+// is open. Only failures to run a command count against a node: a non-zero
+// exit is a result, and busy or draining agents are working as intended.
+type BreakerExecutor struct {
+	Executor Executor
+	Breakers *CircuitBreakers
+}
+
+func (e BreakerExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	if err := e.Breakers.Allow(node.Name); err != nil {
+		return agent.ExecResponse{}, err
+	}
+	resp, err := e.Executor.Exec(ctx, node, req)
+	if ctx.Err() != nil {
+		e.Breakers.abandon(node.Name)
+		return resp, err
+	}
+	failed := err != nil && !errors.Is(err, ErrAgentBusy) && !errors.Is(err, ErrAgentDraining)
+	e.Breakers.Record(node.Name, failed)
+	return resp, err
+}
+
+// NodeBreakers returns the saved breakers that are not closed.
+func (s *Store) NodeBreakers(ctx context.Context) ([]NodeBreaker, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT node, state, failures, until FROM node_breakers ORDER BY node`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NodeBreaker
+	for rows.Next() {
+		var nb NodeBreaker
+		var until sql.NullTime
+		if err := rows.Scan(&nb.Node, &nb.State, &nb.Failures, &until); err != nil {
+			return nil, err
+		}
+		nb.Until = until.Time
+		out = append(out, nb)
+	}
+	return out, rows.Err()
+}
+
+// SaveNodeBreaker records the breaker of one node, forgetting it once it
+// closes.
+func (s *Store) SaveNodeBreaker(ctx context.Context, nb NodeBreaker) error {
+	var err error
+	if nb.State == BreakerClosed {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM node_breakers WHERE node = ?`, nb.Node)
+	} else {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO node_breakers (node, state, failures, until, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (node) DO UPDATE SET state = excluded.state, failures = excluded.failures,
+				until = excluded.until, updated_at = excluded.updated_at`,
+			nb.Node, string(nb.State), nb.Failures, nb.Until.UTC(), time.Now().UTC())
+	}
+	if err != nil {
+		return fmt.Errorf("save breaker of %s: %w", nb.Node, err)
+	}
+	return nil
+}
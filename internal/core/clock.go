@@ -0,0 +1,47 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxClockSkew is how far an agent's clock may be from the controller's
+// before gaxx warns: beyond it TLS validity checks, token expiry and
+// timeouts stop meaning the same thing on both ends.
+const MaxClockSkew = 30 * time.Second
+
+// ClockSkew estimates how far ahead of the local clock an agent's is (negative
+// when behind), given the time the agent reported while answering a request
+// sent at sent and answered at received. The agent stamped it somewhere in
+// that round trip, so the estimate is discounted by half of it and a
+// difference within that is reported as zero.
+func ClockSkew(agentTime, sent, received time.Time) time.Duration {
+	half := received.Sub(sent) / 2
+	skew := agentTime.Sub(sent.Add(half))
+	switch {
+	case skew > half:
+		return skew - half
+	case skew < -half:
+		return skew + half
+	}
+	return 0
+}
+
+// skewWarned holds the nodes Heartbeat already warned about, so a command
+// heartbeating repeatedly warns once.
+var skewWarned sync.Map
+
+// warnClockSkew logs a warning, once per node, when skew exceeds
+// MaxClockSkew.
+func warnClockSkew(node string, skew time.Duration) {
+	if skew.Abs() <= MaxClockSkew {
+		return
+	}
+	if _, warned := skewWarned.LoadOrStore(node, true); warned {
+		return
+	}
+	log.Warn().Str("node", node).Dur("skew", skew.Round(time.Second)).
+		Msg("Agent clock differs from this machine's; sync clocks with NTP (timedatectl set-ntp true)")
+}
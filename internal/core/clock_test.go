@@ -0,0 +1,25 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	received := sent.Add(2 * time.Second)
+	for _, tc := range []struct {
+		agent time.Time
+		want  time.Duration
+	}{
+		{sent.Add(time.Second), 0},
+		{sent, 0},
+		{received, 0},
+		{sent.Add(time.Minute + time.Second), time.Minute - time.Second},
+		{sent.Add(-time.Minute), -time.Minute},
+	} {
+		if got := ClockSkew(tc.agent, sent, received); got != tc.want {
+			t.Errorf("ClockSkew(%v) = %v, want %v", tc.agent.Sub(sent), got, tc.want)
+		}
+	}
+}
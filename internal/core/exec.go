@@ -0,0 +1,505 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// Executor runs a command on a fleet node.
+type Executor interface {
+	Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error)
+}
+
+// AgentExecutor runs commands through the node's gaxx-agent, reached with
+// Conn (plain http when nil).
+type AgentExecutor struct {
+	Conn *AgentConn
+}
+
+func (e AgentExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	return e.Conn.Exec(ctx, node, req)
+}
+
+// SSHExecutor runs commands over SSH using the controller's key.
+type SSHExecutor struct {
+	Config providers.Config
+}
+
+func (e SSHExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	return ExecViaSSH(ctx, e.Config, node, req)
+}
+
+type outputKey struct{}
+
+// WithOutput returns a context under which AgentConn.Exec and ExecViaSSH pass
+// a command's output to fn as it arrives, stream being "stdout" or
+// "stderr", as well as returning all of it in the response. The agent
+// merges stderr into stdout. Agents that predate streaming, and spooled
+// requests, pass it on once the command exits. fn may be called from
+// several goroutines at once.
+func WithOutput(ctx context.Context, fn func(stream, data string)) context.Context {
+	return context.WithValue(ctx, outputKey{}, fn)
+}
+
+func outputFunc(ctx context.Context) func(stream, data string) {
+	fn, _ := ctx.Value(outputKey{}).(func(stream, data string))
+	return fn
+}
+
+// outputWriter passes up to left bytes written to it on to fn.
+type outputWriter struct {
+	stream string
+	fn     func(stream, data string)
+	left   int
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if n := min(len(p), w.left); n > 0 {
+		w.fn(w.stream, string(p[:n]))
+		w.left -= n
+	}
+	return len(p), nil
+}
+
+// ErrAgentDraining is returned when a node's agent refuses new work because
+// the node is being drained before deletion.
+var ErrAgentDraining = errors.New("agent is draining")
+
+// ErrAgentBusy is returned when a node's agent, or a proxy in front of it,
+// answers 429 because it is already running as much as it accepts.
+var ErrAgentBusy = errors.New("agent is busy")
+
+// ErrAgentForbidden is returned when a node's agent answers 403 because its
+// command policy does not allow the command.
+var ErrAgentForbidden = errors.New("agent refused command")
+
+// FallbackExecutor tries each executor in order until one can reach the
+// node. It only moves on when the executor never got through to the node,
+// because any later failure (an error status, a bad response, a timeout
+// while the command runs) may come after the command has started, and scans
+// and uploads must not run twice. A command that runs and exits non-zero is
+// a result, not a failure; nor is work refused by a draining or busy agent,
+// or forbidden by its command policy.
+type FallbackExecutor []Executor
+
+func (f FallbackExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	if len(f) == 0 {
+		return agent.ExecResponse{}, fmt.Errorf("no executors configured")
+	}
+	var errs []string
+	for _, e := range f {
+		resp, err := e.Exec(ctx, node, req)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrAgentDraining) || errors.Is(err, ErrAgentBusy) || errors.Is(err, ErrAgentForbidden) || !unreachable(err) {
+			if len(errs) > 0 {
+				err = fmt.Errorf("%s; %w", strings.Join(errs, "; "), err)
+			}
+			return resp, err
+		}
+		errs = append(errs, err.Error())
+	}
+	return agent.ExecResponse{}, errors.New(strings.Join(errs, "; "))
+}
+
+// unreachable reports whether err means the node could not be connected to
+// at all, so nothing was sent to it.
+func unreachable(err error) bool {
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return true
+	}
+	var dns *net.DNSError
+	return errors.As(err, &dns)
+}
+
+// ExecMode is how commands reach nodes (defaults.exec_mode or --exec-mode).
+type ExecMode string
+
+const (
+	// ExecModeAgent only uses gaxx-agent.
+	ExecModeAgent ExecMode = "agent"
+	// ExecModeSSH only uses SSH, for fleets without the agent.
+	ExecModeSSH ExecMode = "ssh"
+	// ExecModeAuto probes one node's agent and, if it answers, uses the
+	// agent with SSH fallback; otherwise it goes straight to SSH.
+	ExecModeAuto ExecMode = "auto"
+)
+
+// ParseExecMode parses an execution mode; empty is ExecModeAuto.
+func ParseExecMode(s string) (ExecMode, error) {
+	switch m := ExecMode(s); m {
+	case "":
+		return ExecModeAuto, nil
+	case ExecModeAgent, ExecModeSSH, ExecModeAuto:
+		return m, nil
+	}
+	return "", fmt.Errorf("exec mode %q must be agent, ssh or auto", s)
+}
+
+// NewExecutor returns the executor for cfg.Defaults.ExecMode, reaching
+// agents with conn. An unknown mode is treated as auto.
+func NewExecutor(cfg providers.Config, conn *AgentConn) Executor {
+	switch ExecMode(cfg.Defaults.ExecMode) {
+	case ExecModeAgent:
+		return AgentExecutor{Conn: conn}
+	case ExecModeSSH:
+		return SSHExecutor{Config: cfg}
+	}
+	return &AutoExecutor{Config: cfg, Conn: conn}
+}
+
+// agentProbeTimeout bounds AutoExecutor's probe of a node's agent.
+const agentProbeTimeout = 5 * time.Second
+
+// AutoExecutor decides on its first execution how to reach the fleet: when
+// that node's agent answers at all, commands go to the agent with SSH
+// fallback; when it cannot be reached they go over SSH only, so a fleet
+// without the agent does not pay an agent timeout per node. The decision
+// is kept for the executor's lifetime, which for a Client is one run.
+type AutoExecutor struct {
+	Config providers.Config
+	Conn   *AgentConn
+
+	once   sync.Once
+	chosen Executor
+}
+
+func (e *AutoExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	e.once.Do(func() {
+		if probeExecMode(ctx, e.Conn, node) == ExecModeSSH {
+			e.chosen = SSHExecutor{Config: e.Config}
+		} else {
+			e.chosen = FallbackExecutor{AgentExecutor{Conn: e.Conn}, SSHExecutor{Config: e.Config}}
+		}
+	})
+	return e.chosen.Exec(ctx, node, req)
+}
+
+// probeExecMode returns ExecModeAgent if node's agent answers, even with an
+// error status, and ExecModeSSH if it cannot be reached.
+func probeExecMode(ctx context.Context, conn *AgentConn, node providers.Node) ExecMode {
+	ctx, cancel := context.WithTimeout(ctx, agentProbeTimeout)
+	defer cancel()
+	mode := ExecModeAgent
+	var uerr *url.Error
+	if _, err := conn.Heartbeat(ctx, node); errors.As(err, &uerr) {
+		mode = ExecModeSSH
+	}
+	telemetry.CounterGlobal("gaxx_exec_mode_probes", 1, map[string]string{"mode": string(mode), "component": "cli"})
+	return mode
+}
+
+// Exec posts an exec request to the node's gaxx-agent, asking it to stream
+// the output when ctx has a WithOutput function.
+func (a *AgentConn) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	var resp agent.ExecResponse
+	onOutput := outputFunc(ctx)
+	req.Stream = onOutput != nil && !req.Spool
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	client, err := a.client(time.Duration(req.Timeout)*time.Second + 30*time.Second)
+	if err != nil {
+		return resp, err
+	}
+	url := a.URL(node, "/v0/exec")
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+tok)
+	}
+
+	start := time.Now()
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return resp, fmt.Errorf("agent request: %w", err)
+	}
+	defer drainClose(httpResp.Body)
+	labels := map[string]string{"component": "cli", "endpoint": "exec"}
+	telemetry.TimerGlobal("gaxx_agent_call_duration", time.Since(start), labels)
+	telemetry.SummaryGlobal("gaxx_agent_call_latency_ms", float64(time.Since(start).Milliseconds()), labels)
+	if httpResp.StatusCode == http.StatusServiceUnavailable {
+		return resp, ErrAgentDraining
+	}
+	if httpResp.StatusCode == http.StatusTooManyRequests {
+		return resp, ErrAgentBusy
+	}
+	if httpResp.StatusCode == http.StatusForbidden {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 512))
+		return resp, fmt.Errorf("%w: %s", ErrAgentForbidden, strings.TrimSpace(string(msg)))
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("agent returned status %d", httpResp.StatusCode)
+	}
+	if strings.HasPrefix(httpResp.Header.Get("Content-Type"), agent.StreamContentType) {
+		return readExecFrames(httpResp.Body, onOutput)
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("decode agent response: %w", err)
+	}
+	if onOutput != nil && resp.Stdout != "" {
+		onOutput("stdout", resp.Stdout)
+	}
+	return resp, nil
+}
+
+// readExecFrames reads a streamed exec response, passing output to
+// onOutput, if set, as it arrives, and returns the result with the output
+// put back in Stdout.
+func readExecFrames(r io.Reader, onOutput func(stream, data string)) (agent.ExecResponse, error) {
+	dec := json.NewDecoder(r)
+	var out strings.Builder
+	for {
+		var frame agent.ExecFrame
+		if err := dec.Decode(&frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return agent.ExecResponse{Stdout: out.String()}, fmt.Errorf("read agent output: %w", err)
+		}
+		if frame.Output != "" {
+			out.WriteString(frame.Output)
+			if onOutput != nil {
+				onOutput("stdout", frame.Output)
+			}
+		}
+		if frame.Result != nil {
+			resp := *frame.Result
+			resp.Stdout = out.String()
+			return resp, nil
+		}
+	}
+}
+
+// Heartbeat asks the node's gaxx-agent for its host, time and version, and
+// sets the response's ClockSkew, logging a warning when it exceeds
+// MaxClockSkew.
+func (a *AgentConn) Heartbeat(ctx context.Context, node providers.Node) (agent.HeartbeatResponse, error) {
+	var hb agent.HeartbeatResponse
+	sent := time.Now()
+	err := a.call(ctx, node, http.MethodGet, "heartbeat", &hb)
+	if err == nil && !hb.Time.IsZero() {
+		hb.ClockSkew = ClockSkew(hb.Time, sent, time.Now())
+		warnClockSkew(node.Name, hb.ClockSkew)
+	}
+	return hb, err
+}
+
+// SysInfo fetches the node's resource usage and running commands from its
+// gaxx-agent.
+func (a *AgentConn) SysInfo(ctx context.Context, node providers.Node) (agent.SysInfoResponse, error) {
+	var info agent.SysInfoResponse
+	err := a.call(ctx, node, http.MethodGet, "sysinfo", &info)
+	return info, err
+}
+
+// Drain tells the node's gaxx-agent to refuse new work and returns its
+// state, including how many commands are still running. It is safe to call
+// repeatedly to poll.
+func (a *AgentConn) Drain(ctx context.Context, node providers.Node) (agent.DrainResponse, error) {
+	var d agent.DrainResponse
+	err := a.call(ctx, node, http.MethodPost, "drain", &d)
+	return d, err
+}
+
+// call sends a bodiless request to /v0/<endpoint> on the node's gaxx-agent
+// and decodes the JSON response into out.
+func (a *AgentConn) call(ctx context.Context, node providers.Node, method, endpoint string, out any) error {
+	client, err := a.client(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.URL(node, "/v0/"+endpoint), nil)
+	if err != nil {
+		return err
+	}
+	if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// drainClose reads what is left of an agent response before closing it, so
+// that its connection can be reused.
+func drainClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
+// ExecViaSSH runs an exec request over SSH, feeding Input on stdin.
+func ExecViaSSH(ctx context.Context, cfg providers.Config, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	var resp agent.ExecResponse
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+		defer cancel()
+	}
+	c, err := SSHClientFor(cfg, node)
+	if err != nil {
+		return resp, err
+	}
+	cli, err := gssh.Dial(ctx, c)
+	if err != nil {
+		return resp, fmt.Errorf("ssh dial: %w", err)
+	}
+	defer cli.Close()
+	session, err := gssh.NewSession(ctx, cli)
+	if err != nil {
+		return resp, fmt.Errorf("ssh session: %w", err)
+	}
+	defer session.Close()
+
+	// Cap output as the agent does, so one noisy node cannot exhaust memory.
+	stdout := &agent.LimitedBuffer{Max: agent.DefaultMaxOutput}
+	stderr := &agent.LimitedBuffer{Max: agent.DefaultMaxOutput}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if fn := outputFunc(ctx); fn != nil {
+		session.Stdout = io.MultiWriter(stdout, &outputWriter{stream: "stdout", fn: fn, left: stdout.Max})
+		session.Stderr = io.MultiWriter(stderr, &outputWriter{stream: "stderr", fn: fn, left: stderr.Max})
+	}
+	if req.Input != "" {
+		session.Stdin = strings.NewReader(req.Input)
+	}
+	command := ShellJoin(req.Command, req.Args...)
+	switch {
+	case !req.InheritsEnv():
+		// Only DefaultPath, which a PATH in the request's env overrides.
+		command = "env -i " + ShellJoin("PATH="+agent.DefaultPath, req.Env...) + " " + command
+	case len(req.Env) > 0:
+		command = "env " + ShellJoin(req.Env[0], req.Env[1:]...) + " " + command
+	}
+	var limits agent.ResourceLimits
+	if req.Limits != nil {
+		limits = *req.Limits
+		command = limitPrefix(limits) + command
+	}
+	if req.WorkDir != "" {
+		command = "cd " + ShellQuote(req.WorkDir) + " && " + command
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(xssh.SIGKILL)
+		return resp, ctx.Err()
+	case err = <-done:
+	}
+	resp.Stdout = stdout.String()
+	resp.Stderr = stderr.String()
+	resp.Truncated = stdout.Truncated() || stderr.Truncated()
+	resp.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		var exitErr *xssh.ExitError
+		if !errors.As(err, &exitErr) {
+			return resp, fmt.Errorf("ssh run: %w", err)
+		}
+		resp.ExitCode = exitErr.ExitStatus()
+		resp.LimitExceeded = agent.LimitForSignal(exitErr.Signal(), limits)
+	}
+	return resp, nil
+}
+
+// limitPrefix renders resource limits as shell that applies them to the
+// command that follows, for nodes reached without the agent.
+func limitPrefix(l agent.ResourceLimits) string {
+	var b strings.Builder
+	if l.MemoryMB > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d && ", l.MemoryMB*1024)
+	}
+	if l.CPUSeconds > 0 {
+		fmt.Fprintf(&b, "ulimit -t %d && ", l.CPUSeconds)
+	}
+	if l.Nice > 0 {
+		fmt.Fprintf(&b, "nice -n %d ", l.Nice)
+	}
+	return b.String()
+}
+
+// SSHClientFor builds an SSH client for a node using the configured key and
+// a trust-on-first-use known_hosts file.
+func SSHClientFor(cfg providers.Config, node providers.Node) (*gssh.Client, error) {
+	keyPath := node.KeyPath
+	if keyPath == "" {
+		keyPath = cfg.SSHKeyPath()
+	}
+	signer, err := gssh.LoadPrivateKeySigner(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load ssh key: %w", err)
+	}
+	hostKeys, err := gssh.TrustOnFirstUseCallback(cfg.SSH.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("known hosts: %w", err)
+	}
+	user := node.SSHUser
+	if user == "" {
+		user = cfg.Defaults.User
+	}
+	port := node.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	return &gssh.Client{
+		Addr:       providers.HostPort(node.DialIP(), port),
+		User:       user,
+		Signer:     signer,
+		KnownHosts: hostKeys,
+		Timeout:    30 * time.Second,
+		Retries:    cfg.Defaults.Retries,
+	}, nil
+}
+
+// ShellQuote quotes s for a POSIX shell.
+func ShellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,@%+", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes and joins a command and its arguments.
+func ShellJoin(command string, args ...string) string {
+	parts := []string{ShellQuote(command)}
+	for _, a := range args {
+		parts = append(parts, ShellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
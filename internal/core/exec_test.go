@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+type fakeExecutor struct {
+	resp  agent.ExecResponse
+	err   error
+	calls int
+}
+
+func (f *fakeExecutor) Exec(ctx context.Context, node providers.Node, req agent.ExecRequest) (agent.ExecResponse, error) {
+	f.calls++
+	return f.resp, f.err
+}
+
+func TestFallbackExecutor(t *testing.T) {
+	ctx := context.Background()
+	node := providers.Node{Name: "w-1"}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	down := &fakeExecutor{err: fmt.Errorf("agent request: %w", &url.Error{Op: "Post", URL: "http://w-1:8088/exec", Err: refused})}
+	ssh := &fakeExecutor{resp: agent.ExecResponse{Stdout: "ok\n"}}
+	resp, err := FallbackExecutor{down, ssh}.Exec(ctx, node, agent.ExecRequest{Command: "true"})
+	if err != nil || resp.Stdout != "ok\n" {
+		t.Fatalf("fallback = %+v, %v", resp, err)
+	}
+
+	// A non-zero exit is a result and must not be re-run over SSH.
+	failing := &fakeExecutor{resp: agent.ExecResponse{ExitCode: 2}}
+	ssh.calls = 0
+	resp, err = FallbackExecutor{failing, ssh}.Exec(ctx, node, agent.ExecRequest{Command: "false"})
+	if err != nil || resp.ExitCode != 2 || ssh.calls != 0 {
+		t.Fatalf("exit code handling: resp=%+v err=%v ssh calls=%d", resp, err, ssh.calls)
+	}
+
+	// A busy agent must not be bypassed over SSH.
+	busy := &fakeExecutor{err: ErrAgentBusy}
+	ssh.calls = 0
+	if _, err = (FallbackExecutor{busy, ssh}).Exec(ctx, node, agent.ExecRequest{Command: "true"}); !errors.Is(err, ErrAgentBusy) || ssh.calls != 0 {
+		t.Fatalf("busy agent: err=%v ssh calls=%d", err, ssh.calls)
+	}
+
+	// Once the agent was reached the command may have started, so any
+	// other failure must not run it a second time over SSH.
+	for _, err := range []error{
+		errors.New("agent returned status 500"),
+		fmt.Errorf("decode agent response: %w", errors.New("unexpected EOF")),
+		fmt.Errorf("agent request: %w", &url.Error{Op: "Post", URL: "http://w-1:8088/exec", Err: context.DeadlineExceeded}),
+	} {
+		ssh.calls = 0
+		if _, got := (FallbackExecutor{&fakeExecutor{err: err}, ssh}).Exec(ctx, node, agent.ExecRequest{Command: "true"}); got != err || ssh.calls != 0 {
+			t.Fatalf("%v: err=%v ssh calls=%d", err, got, ssh.calls)
+		}
+	}
+
+	other := &fakeExecutor{err: errors.New("ssh dial: timeout")}
+	_, err = FallbackExecutor{down, other}.Exec(ctx, node, agent.ExecRequest{Command: "true"})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "ssh dial") {
+		t.Fatalf("combined error = %v", err)
+	}
+}
+
+func TestFallbackExecutorForbidden(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `command "nc" is not allowed on this node`, http.StatusForbidden)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+
+	// A command the agent's policy denies must not be run over SSH instead.
+	ssh := &fakeExecutor{resp: agent.ExecResponse{Stdout: "ran anyway\n"}}
+	_, err := FallbackExecutor{AgentExecutor{}, ssh}.Exec(context.Background(), node, agent.ExecRequest{Command: "nc"})
+	if !errors.Is(err, ErrAgentForbidden) || !strings.Contains(err.Error(), "not allowed") || ssh.calls != 0 {
+		t.Fatalf("forbidden command: err=%v ssh calls=%d", err, ssh.calls)
+	}
+}
+
+func TestExecViaAgentOutput(t *testing.T) {
+	var streamed []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req agent.ExecRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		streamed = append(streamed, req.Stream)
+		switch req.Command {
+		case "legacy":
+			_ = json.NewEncoder(w).Encode(agent.ExecResponse{Stdout: "all at once\n"})
+		case "stream", "cut":
+			w.Header().Set("Content-Type", agent.StreamContentType)
+			enc := json.NewEncoder(w)
+			_ = enc.Encode(agent.ExecFrame{Output: "a\n"})
+			_ = enc.Encode(agent.ExecFrame{Output: "b\n"})
+			if req.Command == "stream" {
+				_ = enc.Encode(agent.ExecFrame{Result: &agent.ExecResponse{ExitCode: 2, Duration: 7}})
+			}
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+
+	var conn *AgentConn
+	var got []string
+	ctx := WithOutput(context.Background(), func(stream, data string) { got = append(got, stream+":"+data) })
+	resp, err := conn.Exec(ctx, node, agent.ExecRequest{Command: "stream"})
+	if err != nil || resp.Stdout != "a\nb\n" || resp.ExitCode != 2 || strings.Join(got, "") != "stdout:a\nstdout:b\n" {
+		t.Fatalf("stream: resp=%+v err=%v output=%q", resp, err, got)
+	}
+
+	// Agents that predate streaming answer all at once.
+	got = nil
+	resp, err = conn.Exec(ctx, node, agent.ExecRequest{Command: "legacy"})
+	if err != nil || resp.Stdout != "all at once\n" || strings.Join(got, "") != "stdout:all at once\n" {
+		t.Fatalf("legacy: resp=%+v err=%v output=%q", resp, err, got)
+	}
+
+	// A stream cut off before the result is an error, not a success.
+	if resp, err = conn.Exec(ctx, node, agent.ExecRequest{Command: "cut"}); err == nil || resp.Stdout != "a\nb\n" {
+		t.Fatalf("cut stream: resp=%+v err=%v", resp, err)
+	}
+
+	// Streaming is only asked for when someone is listening.
+	if _, err = conn.Exec(context.Background(), node, agent.ExecRequest{Command: "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, true, true, false}; fmt.Sprint(streamed) != fmt.Sprint(want) {
+		t.Errorf("stream requested = %v, want %v", streamed, want)
+	}
+}
+
+func TestExecMode(t *testing.T) {
+	if m, err := ParseExecMode(""); err != nil || m != ExecModeAuto {
+		t.Errorf("empty = %q, %v", m, err)
+	}
+	if _, err := ParseExecMode("telnet"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	var cfg providers.Config
+	for mode, want := range map[string]string{"agent": "core.AgentExecutor", "ssh": "core.SSHExecutor", "": "*core.AutoExecutor"} {
+		cfg.Defaults.ExecMode = mode
+		if got := fmt.Sprintf("%T", NewExecutor(cfg, nil)); got != want {
+			t.Errorf("%q executor = %s, want %s", mode, got, want)
+		}
+	}
+
+	// An agent that answers, even refusing, is installed.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+	if m := probeExecMode(context.Background(), nil, node); m != ExecModeAgent {
+		t.Errorf("answering agent probed as %s", m)
+	}
+	srv.Close()
+	if m := probeExecMode(context.Background(), nil, node); m != ExecModeSSH {
+		t.Errorf("closed agent port probed as %s", m)
+	}
+}
+
+func TestAgentConnectionReuse(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(agent.HeartbeatResponse{Host: "w-1"})
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+
+	conn := NewAgentConn(providers.Config{})
+	for i := 0; i < 20; i++ {
+		if _, err := conn.Heartbeat(context.Background(), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("20 heartbeats opened %d connections, want 1", conns)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := ShellJoin("echo", "plain", "two words", "it's", "")
+	want := `echo plain 'two words' 'it'\''s' ''`
+	if got != want {
+		t.Errorf("ShellJoin = %s, want %s", got, want)
+	}
+}
+
+func TestAgentTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(agent.HeartbeatResponse{Host: "w-1"})
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	plain := NewAgentConn(providers.Config{})
+	if _, err := plain.Heartbeat(ctx, node); err == nil {
+		t.Error("plain http to a TLS agent succeeded")
+	}
+	var cfg providers.Config
+	cfg.Agent.TLS.Enabled = true
+	if _, err := NewAgentConn(cfg).Heartbeat(ctx, node); err == nil {
+		t.Error("unknown CA accepted")
+	}
+	for _, tc := range []struct {
+		name     string
+		ca       string
+		insecure bool
+	}{{"ca_cert", ca, false}, {"insecure_skip_verify", "", true}} {
+		cfg.Agent.TLS.CACert, cfg.Agent.TLS.InsecureSkipVerify = tc.ca, tc.insecure
+		conn := NewAgentConn(cfg)
+		if err := conn.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if hb, err := conn.Heartbeat(ctx, node); err != nil || hb.Host != "w-1" {
+			t.Errorf("%s: heartbeat = %+v, %v", tc.name, hb, err)
+		}
+		// Making a TLS conn leaves the others as they were.
+		if _, err := plain.Heartbeat(ctx, node); err == nil {
+			t.Errorf("%s: plain conn now speaks TLS", tc.name)
+		}
+	}
+	cfg.Agent.TLS.ClientCert = ca
+	conn := NewAgentConn(cfg)
+	if conn.Err() == nil {
+		t.Error("client_cert without client_key accepted")
+	}
+	if _, err := conn.Heartbeat(ctx, node); err == nil || !errors.Is(err, conn.Err()) {
+		t.Errorf("heartbeat through a broken conn: %v", err)
+	}
+}
+
+func TestBreakerExecutor(t *testing.T) {
+	ctx := context.Background()
+	node := providers.Node{Name: "w-1"}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	down := &fakeExecutor{err: fmt.Errorf("agent request: %w", &url.Error{Op: "Post", URL: "http://w-1:8088/exec", Err: refused})}
+	b := NewCircuitBreakers(2, time.Hour)
+	exec := BreakerExecutor{Executor: down, Breakers: b}
+
+	for i := 0; i < 3; i++ {
+		_, _ = exec.Exec(ctx, node, agent.ExecRequest{Command: "true"})
+	}
+	if down.calls != 2 {
+		t.Errorf("broken node ran %d times, want 2", down.calls)
+	}
+	if states := b.States(); len(states) != 1 || states[0].State != BreakerOpen || states[0].Failures != 2 {
+		t.Fatalf("states = %+v", states)
+	}
+
+	// After the cooldown one probe goes through; its success closes the
+	// breaker.
+	b.mu.Lock()
+	b.nodes["w-1"].Until = time.Now()
+	b.mu.Unlock()
+	if err := b.Allow("w-1"); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if err := b.Allow("w-1"); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("second request during the probe = %v", err)
+	}
+	b.Record("w-1", false)
+	down.err = nil
+	if _, err := exec.Exec(ctx, node, agent.ExecRequest{Command: "true"}); err != nil || len(b.States()) != 0 {
+		t.Errorf("after the probe: err=%v states=%+v", err, b.States())
+	}
+
+	// Busy agents and non-zero exits don't count against a node.
+	busy := BreakerExecutor{Executor: &fakeExecutor{err: ErrAgentBusy}, Breakers: b}
+	for i := 0; i < 3; i++ {
+		_, _ = busy.Exec(ctx, node, agent.ExecRequest{Command: "true"})
+	}
+	if len(b.States()) != 0 {
+		t.Errorf("busy agent opened the breaker: %+v", b.States())
+	}
+}
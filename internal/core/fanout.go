@@ -0,0 +1,39 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// FanOut calls fn for every item, at most concurrency at a time (all at
+// once if concurrency is not positive), and returns each call's error at
+// the item's index. Items not started by the time ctx is done get ctx's
+// error instead of a call. Combine the result with errors.Join when only
+// success or failure matters.
+func FanOut[T any](ctx context.Context, items []T, concurrency int, fn func(context.Context, T) error) []error {
+	errs := make([]error, len(items))
+	if concurrency <= 0 || concurrency > len(items) {
+		concurrency = len(items)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				errs[i] = fn(ctx, items[i])
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
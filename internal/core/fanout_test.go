@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i
+	}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	errs := FanOut(context.Background(), items, 4, func(ctx context.Context, i int) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		if i%10 == 3 {
+			return fmt.Errorf("item %d", i)
+		}
+		return nil
+	})
+	if peak > 4 {
+		t.Errorf("%d calls overlapped, want at most 4", peak)
+	}
+	failed := 0
+	for i, err := range errs {
+		if (err != nil) != (i%10 == 3) {
+			t.Errorf("item %d: err = %v", i, err)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+	if joined := errors.Join(errs...); failed != 5 || joined == nil {
+		t.Errorf("%d failed, joined = %v", failed, joined)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	errs = FanOut(ctx, items[:3], 0, func(context.Context, int) error { calls++; return nil })
+	if calls != 0 || !errors.Is(errs[2], context.Canceled) {
+		t.Errorf("after cancel: %d calls, errs = %v", calls, errs)
+	}
+}
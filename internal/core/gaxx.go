@@ -8,10 +8,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/3cpo-dev/gaxx/internal/providers"
+
 	"golang.org/x/crypto/ssh"
 )
 
 // Config represents the simplified configuration
+//
+// Deprecated: use providers.Config loaded with providers.LoadConfig.
 type Config struct {
 	Provider    string `yaml:"provider"`
 	Token       string `yaml:"token"`
@@ -26,10 +30,18 @@ type Instance struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 	IP   string `json:"ip"`
+	IPv6 string `json:"ipv6,omitempty"`
 	User string `json:"user"`
 	Port int    `json:"port"`
 }
 
+// DialIP returns the address to reach the instance on: its IPv4 address,
+// or its IPv6 one when it has none. This deprecated stack does not read
+// defaults.prefer_ipv6.
+func (i Instance) DialIP() string {
+	return providers.Node{IP: i.IP, IPv6: i.IPv6}.DialIP()
+}
+
 // Task represents a task to execute
 type Task struct {
 	Command string            `json:"command"`
@@ -39,6 +51,8 @@ type Task struct {
 }
 
 // Provider interface for cloud providers
+//
+// Deprecated: use providers.Provider.
 type Provider interface {
 	CreateInstances(ctx context.Context, count int, name string) ([]Instance, error)
 	DeleteInstances(ctx context.Context, name string) error
@@ -46,6 +60,8 @@ type Provider interface {
 }
 
 // SSHClient handles SSH operations
+//
+// Deprecated: use the internal/ssh package, which verifies host keys.
 type SSHClient struct {
 	keyPath string
 	timeout time.Duration
@@ -71,7 +87,7 @@ func (s *SSHClient) Execute(host string, cmd string) (string, error) {
 		Timeout:         s.timeout,
 	}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+	client, err := ssh.Dial("tcp", providers.HostPort(host, 22), config)
 	if err != nil {
 		return "", fmt.Errorf("ssh dial: %w", err)
 	}
@@ -144,6 +160,8 @@ func (m *Metrics) GetStats() (int64, int64, time.Duration) {
 }
 
 // Gaxx is the main simplified orchestrator
+//
+// Deprecated: the CLI orchestrates through providers.Provider directly.
 type Gaxx struct {
 	config   *Config
 	provider Provider
@@ -152,6 +170,8 @@ type Gaxx struct {
 }
 
 // NewGaxx creates a new Gaxx instance
+//
+// Deprecated: see Gaxx.
 func NewGaxx(config *Config, provider Provider) *Gaxx {
 	return &Gaxx{
 		config:   config,
@@ -161,14 +181,47 @@ func NewGaxx(config *Config, provider Provider) *Gaxx {
 	}
 }
 
-// SpawnFleet creates a fleet of instances
+// ErrFleetExists is returned when spawning into a fleet that already has instances
+var ErrFleetExists = providers.ErrFleetExists
+
+// SpawnPolicy controls how SpawnFleet treats instances already in the fleet
+type SpawnPolicy = providers.SpawnPolicy
+
+const (
+	SpawnRefuse = providers.SpawnRefuse
+	SpawnTopUp  = providers.SpawnTopUp
+	SpawnForce  = providers.SpawnForce
+)
+
+// SpawnFleet creates a fleet of instances, refusing if the fleet already exists
 func (g *Gaxx) SpawnFleet(ctx context.Context, name string, count int) ([]Instance, error) {
+	return g.SpawnFleetWithPolicy(ctx, name, count, SpawnRefuse)
+}
+
+// SpawnFleetWithPolicy creates a fleet of instances, consulting the existing
+// members of the fleet according to policy
+func (g *Gaxx) SpawnFleetWithPolicy(ctx context.Context, name string, count int, policy SpawnPolicy) ([]Instance, error) {
 	start := time.Now()
 	defer func() {
 		g.metrics.RecordRequest(time.Since(start))
 	}()
 
-	instances, err := g.provider.CreateInstances(ctx, count, name)
+	listed, err := g.provider.ListInstances(ctx, name)
+	if err != nil {
+		g.metrics.RecordError()
+		return nil, fmt.Errorf("list existing instances: %w", err)
+	}
+	existing := FleetMembers(listed, name)
+
+	toCreate, err := planSpawn(len(existing), count, policy)
+	if err != nil {
+		return nil, fmt.Errorf("fleet '%s' has %d instances: %w", name, len(existing), err)
+	}
+	if toCreate == 0 {
+		return nil, nil
+	}
+
+	instances, err := g.provider.CreateInstances(ctx, toCreate, name)
 	if err != nil {
 		g.metrics.RecordError()
 		return nil, fmt.Errorf("create instances: %w", err)
@@ -185,6 +238,27 @@ func (g *Gaxx) SpawnFleet(ctx context.Context, name string, count int) ([]Instan
 	return instances, nil
 }
 
+// planSpawn returns how many instances to create given the number already in the fleet
+func planSpawn(existing, requested int, policy SpawnPolicy) (int, error) {
+	return providers.PlanSpawn(existing, requested, policy)
+}
+
+// FleetMembers filters instances down to those labelled "<name>-<n>"
+func FleetMembers(instances []Instance, name string) []Instance {
+	var members []Instance
+	for _, inst := range instances {
+		if fleetIndex(inst.Name, name) > 0 {
+			members = append(members, inst)
+		}
+	}
+	return members
+}
+
+// fleetIndex returns n for a label of the form "<name>-<n>", or 0 otherwise
+func fleetIndex(label, name string) int {
+	return providers.FleetIndex(label, name)
+}
+
 // ExecuteTasks runs tasks across instances with controlled concurrency
 func (g *Gaxx) ExecuteTasks(ctx context.Context, instances []Instance, tasks []Task) error {
 	start := time.Now()
@@ -192,38 +266,34 @@ func (g *Gaxx) ExecuteTasks(ctx context.Context, instances []Instance, tasks []T
 		g.metrics.RecordRequest(time.Since(start))
 	}()
 
-	sem := make(chan struct{}, g.config.Concurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var errors []error
-
+	type job struct {
+		inst Instance
+		task Task
+	}
+	var jobs []job
 	for _, task := range tasks {
 		for _, instance := range instances {
-			wg.Add(1)
-			go func(inst Instance, t Task) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				cmd := g.BuildCommand(t)
-				output, err := g.ssh.Execute(inst.IP, cmd)
-
-				if err != nil {
-					g.metrics.RecordError()
-					mu.Lock()
-					errors = append(errors, fmt.Errorf("instance %s: %w", inst.ID, err))
-					mu.Unlock()
-				} else {
-					fmt.Printf("[%s] %s\n", inst.Name, output)
-				}
-			}(instance, task)
+			jobs = append(jobs, job{instance, task})
 		}
 	}
 
-	wg.Wait()
+	var errs []error
+	for _, err := range FanOut(ctx, jobs, g.config.Concurrency, func(ctx context.Context, j job) error {
+		output, err := g.ssh.Execute(j.inst.DialIP(), g.BuildCommand(j.task))
+		if err != nil {
+			g.metrics.RecordError()
+			return fmt.Errorf("instance %s: %w", j.inst.ID, err)
+		}
+		fmt.Printf("[%s] %s\n", j.inst.Name, output)
+		return nil
+	}) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("task execution failed: %v", errors)
+	if len(errs) > 0 {
+		return fmt.Errorf("task execution failed: %v", errs)
 	}
 	return nil
 }
@@ -273,7 +343,7 @@ func (g *Gaxx) WaitForInstance(ctx context.Context, instance Instance) error {
 		case <-timeout:
 			return fmt.Errorf("timeout waiting for instance")
 		case <-ticker.C:
-			_, err := g.ssh.Execute(instance.IP, "echo ready")
+			_, err := g.ssh.Execute(instance.DialIP(), "echo ready")
 			if err == nil {
 				return nil
 			}
@@ -293,6 +363,8 @@ func (g *Gaxx) BuildCommand(task Task) string {
 }
 
 // LoadConfig loads configuration from file or environment
+//
+// Deprecated: it ignores path and never reads a file; use providers.LoadConfig.
 func LoadConfig(path string) (*Config, error) {
 	if path == "" {
 		path = filepath.Join(os.Getenv("HOME"), ".config", "gaxx", "config.yaml")
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -194,3 +195,54 @@ func TestBuildCommand(t *testing.T) {
 		t.Errorf("Expected command '%s', got '%s'", expected, cmd)
 	}
 }
+
+func TestPlanSpawn(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  int
+		requested int
+		policy    SpawnPolicy
+		want      int
+		wantErr   bool
+	}{
+		{"new fleet", 0, 5, SpawnRefuse, 5, false},
+		{"refuse existing", 5, 5, SpawnRefuse, 0, true},
+		{"top-up partial", 2, 5, SpawnTopUp, 3, false},
+		{"top-up full", 5, 5, SpawnTopUp, 0, false},
+		{"force existing", 5, 5, SpawnForce, 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planSpawn(tt.existing, tt.requested, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planSpawn error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrFleetExists) {
+				t.Errorf("Expected ErrFleetExists, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %d instances to create, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFleetMembers(t *testing.T) {
+	instances := []Instance{
+		{Name: "web-1"},
+		{Name: "web-2"},
+		{Name: "webserver-1"},
+		{Name: "web-extra"},
+	}
+
+	members := FleetMembers(instances, "web")
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 fleet members, got %d", len(members))
+	}
+
+	labels := nextLabels(members, "web", 2)
+	if labels[0] != "web-3" || labels[1] != "web-4" {
+		t.Errorf("Expected labels web-3, web-4, got %v", labels)
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS agent_versions (
+  fleet TEXT NOT NULL,
+  node TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  version TEXT NOT NULL,
+  seen_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (fleet, node)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS run_results (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  run_id INTEGER NOT NULL,
+  node TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  chunk INTEGER NOT NULL,
+  exit_code INTEGER NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  output_bytes INTEGER NOT NULL DEFAULT 0,
+  output_lines INTEGER NOT NULL DEFAULT 0,
+  output_head TEXT NOT NULL DEFAULT '',
+  truncated BOOLEAN NOT NULL DEFAULT 0,
+  limit_exceeded TEXT NOT NULL DEFAULT '',
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS run_results_run_node ON run_results (run_id, node);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS prices (
+  provider TEXT NOT NULL,
+  size TEXT NOT NULL,
+  hourly REAL NOT NULL,
+  fetched_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (provider, size)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS node_benchmarks (
+  fleet TEXT NOT NULL,
+  node TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  cpu_mbps REAL NOT NULL,
+  io_mbps REAL NOT NULL,
+  score REAL NOT NULL,
+  measured_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (fleet, node)
+);
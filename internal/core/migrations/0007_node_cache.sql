@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS node_cache (
+  provider TEXT NOT NULL,
+  fleet TEXT NOT NULL,
+  nodes TEXT NOT NULL,
+  fetched_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (provider, fleet)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS node_breakers (
+  node TEXT PRIMARY KEY,
+  state TEXT NOT NULL,
+  failures INTEGER NOT NULL,
+  until TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
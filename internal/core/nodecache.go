@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// CachedNodes returns the nodes of fleet (every node when empty) last listed
+// from provider, if that was at most maxAge ago.
+func (s *Store) CachedNodes(ctx context.Context, provider, fleet string, maxAge time.Duration) ([]providers.Node, bool, error) {
+	var data string
+	var fetched time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT nodes, fetched_at FROM node_cache WHERE provider = ? AND fleet = ?`,
+		provider, fleet).Scan(&data, &fetched)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Since(fetched) > maxAge) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var nodes []providers.Node
+	if err := json.Unmarshal([]byte(data), &nodes); err != nil {
+		return nil, false, fmt.Errorf("cached nodes of %s/%s: %w", provider, fleet, err)
+	}
+	return nodes, true, nil
+}
+
+// CacheNodes records nodes as the current listing of fleet on provider.
+func (s *Store) CacheNodes(ctx context.Context, provider, fleet string, nodes []providers.Node) error {
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO node_cache (provider, fleet, nodes, fetched_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (provider, fleet) DO UPDATE SET nodes = excluded.nodes, fetched_at = excluded.fetched_at`,
+		provider, fleet, string(data), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("cache nodes of %s/%s: %w", provider, fleet, err)
+	}
+	return nil
+}
+
+// InvalidateNodes drops the cached listings that a change to fleet on
+// provider makes stale: its own and the all-nodes listing, or every
+// listing of provider when fleet is empty.
+func (s *Store) InvalidateNodes(ctx context.Context, provider, fleet string) error {
+	var err error
+	if fleet == "" {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM node_cache WHERE provider = ?`, provider)
+	} else {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM node_cache WHERE provider = ? AND fleet IN (?, '')`, provider, fleet)
+	}
+	if err != nil {
+		return fmt.Errorf("invalidate cached nodes of %s/%s: %w", provider, fleet, err)
+	}
+	return nil
+}
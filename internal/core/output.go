@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// outputFetchChunk is how much spooled output AgentConn.FetchOutput asks
+// for at once.
+var outputFetchChunk int64 = 4 << 20
+
+// FetchOutput copies the size bytes of output an exec spooled on the node
+// (see agent.ExecRequest.Spool) to w, in ranges of outputFetchChunk so that
+// neither side holds it in memory, then deletes it from the node.
+func (a *AgentConn) FetchOutput(ctx context.Context, node providers.Node, id string, size int64, w io.Writer) error {
+	client, err := a.client(2 * time.Minute)
+	if err != nil {
+		return err
+	}
+	url := a.URL(node, "/v0/output/"+id)
+	for off := int64(0); off < size; {
+		end := min(off+outputFetchChunk, size) - 1
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("fetch output: %w", err)
+		}
+		// A whole-file answer to a range request is only usable from the start.
+		if resp.StatusCode != http.StatusPartialContent && (resp.StatusCode != http.StatusOK || off != 0) {
+			drainClose(resp.Body)
+			return fmt.Errorf("fetch output: agent returned status %d", resp.StatusCode)
+		}
+		n, err := io.Copy(w, io.LimitReader(resp.Body, end-off+1))
+		drainClose(resp.Body)
+		off += n
+		if err != nil {
+			return fmt.Errorf("fetch output: %w", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("fetch output: no data at offset %d of %d", off, size)
+		}
+	}
+	return a.DeleteOutput(ctx, node, id)
+}
+
+// DeleteOutput removes spooled output from the node. Output already gone is
+// not an error.
+func (a *AgentConn) DeleteOutput(ctx context.Context, node providers.Node, id string) error {
+	client, err := a.client(30 * time.Second)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, a.URL(node, "/v0/output/"+id), nil)
+	if err != nil {
+		return err
+	}
+	if tok := os.Getenv("GAXX_AGENT_TOKEN"); tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("delete output: %w", err)
+	}
+	drainClose(resp.Body)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete output: agent returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+func TestFetchOutput(t *testing.T) {
+	defer func(n int64) { outputFetchChunk = n }(outputFetchChunk)
+	outputFetchChunk = 7
+	output := strings.Repeat("0123456789", 5)
+	var ranges []string
+	deleted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/output/abc" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(output))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	node := providers.Node{Name: "w-1", IP: "127.0.0.1", AgentPort: port}
+
+	var conn *AgentConn
+	var got bytes.Buffer
+	if err := conn.FetchOutput(context.Background(), node, "abc", int64(len(output)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != output || !deleted {
+		t.Errorf("fetched %q, deleted %v", got.String(), deleted)
+	}
+	if len(ranges) != 8 || ranges[0] != "bytes=0-6" || ranges[7] != "bytes=49-49" {
+		t.Errorf("ranges = %v", ranges)
+	}
+
+	if err := conn.FetchOutput(context.Background(), node, "gone", 10, &got); err == nil {
+		t.Error("fetching missing output succeeded")
+	}
+}
@@ -8,15 +8,21 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
 )
 
 // LinodeProvider implements the Provider interface for Linode
+//
+// Deprecated: use the internal/providers/linode package.
 type LinodeProvider struct {
 	token  string
 	client *http.Client
 }
 
 // NewLinodeProvider creates a new Linode provider
+//
+// Deprecated: use linode.New.
 func NewLinodeProvider(token string) *LinodeProvider {
 	return &LinodeProvider{
 		token: token,
@@ -36,9 +42,20 @@ type LinodeInstance struct {
 	ID     int      `json:"id"`
 	Label  string   `json:"label"`
 	IPv4   []string `json:"ipv4"`
+	IPv6   string   `json:"ipv6"`
 	Status string   `json:"status"`
 }
 
+func (i LinodeInstance) instance() Instance {
+	inst := Instance{ID: fmt.Sprintf("%d", i.ID), Name: i.Label, User: "gx", Port: 22}
+	if len(i.IPv4) > 0 {
+		inst.IP = i.IPv4[0]
+	}
+	// Given with its prefix length, as in "2600:3c01::f03c:91ff:fe24:3a2f/128".
+	inst.IPv6, _, _ = strings.Cut(i.IPv6, "/")
+	return inst
+}
+
 // LinodeCreateRequest represents the request to create a Linode instance
 type LinodeCreateRequest struct {
 	Region         string   `json:"region"`
@@ -51,12 +68,16 @@ type LinodeCreateRequest struct {
 	Booted         bool     `json:"booted"`
 }
 
-// CreateInstances creates multiple Linode instances
+// CreateInstances creates multiple Linode instances, skipping labels already in use
 func (p *LinodeProvider) CreateInstances(ctx context.Context, count int, name string) ([]Instance, error) {
+	existing, err := p.ListInstances(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("list existing instances: %w", err)
+	}
+	labels := nextLabels(existing, name, count)
 	instances := make([]Instance, 0, count)
 
-	for i := 0; i < count; i++ {
-		label := fmt.Sprintf("%s-%d", name, i+1)
+	for i, label := range labels {
 		instance, err := p.createInstance(ctx, label)
 		if err != nil {
 			// Clean up already created instances
@@ -113,14 +134,8 @@ func (p *LinodeProvider) waitForInstance(ctx context.Context, instanceID int) (I
 				continue
 			}
 
-			if linodeInst.Status == "running" && len(linodeInst.IPv4) > 0 {
-				return Instance{
-					ID:   fmt.Sprintf("%d", linodeInst.ID),
-					Name: linodeInst.Label,
-					IP:   linodeInst.IPv4[0],
-					User: "gx",
-					Port: 22,
-				}, nil
+			if instance := linodeInst.instance(); linodeInst.Status == "running" && instance.DialIP() != "" {
+				return instance, nil
 			}
 		case <-ctx.Done():
 			return Instance{}, ctx.Err()
@@ -160,17 +175,7 @@ func (p *LinodeProvider) ListInstances(ctx context.Context, name string) ([]Inst
 	var instances []Instance
 	for _, linodeInst := range response.Data {
 		if name == "" || strings.HasPrefix(linodeInst.Label, name) {
-			ip := ""
-			if len(linodeInst.IPv4) > 0 {
-				ip = linodeInst.IPv4[0]
-			}
-			instances = append(instances, Instance{
-				ID:   fmt.Sprintf("%d", linodeInst.ID),
-				Name: linodeInst.Label,
-				IP:   ip,
-				User: "gx",
-				Port: 22,
-			})
+			instances = append(instances, linodeInst.instance())
 		}
 	}
 
@@ -203,7 +208,7 @@ func (p *LinodeProvider) doRequest(ctx context.Context, method, path string, bod
 
 		resp, err := p.client.Do(req)
 		if err != nil {
-			if attempt < maxRetries-1 {
+			if attempt < maxRetries-1 && providers.RetryableRequest(method, err) {
 				time.Sleep(time.Duration(attempt+1) * time.Second)
 				continue
 			}
@@ -215,7 +220,7 @@ func (p *LinodeProvider) doRequest(ctx context.Context, method, path string, bod
 			resp.Body.Close()
 
 			// Retry on rate limit or server errors
-			if resp.StatusCode == 429 || resp.StatusCode >= 500 {
+			if providers.RetryableReply(method, resp.StatusCode) {
 				if attempt < maxRetries-1 {
 					time.Sleep(time.Duration(attempt+1) * time.Second)
 					continue
@@ -245,6 +250,21 @@ func (p *LinodeProvider) cleanupInstances(ctx context.Context, instances []Insta
 	}
 }
 
+// nextLabels returns count fleet labels that do not collide with existing instances
+func nextLabels(existing []Instance, name string, count int) []string {
+	next := 1
+	for _, inst := range existing {
+		if n := fleetIndex(inst.Name, name); n >= next {
+			next = n + 1
+		}
+	}
+	labels := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		labels = append(labels, fmt.Sprintf("%s-%d", name, next+i))
+	}
+	return labels
+}
+
 // generatePassword generates a random password
 func generatePassword() string {
 	// Simple password generation - in production, use crypto/rand
@@ -252,12 +272,16 @@ func generatePassword() string {
 }
 
 // VultrProvider implements the Provider interface for Vultr
+//
+// Deprecated: use the internal/providers/vultr package.
 type VultrProvider struct {
 	token  string
 	client *http.Client
 }
 
 // NewVultrProvider creates a new Vultr provider
+//
+// Deprecated: use vultr.New.
 func NewVultrProvider(token string) *VultrProvider {
 	return &VultrProvider{
 		token: token,
@@ -277,15 +301,32 @@ type VultrInstance struct {
 	ID     string `json:"id"`
 	Label  string `json:"label"`
 	MainIP string `json:"main_ip"`
+	V6IP   string `json:"v6_main_ip"`
 	Status string `json:"server_status"`
 }
 
-// CreateInstances creates multiple Vultr instances
+func (v VultrInstance) instance() Instance {
+	inst := Instance{ID: v.ID, Name: v.Label, IP: v.MainIP, IPv6: v.V6IP, User: "gx", Port: 22}
+	// Addresses not assigned yet read as unspecified.
+	if inst.IP == "0.0.0.0" {
+		inst.IP = ""
+	}
+	if inst.IPv6 == "::" {
+		inst.IPv6 = ""
+	}
+	return inst
+}
+
+// CreateInstances creates multiple Vultr instances, skipping labels already in use
 func (p *VultrProvider) CreateInstances(ctx context.Context, count int, name string) ([]Instance, error) {
+	existing, err := p.ListInstances(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("list existing instances: %w", err)
+	}
+	labels := nextLabels(existing, name, count)
 	instances := make([]Instance, 0, count)
 
-	for i := 0; i < count; i++ {
-		label := fmt.Sprintf("%s-%d", name, i+1)
+	for i, label := range labels {
 		instance, err := p.createInstance(ctx, label)
 		if err != nil {
 			// Clean up already created instances
@@ -306,7 +347,7 @@ func (p *VultrProvider) createInstance(ctx context.Context, label string) (Insta
 		"os_id":       477, // Ubuntu 22.04
 		"label":       label,
 		"tag":         "gaxx",
-		"enable_ipv6": false,
+		"enable_ipv6": true,
 	}
 
 	var vultrInst VultrInstance
@@ -340,14 +381,8 @@ func (p *VultrProvider) waitForInstance(ctx context.Context, instanceID string)
 				continue
 			}
 
-			if vultrInst.Status == "ok" && vultrInst.MainIP != "" {
-				return Instance{
-					ID:   vultrInst.ID,
-					Name: vultrInst.Label,
-					IP:   vultrInst.MainIP,
-					User: "gx",
-					Port: 22,
-				}, nil
+			if instance := vultrInst.instance(); vultrInst.Status == "ok" && instance.DialIP() != "" {
+				return instance, nil
 			}
 		case <-ctx.Done():
 			return Instance{}, ctx.Err()
@@ -384,13 +419,7 @@ func (p *VultrProvider) ListInstances(ctx context.Context, name string) ([]Insta
 	var instances []Instance
 	for _, vultrInst := range response {
 		if name == "" || strings.HasPrefix(vultrInst.Label, name) {
-			instances = append(instances, Instance{
-				ID:   vultrInst.ID,
-				Name: vultrInst.Label,
-				IP:   vultrInst.MainIP,
-				User: "gx",
-				Port: 22,
-			})
+			instances = append(instances, vultrInst.instance())
 		}
 	}
 
@@ -423,7 +452,7 @@ func (p *VultrProvider) doRequest(ctx context.Context, method, path string, body
 
 		resp, err := p.client.Do(req)
 		if err != nil {
-			if attempt < maxRetries-1 {
+			if attempt < maxRetries-1 && providers.RetryableRequest(method, err) {
 				time.Sleep(time.Duration(attempt+1) * time.Second)
 				continue
 			}
@@ -435,7 +464,7 @@ func (p *VultrProvider) doRequest(ctx context.Context, method, path string, body
 			resp.Body.Close()
 
 			// Retry on rate limit or server errors
-			if resp.StatusCode == 429 || resp.StatusCode >= 500 {
+			if providers.RetryableReply(method, resp.StatusCode) {
 				if attempt < maxRetries-1 {
 					time.Sleep(time.Duration(attempt+1) * time.Second)
 					continue
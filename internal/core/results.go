@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Output summaries keep the first outputHeadLines lines, up to
+// outputHeadBytes, of each result.
+const (
+	outputHeadLines = 20
+	outputHeadBytes = 4 << 10
+)
+
+// ErrRunNotFound is returned for a run ID the store has no record of.
+var ErrRunNotFound = errors.New("run not found")
+
+// Run is one recorded run or scan of a task across a fleet.
+type Run struct {
+	ID         int64     `json:"id"`
+	Fleet      string    `json:"fleet"`
+	Module     string    `json:"module"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// NodeResult is the recorded outcome of one execution in a run. Only a
+// summary of the output is kept: its size and its first lines.
+type NodeResult struct {
+	RunID         int64     `json:"run_id"`
+	Node          string    `json:"node"`
+	IP            string    `json:"ip"`
+	Chunk         int       `json:"chunk"`
+	ExitCode      int       `json:"exit_code"`
+	Error         string    `json:"error,omitempty"`
+	OutputBytes   int       `json:"output_bytes"`
+	OutputLines   int       `json:"output_lines"`
+	OutputHead    string    `json:"output_head"`
+	Truncated     bool      `json:"truncated,omitempty"`
+	LimitExceeded string    `json:"limit_exceeded,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+}
+
+// Failed reports whether the execution errored or exited non-zero.
+func (r NodeResult) Failed() bool { return r.Error != "" || r.ExitCode != 0 }
+
+// SummarizeOutput fills in the result's output size, line count and head
+// from the full output.
+func (r *NodeResult) SummarizeOutput(output string) {
+	r.OutputBytes = len(output)
+	r.OutputLines = strings.Count(output, "\n")
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		r.OutputLines++
+	}
+	head := output
+	if i := nthIndex(head, '\n', outputHeadLines); i >= 0 {
+		head = head[:i+1]
+	}
+	if len(head) > outputHeadBytes {
+		head = head[:outputHeadBytes]
+	}
+	r.OutputHead = head
+}
+
+// nthIndex returns the index of the nth occurrence of c in s, or -1.
+func nthIndex(s string, c byte, n int) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			n--
+			if n == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ResultFilter narrows the results returned by Store.Results. Zero fields
+// match everything.
+type ResultFilter struct {
+	Node   string
+	Failed bool
+	// Contains matches results whose output head contains the text.
+	Contains string
+}
+
+// BeginRun records a run as started and returns its ID.
+func (s *Store) BeginRun(ctx context.Context, fleet, module string, started time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO runs (name, module, status, started_at) VALUES (?, ?, ?, ?)`,
+		fleet, module, "running", started.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("record run: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// FinishRun sets the run's final status and stores its results.
+func (s *Store) FinishRun(ctx context.Context, id int64, status string, finished time.Time, results []NodeResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE runs SET status = ?, finished_at = ? WHERE id = ?`, status, finished.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("finish run %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("finish run %d: %w", id, ErrRunNotFound)
+	}
+	for _, r := range results {
+		_, err := tx.ExecContext(ctx, `INSERT INTO run_results
+			(run_id, node, ip, chunk, exit_code, error, output_bytes, output_lines, output_head, truncated, limit_exceeded, started_at, finished_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, r.Node, r.IP, r.Chunk, r.ExitCode, r.Error, r.OutputBytes, r.OutputLines, r.OutputHead,
+			r.Truncated, r.LimitExceeded, r.StartedAt.UTC(), r.FinishedAt.UTC())
+		if err != nil {
+			return fmt.Errorf("record result of %s: %w", r.Node, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Runs returns the most recent runs, newest first.
+func (s *Store) Runs(ctx context.Context, limit int) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, module, status, started_at, finished_at FROM runs ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Run
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// Run returns the run with the given ID, or ErrRunNotFound.
+func (s *Store) Run(ctx context.Context, id int64) (Run, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, name, module, status, started_at, finished_at FROM runs WHERE id = ?`, id)
+	r, err := scanRun(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, fmt.Errorf("run %d: %w", id, ErrRunNotFound)
+	}
+	return r, err
+}
+
+func scanRun(row interface{ Scan(...any) error }) (Run, error) {
+	var r Run
+	var started, finished sql.NullTime
+	if err := row.Scan(&r.ID, &r.Fleet, &r.Module, &r.Status, &started, &finished); err != nil {
+		return r, err
+	}
+	r.StartedAt, r.FinishedAt = started.Time, finished.Time
+	return r, nil
+}
+
+// Results returns the run's results matching f, ordered by node and chunk.
+func (s *Store) Results(ctx context.Context, runID int64, f ResultFilter) ([]NodeResult, error) {
+	query := `SELECT run_id, node, ip, chunk, exit_code, error, output_bytes, output_lines, output_head,
+		truncated, limit_exceeded, started_at, finished_at FROM run_results WHERE run_id = ?`
+	args := []any{runID}
+	if f.Node != "" {
+		query += ` AND node = ?`
+		args = append(args, f.Node)
+	}
+	if f.Failed {
+		query += ` AND (exit_code != 0 OR error != '')`
+	}
+	if f.Contains != "" {
+		query += ` AND instr(output_head, ?) > 0`
+		args = append(args, f.Contains)
+	}
+	query += ` ORDER BY node, chunk`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NodeResult
+	for rows.Next() {
+		var r NodeResult
+		var started, finished sql.NullTime
+		if err := rows.Scan(&r.RunID, &r.Node, &r.IP, &r.Chunk, &r.ExitCode, &r.Error, &r.OutputBytes,
+			&r.OutputLines, &r.OutputHead, &r.Truncated, &r.LimitExceeded, &started, &finished); err != nil {
+			return nil, err
+		}
+		r.StartedAt, r.FinishedAt = started.Time, finished.Time
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
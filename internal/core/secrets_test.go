@@ -0,0 +1,54 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSecretsEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.env")
+	content := `# API keys for the scan module
+SHODAN_KEY=abc123
+export CENSYS_ID = id-1   # trailing comment
+QUOTED="two words # not a comment"
+ESCAPED="line1\nline2 \"q\""
+LITERAL='${not_expanded} \n'
+EMPTY=
+
+URL=https://example.com/a#frag
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := LoadSecretsEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"SHODAN_KEY": "abc123",
+		"CENSYS_ID":  "id-1",
+		"QUOTED":     "two words # not a comment",
+		"ESCAPED":    "line1\nline2 \"q\"",
+		"LITERAL":    `${not_expanded} \n`,
+		"EMPTY":      "",
+		"URL":        "https://example.com/a#frag",
+	}
+	if len(env) != len(want) {
+		t.Errorf("got %d vars, want %d: %v", len(env), len(want), env)
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %q, want %q", k, env[k], v)
+		}
+	}
+
+	for _, bad := range []string{"NOEQUALS\n", "1BAD=x\n", `OPEN="unterminated` + "\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSecretsEnv(path); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
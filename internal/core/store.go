@@ -0,0 +1,271 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// storeParams configures every connection to the store: WAL so readers
+// don't block the writer, a busy timeout so concurrent writers wait for the
+// lock instead of failing with "database is locked", and immediate
+// transactions so a transaction takes the write lock when it begins rather
+// than failing to upgrade a read lock part way through.
+const storeParams = "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// storeMaxConns bounds the pool. SQLite allows one writer at a time, so
+// more connections only add readers waiting on the same file.
+const storeMaxConns = 4
+
+// Store is the local SQLite database of fleet state kept by the CLI.
+type Store struct {
+	db *sql.DB
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OpenStore opens the database at path, creating it and its directory if
+// needed, and brings its schema up to date.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create store dir: %w", err)
+	}
+	db, err := sql.Open("sqlite3", path+storeParams)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	db.SetMaxOpenConns(storeMaxConns)
+	db.SetMaxIdleConns(storeMaxConns)
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the embedded migrations that haven't been applied yet, in
+// name order, recording each in schema_migrations in the same transaction.
+func (s *Store) migrate() error {
+	return migrateFS(s.db, migrations)
+}
+
+// migrateFS applies the pending migrations/*.sql scripts in fsys to db. A
+// migration's version is its file name without the extension.
+func migrateFS(db *sql.DB, fsys fs.FS) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	names, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+		script, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(db, version, string(script)); err != nil {
+			return fmt.Errorf("migrate %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs script and records version unless version is already
+// recorded. Checking inside the transaction keeps concurrent opens from
+// applying a migration twice.
+func applyMigration(db *sql.DB, version, script string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var applied int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersions returns the applied migrations, oldest first.
+func (s *Store) SchemaVersions(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// Close closes the database and its file handles. Later calls do nothing,
+// so a deferred Close is safe after an explicit one.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { s.closeErr = s.db.Close() })
+	return s.closeErr
+}
+
+// NodeVersion is the agent version seen on a fleet node.
+type NodeVersion struct {
+	Node    string
+	IP      string
+	Version string
+	SeenAt  time.Time
+}
+
+// RecordAgentVersions stores the agent version of each node in fleet,
+// replacing what was recorded for those nodes before.
+func (s *Store) RecordAgentVersions(ctx context.Context, fleet string, versions []NodeVersion) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range versions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO agent_versions (fleet, node, ip, version, seen_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (fleet, node) DO UPDATE SET ip = excluded.ip, version = excluded.version, seen_at = excluded.seen_at`,
+			fleet, v.Node, v.IP, v.Version, v.SeenAt.UTC())
+		if err != nil {
+			return fmt.Errorf("record agent version of %s: %w", v.Node, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// AgentVersions returns the agent versions recorded for fleet, by node name.
+func (s *Store) AgentVersions(ctx context.Context, fleet string) ([]NodeVersion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT node, ip, version, seen_at FROM agent_versions WHERE fleet = ? ORDER BY node`, fleet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NodeVersion
+	for rows.Next() {
+		var v NodeVersion
+		if err := rows.Scan(&v.Node, &v.IP, &v.Version, &v.SeenAt); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// NodeBenchmark is a fleet node's latest gaxx bench result: hashing (CPU)
+// and synced write (IO) throughput in MB/s, and Score, their geometric
+// mean, to compare nodes by.
+type NodeBenchmark struct {
+	Node       string
+	IP         string
+	CPU        float64
+	IO         float64
+	Score      float64
+	MeasuredAt time.Time
+}
+
+// RecordBenchmarks stores benchmark results for nodes of fleet, replacing
+// what was recorded for those nodes before.
+func (s *Store) RecordBenchmarks(ctx context.Context, fleet string, benchmarks []NodeBenchmark) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, b := range benchmarks {
+		_, err := tx.ExecContext(ctx, `INSERT INTO node_benchmarks (fleet, node, ip, cpu_mbps, io_mbps, score, measured_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (fleet, node) DO UPDATE SET ip = excluded.ip, cpu_mbps = excluded.cpu_mbps,
+				io_mbps = excluded.io_mbps, score = excluded.score, measured_at = excluded.measured_at`,
+			fleet, b.Node, b.IP, b.CPU, b.IO, b.Score, b.MeasuredAt.UTC())
+		if err != nil {
+			return fmt.Errorf("record benchmark of %s: %w", b.Node, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Benchmarks returns the benchmarks recorded for fleet, fastest first.
+func (s *Store) Benchmarks(ctx context.Context, fleet string) ([]NodeBenchmark, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT node, ip, cpu_mbps, io_mbps, score, measured_at
+		FROM node_benchmarks WHERE fleet = ? ORDER BY score DESC, node`, fleet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NodeBenchmark
+	for rows.Next() {
+		var b NodeBenchmark
+		if err := rows.Scan(&b.Node, &b.IP, &b.CPU, &b.IO, &b.Score, &b.MeasuredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// SavePrices replaces the cached hourly prices of provider.
+func (s *Store) SavePrices(ctx context.Context, provider string, prices map[string]float64, fetched time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM prices WHERE provider = ?`, provider); err != nil {
+		return fmt.Errorf("save prices: %w", err)
+	}
+	for size, hourly := range prices {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO prices (provider, size, hourly, fetched_at) VALUES (?, ?, ?, ?)`,
+			provider, size, hourly, fetched.UTC()); err != nil {
+			return fmt.Errorf("save prices: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Price returns the cached hourly price of size on provider and when it was
+// fetched, or sql.ErrNoRows if none is cached.
+func (s *Store) Price(ctx context.Context, provider, size string) (float64, time.Time, error) {
+	var hourly float64
+	var fetched time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT hourly, fetched_at FROM prices WHERE provider = ? AND size = ?`,
+		provider, size).Scan(&hourly, &fetched)
+	return hourly, fetched, err
+}
@@ -0,0 +1,441 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+func TestStoreAgentVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "gaxx.db")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	seen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.RecordAgentVersions(ctx, "scan", []NodeVersion{
+		{Node: "scan-2", IP: "10.0.0.2", Version: "1.9.0", SeenAt: seen},
+		{Node: "scan-1", IP: "10.0.0.1", Version: "2.0.0", SeenAt: seen},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordAgentVersions(ctx, "other", []NodeVersion{
+		{Node: "scan-1", IP: "10.0.1.1", Version: "0.1.0", SeenAt: seen},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// An upgrade replaces the node's row.
+	if err := s.RecordAgentVersions(ctx, "scan", []NodeVersion{
+		{Node: "scan-2", IP: "10.0.0.2", Version: "2.0.0", SeenAt: seen.Add(time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening finds every migration already applied.
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if versions, err := s.SchemaVersions(ctx); err != nil || len(versions) == 0 || versions[0] != "0001_init" {
+		t.Errorf("schema versions = %v, %v", versions, err)
+	}
+	got, err := s.AgentVersions(ctx, "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NodeVersion{
+		{Node: "scan-1", IP: "10.0.0.1", Version: "2.0.0", SeenAt: seen},
+		{Node: "scan-2", IP: "10.0.0.2", Version: "2.0.0", SeenAt: seen.Add(time.Hour)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d versions, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Node != want[i].Node || got[i].IP != want[i].IP || got[i].Version != want[i].Version || !got[i].SeenAt.Equal(want[i].SeenAt) {
+			t.Errorf("version %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStoreConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gaxx.db")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// A second handle, as from another gaxx process, contends for the lock.
+	other, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	var mode string
+	if err := s.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v", mode, err)
+	}
+
+	ctx := context.Background()
+	const writers = 16
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store := s
+			if i%2 == 1 {
+				store = other
+			}
+			now := time.Now()
+			id, err := store.BeginRun(ctx, "scan", "ls", now)
+			if err == nil {
+				err = store.FinishRun(ctx, id, "succeeded", now, []NodeResult{{Node: fmt.Sprintf("scan-%d", i)}})
+			}
+			if err == nil {
+				err = store.RecordAgentVersions(ctx, "scan", []NodeVersion{{Node: fmt.Sprintf("scan-%d", i), Version: "1.0.0", SeenAt: now}})
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	runs, err := s.Runs(ctx, 2*writers)
+	if err != nil || len(runs) != writers {
+		t.Errorf("got %d runs, want %d (%v)", len(runs), writers, err)
+	}
+	versions, err := s.AgentVersions(ctx, "scan")
+	if err != nil || len(versions) != writers {
+		t.Errorf("got %d versions, want %d (%v)", len(versions), writers, err)
+	}
+}
+
+func TestStorePrices(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	fetched := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := s.SavePrices(ctx, "linode", map[string]float64{"g6-standard-2": 0.036, "g6-old": 0.01}, fetched); err != nil {
+		t.Fatal(err)
+	}
+	// A refresh replaces the provider's table, dropping retired sizes.
+	if err := s.SavePrices(ctx, "linode", map[string]float64{"g6-standard-2": 0.04}, fetched.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	hourly, at, err := s.Price(ctx, "linode", "g6-standard-2")
+	if err != nil || hourly != 0.04 || !at.Equal(fetched.Add(time.Hour)) {
+		t.Errorf("price = %v at %v, %v", hourly, at, err)
+	}
+	for _, size := range []string{"g6-old", "vc2-1c-1gb"} {
+		if _, _, err := s.Price(ctx, "linode", size); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s: err = %v, want sql.ErrNoRows", size, err)
+		}
+	}
+}
+
+func TestStoreAPIKeys(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	token, key, err := s.CreateAPIKey(ctx, "ci", "execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, apiKeyPrefix) || key.Name != "ci" || key.Scope != "execute" {
+		t.Fatalf("token %q, key %+v", token, key)
+	}
+	if _, _, err := s.CreateAPIKey(ctx, "ci", "read"); err == nil {
+		t.Error("duplicate name accepted")
+	}
+
+	got, err := s.AuthenticateAPIKey(ctx, token)
+	if err != nil || got.ID != key.ID || got.Scope != "execute" || got.LastUsedAt.IsZero() {
+		t.Errorf("authenticate = %+v, %v", got, err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, token+"x"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("wrong token: err = %v", err)
+	}
+	// Only the hash is stored.
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE hash = ?`, token).Scan(&n); err != nil || n != 0 {
+		t.Errorf("token stored in clear: %d, %v", n, err)
+	}
+
+	keys, err := s.APIKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].Name != "ci" {
+		t.Errorf("keys = %+v, %v", keys, err)
+	}
+	if err := s.RevokeAPIKey(ctx, "ci"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeAPIKey(ctx, "ci"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoke twice: err = %v", err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, token); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoked token: err = %v", err)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := s.Runs(context.Background(), 1); err == nil {
+		t.Error("query after Close succeeded")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Neither script is safe to run twice, so a second run must skip them.
+	fsys := fstest.MapFS{
+		"migrations/0001_a.sql": {Data: []byte(`CREATE TABLE a (id INTEGER)`)},
+	}
+	for i := 0; i < 2; i++ {
+		if err := migrateFS(db, fsys); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+	fsys["migrations/0002_b.sql"] = &fstest.MapFile{Data: []byte(`ALTER TABLE a ADD COLUMN b TEXT`)}
+	for i := 0; i < 2; i++ {
+		if err := migrateFS(db, fsys); err != nil {
+			t.Fatalf("run %d with 0002: %v", i+1, err)
+		}
+	}
+
+	// A failing migration is rolled back and not recorded.
+	fsys["migrations/0003_bad.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE c (id INTEGER); SELECT * FROM missing`)}
+	if err := migrateFS(db, fsys); err == nil || !strings.Contains(err.Error(), "0003_bad") {
+		t.Fatalf("bad migration: err = %v", err)
+	}
+	s := &Store{db: db}
+	versions, err := s.SchemaVersions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(versions, ",") != "0001_a,0002_b" {
+		t.Errorf("versions = %v", versions)
+	}
+	if _, err := db.Exec(`INSERT INTO c (id) VALUES (1)`); err == nil {
+		t.Error("table from the failed migration exists")
+	}
+}
+
+func TestStoreResults(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	start := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	id, err := s.BeginRun(ctx, "scan", "modules/http_probe.yaml", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := NodeResult{Node: "scan-1", IP: "10.0.0.1", Chunk: 1, StartedAt: start, FinishedAt: start.Add(time.Second)}
+	ok.SummarizeOutput("https://a.example [200]\nhttps://b.example [301]\n")
+	failed := NodeResult{Node: "scan-2", IP: "10.0.0.2", Chunk: 2, ExitCode: 2, StartedAt: start, FinishedAt: start.Add(2 * time.Second)}
+	failed.SummarizeOutput("error: no route")
+	if err := s.FinishRun(ctx, id, "failed", start.Add(3*time.Second), []NodeResult{failed, ok}); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := s.Run(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Fleet != "scan" || run.Status != "failed" || !run.FinishedAt.Equal(start.Add(3*time.Second)) {
+		t.Errorf("run = %+v", run)
+	}
+	if _, err := s.Run(ctx, id+1); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("missing run: err = %v", err)
+	}
+	if runs, err := s.Runs(ctx, 10); err != nil || len(runs) != 1 || runs[0].ID != id {
+		t.Errorf("runs = %+v, %v", runs, err)
+	}
+
+	all, err := s.Results(ctx, id, ResultFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Node != "scan-1" || all[0].OutputLines != 2 || all[1].OutputLines != 1 {
+		t.Fatalf("results = %+v", all)
+	}
+	if !all[1].StartedAt.Equal(start) || !all[1].FinishedAt.Equal(start.Add(2*time.Second)) {
+		t.Errorf("timestamps = %v, %v", all[1].StartedAt, all[1].FinishedAt)
+	}
+
+	filters := []struct {
+		f    ResultFilter
+		want string
+	}{
+		{ResultFilter{Failed: true}, "scan-2"},
+		{ResultFilter{Node: "scan-1"}, "scan-1"},
+		{ResultFilter{Contains: "[301]"}, "scan-1"},
+	}
+	for _, tt := range filters {
+		got, err := s.Results(ctx, id, tt.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Node != tt.want {
+			t.Errorf("Results(%+v) = %+v, want only %s", tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeOutput(t *testing.T) {
+	var r NodeResult
+	r.SummarizeOutput(strings.Repeat("line\n", 30))
+	if r.OutputBytes != 150 || r.OutputLines != 30 || r.OutputHead != strings.Repeat("line\n", outputHeadLines) {
+		t.Errorf("summary = %d bytes, %d lines, head %q", r.OutputBytes, r.OutputLines, r.OutputHead)
+	}
+	r.SummarizeOutput(strings.Repeat("x", 2*outputHeadBytes))
+	if r.OutputLines != 1 || len(r.OutputHead) != outputHeadBytes {
+		t.Errorf("long line: %d lines, head of %d bytes", r.OutputLines, len(r.OutputHead))
+	}
+}
+
+func TestStoreBenchmarks(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	at := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := s.RecordBenchmarks(ctx, "scan", []NodeBenchmark{
+		{Node: "scan-1", IP: "10.0.0.1", CPU: 400, IO: 100, Score: 200, MeasuredAt: at},
+		{Node: "scan-2", IP: "10.0.0.2", CPU: 900, IO: 100, Score: 300, MeasuredAt: at},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A rerun replaces the node's score.
+	if err := s.RecordBenchmarks(ctx, "scan", []NodeBenchmark{{Node: "scan-1", IP: "10.0.0.1", CPU: 1600, IO: 100, Score: 400, MeasuredAt: at.Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Benchmarks(ctx, "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Node != "scan-1" || got[0].Score != 400 || !got[0].MeasuredAt.Equal(at.Add(time.Hour)) || got[1].Node != "scan-2" {
+		t.Errorf("benchmarks = %+v", got)
+	}
+	if other, err := s.Benchmarks(ctx, "other"); err != nil || len(other) != 0 {
+		t.Errorf("other fleet = %v, %v", other, err)
+	}
+}
+
+func TestStoreNodeCache(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	scan := []providers.Node{{Name: "scan-1", IP: "10.0.0.1", AgentPort: 18088, Tags: []string{"gaxx"}}}
+	for fleet, nodes := range map[string][]providers.Node{"scan": scan, "web": nil, "": scan} {
+		if err := s.CacheNodes(ctx, "linode", fleet, nodes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, ok, err := s.CachedNodes(ctx, "linode", "scan", time.Minute)
+	if err != nil || !ok || len(got) != 1 || got[0].AgentPort != 18088 || got[0].Tags[0] != "gaxx" {
+		t.Fatalf("cached = %+v, %v, %v", got, ok, err)
+	}
+	if _, ok, _ := s.CachedNodes(ctx, "linode", "scan", 0); ok {
+		t.Error("expired listing served")
+	}
+	if _, ok, _ := s.CachedNodes(ctx, "vultr", "scan", time.Minute); ok {
+		t.Error("listing served for another provider")
+	}
+
+	// Changing scan drops its listing and the all-nodes one, not web's.
+	if err := s.InvalidateNodes(ctx, "linode", "scan"); err != nil {
+		t.Fatal(err)
+	}
+	for fleet, want := range map[string]bool{"scan": false, "": false, "web": true} {
+		if _, ok, _ := s.CachedNodes(ctx, "linode", fleet, time.Minute); ok != want {
+			t.Errorf("%q cached = %v after invalidating scan", fleet, ok)
+		}
+	}
+	if err := s.InvalidateNodes(ctx, "linode", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.CachedNodes(ctx, "linode", "web", time.Minute); ok {
+		t.Error("web still cached after invalidating every fleet")
+	}
+}
+
+func TestStoreNodeBreakers(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	until := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	b := NewCircuitBreakers(2, time.Minute)
+	b.Store = s
+	b.Record("w-1", true)
+	b.Record("w-1", true)
+	b.Record("w-2", true)
+	saved, err := s.NodeBreakers(ctx)
+	if err != nil || len(saved) != 1 || saved[0].Node != "w-1" || saved[0].State != BreakerOpen || saved[0].Until.Before(until) {
+		t.Fatalf("saved = %+v, %v", saved, err)
+	}
+
+	// A later process picks the open breaker up; closing it forgets it.
+	later := NewCircuitBreakers(2, time.Minute)
+	later.Store = s
+	if err := later.Allow("w-1"); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("restored breaker allowed work: %v", err)
+	}
+	if err := s.SaveNodeBreaker(ctx, NodeBreaker{Node: "w-1", State: BreakerClosed}); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := s.NodeBreakers(ctx); len(saved) != 0 {
+		t.Errorf("closed breaker kept: %+v", saved)
+	}
+}
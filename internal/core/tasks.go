@@ -0,0 +1,31 @@
+package core
+
+// ChunkInputs splits items into consecutive chunks of at most size items.
+// A size below 1 is treated as 1.
+func ChunkInputs(items []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+	chunks := make([][]string, 0, (len(items)+size-1)/size)
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end])
+	}
+	return chunks
+}
+
+// AutoChunkSize returns the chunk size that splits items into about chunks
+// chunks, at least one item each.
+func AutoChunkSize(items, chunks int) int {
+	if chunks < 1 {
+		chunks = 1
+	}
+	size := (items + chunks - 1) / chunks
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
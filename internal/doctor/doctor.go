@@ -0,0 +1,95 @@
+// Package doctor checks how gaxx-agent is installed on a node: a shell
+// script run over SSH reports whether the binary exists and runs, whether
+// its systemd service is active and whether anything listens on the agent
+// port, and Report explains what that means.
+package doctor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+)
+
+// AgentBinary is where cloud-init installs the agent.
+const AgentBinary = "/usr/local/bin/gaxx-agent"
+
+// Report is what gaxx doctor found on one node.
+type Report struct {
+	SSHErr    error
+	Version   string // empty when the binary is missing or won't run
+	Installed bool
+	Service   string // systemctl is-active: active, inactive, failed, ...
+	Listening bool
+	// HeartbeatErr is the error heartbeating the agent from the
+	// controller, which Script cannot check.
+	HeartbeatErr error
+	Journal      string
+}
+
+// Problems explains, most fundamental first, why the node's agent isn't
+// working; it is empty for a healthy node.
+func (r Report) Problems(port int) []string {
+	if r.SSHErr != nil {
+		return []string{fmt.Sprintf("cannot SSH in: %v", r.SSHErr)}
+	}
+	var out []string
+	switch {
+	case !r.Installed:
+		out = append(out, fmt.Sprintf("%s is missing; cloud-init probably failed to download it (see /var/log/cloud-init-output.log) or run gaxx agent install", AgentBinary))
+	case r.Version == "":
+		out = append(out, fmt.Sprintf("%s does not run on this machine; check it was built for the node's OS and architecture", AgentBinary))
+	}
+	if r.Service != "active" {
+		out = append(out, fmt.Sprintf("gaxx-agent service is %s; see its journal below", r.Service))
+	}
+	if r.Service == "active" && !r.Listening {
+		out = append(out, fmt.Sprintf("nothing is listening on port %d; check the agent's --addr / GAXX_AGENT_ADDR", port))
+	}
+	if r.Listening && r.HeartbeatErr != nil {
+		out = append(out, fmt.Sprintf("port %d is open on the node but the agent can't be reached from here (%v); check firewalls and security groups", port, r.HeartbeatErr))
+	}
+	return out
+}
+
+// separator ends the checks in Script's output; the journal follows it.
+const separator = "--- gaxx doctor journal ---"
+
+// Script returns the remote shell script whose output ParseOutput reads:
+// key=value lines for each check, then a separator and the last lines of
+// the agent's journal. The session has no terminal, so sudo is run with -n
+// and fails at once where it would ask for a password.
+func Script(port, lines int) string {
+	return strings.Join([]string{
+		`B=` + AgentBinary,
+		`if [ -e "$B" ]; then echo installed=yes; echo "version=$("$B" --version 2>/dev/null | head -n 1)"; else echo installed=no; fi`,
+		`echo "service=$(systemctl is-active gaxx-agent 2>/dev/null || true)"`,
+		`if ss -ltn 2>/dev/null | awk '{print $4}' | grep -Eq '[:.]` + strconv.Itoa(port) + `$'; then echo listening=yes; else echo listening=no; fi`,
+		`echo ` + core.ShellQuote(separator),
+		`SUDO="sudo -n"; [ "$(id -u)" -eq 0 ] && SUDO=; $SUDO ` + core.ShellJoin("journalctl", "-u", "gaxx-agent", "--no-pager", "-n", strconv.Itoa(lines)) + ` 2>&1`,
+	}, "\n")
+}
+
+// ParseOutput reads the output of Script.
+func ParseOutput(out string) Report {
+	checks, journal, _ := strings.Cut(out, separator+"\n")
+	r := Report{Journal: journal, Service: "unknown"}
+	for _, line := range strings.Split(checks, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "installed":
+			r.Installed = value == "yes"
+		case "version":
+			r.Version = value
+		case "service":
+			if value != "" {
+				r.Service = value
+			}
+		case "listening":
+			r.Listening = value == "yes"
+		}
+	}
+	return r
+}
@@ -0,0 +1,88 @@
+package doctor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	script := Script(8088, 30)
+	for _, want := range []string{
+		`B=` + AgentBinary,
+		`grep -Eq '[:.]8088$'`,
+		`SUDO="sudo -n"`,
+		`journalctl -u gaxx-agent --no-pager -n 30`,
+		`echo '` + separator + `'`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name, out string
+		want      Report
+	}{
+		{
+			name: "healthy",
+			out:  "installed=yes\nversion=gaxx-agent 1.4.0\nservice=active\nlistening=yes\n" + separator + "\nMay 01 started\n",
+			want: Report{Installed: true, Version: "gaxx-agent 1.4.0", Service: "active", Listening: true, Journal: "May 01 started\n"},
+		},
+		{
+			name: "missing binary",
+			out:  "installed=no\nservice=inactive\nlistening=no\n" + separator + "\n",
+			want: Report{Service: "inactive"},
+		},
+		{
+			name: "binary that won't run",
+			out:  "installed=yes\nversion=\nservice=failed\nlistening=no\n" + separator + "\n",
+			want: Report{Installed: true, Service: "failed"},
+		},
+		{
+			name: "no systemd and no journal access",
+			out:  "installed=yes\nversion=gaxx-agent 1.4.0\nservice=\nlistening=yes\n" + separator + "\nsudo: a password is required\n",
+			want: Report{Installed: true, Version: "gaxx-agent 1.4.0", Service: "unknown", Listening: true, Journal: "sudo: a password is required\n"},
+		},
+		{
+			name: "cut short",
+			out:  "installed=yes\n",
+			want: Report{Installed: true, Service: "unknown"},
+		},
+	} {
+		if got := ParseOutput(tc.out); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	healthy := Report{Installed: true, Version: "gaxx-agent 1.4.0", Service: "active", Listening: true}
+	for _, tc := range []struct {
+		name   string
+		report func(r *Report)
+		want   []string
+	}{
+		{"healthy", func(r *Report) {}, nil},
+		{"ssh failed", func(r *Report) { r.SSHErr = errors.New("connection refused"); r.Installed = false }, []string{"cannot SSH in"}},
+		{"missing", func(r *Report) { r.Installed, r.Version, r.Service, r.Listening = false, "", "inactive", false }, []string{"is missing", "service is inactive"}},
+		{"broken binary", func(r *Report) { r.Version, r.Service, r.Listening = "", "failed", false }, []string{"does not run", "service is failed"}},
+		{"not listening", func(r *Report) { r.Listening = false }, []string{"nothing is listening on port 8088"}},
+		{"firewalled", func(r *Report) { r.HeartbeatErr = errors.New("i/o timeout") }, []string{"can't be reached from here"}},
+	} {
+		r := healthy
+		tc.report(&r)
+		got := r.Problems(8088)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: problems = %q, want %d", tc.name, got, len(tc.want))
+			continue
+		}
+		for i, w := range tc.want {
+			if !strings.Contains(got[i], w) {
+				t.Errorf("%s: problem %d = %q, want it to mention %q", tc.name, i, got[i], w)
+			}
+		}
+	}
+}
@@ -1,18 +1,70 @@
 package providers
 
 import (
+	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
+// AgentSystemdUnit returns the systemd unit that runs gaxx-agent as user.
+func AgentSystemdUnit(username string) string {
+	if username == "" {
+		username = "gx"
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Gaxx Agent
+After=network.target
+[Service]
+ExecStart=/usr/local/bin/gaxx-agent
+User=%s
+Restart=always
+RestartSec=2
+[Install]
+WantedBy=multi-user.target
+`, username)
+}
+
+// DefaultAgentDownloadURL is used when agent.download_url is unset.
+const DefaultAgentDownloadURL = "https://example.com/gaxx-agent-{{.OS}}-{{.Arch}}"
+
+// AgentURLForShell rewrites the {{.OS}} and {{.Arch}} placeholders of an agent
+// URL template into the shell variables set by CloudInitUserData.
+func AgentURLForShell(tmpl string) string {
+	return strings.NewReplacer("{{.OS}}", "${GAXX_OS}", "{{.Arch}}", "${GAXX_ARCH}").Replace(tmpl)
+}
+
+// CheckAgentSHA256 validates an agent digest for CloudInitUserData and
+// returns it in lower case. An empty digest is returned as is.
+func CheckAgentSHA256(sum string) (string, error) {
+	if sum == "" {
+		return "", nil
+	}
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return "", fmt.Errorf("agent sha256 %q is not a hex SHA-256 digest", sum)
+	}
+	return sum, nil
+}
+
 // CloudInitUserData returns a minimal cloud-init YAML that:
-// - creates a non-root user
-// - configures SSH hardening
-// - writes the controller's ephemeral SSH public key
-// - installs and starts gaxx-agent via a simple systemd unit
-func CloudInitUserData(username, sshAuthorizedKey, agentDownloadURL string) string {
+//   - creates a non-root user
+//   - configures SSH hardening
+//   - writes the controller's ephemeral SSH public key
+//   - downloads gaxx-agent for the instance's architecture, checks its
+//     digest against agentSHA256 when one is given and that it runs, and
+//     starts it via a simple systemd unit
+//
+// agentDownloadURL may contain {{.OS}} and {{.Arch}} placeholders;
+// agentSHA256 must have passed CheckAgentSHA256.
+func CloudInitUserData(username, sshAuthorizedKey, agentDownloadURL, agentSHA256 string) string {
 	if username == "" {
 		username = "gx"
 	}
+	verify := ""
+	if agentSHA256 != "" {
+		verify = fmt.Sprintf(`
+    echo "%s  gaxx-agent" | sha256sum -c - || { echo "gaxx: agent checksum mismatch, not installing" >&2; exit 1; }`, agentSHA256)
+	}
 	return fmt.Sprintf(`#cloud-config
 users:
   - name: %s
@@ -32,14 +84,32 @@ write_files:
       PasswordAuthentication no
       ChallengeResponseAuthentication no
       UsePAM yes
+  - path: /etc/systemd/system/gaxx-agent.service
+    permissions: '0644'
+    content: |
+%s
 runcmd:
   - |
     set -euo pipefail
     cd /tmp
-    curl -fsSL %s -o gaxx-agent
+    GAXX_OS=$(uname -s | tr '[:upper:]' '[:lower:]')
+    case "$(uname -m)" in
+      x86_64|amd64) GAXX_ARCH=amd64 ;;
+      aarch64|arm64) GAXX_ARCH=arm64 ;;
+      armv7l|armv6l) GAXX_ARCH=arm ;;
+      *) echo "gaxx: unsupported architecture $(uname -m)" >&2; exit 1 ;;
+    esac
+    curl -fsSL "%s" -o gaxx-agent%s
+    chmod 0755 gaxx-agent
+    ./gaxx-agent --version
     install -m 0755 gaxx-agent /usr/local/bin/gaxx-agent
-    printf '[Unit]\nDescription=Gaxx Agent\nAfter=network.target\n[Service]\nExecStart=/usr/local/bin/gaxx-agent\nUser=%s\nRestart=always\nRestartSec=2\n[Install]\nWantedBy=multi-user.target\n' > /etc/systemd/system/gaxx-agent.service
     systemctl daemon-reload
     systemctl enable --now gaxx-agent
-`, username, sshAuthorizedKey, agentDownloadURL, username)
+`, username, sshAuthorizedKey, indent(AgentSystemdUnit(username), "      "), AgentURLForShell(agentDownloadURL), verify)
+}
+
+// indent prefixes every line of s with prefix, dropping the trailing newline.
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
 }
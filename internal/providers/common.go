@@ -1,32 +1,88 @@
 package providers
 
+import "gopkg.in/yaml.v3"
+
+// Default API rate limits, used when a provider's requests_per_second is unset.
+const (
+	// DefaultLinodeRequestsPerSecond stays well under Linode's per-endpoint
+	// limits; instance creation is throttled far below the general
+	// 800 requests/minute allowance.
+	DefaultLinodeRequestsPerSecond = 2.0
+	// DefaultVultrRequestsPerSecond matches Vultr's documented v2 API limit
+	// of 30 requests/second per key.
+	DefaultVultrRequestsPerSecond = 30.0
+)
+
+// RequestsPerSecond returns the configured rate limit, or fallback if unset.
+func RequestsPerSecond(configured, fallback float64) float64 {
+	if configured > 0 {
+		return configured
+	}
+	return fallback
+}
+
 type Config struct {
 	Providers struct {
 		Default string `yaml:"default"`
 		Linode  struct {
-			Token  string   `yaml:"token"`
-			Region string   `yaml:"region"`
-			Type   string   `yaml:"type"`
-			Image  string   `yaml:"image"`
-			Tags   []string `yaml:"tags"`
+			Token             string   `yaml:"token"`
+			Region            string   `yaml:"region"`
+			Type              string   `yaml:"type"`
+			Image             string   `yaml:"image"`
+			Tags              []string `yaml:"tags"`
+			RequestsPerSecond float64  `yaml:"requests_per_second"`
 		} `yaml:"linode"`
 		Vultr struct {
-			Token  string   `yaml:"token"`
-			Region string   `yaml:"region"`
-			Plan   string   `yaml:"plan"`
-			OSID   string   `yaml:"os_id"`
-			Tags   []string `yaml:"tags"`
+			Token             string   `yaml:"token"`
+			Region            string   `yaml:"region"`
+			Plan              string   `yaml:"plan"`
+			OSID              string   `yaml:"os_id"`
+			Tags              []string `yaml:"tags"`
+			RequestsPerSecond float64  `yaml:"requests_per_second"`
 		} `yaml:"vultr"`
 		LocalSSH struct {
 			Hosts []struct {
 				Name    string `yaml:"name"`
 				IP      string `yaml:"ip"`
+				IPv6    string `yaml:"ipv6"`
 				User    string `yaml:"user"`
 				KeyPath string `yaml:"key_path"`
 				Port    int    `yaml:"port"`
+				// AgentPort overrides defaults.agent_port for this host.
+				AgentPort int      `yaml:"agent_port"`
+				Tags      []string `yaml:"tags"`
 			} `yaml:"hosts"`
 		} `yaml:"localssh"`
+		// Plugins lists Go plugins (.so) to load; each registers its
+		// providers with RegisterProvider from an init function.
+		Plugins []string `yaml:"plugins"`
+		// Custom holds the config sections of providers added with
+		// RegisterProvider; read one with ProviderConfig.
+		Custom map[string]yaml.Node `yaml:",inline"`
 	} `yaml:"providers"`
+	Agent struct {
+		// DownloadURL is where cloud-init fetches gaxx-agent. {{.OS}} and
+		// {{.Arch}} are replaced on the instance with its GOOS/GOARCH.
+		DownloadURL string `yaml:"download_url"`
+		// SHA256, if set, is the hex digest the downloaded agent must
+		// have; on a mismatch cloud-init stops before installing it. One
+		// digest pins one build, so with {{.Arch}} in DownloadURL it suits
+		// fleets of a single architecture.
+		SHA256 string `yaml:"sha256"`
+		// TLS is how the controller reaches agents serving https
+		// (GAXX_AGENT_TLS_CERT on the agent).
+		TLS struct {
+			Enabled bool `yaml:"enabled"`
+			// CACert verifies agents' certificates; empty uses the
+			// system roots.
+			CACert             string `yaml:"ca_cert"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+			// ClientCert and ClientKey are presented to agents that
+			// require mTLS (GAXX_AGENT_REQUIRE_MTLS).
+			ClientCert string `yaml:"client_cert"`
+			ClientKey  string `yaml:"client_key"`
+		} `yaml:"tls"`
+	} `yaml:"agent"`
 	SSH struct {
 		KeyDir     string `yaml:"key_dir"`
 		KnownHosts string `yaml:"known_hosts"`
@@ -34,13 +90,48 @@ type Config struct {
 	Defaults struct {
 		User           string `yaml:"user"`
 		SSHPort        int    `yaml:"ssh_port"`
+		AgentPort      int    `yaml:"agent_port"`
 		Retries        int    `yaml:"retries"`
 		TimeoutSeconds int    `yaml:"timeout_seconds"`
+		// ExecMode is how commands reach nodes: agent, ssh, or auto (the
+		// default), which probes one node's agent per run and uses SSH
+		// alone when it cannot be reached.
+		ExecMode string `yaml:"exec_mode"`
+		// PreferIPv6 dials nodes that have both addresses over IPv6.
+		PreferIPv6 bool `yaml:"prefer_ipv6"`
+		// BreakerThreshold is how many consecutive executions a node may
+		// fail to run before it gets no work for BreakerCooldownSeconds;
+		// 0 disables the circuit breaker.
+		BreakerThreshold       int `yaml:"breaker_threshold"`
+		BreakerCooldownSeconds int `yaml:"breaker_cooldown_seconds"`
 	} `yaml:"defaults"`
+	Store struct {
+		// Path is the SQLite database recording fleet state, such as the
+		// agent version last seen on each node.
+		Path string `yaml:"path"`
+		// NodeCacheSeconds is how long a fleet's node listing is reused
+		// before asking the provider again; 0 disables the cache.
+		NodeCacheSeconds int `yaml:"node_cache_seconds"`
+	} `yaml:"store"`
+	Modules struct {
+		// Path lists directories searched, in order, for modules named
+		// without a file path; bundled modules are searched last.
+		Path []string `yaml:"path"`
+		// Repo is the base URL repo://<path> module references are
+		// fetched from, such as a git host's raw file URL for a branch.
+		Repo string `yaml:"repo"`
+		// Cache is where fetched modules are kept, by checksum, so pinned
+		// ones are only downloaded once.
+		Cache string `yaml:"cache"`
+	} `yaml:"modules"`
 	Telemetry struct {
 		Enabled         bool   `yaml:"enabled"`
 		OTLPEndpoint    string `yaml:"otlp_endpoint"`
+		PushgatewayURL  string `yaml:"pushgateway_url"`
 		MonitoringPort  int    `yaml:"monitoring_port"`
 		MetricsInterval int    `yaml:"metrics_interval"`
+		// MaxLabelValues caps distinct values per metric label; 0 uses the
+		// collector default and a negative value disables the cap.
+		MaxLabelValues int `yaml:"max_label_values"`
 	} `yaml:"telemetry"`
 }
@@ -0,0 +1,129 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath returns $GAXX_CONFIG, or ~/.config/gaxx/config.yaml.
+func DefaultConfigPath() string {
+	if p := os.Getenv("GAXX_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(configDir(), "config.yaml")
+}
+
+func configDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".config", "gaxx")
+}
+
+// DefaultConfig returns the configuration used when no file is present.
+func DefaultConfig() Config {
+	var cfg Config
+	cfg.Providers.Default = "linode"
+	cfg.SSH.KeyDir = filepath.Join(configDir(), "ssh")
+	cfg.SSH.KnownHosts = filepath.Join(configDir(), "known_hosts")
+	cfg.Defaults.User = "gx"
+	cfg.Defaults.SSHPort = 22
+	cfg.Defaults.AgentPort = DefaultAgentPort
+	cfg.Defaults.Retries = 3
+	cfg.Defaults.TimeoutSeconds = 600
+	cfg.Defaults.ExecMode = "auto"
+	cfg.Defaults.BreakerThreshold = 3
+	cfg.Defaults.BreakerCooldownSeconds = 60
+	cfg.Store.Path = filepath.Join(configDir(), "gaxx.db")
+	cfg.Store.NodeCacheSeconds = 15
+	cfg.Modules.Path = []string{filepath.Join(configDir(), "modules")}
+	cfg.Modules.Cache = filepath.Join(configDir(), "cache", "modules")
+	return cfg
+}
+
+// LoadConfig reads the YAML config at path (DefaultConfigPath if empty) on top
+// of DefaultConfig. A missing file is not an error. Provider tokens fall back
+// to LINODE_TOKEN and VULTR_TOKEN/VULTR_API_KEY, and "~" in paths is expanded.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		path = DefaultConfigPath()
+	}
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(ExpandHome(path))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return cfg, fmt.Errorf("read config: %w", err)
+	default:
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+
+	if cfg.Providers.Linode.Token == "" {
+		cfg.Providers.Linode.Token = os.Getenv("LINODE_TOKEN")
+	}
+	if cfg.Providers.Vultr.Token == "" {
+		cfg.Providers.Vultr.Token = firstEnv("VULTR_TOKEN", "VULTR_API_KEY")
+	}
+	cfg.SSH.KeyDir = ExpandHome(cfg.SSH.KeyDir)
+	cfg.SSH.KnownHosts = ExpandHome(cfg.SSH.KnownHosts)
+	cfg.Store.Path = ExpandHome(cfg.Store.Path)
+	for i, dir := range cfg.Modules.Path {
+		cfg.Modules.Path[i] = ExpandHome(dir)
+	}
+	cfg.Modules.Cache = ExpandHome(cfg.Modules.Cache)
+	cfg.Agent.TLS.CACert = ExpandHome(cfg.Agent.TLS.CACert)
+	cfg.Agent.TLS.ClientCert = ExpandHome(cfg.Agent.TLS.ClientCert)
+	cfg.Agent.TLS.ClientKey = ExpandHome(cfg.Agent.TLS.ClientKey)
+	for i := range cfg.Providers.LocalSSH.Hosts {
+		cfg.Providers.LocalSSH.Hosts[i].KeyPath = ExpandHome(cfg.Providers.LocalSSH.Hosts[i].KeyPath)
+	}
+	return cfg, nil
+}
+
+// ProviderConfig decodes the providers.<name> section of the config into
+// out, for providers added with RegisterProvider. A missing section leaves
+// out unchanged.
+func (c Config) ProviderConfig(name string, out any) error {
+	node, ok := c.Providers.Custom[name]
+	if !ok {
+		return nil
+	}
+	if err := node.Decode(out); err != nil {
+		return fmt.Errorf("parse providers.%s: %w", name, err)
+	}
+	return nil
+}
+
+// ExpandHome replaces a leading "~" with the user's home directory.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// SSHKeyPath returns the controller's private key inside the configured key dir.
+func (c Config) SSHKeyPath() string {
+	return filepath.Join(c.SSH.KeyDir, "id_ed25519")
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
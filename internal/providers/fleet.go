@@ -0,0 +1,129 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrFleetExists is returned when spawning into a fleet that already has nodes.
+var ErrFleetExists = errors.New("fleet already exists")
+
+// SpawnPolicy controls how a spawn treats nodes already in the fleet.
+type SpawnPolicy string
+
+const (
+	// SpawnRefuse fails with ErrFleetExists if the fleet has any nodes.
+	SpawnRefuse SpawnPolicy = "refuse"
+	// SpawnTopUp only creates enough nodes to reach the requested count.
+	SpawnTopUp SpawnPolicy = "top-up"
+	// SpawnForce always creates the requested count on top of existing nodes.
+	SpawnForce SpawnPolicy = "force"
+)
+
+// PlanSpawn returns how many nodes to create for a fleet that already has
+// existing members.
+func PlanSpawn(existing, requested int, policy SpawnPolicy) (int, error) {
+	switch policy {
+	case SpawnRefuse, "":
+		if existing > 0 {
+			return 0, fmt.Errorf("%w (use --top-up to reach the requested count or --force to add more)", ErrFleetExists)
+		}
+		return requested, nil
+	case SpawnTopUp:
+		if existing >= requested {
+			return 0, nil
+		}
+		return requested - existing, nil
+	case SpawnForce:
+		return requested, nil
+	default:
+		return 0, fmt.Errorf("unknown spawn policy: %s", policy)
+	}
+}
+
+// FleetNodes filters nodes down to those labelled "<name>-<n>", so that
+// "web" does not also match "webserver-1", as a prefix match would.
+func FleetNodes(nodes []Node, name string) []Node {
+	var members []Node
+	for _, n := range nodes {
+		if FleetIndex(n.Name, name) > 0 {
+			members = append(members, n)
+		}
+	}
+	return members
+}
+
+// NextFleetIndex returns the first label suffix not used by the fleet.
+func NextFleetIndex(nodes []Node, name string) int {
+	next := 1
+	for _, n := range nodes {
+		if i := FleetIndex(n.Name, name); i >= next {
+			next = i + 1
+		}
+	}
+	return next
+}
+
+// FleetIndex returns n for a label of the form "<name>-<n>", or 0 otherwise.
+func FleetIndex(label, name string) int {
+	suffix, ok := strings.CutPrefix(label, name+"-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// ErrEmptyFleet is matched by every *EmptyFleetError.
+var ErrEmptyFleet = errors.New("fleet has no nodes")
+
+// EmptyFleetError is returned when a fleet has no running nodes. It carries
+// what the provider does have, so the message can tell a mistyped fleet
+// name from a wrong provider or token. Total is how many nodes the provider
+// lists across all fleets, or -1 if that listing failed, and Fleets names
+// the fleets among them.
+type EmptyFleetError struct {
+	Fleet    string
+	Provider string
+	Total    int
+	Fleets   []string
+}
+
+func (e *EmptyFleetError) Error() string {
+	msg := fmt.Sprintf("no nodes found for fleet %s", e.Fleet)
+	switch {
+	case e.Total < 0:
+		return msg
+	case e.Total == 0:
+		return fmt.Sprintf("%s: provider %s lists no instances at all; check --provider and its API token, or spawn the fleet with gaxx spawn", msg, e.Provider)
+	case len(e.Fleets) == 0:
+		return fmt.Sprintf("%s: provider %s has %d instances but none in a gaxx fleet; check the fleet name", msg, e.Provider, e.Total)
+	default:
+		return fmt.Sprintf("%s: provider %s has fleets %s; check the name, or wait if the fleet was just spawned and its nodes are still booting", msg, e.Provider, strings.Join(e.Fleets, ", "))
+	}
+}
+
+func (e *EmptyFleetError) Unwrap() error { return ErrEmptyFleet }
+
+// FleetNames returns the sorted names of the fleets nodes belong to, going
+// by their "<name>-<n>" labels. Nodes with other labels are skipped.
+func FleetNames(nodes []Node) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, n := range nodes {
+		i := strings.LastIndex(n.Name, "-")
+		if i <= 0 || FleetIndex(n.Name, n.Name[:i]) == 0 || seen[n.Name[:i]] {
+			continue
+		}
+		seen[n.Name[:i]] = true
+		names = append(names, n.Name[:i])
+	}
+	sort.Strings(names)
+	return names
+}
@@ -9,11 +9,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
 	prov "github.com/3cpo-dev/gaxx/internal/providers"
 	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/rs/zerolog/log"
 )
 
 type Provider struct {
@@ -25,7 +27,7 @@ type Provider struct {
 func New(cfg prov.Config) *Provider {
 	return &Provider{
 		cfg:       cfg,
-		client:    prov.NewRetryableHTTPClient(30*time.Second, 2.0), // 2 req/sec for Linode
+		client:    prov.NewRetryableHTTPClient(30*time.Second, prov.RequestsPerSecond(cfg.Providers.Linode.RequestsPerSecond, prov.DefaultLinodeRequestsPerSecond)),
 		validator: prov.NewCloudProviderValidator(),
 	}
 }
@@ -38,7 +40,9 @@ type linodeInstance struct {
 	ID     int      `json:"id"`
 	Label  string   `json:"label"`
 	IPv4   []string `json:"ipv4"`
+	IPv6   string   `json:"ipv6"`
 	Status string   `json:"status"`
+	Tags   []string `json:"tags"`
 }
 
 type linodeCreateReq struct {
@@ -78,6 +82,11 @@ func randPass() string {
 }
 
 func (p *Provider) CreateFleet(ctx context.Context, req prov.CreateFleetRequest) (*prov.Fleet, error) {
+	// Linode has no spot/preemptible plans.
+	if req.Spot {
+		return nil, fmt.Errorf("linode: %w", prov.ErrSpotNotSupported)
+	}
+
 	// Validate request
 	if err := p.validator.ValidateCreateRequest("linode", req); err != nil {
 		return nil, fmt.Errorf("validation failed: %w", err)
@@ -103,28 +112,26 @@ func (p *Provider) CreateFleet(ctx context.Context, req prov.CreateFleetRequest)
 		return nil, fmt.Errorf("image is required")
 	}
 
-	sshKeyPath := p.cfg.SSH.KeyDir + "/id_ed25519"
-	signer, err := gssh.LoadPrivateKeySigner(sshKeyPath)
+	signer, err := gssh.LoadPrivateKeySigner(p.cfg.SSHKeyPath())
 	if err != nil {
 		return nil, fmt.Errorf("load ssh key: %w", err)
 	}
 	pubAuth := string(gssh.MarshalAuthorized(signer))
 	pubAuth = strings.TrimSpace(pubAuth) // Remove any trailing whitespace
 
-	userData := prov.CloudInitUserData(user, pubAuth, "https://example.com/gaxx-agent")
+	agentSum, err := prov.CheckAgentSHA256(firstNonEmpty(req.AgentSHA256, p.cfg.Agent.SHA256))
+	if err != nil {
+		return nil, err
+	}
+	userData := prov.CloudInitUserData(user, pubAuth, firstNonEmpty(p.cfg.Agent.DownloadURL, prov.DefaultAgentDownloadURL), agentSum)
 	encodedUserData := base64.StdEncoding.EncodeToString([]byte(userData))
 
-	// Build tags, ensuring no duplicates
-	tags := []string{"gaxx"}
-	for _, tag := range p.cfg.Providers.Linode.Tags {
-		if tag != "gaxx" { // Avoid duplicate "gaxx" tag
-			tags = append(tags, tag)
-		}
-	}
+	tags := prov.MergeTags([]string{"gaxx"}, p.cfg.Providers.Linode.Tags, req.Tags)
 
-	fleet := &prov.Fleet{Name: req.Name}
+	var created []prov.Node
+	start := max(1, req.StartIndex)
 	for i := 0; i < max(1, req.Count); i++ {
-		label := fmt.Sprintf("%s-%d", req.Name, i+1)
+		label := fmt.Sprintf("%s-%d", req.Name, start+i)
 		payload := linodeCreateReq{
 			Region:         region,
 			Type:           typeID,
@@ -136,24 +143,66 @@ func (p *Provider) CreateFleet(ctx context.Context, req prov.CreateFleetRequest)
 			Metadata:       &linodeMetadata{UserData: encodedUserData},
 			Booted:         true,
 		}
-		var created linodeCreateResp
-		if err := p.doJSON(ctx, tok, http.MethodPost, linodeAPI+"/linode/instances", payload, &created); err != nil {
-			return nil, fmt.Errorf("create instance: %w", err)
+		var resp linodeCreateResp
+		if err := p.doJSON(ctx, tok, http.MethodPost, linodeAPI+"/linode/instances", payload, &resp); err != nil {
+			created = prov.AdoptInFlight(ctx, label, created, p.ListNodes)
+			return nil, p.rollback(ctx, tok, req, created, fmt.Errorf("create instance %s: %w", label, err))
+		}
+		created = append(created, prov.Node{ID: fmt.Sprintf("%d", resp.ID), Name: label, SSHUser: user, SSHPort: p.cfg.Defaults.SSHPort, AgentPort: p.cfg.Defaults.AgentPort})
+		node, err := p.waitRunning(ctx, tok, resp.ID, user)
+		if err != nil {
+			return nil, p.rollback(ctx, tok, req, created, fmt.Errorf("wait for instance %s: %w", label, err))
 		}
-		// Poll until running with IP
-		deadline := time.Now().Add(10 * time.Minute)
-		for time.Now().Before(deadline) {
-			var cur linodeInstance
-			if err := p.doJSON(ctx, tok, http.MethodGet, fmt.Sprintf(linodeAPI+"/linode/instances/%d", created.ID), nil, &cur); err == nil {
-				if cur.Status == "running" && len(cur.IPv4) > 0 {
-					fleet.Nodes = append(fleet.Nodes, prov.Node{ID: fmt.Sprintf("%d", cur.ID), Name: cur.Label, IP: cur.IPv4[0], SSHUser: user, SSHPort: p.cfg.Defaults.SSHPort})
-					break
-				}
+		created[len(created)-1] = node
+		if req.Progress != nil {
+			req.Progress(i+1, max(1, req.Count))
+		}
+	}
+	return &prov.Fleet{Name: req.Name, Nodes: created}, nil
+}
+
+// waitRunning polls an instance until it is running with a public IPv4
+// address, or an IPv6 one if that is preferred; one can be running with
+// none yet, or with only a private IPv4 address.
+// Transient API errors are polled through; others end the wait.
+func (p *Provider) waitRunning(ctx context.Context, tok string, id int, user string) (prov.Node, error) {
+	deadline := time.Now().Add(10 * time.Minute)
+	loggedPrivate := false
+	for time.Now().Before(deadline) {
+		var cur linodeInstance
+		if err := p.doJSON(ctx, tok, http.MethodGet, fmt.Sprintf(linodeAPI+"/linode/instances/%d", id), nil, &cur); err != nil {
+			if !prov.Retryable(err) {
+				return prov.Node{}, err
+			}
+		} else {
+			n := p.node(cur)
+			if cur.Status == "running" && n.Addressed() {
+				n.SSHUser = user
+				return n, nil
+			}
+			if n.IP == "" && len(cur.IPv4) > 0 && !n.PreferIPv6 && !loggedPrivate {
+				log.Info().Int("instance", id).Strs("ipv4", cur.IPv4).Msg("Linode instance has only private IPv4 addresses; waiting for a public one")
+				loggedPrivate = true
 			}
-			time.Sleep(5 * time.Second)
 		}
+		select {
+		case <-ctx.Done():
+			return prov.Node{}, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	want := "a public IPv4 address"
+	if p.cfg.Defaults.PreferIPv6 {
+		want = "an IPv6 address"
 	}
-	return fleet, nil
+	return prov.Node{}, fmt.Errorf("timed out waiting for instance %d to run with %s", id, want)
+}
+
+// rollback tears down created instances after a failed CreateFleet.
+func (p *Provider) rollback(ctx context.Context, tok string, req prov.CreateFleetRequest, created []prov.Node, cause error) error {
+	return prov.RollbackFleet(ctx, req, created, cause, func(ctx context.Context, n prov.Node) error {
+		return p.doJSON(ctx, tok, http.MethodDelete, linodeAPI+"/linode/instances/"+n.ID, nil, nil)
+	})
 }
 
 func (p *Provider) ListNodes(ctx context.Context, name string) ([]prov.Node, error) {
@@ -167,18 +216,54 @@ func (p *Provider) ListNodes(ctx context.Context, name string) ([]prov.Node, err
 	}
 	var nodes []prov.Node
 	for _, inst := range list.Data {
-		if name != "" && !strings.HasPrefix(inst.Label, name) {
+		if name != "" && prov.FleetIndex(inst.Label, name) == 0 {
 			continue
 		}
-		ip := ""
-		if len(inst.IPv4) > 0 {
-			ip = inst.IPv4[0]
-		}
-		nodes = append(nodes, prov.Node{ID: fmt.Sprintf("%d", inst.ID), Name: inst.Label, IP: ip, SSHUser: p.cfg.Defaults.User, SSHPort: p.cfg.Defaults.SSHPort})
+		nodes = append(nodes, p.node(inst))
 	}
 	return nodes, nil
 }
 
+// GetNode finds one instance by label, filtering the listing client-side
+// without building a node for every instance.
+func (p *Provider) GetNode(ctx context.Context, fleet, name string) (prov.Node, error) {
+	tok, err := p.token()
+	if err != nil {
+		return prov.Node{}, err
+	}
+	var list linodeListResp
+	if err := p.doJSON(ctx, tok, http.MethodGet, linodeAPI+"/linode/instances", nil, &list); err != nil {
+		return prov.Node{}, err
+	}
+	for _, inst := range list.Data {
+		if inst.Label == name && (fleet == "" || prov.FleetIndex(inst.Label, fleet) > 0) {
+			return p.node(inst), nil
+		}
+	}
+	return prov.Node{}, &prov.NodeNotFoundError{Fleet: fleet, Name: name}
+}
+
+// publicIPv4 returns the first public address in addrs, or "" when there
+// is none yet. Linode lists private (192.168.128.0/17) addresses alongside
+// public ones, in no promised order.
+func publicIPv4(addrs []string) string {
+	for _, a := range addrs {
+		ip, err := netip.ParseAddr(a)
+		if err == nil && ip.Is4() && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return a
+		}
+	}
+	return ""
+}
+
+func (p *Provider) node(inst linodeInstance) prov.Node {
+	ip := publicIPv4(inst.IPv4)
+	// The API gives the SLAAC address with its prefix length, as in
+	// "2600:3c01::f03c:91ff:fe24:3a2f/128".
+	ipv6, _, _ := strings.Cut(inst.IPv6, "/")
+	return prov.Node{ID: fmt.Sprintf("%d", inst.ID), Name: inst.Label, IP: ip, IPv6: ipv6, SSHUser: p.cfg.Defaults.User, SSHPort: p.cfg.Defaults.SSHPort, AgentPort: p.cfg.Defaults.AgentPort, Tags: inst.Tags, PreferIPv6: p.cfg.Defaults.PreferIPv6}
+}
+
 func (p *Provider) DeleteFleet(ctx context.Context, name string) error {
 	tok, err := p.token()
 	if err != nil {
@@ -188,12 +273,50 @@ func (p *Provider) DeleteFleet(ctx context.Context, name string) error {
 	if err := p.doJSON(ctx, tok, http.MethodGet, linodeAPI+"/linode/instances", nil, &list); err != nil {
 		return err
 	}
+	var nodes []prov.Node
 	for _, inst := range list.Data {
-		if name == "" || strings.HasPrefix(inst.Label, name) {
-			_ = p.doJSON(ctx, tok, http.MethodDelete, fmt.Sprintf(linodeAPI+"/linode/instances/%d", inst.ID), nil, nil)
+		if name == "" || prov.FleetIndex(inst.Label, name) > 0 {
+			nodes = append(nodes, prov.Node{ID: fmt.Sprintf("%d", inst.ID), Name: inst.Label})
 		}
 	}
-	return nil
+	return prov.DeleteNodes(ctx, nodes, func(ctx context.Context, n prov.Node) error {
+		return p.doJSON(ctx, tok, http.MethodDelete, linodeAPI+"/linode/instances/"+n.ID, nil, nil)
+	})
+}
+
+// DeleteNode deletes one instance by ID.
+func (p *Provider) DeleteNode(ctx context.Context, n prov.Node) error {
+	tok, err := p.token()
+	if err != nil {
+		return err
+	}
+	return p.doJSON(ctx, tok, http.MethodDelete, linodeAPI+"/linode/instances/"+n.ID, nil, nil)
+}
+
+type linodeTypesResp struct {
+	Data []struct {
+		ID    string `json:"id"`
+		Price struct {
+			Hourly float64 `json:"hourly"`
+		} `json:"price"`
+	} `json:"data"`
+}
+
+// Prices fetches the hourly price of every Linode type.
+func (p *Provider) Prices(ctx context.Context) (map[string]float64, error) {
+	tok, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+	var types linodeTypesResp
+	if err := p.doJSON(ctx, tok, http.MethodGet, linodeAPI+"/linode/types?page_size=500", nil, &types); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]float64, len(types.Data))
+	for _, t := range types.Data {
+		prices[t.ID] = t.Price.Hourly
+	}
+	return prices, nil
 }
 
 func (p *Provider) doJSON(ctx context.Context, token, method, url string, body interface{}, out interface{}) error {
@@ -218,19 +341,47 @@ func (p *Provider) doJSON(ctx context.Context, token, method, url string, body i
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 && method != http.MethodDelete {
-		// Read the response body for more detailed error information
-		var errorBody []byte
-		errorBody, _ = io.ReadAll(resp.Body)
-		return fmt.Errorf("linode api status %d: %s", resp.StatusCode, string(errorBody))
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		// Already gone; deleting is idempotent.
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		errorBody, _ := io.ReadAll(resp.Body)
+		return &prov.APIError{Provider: "linode", StatusCode: resp.StatusCode, Message: apiErrorMessage(errorBody)}
 	}
 	if out != nil {
-		dec := json.NewDecoder(resp.Body)
-		return dec.Decode(out)
+		return prov.DecodeJSON(resp.Body, out)
 	}
 	return nil
 }
 
+// linodeErrorResp is the body Linode returns for failed requests.
+type linodeErrorResp struct {
+	Errors []struct {
+		Field  string `json:"field"`
+		Reason string `json:"reason"`
+	} `json:"errors"`
+}
+
+// apiErrorMessage renders a Linode error body as "field: reason; reason",
+// falling back to a snippet of the body when it is not in Linode's error
+// format.
+func apiErrorMessage(body []byte) string {
+	var e linodeErrorResp
+	if err := json.Unmarshal(body, &e); err != nil || len(e.Errors) == 0 {
+		return prov.BodySnippet(body)
+	}
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		if fe.Field != "" {
+			msgs = append(msgs, fe.Field+": "+fe.Reason)
+		} else {
+			msgs = append(msgs, fe.Reason)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
 func firstNonEmpty(a, b string) string {
 	if a != "" {
 		return a
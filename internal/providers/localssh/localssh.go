@@ -16,6 +16,9 @@ func New(cfg providers.Config) *Provider { return &Provider{cfg: cfg} }
 func (p *Provider) Name() string { return "localssh" }
 
 func (p *Provider) CreateFleet(ctx context.Context, req providers.CreateFleetRequest) (*providers.Fleet, error) {
+	if req.Spot {
+		return nil, fmt.Errorf("localssh: %w", providers.ErrSpotNotSupported)
+	}
 	// No-op: we attach to existing hosts defined in config.
 	nodes, err := p.ListNodes(ctx, req.Name)
 	if err != nil {
@@ -28,17 +31,42 @@ func (p *Provider) ListNodes(ctx context.Context, name string) ([]providers.Node
 	_ = ctx
 	var nodes []providers.Node
 	for _, h := range p.cfg.Providers.LocalSSH.Hosts {
+		user, port := h.User, h.Port
+		if user == "" {
+			user = p.cfg.Defaults.User
+		}
+		if port == 0 {
+			port = p.cfg.Defaults.SSHPort
+		}
+		agentPort := h.AgentPort
+		if agentPort == 0 {
+			agentPort = p.cfg.Defaults.AgentPort
+		}
 		nodes = append(nodes, providers.Node{
-			Name:    h.Name,
-			IP:      h.IP,
-			ID:      fmt.Sprintf("local-%s", h.Name),
-			SSHUser: h.User,
-			SSHPort: h.Port,
+			Name:       h.Name,
+			IP:         h.IP,
+			IPv6:       h.IPv6,
+			ID:         fmt.Sprintf("local-%s", h.Name),
+			SSHUser:    user,
+			SSHPort:    port,
+			AgentPort:  agentPort,
+			KeyPath:    h.KeyPath,
+			Tags:       h.Tags,
+			PreferIPv6: p.cfg.Defaults.PreferIPv6,
 		})
 	}
 	return nodes, nil
 }
 
+// GetNode finds a configured host by name; hosts belong to every fleet.
+func (p *Provider) GetNode(ctx context.Context, fleet, name string) (providers.Node, error) {
+	nodes, err := p.ListNodes(ctx, fleet)
+	if err != nil {
+		return providers.Node{}, err
+	}
+	return providers.FindNode(nodes, fleet, name)
+}
+
 func (p *Provider) DeleteFleet(ctx context.Context, name string) error {
 	_ = ctx
 	_ = name
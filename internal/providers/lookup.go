@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNodeNotFound is matched by every *NodeNotFoundError.
+var ErrNodeNotFound = errors.New("node not found")
+
+// NodeNotFoundError is returned by GetNode when fleet has no node called
+// Name. Fleet is empty when every node was searched.
+type NodeNotFoundError struct {
+	Fleet string
+	Name  string
+}
+
+func (e *NodeNotFoundError) Error() string {
+	if e.Fleet == "" {
+		return fmt.Sprintf("node %s not found", e.Name)
+	}
+	return fmt.Sprintf("node %s not found in fleet %s", e.Name, e.Fleet)
+}
+
+func (e *NodeNotFoundError) Unwrap() error { return ErrNodeNotFound }
+
+// NodeGetter is implemented by providers that can look up one node without
+// listing the whole fleet. GetNode returns the running node called name in
+// fleet (any fleet when empty), or a *NodeNotFoundError.
+type NodeGetter interface {
+	GetNode(ctx context.Context, fleet, name string) (Node, error)
+}
+
+// GetNode looks up one node by name, with p's own lookup when it has one
+// and by listing fleet otherwise.
+func GetNode(ctx context.Context, p Provider, fleet, name string) (Node, error) {
+	if g, ok := p.(NodeGetter); ok {
+		return g.GetNode(ctx, fleet, name)
+	}
+	nodes, err := p.ListNodes(ctx, fleet)
+	if err != nil {
+		return Node{}, err
+	}
+	return FindNode(nodes, fleet, name)
+}
+
+// FindNode returns the node called name from nodes listed for fleet.
+func FindNode(nodes []Node, fleet, name string) (Node, error) {
+	for _, n := range nodes {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return Node{}, &NodeNotFoundError{Fleet: fleet, Name: name}
+}
@@ -0,0 +1,62 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoPrice is returned when no hourly price is known for a size.
+var ErrNoPrice = errors.New("no price known")
+
+// PriceLister is implemented by providers that can fetch their current
+// hourly on-demand prices in USD, keyed by size (instance type or plan).
+type PriceLister interface {
+	Prices(ctx context.Context) (map[string]float64, error)
+}
+
+// BuiltinPrices are hourly on-demand USD prices for common sizes, a
+// snapshot used until prices are refreshed from the provider's API. They
+// drift; refresh before budgeting anything large.
+var BuiltinPrices = map[string]map[string]float64{
+	"linode": {
+		"g6-nanode-1":    0.0075,
+		"g6-standard-1":  0.018,
+		"g6-standard-2":  0.036,
+		"g6-standard-4":  0.072,
+		"g6-standard-6":  0.144,
+		"g6-standard-8":  0.288,
+		"g6-dedicated-2": 0.054,
+		"g6-dedicated-4": 0.108,
+		"g6-dedicated-8": 0.216,
+	},
+	"vultr": {
+		"vc2-1c-0.5gb": 0.004,
+		"vc2-1c-1gb":   0.007,
+		"vc2-1c-2gb":   0.015,
+		"vc2-2c-2gb":   0.022,
+		"vc2-2c-4gb":   0.030,
+		"vc2-4c-8gb":   0.060,
+		"vc2-6c-16gb":  0.119,
+		"vc2-8c-32gb":  0.238,
+	},
+}
+
+// BuiltinPrice returns the built-in hourly price of size on provider.
+func BuiltinPrice(provider, size string) (float64, error) {
+	if price, ok := BuiltinPrices[provider][size]; ok {
+		return price, nil
+	}
+	return 0, fmt.Errorf("%s %s: %w", provider, size, ErrNoPrice)
+}
+
+// DefaultSize returns the size spawn uses on provider when none is given.
+func (c Config) DefaultSize(provider string) string {
+	switch provider {
+	case "linode":
+		return c.Providers.Linode.Type
+	case "vultr":
+		return c.Providers.Vultr.Plan
+	}
+	return ""
+}
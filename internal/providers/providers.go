@@ -1,13 +1,88 @@
 package providers
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAgentPort is the port gaxx-agent listens on unless configured
+// otherwise.
+const DefaultAgentPort = 8088
+
+// rollbackTimeout bounds cleanup that runs after the caller's context is done.
+const rollbackTimeout = 2 * time.Minute
+
+// deleteRetries is how many times DeleteNodes retries instances whose delete
+// failed, waiting deleteRetryDelay before the first retry and doubling it
+// after each round.
+const deleteRetries = 3
+
+var deleteRetryDelay = 2 * time.Second
 
 type Node struct {
-	Name    string
+	Name string
+	// IP is the node's public IPv4 address and IPv6 its public IPv6
+	// address; either may be empty. See DialIP for which is used.
 	IP      string
+	IPv6    string
 	ID      string
 	SSHUser string
 	SSHPort int
+	// AgentPort is the port the controller reaches gaxx-agent on, which
+	// differs from the agent's own when it is remapped behind NAT; 0 means
+	// DefaultAgentPort.
+	AgentPort int
+	// KeyPath optionally overrides the controller's SSH key for this node.
+	KeyPath string
+	// Tags are the provider's tags on the instance, as "key:value",
+	// "key=value" or plain words.
+	Tags []string
+	// PreferIPv6 makes DialIP pick the IPv6 address of a node that has
+	// both. Providers set it from the defaults.prefer_ipv6 of the config
+	// they were created with, so each client's nodes follow its own.
+	PreferIPv6 bool `json:"-"`
+}
+
+// DialIP returns the address to reach the node on: its IPv6 address when
+// IPv6 is preferred or it has no IPv4 address, and its IPv4 one otherwise.
+func (n Node) DialIP() string {
+	if n.IPv6 != "" && (n.IP == "" || n.PreferIPv6) {
+		return n.IPv6
+	}
+	return n.IP
+}
+
+// Addressed reports whether the node has the address a new node should be
+// reached on: its IPv6 address when IPv6 is preferred, as it must be for
+// IPv6-only plans, and its IPv4 one otherwise. Providers wait for it before
+// handing over a new node.
+func (n Node) Addressed() bool {
+	if n.PreferIPv6 {
+		return n.IPv6 != ""
+	}
+	return n.IP != ""
+}
+
+// AgentAddr returns the host:port of the node's gaxx-agent.
+func (n Node) AgentAddr() string {
+	port := n.AgentPort
+	if port == 0 {
+		port = DefaultAgentPort
+	}
+	return HostPort(n.DialIP(), port)
+}
+
+// HostPort joins ip and port into an address to dial, bracketing IPv6
+// literals ("[2001:db8::1]:22"). An ip already in brackets is not
+// bracketed twice.
+func HostPort(ip string, port int) string {
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	return net.JoinHostPort(ip, strconv.Itoa(port))
 }
 
 type Fleet struct {
@@ -25,11 +100,193 @@ type CreateFleetRequest struct {
 	SSHUser   string
 	SSHKey    string
 	CloudInit string
+	// AgentSHA256 overrides agent.sha256, the digest cloud-init checks the
+	// downloaded agent against.
+	AgentSHA256 string
+	// StartIndex is the suffix of the first node label; 0 means 1.
+	StartIndex int
+	// KeepPartial leaves already-created nodes running when a later create fails.
+	KeepPartial bool
+	// Spot requests spot/preemptible instances. Providers without them
+	// return ErrSpotNotSupported rather than creating on-demand instances.
+	Spot bool
+	// MaxPrice caps the hourly spot bid in USD; 0 means the provider default.
+	MaxPrice float64
+	// Progress, if set, is called as nodes become ready with how many of
+	// total are. Providers that create nodes one by one should call it.
+	Progress func(ready, total int)
 }
 
+// ErrSpotNotSupported is returned by CreateFleet when Spot is requested from a
+// provider that has no spot or preemptible instances.
+var ErrSpotNotSupported = errors.New("spot instances not supported")
+
+// Provider creates and tears down fleets on one backend. Implementations
+// must be safe for concurrent use and honour ctx cancellation.
+//
+//   - Name returns the name the provider is selected by.
+//   - CreateFleet starts req.Count nodes labelled "<name>-<n>" from
+//     req.StartIndex, tagged so ListNodes can find them again. Nodes must be
+//     reachable over SSH as req.SSHUser with req.SSHKey and run req.CloudInit
+//     on first boot. If some nodes fail, it removes the others unless
+//     req.KeepPartial is set and returns a *PartialFleetError; it returns
+//     ErrSpotNotSupported when req.Spot is set but unavailable.
+//   - ListNodes returns the running nodes of fleet name, or every node the
+//     provider manages when name is empty, each with a public IP.
+//   - DeleteFleet removes every node of fleet name, or all of them when name
+//     is empty. Deleting nodes that are already gone is not an error; partial
+//     failures are reported as a *DeleteFleetError (see DeleteNodes).
+//
+// Providers may also implement NodeGetter to look up single nodes without
+// listing their fleet, and NodeDeleter to delete them.
 type Provider interface {
 	Name() string
 	CreateFleet(ctx context.Context, req CreateFleetRequest) (*Fleet, error)
 	ListNodes(ctx context.Context, name string) ([]Node, error)
 	DeleteFleet(ctx context.Context, name string) error
 }
+
+// PartialFleetError is returned by CreateFleet when some nodes were created
+// before a failure. Survivors lists the nodes that are still running, either
+// because KeepPartial was set or because rolling them back failed.
+type PartialFleetError struct {
+	Err       error
+	Survivors []Node
+	Removed   []Node
+}
+
+func (e *PartialFleetError) Error() string {
+	if len(e.Survivors) == 0 {
+		return fmt.Sprintf("%v (rolled back %d nodes)", e.Err, len(e.Removed))
+	}
+	names := make([]string, 0, len(e.Survivors))
+	for _, n := range e.Survivors {
+		names = append(names, fmt.Sprintf("%s (%s)", n.Name, n.ID))
+	}
+	return fmt.Sprintf("%v; %d nodes still running: %s", e.Err, len(e.Survivors), strings.Join(names, ", "))
+}
+
+func (e *PartialFleetError) Unwrap() error { return e.Err }
+
+// DeleteFleetError is returned by DeleteFleet when some instances could not
+// be deleted. Failed lists the instances that are still running and Errs the
+// last error seen for each.
+type DeleteFleetError struct {
+	Total  int
+	Failed []Node
+	Errs   []error
+}
+
+func (e *DeleteFleetError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, n := range e.Failed {
+		msgs[i] = fmt.Sprintf("%s (%s): %v", n.Name, n.ID, e.Errs[i])
+	}
+	return fmt.Sprintf("%d of %d instances failed to delete: %s", len(e.Failed), e.Total, strings.Join(msgs, "; "))
+}
+
+// DeleteNodes deletes every node with del. Failed deletes are retried with
+// backoff, except those the API refused outright (see permanentAPIError);
+// nodes that still fail are reported in a *DeleteFleetError.
+func DeleteNodes(ctx context.Context, nodes []Node, del func(context.Context, Node) error) error {
+	pending := nodes
+	var gaveUp []Node
+	var gaveUpErrs []error
+	var errs []error
+	delay := deleteRetryDelay
+	for attempt := 0; ; attempt++ {
+		var failed []Node
+		var failedErrs []error
+		for _, n := range pending {
+			err := del(ctx, n)
+			switch {
+			case err == nil:
+			case permanentAPIError(err):
+				gaveUp = append(gaveUp, n)
+				gaveUpErrs = append(gaveUpErrs, err)
+			default:
+				failed = append(failed, n)
+				failedErrs = append(failedErrs, err)
+			}
+		}
+		pending, errs = failed, failedErrs
+		if len(pending) == 0 || attempt == deleteRetries || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	pending, errs = append(gaveUp, pending...), append(gaveUpErrs, errs...)
+	if len(pending) == 0 {
+		return nil
+	}
+	return &DeleteFleetError{Total: len(nodes), Failed: pending, Errs: errs}
+}
+
+// permanentAPIError reports whether err is an API reply that retrying won't
+// change, such as a 401 or 403. Other errors, even unrecognised ones, are
+// worth retrying when deleting so instances aren't left running.
+func permanentAPIError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && !RetryableStatus(apiErr.StatusCode)
+}
+
+// RollbackFleet handles a CreateFleet failure. Unless req.KeepPartial is set,
+// each created node is deleted with del; the outcome is reported as a
+// *PartialFleetError wrapping cause. Cleanup still runs if ctx was cancelled
+// (e.g. by Ctrl-C), on a detached context bounded by rollbackTimeout.
+func RollbackFleet(ctx context.Context, req CreateFleetRequest, created []Node, cause error, del func(context.Context, Node) error) error {
+	if len(created) == 0 {
+		return cause
+	}
+	ctx, cancel := CleanupContext(ctx)
+	defer cancel()
+	perr := &PartialFleetError{Err: cause}
+	if req.KeepPartial {
+		perr.Survivors = created
+		return perr
+	}
+	for _, n := range created {
+		if err := del(ctx, n); err != nil {
+			perr.Survivors = append(perr.Survivors, n)
+			continue
+		}
+		perr.Removed = append(perr.Removed, n)
+	}
+	return perr
+}
+
+// CleanupContext returns ctx unchanged while it is live. Once ctx is done it
+// returns a detached context with rollbackTimeout, so cleanup after a
+// cancellation can still reach the provider API.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+}
+
+// AdoptInFlight handles a create call that was interrupted by cancellation:
+// the provider may have created the instance even though no response arrived.
+// If ctx is done, it looks the label up with list and appends any match to
+// created so it is rolled back with the rest.
+func AdoptInFlight(ctx context.Context, label string, created []Node, list func(context.Context, string) ([]Node, error)) []Node {
+	if ctx.Err() == nil {
+		return created
+	}
+	ctx, cancel := CleanupContext(ctx)
+	defer cancel()
+	nodes, err := list(ctx, label)
+	if err != nil {
+		return created
+	}
+	for _, n := range nodes {
+		if n.Name == label {
+			created = append(created, n)
+		}
+	}
+	return created
+}
@@ -0,0 +1,435 @@
+package providers
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDeleteNodes(t *testing.T) {
+	old := deleteRetryDelay
+	deleteRetryDelay = time.Millisecond
+	defer func() { deleteRetryDelay = old }()
+
+	nodes := []Node{{Name: "w-1", ID: "1"}, {Name: "w-2", ID: "2"}, {Name: "w-3", ID: "3"}}
+	calls := map[string]int{}
+	err := DeleteNodes(context.Background(), nodes, func(ctx context.Context, n Node) error {
+		calls[n.ID]++
+		switch {
+		case n.ID == "2" && calls[n.ID] < 3:
+			return errors.New("status 429")
+		case n.ID == "3":
+			return errors.New("status 500")
+		}
+		return nil
+	})
+
+	var derr *DeleteFleetError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected *DeleteFleetError, got %v", err)
+	}
+	if derr.Total != 3 || len(derr.Failed) != 1 || derr.Failed[0].ID != "3" {
+		t.Fatalf("failed = %+v of %d", derr.Failed, derr.Total)
+	}
+	if !strings.HasPrefix(err.Error(), "1 of 3 instances failed to delete") {
+		t.Errorf("error = %q", err)
+	}
+	if calls["1"] != 1 || calls["2"] != 3 || calls["3"] != deleteRetries+1 {
+		t.Errorf("calls = %v", calls)
+	}
+
+	if err := DeleteNodes(context.Background(), nodes, func(context.Context, Node) error { return nil }); err != nil {
+		t.Errorf("all deleted: %v", err)
+	}
+
+	calls = map[string]int{}
+	err = DeleteNodes(context.Background(), nodes[:1], func(ctx context.Context, n Node) error {
+		calls[n.ID]++
+		return &APIError{Provider: "linode", StatusCode: 401, Message: "Invalid Token"}
+	})
+	if !errors.As(err, &derr) || len(derr.Failed) != 1 || calls["1"] != 1 {
+		t.Errorf("401 retried: calls = %v, err = %v", calls, err)
+	}
+}
+
+func TestRollbackFleet(t *testing.T) {
+	cause := errors.New("create w-3: status 500")
+	created := []Node{{Name: "w-1", ID: "1"}, {Name: "w-2", ID: "2"}}
+	var deleted []string
+	del := func(ctx context.Context, n Node) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		deleted = append(deleted, n.ID)
+		if n.ID == "2" {
+			return errors.New("status 500")
+		}
+		return nil
+	}
+
+	if err := RollbackFleet(context.Background(), CreateFleetRequest{}, nil, cause, del); err != cause {
+		t.Errorf("nothing created: %v", err)
+	}
+
+	err := RollbackFleet(context.Background(), CreateFleetRequest{KeepPartial: true}, created, cause, del)
+	var perr *PartialFleetError
+	if !errors.As(err, &perr) || !errors.Is(err, cause) {
+		t.Fatalf("keep partial: %v", err)
+	}
+	if len(perr.Survivors) != 2 || len(perr.Removed) != 0 || len(deleted) != 0 {
+		t.Errorf("keep partial: survivors = %v, removed = %v, deleted = %v", perr.Survivors, perr.Removed, deleted)
+	}
+	if !strings.Contains(err.Error(), "2 nodes still running: w-1 (1), w-2 (2)") {
+		t.Errorf("keep partial: %v", err)
+	}
+
+	// A cancelled ctx (Ctrl-C) must not stop the rollback, and a node whose
+	// delete fails is still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = RollbackFleet(ctx, CreateFleetRequest{}, created, cause, del)
+	if !errors.As(err, &perr) || !errors.Is(err, cause) {
+		t.Fatalf("rollback: %v", err)
+	}
+	if strings.Join(deleted, ",") != "1,2" {
+		t.Errorf("rollback deleted %v", deleted)
+	}
+	if len(perr.Removed) != 1 || perr.Removed[0].ID != "1" || len(perr.Survivors) != 1 || perr.Survivors[0].ID != "2" {
+		t.Errorf("rollback: removed = %v, survivors = %v", perr.Removed, perr.Survivors)
+	}
+
+	deleted = nil
+	err = RollbackFleet(context.Background(), CreateFleetRequest{}, created[:1], cause, del)
+	if !errors.As(err, &perr) || len(perr.Survivors) != 0 || !strings.HasSuffix(err.Error(), "(rolled back 1 nodes)") {
+		t.Errorf("clean rollback: %v", err)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", &APIError{Provider: "vultr", StatusCode: 429}, true},
+		{"server error", fmt.Errorf("create instance: %w", &APIError{Provider: "linode", StatusCode: 503}), true},
+		{"bad region", &APIError{Provider: "linode", StatusCode: 400, Message: "region: Region is not valid"}, false},
+		{"bad token", &APIError{Provider: "vultr", StatusCode: 401}, false},
+		{"validation", ValidationError{Field: "count", Value: "0"}, false},
+		{"reset", &url.Error{Op: "Get", URL: "https://api.linode.com", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{"refused", &url.Error{Op: "Get", URL: "https://api.linode.com", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{"closed", &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: io.EOF}, true},
+		{"timeout", &url.Error{Op: "Get", URL: "https://api.vultr.com", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, true},
+		{"unknown host", &url.Error{Op: "Get", URL: "https://api.linode.invalid", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, false},
+		{"bad certificate", &url.Error{Op: "Get", URL: "https://api.linode.com", Err: x509.UnknownAuthorityError{}}, false},
+		{"cancelled", &url.Error{Op: "Get", URL: "https://api.linode.com", Err: context.Canceled}, false},
+		{"unknown", errors.New("something else"), false},
+	} {
+		if got := Retryable(tc.err); got != tc.want {
+			t.Errorf("%s: Retryable(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryableRequest(t *testing.T) {
+	reset := &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}
+	refused := &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	timeout := &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+	closed := &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: io.EOF}
+	for _, tc := range []struct {
+		method string
+		err    error
+		want   bool
+	}{
+		{http.MethodGet, reset, true},
+		{http.MethodDelete, closed, true},
+		{http.MethodPost, reset, false},
+		{http.MethodPost, closed, false},
+		{http.MethodPost, refused, true},
+		{http.MethodPost, timeout, true},
+		{http.MethodPost, &url.Error{Op: "Post", URL: "https://api.vultr.com", Err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}}, false},
+		{http.MethodGet, &url.Error{Op: "Get", URL: "https://api.linode.com", Err: x509.UnknownAuthorityError{}}, false},
+	} {
+		if got := RetryableRequest(tc.method, tc.err); got != tc.want {
+			t.Errorf("RetryableRequest(%s, %v) = %v, want %v", tc.method, tc.err, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		method string
+		code   int
+		want   bool
+	}{
+		{http.MethodGet, 503, true},
+		{http.MethodPost, 503, false},
+		{http.MethodPost, 429, true},
+		{http.MethodPut, 500, true},
+		{http.MethodGet, 404, false},
+	} {
+		if got := RetryableReply(tc.method, tc.code); got != tc.want {
+			t.Errorf("RetryableReply(%s, %d) = %v, want %v", tc.method, tc.code, got, tc.want)
+		}
+	}
+}
+
+// TestRateLimiterConcurrent tests that concurrent callers, as under gaxx
+// serve, are spaced out; run it with -race.
+func TestRateLimiterConcurrent(t *testing.T) {
+	rl := NewRateLimiter(200)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				rl.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+	// 24 calls at 200/s take at least 23 intervals of 5ms.
+	if elapsed := time.Since(start); elapsed < 23*5*time.Millisecond {
+		t.Errorf("24 calls took %v", elapsed)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// TestRetryableHTTPClientCreate tests that a create whose reply was lost or
+// failed is not sent twice, while reads are retried.
+func TestRetryableHTTPClientCreate(t *testing.T) {
+	for i, tc := range []struct {
+		method string
+		reply  func() (*http.Response, error)
+		sends  int
+	}{
+		{http.MethodPost, func() (*http.Response, error) { return nil, io.ErrUnexpectedEOF }, 1},
+		{http.MethodPost, func() (*http.Response, error) { return &http.Response{StatusCode: 502, Body: http.NoBody}, nil }, 1},
+		{http.MethodPost, func() (*http.Response, error) { return &http.Response{StatusCode: 429, Body: http.NoBody}, nil }, 4},
+		{http.MethodPost, func() (*http.Response, error) { return nil, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED} }, 4},
+		{http.MethodGet, func() (*http.Response, error) { return nil, io.ErrUnexpectedEOF }, 4},
+		{http.MethodGet, func() (*http.Response, error) { return &http.Response{StatusCode: 502, Body: http.NoBody}, nil }, 4},
+	} {
+		sends := 0
+		c := NewRetryableHTTPClient(time.Second, 1000)
+		c.retryConfig.InitialDelay = time.Millisecond
+		c.client.Transport = roundTripFunc(func(*http.Request) (*http.Response, error) {
+			sends++
+			return tc.reply()
+		})
+		req, _ := http.NewRequest(tc.method, "https://api.vultr.com/v2/instances", strings.NewReader(`{"label":"web-1"}`))
+		if resp, err := c.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		if sends != tc.sends {
+			t.Errorf("case %d, %s: sent %d times, want %d", i, tc.method, sends, tc.sends)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var out struct {
+		Data []struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	if err := DecodeJSON(strings.NewReader(`{"data":[{"id":7}]}`), &out); err != nil || len(out.Data) != 1 || out.Data[0].ID != 7 {
+		t.Fatalf("decode = %+v, %v", out, err)
+	}
+
+	portal := "<html>\n  <head><title>Hotel WiFi</title></head>\n  <body>Please log in" + strings.Repeat(" to continue", 50) + "</body></html>"
+	err := DecodeJSON(strings.NewReader(portal), &out)
+	if err == nil {
+		t.Fatal("expected an error for an HTML body")
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "decode response: ") || !strings.Contains(msg, "body: <html> <head><title>Hotel WiFi</title>") {
+		t.Errorf("error does not quote the body: %q", msg)
+	}
+	if !strings.HasSuffix(msg, "...") || len(msg) > 400 {
+		t.Errorf("body snippet not truncated (%d bytes): %q", len(msg), msg)
+	}
+
+	if got := BodySnippet(nil); got != "(empty)" {
+		t.Errorf("BodySnippet(nil) = %q", got)
+	}
+}
+
+func TestExpiredNodes(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	nodes := []Node{
+		{Name: "old-1", Tags: []string{"gaxx", ExpiryTag(now.Add(-time.Minute))}},
+		{Name: "due-1", Tags: []string{ExpiryTag(now.In(time.FixedZone("x", 3600)))}},
+		{Name: "new-1", Tags: []string{ExpiryTag(now.Add(time.Hour))}},
+		{Name: "keep-1", Tags: []string{"gaxx"}},
+		{Name: "bad-1", Tags: []string{ExpiresTag + ":tomorrow"}},
+	}
+	if tag := ExpiryTag(now); tag != "gaxx-expires:2025-06-01T12:00:00Z" {
+		t.Errorf("tag = %q", tag)
+	}
+	var names []string
+	for _, n := range ExpiredNodes(nodes, now) {
+		names = append(names, n.Name)
+	}
+	if got := strings.Join(names, ","); got != "old-1,due-1" {
+		t.Errorf("expired = %s", got)
+	}
+	if got := MergeTags([]string{"gaxx"}, []string{"team:x", "gaxx", ""}, []string{"team:x", "ttl"}); strings.Join(got, ",") != "gaxx,team:x,ttl" {
+		t.Errorf("merged = %v", got)
+	}
+}
+
+func TestEmptyFleetError(t *testing.T) {
+	nodes := []Node{{Name: "web-2"}, {Name: "scan-1"}, {Name: "web-1"}, {Name: "db"}, {Name: "x-0"}, {Name: "my-api-3"}}
+	if got := strings.Join(FleetNames(nodes), ","); got != "my-api,scan,web" {
+		t.Errorf("fleet names = %s", got)
+	}
+	for _, tc := range []struct {
+		err  EmptyFleetError
+		want string
+	}{
+		{EmptyFleetError{Fleet: "webb", Provider: "linode", Total: -1}, "no nodes found for fleet webb"},
+		{EmptyFleetError{Fleet: "webb", Provider: "linode"}, "provider linode lists no instances at all; check --provider and its API token"},
+		{EmptyFleetError{Fleet: "webb", Provider: "linode", Total: 2}, "has 2 instances but none in a gaxx fleet"},
+		{EmptyFleetError{Fleet: "webb", Provider: "linode", Total: 3, Fleets: []string{"scan", "web"}}, "provider linode has fleets scan, web; check the name"},
+	} {
+		err := error(&tc.err)
+		if !strings.HasPrefix(err.Error(), "no nodes found for fleet webb") || !strings.Contains(err.Error(), tc.want) || !errors.Is(err, ErrEmptyFleet) {
+			t.Errorf("%+v: %v", tc.err, err)
+		}
+	}
+}
+
+func TestCloudInitAgentSHA256(t *testing.T) {
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if got := CloudInitUserData("gx", "ssh-ed25519 AAAA", DefaultAgentDownloadURL, ""); strings.Contains(got, "sha256sum") {
+		t.Error("digest checked without one configured")
+	}
+	got := CloudInitUserData("gx", "ssh-ed25519 AAAA", DefaultAgentDownloadURL, sum)
+	check := strings.Index(got, `echo "`+sum+`  gaxx-agent" | sha256sum -c -`)
+	if check < 0 || check < strings.Index(got, "curl ") || check > strings.Index(got, "install -m") {
+		t.Errorf("digest not checked between download and install:\n%s", got)
+	}
+
+	if s, err := CheckAgentSHA256(" " + strings.ToUpper(sum) + "\n"); err != nil || s != sum {
+		t.Errorf("CheckAgentSHA256 = %q, %v", s, err)
+	}
+	for _, bad := range []string{"abc", sum + "00", strings.Replace(sum, "9", "g", 1)} {
+		if _, err := CheckAgentSHA256(bad); err == nil {
+			t.Errorf("CheckAgentSHA256(%q) accepted", bad)
+		}
+	}
+}
+
+func TestAgentAddr(t *testing.T) {
+	for _, tc := range []struct {
+		node Node
+		want string
+	}{
+		{Node{IP: "10.0.0.1"}, "10.0.0.1:8088"},
+		{Node{IP: "198.51.100.7", AgentPort: 18088}, "198.51.100.7:18088"},
+		{Node{IP: "2001:db8::1", AgentPort: 9000}, "[2001:db8::1]:9000"},
+		{Node{IPv6: "2001:db8::2"}, "[2001:db8::2]:8088"},
+		{Node{IP: "10.0.0.1", IPv6: "2001:db8::3"}, "10.0.0.1:8088"},
+	} {
+		if got := tc.node.AgentAddr(); got != tc.want {
+			t.Errorf("AgentAddr(%+v) = %s, want %s", tc.node, got, tc.want)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		port int
+		want string
+	}{
+		{"203.0.113.5", 22, "203.0.113.5:22"},
+		{"2001:db8::1", 22, "[2001:db8::1]:22"},
+		{"[2001:db8::1]", 8088, "[2001:db8::1]:8088"},
+		{"fe80::1%eth0", 2222, "[fe80::1%eth0]:2222"},
+		{"node.example.com", 22, "node.example.com:22"},
+	} {
+		if got := HostPort(tc.ip, tc.port); got != tc.want {
+			t.Errorf("HostPort(%q, %d) = %s, want %s", tc.ip, tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestDialIP(t *testing.T) {
+	dual := Node{IP: "198.51.100.7", IPv6: "2001:db8::7"}
+	v4 := Node{IP: "198.51.100.8", PreferIPv6: true}
+	if got := dual.DialIP(); got != dual.IP {
+		t.Errorf("dual-stack node dials %s, want IPv4", got)
+	}
+	dual.PreferIPv6 = true
+	if got := dual.DialIP(); got != dual.IPv6 {
+		t.Errorf("with IPv6 preferred dual-stack node dials %s", got)
+	}
+	if got := v4.DialIP(); got != v4.IP {
+		t.Errorf("IPv4-only node dials %q", got)
+	}
+}
+
+func TestAddressed(t *testing.T) {
+	v4, v6 := Node{IP: "198.51.100.8"}, Node{IPv6: "2001:db8::8"}
+	if !v4.Addressed() || v6.Addressed() {
+		t.Errorf("IPv4 preferred: Addressed = %v, %v", v4.Addressed(), v6.Addressed())
+	}
+	v4.PreferIPv6, v6.PreferIPv6 = true, true
+	if v4.Addressed() || !v6.Addressed() {
+		t.Errorf("IPv6 preferred: Addressed = %v, %v", v4.Addressed(), v6.Addressed())
+	}
+}
+
+// listOnly implements Provider without NodeGetter.
+type listOnly struct{ nodes []Node }
+
+func (p listOnly) Name() string { return "list" }
+func (p listOnly) CreateFleet(context.Context, CreateFleetRequest) (*Fleet, error) {
+	return nil, ErrSpotNotSupported
+}
+func (p listOnly) ListNodes(context.Context, string) ([]Node, error) { return p.nodes, nil }
+func (p listOnly) DeleteFleet(context.Context, string) error         { return nil }
+
+// getter looks nodes up itself and fails any listing.
+type getter struct{ listOnly }
+
+func (p getter) ListNodes(context.Context, string) ([]Node, error) {
+	return nil, errors.New("listed")
+}
+func (p getter) GetNode(ctx context.Context, fleet, name string) (Node, error) {
+	return FindNode(p.nodes, fleet, name)
+}
+
+func TestGetNode(t *testing.T) {
+	nodes := []Node{{Name: "w-1", IP: "10.0.0.1"}, {Name: "w-2", IP: "10.0.0.2"}}
+	for _, p := range []Provider{listOnly{nodes}, getter{listOnly{nodes}}} {
+		n, err := GetNode(context.Background(), p, "w", "w-2")
+		if err != nil || n.IP != "10.0.0.2" {
+			t.Errorf("%T: GetNode = %+v, %v", p, n, err)
+		}
+		_, err = GetNode(context.Background(), p, "w", "w-3")
+		var nf *NodeNotFoundError
+		if !errors.As(err, &nf) || nf.Fleet != "w" || nf.Name != "w-3" || !errors.Is(err, ErrNodeNotFound) {
+			t.Errorf("%T: missing node error = %v", p, err)
+		}
+	}
+	if err := (&NodeNotFoundError{Fleet: "w", Name: "w-3"}).Error(); err != "node w-3 not found in fleet w" {
+		t.Errorf("Error() = %q", err)
+	}
+}
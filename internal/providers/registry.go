@@ -2,6 +2,8 @@ package providers
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 )
 
 type Registry struct {
@@ -16,6 +18,11 @@ func (r *Registry) Register(p Provider) {
 	r.providers[p.Name()] = p
 }
 
+// RegisterAs registers p under name, which need not match p.Name().
+func (r *Registry) RegisterAs(name string, p Provider) {
+	r.providers[name] = p
+}
+
 func (r *Registry) Get(name string) (Provider, error) {
 	p, ok := r.providers[name]
 	if !ok {
@@ -23,3 +30,50 @@ func (r *Registry) Get(name string) (Provider, error) {
 	}
 	return p, nil
 }
+
+// Factory builds a provider from the controller config.
+type Factory func(Config) Provider
+
+var (
+	factoriesMu sync.Mutex
+	factories   = map[string]Factory{}
+)
+
+// RegisterProvider makes an out-of-tree provider available under name to
+// every client created afterwards. It is meant to be called from an init
+// function, including one in a plugin loaded with plugin.Open. It panics if
+// name is empty, factory is nil, or name is already registered.
+func RegisterProvider(name string, factory func(Config) Provider) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if name == "" || factory == nil {
+		panic("providers: RegisterProvider needs a name and a factory")
+	}
+	if _, dup := factories[name]; dup {
+		panic("providers: RegisterProvider called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// RegisteredProviders returns the names of providers added with
+// RegisterProvider, sorted.
+func RegisteredProviders() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterFactories adds a provider built against cfg to r for every
+// factory added with RegisterProvider.
+func (r *Registry) RegisterFactories(cfg Config) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	for name, factory := range factories {
+		r.RegisterAs(name, factory(cfg))
+	}
+}
@@ -1,11 +1,21 @@
 package providers
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 )
@@ -30,8 +40,91 @@ func DefaultRetryConfig() RetryConfig {
 	}
 }
 
-// RateLimiter provides rate limiting for API calls
+// APIError is a provider API's reply with an error status.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s api status %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// RetryableStatus reports whether a reply with this status may succeed if
+// sent again: rate limits and server errors.
+func RetryableStatus(code int) bool {
+	return slices.Contains(DefaultRetryConfig().RetryableErrors, code)
+}
+
+// Retryable reports whether the call that returned err may succeed if made
+// again. Rate limits, server errors, timeouts and dropped or refused
+// connections are transient. Validation and auth failures, other API
+// errors, unknown hosts, bad certificates and cancellation are not, nor is
+// anything unrecognised.
+func Retryable(err error) bool {
+	var valErr ValidationError
+	if err == nil || errors.As(err, &valErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return RetryableStatus(apiErr.StatusCode)
+	}
+	var unknownCA x509.UnknownAuthorityError
+	var badHost x509.HostnameError
+	var badCert x509.CertificateInvalidError
+	if errors.As(err, &unknownCA) || errors.As(err, &badHost) || errors.As(err, &badCert) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	// *url.Error is a net.Error itself, so only its timeouts say anything.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryableRequest reports whether a method request that failed with err
+// may be sent again. A request that isn't idempotent, such as the POST
+// creating an instance, may have been carried out although its reply was
+// lost, so it is only sent again when err shows it never reached the
+// server: the connection or the host lookup failed.
+func RetryableRequest(method string, err error) bool {
+	if !Retryable(err) {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return idempotent(method) || (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr)
+}
+
+// RetryableReply reports whether a method request that got a reply with
+// this status may succeed if sent again. Of the retryable statuses, only a
+// rate limit says a request that isn't idempotent was not carried out.
+func RetryableReply(method string, code int) bool {
+	return RetryableStatus(code) && (idempotent(method) || code == http.StatusTooManyRequests)
+}
+
+// idempotent reports whether sending a method request twice has the same
+// effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// RateLimiter provides rate limiting for API calls. It is safe for
+// concurrent use: callers are spaced out in turn.
 type RateLimiter struct {
+	mu       sync.Mutex
 	lastCall time.Time
 	interval time.Duration
 }
@@ -46,6 +139,8 @@ func NewRateLimiter(requestsPerSecond float64) *RateLimiter {
 
 // Wait blocks until it's safe to make the next API call
 func (rl *RateLimiter) Wait() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 	if rl.lastCall.IsZero() {
 		rl.lastCall = time.Now()
 		return
@@ -86,11 +181,18 @@ func (c *RetryableHTTPClient) Do(req *http.Request) (*http.Response, error) {
 
 		// Clone request for retry (body might be consumed)
 		reqClone := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %w", err)
+			}
+			reqClone.Body = body
+		}
 
 		resp, err := c.client.Do(reqClone)
 		if err != nil {
 			lastErr = err
-			if attempt < c.retryConfig.MaxRetries {
+			if attempt < c.retryConfig.MaxRetries && req.Context().Err() == nil && RetryableRequest(req.Method, err) {
 				delay := c.calculateDelay(attempt)
 				log.Warn().
 					Err(err).
@@ -106,7 +208,7 @@ func (c *RetryableHTTPClient) Do(req *http.Request) (*http.Response, error) {
 		}
 
 		// Check if status code is retryable
-		if c.shouldRetry(resp.StatusCode) && attempt < c.retryConfig.MaxRetries {
+		if c.shouldRetry(req.Method, resp.StatusCode) && attempt < c.retryConfig.MaxRetries {
 			resp.Body.Close()
 			delay := c.calculateDelay(attempt)
 			log.Warn().
@@ -126,8 +228,12 @@ func (c *RetryableHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return nil, lastErr
 }
 
-// shouldRetry determines if a status code should trigger a retry
-func (c *RetryableHTTPClient) shouldRetry(statusCode int) bool {
+// shouldRetry determines if a status code should trigger a retry; for
+// requests that aren't idempotent only a rate limit does (see RetryableReply).
+func (c *RetryableHTTPClient) shouldRetry(method string, statusCode int) bool {
+	if !idempotent(method) && statusCode != http.StatusTooManyRequests {
+		return false
+	}
 	for _, code := range c.retryConfig.RetryableErrors {
 		if statusCode == code {
 			return true
@@ -290,3 +396,38 @@ func (v *CloudProviderValidator) validateSize(provider, size string) error {
 		Message: fmt.Sprintf("invalid size for %s. Valid sizes: %v", provider, validSizes),
 	}
 }
+
+// maxBodySnippet bounds how much of an undecodable response body is quoted
+// in the error.
+const maxBodySnippet = 256
+
+// DecodeJSON decodes a successful API response into out. When the body
+// isn't the expected JSON (an API change, or an HTML page from a proxy or
+// captive portal), the error quotes the start of the body.
+func DecodeJSON(r io.Reader, out interface{}) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w; body: %s", err, BodySnippet(body))
+	}
+	return nil
+}
+
+// BodySnippet returns body with whitespace collapsed, cut to a few hundred
+// bytes for use in error messages.
+func BodySnippet(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if s == "" {
+		return "(empty)"
+	}
+	if len(s) > maxBodySnippet {
+		cut := maxBodySnippet
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut] + "..."
+	}
+	return s
+}
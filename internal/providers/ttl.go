@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ExpiresTag is the tag key spawn --ttl stamps on instances; its value is
+// the expiry as an RFC 3339 UTC time.
+const ExpiresTag = "gaxx-expires"
+
+// NodeDeleter is implemented by providers that can delete single nodes,
+// which gaxx reap needs to remove expired nodes from a fleet.
+type NodeDeleter interface {
+	DeleteNode(ctx context.Context, node Node) error
+}
+
+// ExpiryTag returns the tag marking a node as expiring at t.
+func ExpiryTag(t time.Time) string {
+	return ExpiresTag + ":" + t.UTC().Format(time.RFC3339)
+}
+
+// NodeExpiry returns when n expires, if it carries an expiry tag.
+func NodeExpiry(n Node) (time.Time, bool) {
+	for _, tag := range n.Tags {
+		value, ok := strings.CutPrefix(tag, ExpiresTag+":")
+		if !ok {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ExpiredNodes returns the nodes whose expiry is at or before now. Nodes
+// without an expiry tag never expire.
+func ExpiredNodes(nodes []Node, now time.Time) []Node {
+	var expired []Node
+	for _, n := range nodes {
+		if t, ok := NodeExpiry(n); ok && !t.After(now) {
+			expired = append(expired, n)
+		}
+	}
+	return expired
+}
+
+// MergeTags concatenates tag lists, dropping empty and repeated tags.
+func MergeTags(lists ...[]string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, tag := range list {
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
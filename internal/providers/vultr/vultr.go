@@ -7,26 +7,75 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	prov "github.com/3cpo-dev/gaxx/internal/providers"
 	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/rs/zerolog/log"
 )
 
-type Provider struct{ cfg prov.Config }
+type Provider struct {
+	cfg    prov.Config
+	client *prov.RetryableHTTPClient
+}
 
-func New(cfg prov.Config) *Provider { return &Provider{cfg: cfg} }
+func New(cfg prov.Config) *Provider {
+	return &Provider{
+		cfg:    cfg,
+		client: prov.NewRetryableHTTPClient(30*time.Second, prov.RequestsPerSecond(cfg.Providers.Vultr.RequestsPerSecond, prov.DefaultVultrRequestsPerSecond)),
+	}
+}
 
 func (p *Provider) Name() string { return "vultr" }
 
-const vultrAPI = "https://api.vultr.com/v2"
+// vultrAPI and pollInterval are variables so tests can use a fake API.
+var (
+	vultrAPI     = "https://api.vultr.com/v2"
+	pollInterval = 5 * time.Second
+)
 
 type vultrInstance struct {
 	ID     string `json:"id"`
 	Label  string `json:"label"`
 	MainIP string `json:"main_ip"`
+	V6IP   string `json:"v6_main_ip"`
 	Status string `json:"status"`
+	// PowerStatus is running or stopped; ServerStatus goes through none,
+	// locked and installingbooting to ok once the OS has booted.
+	PowerStatus  string   `json:"power_status"`
+	ServerStatus string   `json:"server_status"`
+	Tags         []string `json:"tags"`
+}
+
+// pending reports what a new instance is still waiting for, or "" once it
+// is active, powered on, booted and has an address. An error means it
+// will not get there by waiting.
+func (inst vultrInstance) pending(n prov.Node) (string, error) {
+	switch inst.Status {
+	case "active":
+	case "pending", "resizing", "":
+		return "status " + firstNonEmpty(inst.Status, "unknown"), nil
+	default:
+		// suspended and closed instances don't come back on their own.
+		return "", fmt.Errorf("instance is %s", inst.Status)
+	}
+	// Vultr reports active while the OS is still installing, so also
+	// wait for it to power on and boot.
+	if inst.PowerStatus != "" && inst.PowerStatus != "running" {
+		return "power " + inst.PowerStatus, nil
+	}
+	if inst.ServerStatus != "" && inst.ServerStatus != "ok" {
+		return "server " + inst.ServerStatus, nil
+	}
+	if !n.Addressed() {
+		if n.PreferIPv6 {
+			return "an IPv6 address", nil
+		}
+		return "an IPv4 address", nil
+	}
+	return "", nil
 }
 
 type vultrListResp struct {
@@ -34,11 +83,15 @@ type vultrListResp struct {
 }
 
 type vultrCreateReq struct {
-	Region   string `json:"region"`
-	Plan     string `json:"plan"`
-	OSID     string `json:"os_id"`
-	Label    string `json:"label"`
-	UserData string `json:"user_data"`
+	Region   string   `json:"region"`
+	Plan     string   `json:"plan"`
+	OSID     string   `json:"os_id"`
+	Label    string   `json:"label"`
+	UserData string   `json:"user_data"`
+	Tags     []string `json:"tags,omitempty"`
+	// EnableIPv6 gives the instance an IPv6 address too; Vultr doesn't by
+	// default.
+	EnableIPv6 bool `json:"enable_ipv6"`
 }
 
 type vultrCreateResp struct {
@@ -54,6 +107,10 @@ func (p *Provider) token() (string, error) {
 }
 
 func (p *Provider) CreateFleet(ctx context.Context, req prov.CreateFleetRequest) (*prov.Fleet, error) {
+	// Vultr has no spot/preemptible instances.
+	if req.Spot {
+		return nil, fmt.Errorf("vultr: %w", prov.ErrSpotNotSupported)
+	}
 	tok, err := p.token()
 	if err != nil {
 		return nil, err
@@ -62,36 +119,84 @@ func (p *Provider) CreateFleet(ctx context.Context, req prov.CreateFleetRequest)
 	plan := firstNonEmpty(req.Size, p.cfg.Providers.Vultr.Plan)
 	osid := firstNonEmpty(req.Image, p.cfg.Providers.Vultr.OSID)
 	user := firstNonEmpty(req.SSHUser, p.cfg.Defaults.User)
-	sshKeyPath := p.cfg.SSH.KeyDir + "/id_ed25519"
-	signer, err := gssh.LoadPrivateKeySigner(sshKeyPath)
+	signer, err := gssh.LoadPrivateKeySigner(p.cfg.SSHKeyPath())
 	if err != nil {
 		return nil, fmt.Errorf("load ssh key: %w", err)
 	}
 	pubAuth := string(gssh.MarshalAuthorized(signer))
-	userData := prov.CloudInitUserData(user, pubAuth, "https://example.com/gaxx-agent")
+	agentSum, err := prov.CheckAgentSHA256(firstNonEmpty(req.AgentSHA256, p.cfg.Agent.SHA256))
+	if err != nil {
+		return nil, err
+	}
+	userData := prov.CloudInitUserData(user, pubAuth, firstNonEmpty(p.cfg.Agent.DownloadURL, prov.DefaultAgentDownloadURL), agentSum)
 	encodedUserData := base64.StdEncoding.EncodeToString([]byte(userData))
+	tags := prov.MergeTags([]string{"gaxx"}, p.cfg.Providers.Vultr.Tags, req.Tags)
 
-	fleet := &prov.Fleet{Name: req.Name}
+	var created []prov.Node
+	start := max(1, req.StartIndex)
 	for i := 0; i < max(1, req.Count); i++ {
-		label := fmt.Sprintf("%s-%d", req.Name, i+1)
-		payload := vultrCreateReq{Region: region, Plan: plan, OSID: osid, Label: label, UserData: encodedUserData}
-		var created vultrCreateResp
-		if err := p.doJSON(ctx, tok, http.MethodPost, vultrAPI+"/instances", payload, &created); err != nil {
-			return nil, fmt.Errorf("create instance: %w", err)
+		label := fmt.Sprintf("%s-%d", req.Name, start+i)
+		payload := vultrCreateReq{Region: region, Plan: plan, OSID: osid, Label: label, UserData: encodedUserData, Tags: tags, EnableIPv6: true}
+		var resp vultrCreateResp
+		if err := p.doJSON(ctx, tok, http.MethodPost, vultrAPI+"/instances", payload, &resp); err != nil {
+			created = prov.AdoptInFlight(ctx, label, created, p.ListNodes)
+			return nil, p.rollback(ctx, tok, req, created, fmt.Errorf("create instance %s: %w", label, err))
+		}
+		created = append(created, prov.Node{ID: resp.Instance.ID, Name: label, SSHUser: user, SSHPort: p.cfg.Defaults.SSHPort, AgentPort: p.cfg.Defaults.AgentPort})
+		node, err := p.waitActive(ctx, tok, resp.Instance.ID, user)
+		if err != nil {
+			return nil, p.rollback(ctx, tok, req, created, fmt.Errorf("wait for instance %s: %w", label, err))
 		}
-		deadline := time.Now().Add(10 * time.Minute)
-		for time.Now().Before(deadline) {
-			var cur vultrInstance
-			if err := p.doJSON(ctx, tok, http.MethodGet, vultrAPI+"/instances/"+created.Instance.ID, nil, &cur); err == nil {
-				if cur.Status == "active" && cur.MainIP != "" {
-					fleet.Nodes = append(fleet.Nodes, prov.Node{ID: cur.ID, Name: cur.Label, IP: cur.MainIP, SSHUser: user, SSHPort: p.cfg.Defaults.SSHPort})
-					break
-				}
+		created[len(created)-1] = node
+		if req.Progress != nil {
+			req.Progress(i+1, max(1, req.Count))
+		}
+	}
+	return &prov.Fleet{Name: req.Name, Nodes: created}, nil
+}
+
+// waitActive polls an instance until it is active, running and booted with
+// an IP, logging each state it passes through. Transient API errors are
+// polled through; others end the wait.
+func (p *Provider) waitActive(ctx context.Context, tok, id, user string) (prov.Node, error) {
+	deadline := time.Now().Add(10 * time.Minute)
+	last := ""
+	for time.Now().Before(deadline) {
+		// A single instance comes wrapped like a created one.
+		var cur vultrCreateResp
+		if err := p.doJSON(ctx, tok, http.MethodGet, vultrAPI+"/instances/"+id, nil, &cur); err != nil {
+			if !prov.Retryable(err) {
+				return prov.Node{}, err
 			}
-			time.Sleep(5 * time.Second)
+		} else {
+			n := p.node(cur.Instance)
+			waiting, err := cur.Instance.pending(n)
+			if err != nil {
+				return prov.Node{}, err
+			}
+			if waiting == "" {
+				n.SSHUser = user
+				return n, nil
+			}
+			if waiting != last {
+				log.Debug().Str("instance", id).Str("waiting_for", waiting).Msg("Vultr instance not ready yet")
+				last = waiting
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return prov.Node{}, ctx.Err()
+		case <-time.After(pollInterval):
 		}
 	}
-	return fleet, nil
+	return prov.Node{}, fmt.Errorf("timed out waiting for instance %s to become active (last waiting for %s)", id, firstNonEmpty(last, "the API"))
+}
+
+// rollback tears down created instances after a failed CreateFleet.
+func (p *Provider) rollback(ctx context.Context, tok string, req prov.CreateFleetRequest, created []prov.Node, cause error) error {
+	return prov.RollbackFleet(ctx, req, created, cause, func(ctx context.Context, n prov.Node) error {
+		return p.doJSON(ctx, tok, http.MethodDelete, vultrAPI+"/instances/"+n.ID, nil, nil)
+	})
 }
 
 func (p *Provider) ListNodes(ctx context.Context, name string) ([]prov.Node, error) {
@@ -105,14 +210,44 @@ func (p *Provider) ListNodes(ctx context.Context, name string) ([]prov.Node, err
 	}
 	var nodes []prov.Node
 	for _, inst := range list.Instances {
-		if name != "" && !strings.HasPrefix(inst.Label, name) {
+		if name != "" && prov.FleetIndex(inst.Label, name) == 0 {
 			continue
 		}
-		nodes = append(nodes, prov.Node{ID: inst.ID, Name: inst.Label, IP: inst.MainIP, SSHUser: p.cfg.Defaults.User, SSHPort: p.cfg.Defaults.SSHPort})
+		nodes = append(nodes, p.node(inst))
 	}
 	return nodes, nil
 }
 
+// GetNode finds one instance by label, letting the API filter on it.
+func (p *Provider) GetNode(ctx context.Context, fleet, name string) (prov.Node, error) {
+	tok, err := p.token()
+	if err != nil {
+		return prov.Node{}, err
+	}
+	var list vultrListResp
+	if err := p.doJSON(ctx, tok, http.MethodGet, vultrAPI+"/instances?label="+url.QueryEscape(name), nil, &list); err != nil {
+		return prov.Node{}, err
+	}
+	for _, inst := range list.Instances {
+		if inst.Label == name && (fleet == "" || prov.FleetIndex(inst.Label, fleet) > 0) {
+			return p.node(inst), nil
+		}
+	}
+	return prov.Node{}, &prov.NodeNotFoundError{Fleet: fleet, Name: name}
+}
+
+func (p *Provider) node(inst vultrInstance) prov.Node {
+	// Addresses not assigned yet, or disabled, read as unspecified.
+	ip, ipv6 := inst.MainIP, inst.V6IP
+	if ip == "0.0.0.0" {
+		ip = ""
+	}
+	if ipv6 == "::" {
+		ipv6 = ""
+	}
+	return prov.Node{ID: inst.ID, Name: inst.Label, IP: ip, IPv6: ipv6, SSHUser: p.cfg.Defaults.User, SSHPort: p.cfg.Defaults.SSHPort, AgentPort: p.cfg.Defaults.AgentPort, Tags: inst.Tags, PreferIPv6: p.cfg.Defaults.PreferIPv6}
+}
+
 func (p *Provider) DeleteFleet(ctx context.Context, name string) error {
 	tok, err := p.token()
 	if err != nil {
@@ -122,12 +257,57 @@ func (p *Provider) DeleteFleet(ctx context.Context, name string) error {
 	if err := p.doJSON(ctx, tok, http.MethodGet, vultrAPI+"/instances", nil, &list); err != nil {
 		return err
 	}
+	var nodes []prov.Node
 	for _, inst := range list.Instances {
-		if name == "" || strings.HasPrefix(inst.Label, name) {
-			_ = p.doJSON(ctx, tok, http.MethodDelete, vultrAPI+"/instances/"+inst.ID, nil, nil)
+		if name == "" || prov.FleetIndex(inst.Label, name) > 0 {
+			nodes = append(nodes, prov.Node{ID: inst.ID, Name: inst.Label})
 		}
 	}
-	return nil
+	return prov.DeleteNodes(ctx, nodes, func(ctx context.Context, n prov.Node) error {
+		return p.doJSON(ctx, tok, http.MethodDelete, vultrAPI+"/instances/"+n.ID, nil, nil)
+	})
+}
+
+// DeleteNode deletes one instance by ID.
+func (p *Provider) DeleteNode(ctx context.Context, n prov.Node) error {
+	tok, err := p.token()
+	if err != nil {
+		return err
+	}
+	return p.doJSON(ctx, tok, http.MethodDelete, vultrAPI+"/instances/"+n.ID, nil, nil)
+}
+
+type vultrPlansResp struct {
+	Plans []struct {
+		ID          string  `json:"id"`
+		MonthlyCost float64 `json:"monthly_cost"`
+		HourlyCost  float64 `json:"hourly_cost"`
+	} `json:"plans"`
+}
+
+// vultrBillingHours is the hours after which Vultr bills the monthly price.
+const vultrBillingHours = 672
+
+// Prices fetches the hourly price of every Vultr plan, derived from the
+// monthly cost for plans that don't list one.
+func (p *Provider) Prices(ctx context.Context) (map[string]float64, error) {
+	tok, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+	var plans vultrPlansResp
+	if err := p.doJSON(ctx, tok, http.MethodGet, vultrAPI+"/plans?per_page=500", nil, &plans); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]float64, len(plans.Plans))
+	for _, pl := range plans.Plans {
+		hourly := pl.HourlyCost
+		if hourly <= 0 {
+			hourly = pl.MonthlyCost / vultrBillingHours
+		}
+		prices[pl.ID] = hourly
+	}
+	return prices, nil
 }
 
 func (p *Provider) doJSON(ctx context.Context, token, method, url string, body interface{}, out interface{}) error {
@@ -147,21 +327,23 @@ func (p *Provider) doJSON(ctx context.Context, token, method, url string, body i
 		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 && method != http.MethodDelete {
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		// Already gone; deleting is idempotent.
+		return nil
+	}
+	if resp.StatusCode >= 300 {
 		// Read the response body for more detailed error information
 		var errorBody []byte
 		errorBody, _ = io.ReadAll(resp.Body)
-		return fmt.Errorf("vultr api status %d: %s", resp.StatusCode, string(errorBody))
+		return &prov.APIError{Provider: "vultr", StatusCode: resp.StatusCode, Message: prov.BodySnippet(errorBody)}
 	}
 	if out != nil {
-		dec := json.NewDecoder(resp.Body)
-		return dec.Decode(out)
+		return prov.DecodeJSON(resp.Body, out)
 	}
 	return nil
 }
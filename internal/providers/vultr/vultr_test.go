@@ -0,0 +1,113 @@
+package vultr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	prov "github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// instanceJSON is a GET /v2/instances/{id} response as Vultr sends it,
+// with the fields that change while an instance boots left to fill in.
+const instanceJSON = `{
+  "instance": {
+    "id": "cb676a46-66fd-4dfb-b839-443f2e6c0b60",
+    "os": "Ubuntu 22.04 LTS x64",
+    "ram": 1024,
+    "disk": 25,
+    "main_ip": %q,
+    "vcpu_count": 1,
+    "region": "ewr",
+    "plan": "vc2-1c-1gb",
+    "date_created": "2024-05-01T10:21:07+00:00",
+    "status": %q,
+    "allowed_bandwidth": 1000,
+    "netmask_v4": "255.255.254.0",
+    "gateway_v4": "45.77.0.1",
+    "power_status": %q,
+    "server_status": %q,
+    "v6_network": "2001:19f0:5:1a4f::",
+    "v6_main_ip": %q,
+    "v6_network_size": 64,
+    "label": "web-1",
+    "internal_ip": "",
+    "kvm": "https://my.vultr.com/subs/vps/novnc/api.php?data=abc",
+    "hostname": "web-1",
+    "os_id": 1743,
+    "app_id": 0,
+    "image_id": "",
+    "firewall_group_id": "",
+    "features": ["ipv6"],
+    "tags": ["gaxx"],
+    "user_scheme": "root"
+  }
+}`
+
+func TestWaitActive(t *testing.T) {
+	oldAPI, oldPoll := vultrAPI, pollInterval
+	defer func() { vultrAPI, pollInterval = oldAPI, oldPoll }()
+	pollInterval = time.Millisecond
+
+	for _, tc := range []struct {
+		name    string
+		polls   [][5]string // main_ip, status, power_status, server_status, v6_main_ip
+		wantErr string
+	}{
+		{
+			name: "boots",
+			polls: [][5]string{
+				{"0.0.0.0", "pending", "stopped", "none", "::"},
+				{"45.77.0.10", "active", "running", "installingbooting", "2001:19f0:5:1a4f::10"},
+				{"45.77.0.10", "active", "running", "ok", "2001:19f0:5:1a4f::10"},
+			},
+		},
+		{
+			name: "suspended",
+			polls: [][5]string{
+				{"0.0.0.0", "pending", "stopped", "none", "::"},
+				{"45.77.0.10", "suspended", "stopped", "locked", "::"},
+			},
+			wantErr: "instance is suspended",
+		},
+	} {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/instances/cb676a46-66fd-4dfb-b839-443f2e6c0b60" || r.Header.Get("Authorization") != "Bearer tok" {
+				http.Error(w, `{"error":"not found","status":404}`, http.StatusNotFound)
+				return
+			}
+			f := tc.polls[min(calls, len(tc.polls)-1)]
+			calls++
+			fmt.Fprintf(w, instanceJSON, f[0], f[1], f[2], f[3], f[4])
+		}))
+		vultrAPI = srv.URL + "/v2"
+
+		var cfg prov.Config
+		cfg.Defaults.SSHPort = 22
+		p := New(cfg)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		n, err := p.waitActive(ctx, "tok", "cb676a46-66fd-4dfb-b839-443f2e6c0b60", "root")
+		cancel()
+		srv.Close()
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if calls != len(tc.polls) {
+			t.Errorf("%s: polled %d times, want %d", tc.name, calls, len(tc.polls))
+		}
+		if n.Name != "web-1" || n.IP != "45.77.0.10" || n.IPv6 != "2001:19f0:5:1a4f::10" || n.SSHUser != "root" || n.SSHPort != 22 {
+			t.Errorf("%s: node = %+v", tc.name, n)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package proxy serves a local SOCKS5 and HTTP proxy that spreads the
+// connections it is asked for over a set of upstreams, such as SSH
+// connections to fleet nodes, so that they leave from different addresses.
+//
+// One port speaks both protocols: a client that opens with the SOCKS5
+// version byte gets SOCKS5 (no authentication, CONNECT only), and anything
+// else is read as an HTTP proxy request, either CONNECT or a plain request
+// with an absolute URL.
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Strategy is how the server picks an upstream for each connection.
+type Strategy string
+
+const (
+	// RoundRobin takes the upstreams in turn.
+	RoundRobin Strategy = "roundrobin"
+	// Random picks one at random.
+	Random Strategy = "random"
+)
+
+// ParseStrategy parses roundrobin or random (or empty, for roundrobin).
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(s); st {
+	case "":
+		return RoundRobin, nil
+	case RoundRobin, Random:
+		return st, nil
+	}
+	return "", fmt.Errorf("strategy %q must be roundrobin or random", s)
+}
+
+// ErrUnavailable marks an upstream that could not be used at all, as
+// opposed to one that could not reach the target. The server moves on to
+// the next upstream for these.
+var ErrUnavailable = errors.New("upstream unavailable")
+
+// Upstream is an exit that connections can be sent through.
+type Upstream interface {
+	// Name identifies the upstream in logs.
+	Name() string
+	// DialContext opens a connection to addr from the upstream. Errors
+	// wrapping ErrUnavailable mean the upstream itself is down.
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// handshakeTimeout bounds how long a client has to say where it wants to go.
+const handshakeTimeout = 30 * time.Second
+
+// Server is a SOCKS5 and HTTP proxy over Upstreams.
+type Server struct {
+	Upstreams []Upstream
+	Strategy  Strategy
+	// DialTimeout bounds each attempt to reach a target; 0 means 30s.
+	DialTimeout time.Duration
+
+	next atomic.Uint64
+}
+
+// Serve accepts connections on ln until ctx ends, then closes ln and
+// returns once the connections being served have finished.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if len(s.Upstreams) == 0 {
+		return errors.New("proxy: no upstreams")
+	}
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == socksVersion {
+		err = s.serveSOCKS(ctx, conn, br)
+	} else {
+		err = s.serveHTTP(ctx, conn, br)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Debug().Err(err).Str("client", conn.RemoteAddr().String()).Msg("Proxy connection failed")
+	}
+}
+
+// pick returns the order to try the upstreams in for one connection.
+func (s *Server) pick() []Upstream {
+	n := len(s.Upstreams)
+	var start int
+	if s.Strategy == Random {
+		start = rand.Intn(n)
+	} else {
+		start = int((s.next.Add(1) - 1) % uint64(n))
+	}
+	order := make([]Upstream, 0, n)
+	for i := 0; i < n; i++ {
+		order = append(order, s.Upstreams[(start+i)%n])
+	}
+	return order
+}
+
+// dial connects to addr through the picked upstream, falling back to the
+// next ones while they are unavailable.
+func (s *Server) dial(ctx context.Context, addr string) (net.Conn, error) {
+	timeout := s.DialTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	var errs []error
+	for _, up := range s.pick() {
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err := up.DialContext(dctx, "tcp", addr)
+		cancel()
+		if err == nil {
+			log.Debug().Str("upstream", up.Name()).Str("target", addr).Msg("Proxying connection")
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", up.Name(), err))
+		if !errors.Is(err, ErrUnavailable) || ctx.Err() != nil {
+			break
+		}
+		log.Warn().Err(err).Str("upstream", up.Name()).Msg("Proxy upstream unavailable, trying the next")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SOCKS5 protocol values.
+const (
+	socksVersion   = 5
+	socksNoAuth    = 0
+	socksNoMethods = 0xff
+	socksConnect   = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded       = 0
+	socksHostUnreachable = 4
+	socksCmdUnsupported  = 7
+	socksAddrUnsupported = 8
+)
+
+// serveSOCKS handles a SOCKS5 (RFC 1928) client.
+func (s *Server) serveSOCKS(ctx context.Context, conn net.Conn, br *bufio.Reader) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return err
+	}
+	if !bytes.Contains(methods, []byte{socksNoAuth}) {
+		_, _ = conn.Write([]byte{socksVersion, socksNoMethods})
+		return errors.New("socks: client offers no unauthenticated method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return err
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil {
+		return err
+	}
+	host, err := readSOCKSHost(br, req[3])
+	if err != nil {
+		socksReply(conn, socksAddrUnsupported)
+		return err
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return err
+	}
+	if req[1] != socksConnect {
+		socksReply(conn, socksCmdUnsupported)
+		return fmt.Errorf("socks: unsupported command %d", req[1])
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	up, err := s.dial(ctx, addr)
+	if err != nil {
+		socksReply(conn, socksHostUnreachable)
+		return err
+	}
+	defer up.Close()
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	pipe(ctx, conn, br, up)
+	return nil
+}
+
+func readSOCKSHost(r io.Reader, atyp byte) (string, error) {
+	switch atyp {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		return string(name), nil
+	}
+	return "", fmt.Errorf("socks: unsupported address type %d", atyp)
+}
+
+// socksReply answers a request. The bound address is left as 0.0.0.0:0;
+// it is the node's, and clients don't use it for CONNECT.
+func socksReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// serveHTTP handles an HTTP proxy client: CONNECT tunnels to the target,
+// and other requests are sent on with Connection: close, so that each
+// connection carries one request to one host.
+func (s *Server) serveHTTP(ctx context.Context, conn net.Conn, br *bufio.Reader) error {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	if req.Method == http.MethodConnect {
+		up, err := s.dial(ctx, req.Host)
+		if err != nil {
+			httpError(conn, http.StatusBadGateway)
+			return err
+		}
+		defer up.Close()
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		pipe(ctx, conn, br, up)
+		return nil
+	}
+
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		httpError(conn, http.StatusBadRequest)
+		return fmt.Errorf("http: not a proxy request: %s %s", req.Method, req.RequestURI)
+	}
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	up, err := s.dial(ctx, addr)
+	if err != nil {
+		httpError(conn, http.StatusBadGateway)
+		return err
+	}
+	defer up.Close()
+	stop := context.AfterFunc(ctx, func() { up.Close() })
+	defer stop()
+	_ = conn.SetDeadline(time.Time{})
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Close = true
+	if err := req.Write(up); err != nil {
+		return err
+	}
+	_, err = io.Copy(conn, up)
+	return err
+}
+
+func httpError(w io.Writer, code int) {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
+}
+
+// pipe copies between the client, read through r, and the upstream until
+// both directions are done or ctx ends. Each side's write half is closed
+// when the other finishes sending, where the connection supports it.
+func pipe(ctx context.Context, client net.Conn, r io.Reader, up net.Conn) {
+	stop := context.AfterFunc(ctx, func() { up.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(up, r)
+		closeWrite(up)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(client, up)
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = c.Close()
+}
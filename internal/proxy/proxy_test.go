@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeUpstream dials directly, recording each use, or fails as down.
+type fakeUpstream struct {
+	name string
+	down bool
+	log  *[]string
+	mu   *sync.Mutex
+}
+
+func (u fakeUpstream) Name() string { return u.name }
+
+func (u fakeUpstream) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	u.mu.Lock()
+	*u.log = append(*u.log, u.name)
+	u.mu.Unlock()
+	if u.down {
+		return nil, fmt.Errorf("%w: connection lost", ErrUnavailable)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// echoServer answers each line with the line upper-cased.
+func echoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					fmt.Fprintln(conn, strings.ToUpper(sc.Text()))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startProxy(t *testing.T, s *Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+// dialSOCKS opens a SOCKS5 tunnel to host:port via proxy, giving the
+// host as a domain name.
+func dialSOCKS(t *testing.T, proxy, target string) (net.Conn, byte) {
+	host, port, _ := net.SplitHostPort(target)
+	var p int
+	fmt.Sscan(port, &p)
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := []byte{5, 1, 0, 5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(p>>8), byte(p))
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		t.Fatalf("method reply = %v", reply[:2])
+	}
+	return conn, reply[3]
+}
+
+func roundTrip(t *testing.T, conn net.Conn, line string) string {
+	fmt.Fprintln(conn, line)
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return strings.TrimSpace(got)
+}
+
+func TestSOCKSRoundRobin(t *testing.T) {
+	target := echoServer(t)
+	var used []string
+	var mu sync.Mutex
+	s := &Server{Strategy: RoundRobin}
+	for _, name := range []string{"w-1", "w-2", "w-3"} {
+		s.Upstreams = append(s.Upstreams, fakeUpstream{name: name, down: name == "w-2", log: &used, mu: &mu})
+	}
+	addr := startProxy(t, s)
+
+	for i := 0; i < 3; i++ {
+		conn, code := dialSOCKS(t, addr, target)
+		if code != socksSucceeded {
+			t.Fatalf("connect %d: reply %d", i, code)
+		}
+		if got := roundTrip(t, conn, "hello"); got != "HELLO" {
+			t.Errorf("connect %d: got %q", i, got)
+		}
+		conn.Close()
+	}
+	// w-2 is down, so its turn falls through to w-3.
+	if got := strings.Join(used, " "); got != "w-1 w-2 w-3 w-3" {
+		t.Errorf("upstreams used = %s", got)
+	}
+}
+
+func TestSOCKSUnreachable(t *testing.T) {
+	var used []string
+	var mu sync.Mutex
+	s := &Server{Upstreams: []Upstream{
+		fakeUpstream{name: "w-1", log: &used, mu: &mu},
+		fakeUpstream{name: "w-2", log: &used, mu: &mu},
+	}}
+	addr := startProxy(t, s)
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := ln.Addr().String()
+	ln.Close()
+	conn, code := dialSOCKS(t, addr, closed)
+	conn.Close()
+	if code != socksHostUnreachable {
+		t.Errorf("reply = %d, want host unreachable", code)
+	}
+	// A refused target is not the upstream's fault; others aren't tried.
+	if len(used) != 1 {
+		t.Errorf("upstreams used = %v", used)
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	target := echoServer(t)
+	var used []string
+	var mu sync.Mutex
+	addr := startProxy(t, &Server{Upstreams: []Upstream{fakeUpstream{name: "w-1", log: &used, mu: &mu}}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT = %v, %v", resp, err)
+	}
+	fmt.Fprintln(conn, "tunnel")
+	if got, _ := br.ReadString('\n'); strings.TrimSpace(got) != "TUNNEL" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHTTPForward(t *testing.T) {
+	origin := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s proxy-connection=%q", r.Method, r.URL, r.Header.Get("Proxy-Connection"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go origin.Serve(ln)
+	defer origin.Close()
+
+	var used []string
+	var mu sync.Mutex
+	addr := startProxy(t, &Server{Upstreams: []Upstream{fakeUpstream{name: "w-1", log: &used, mu: &mu}}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET http://%s/status?x=1 HTTP/1.1\r\nHost: %s\r\nProxy-Connection: keep-alive\r\n\r\n", ln.Addr(), ln.Addr())
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if got := string(body); got != `GET /status?x=1 proxy-connection=""` {
+		t.Errorf("origin saw %s", got)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for in, want := range map[string]Strategy{"": RoundRobin, "roundrobin": RoundRobin, "random": Random} {
+		if got, err := ParseStrategy(in); err != nil || got != want {
+			t.Errorf("ParseStrategy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseStrategy("sticky"); err == nil {
+		t.Error("ParseStrategy(sticky) accepted")
+	}
+	if err := (&Server{}).Serve(context.Background(), nil); err == nil {
+		t.Error("Serve with no upstreams succeeded")
+	}
+}
@@ -0,0 +1,73 @@
+// Package redact masks secrets in command lines, environments and output
+// before gaxx logs or prints them, so that tokens passed to remote commands
+// don't end up in CI logs, result files or terminal scrollback.
+//
+// A value is a secret when the name it is assigned to contains token,
+// password, passwd, secret or key, in any case: TOKEN=x, --api-key x,
+// "password": "x", and Authorization: Bearer x all lose their values.
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Mask replaces every redacted value.
+const Mask = "***"
+
+// secretName matches names of settings that hold secrets.
+var secretName = regexp.MustCompile(`(?i)token|passw(?:or)?d|secret|key`)
+
+var (
+	// assignment matches name=value and name: value, optionally quoted
+	// as in JSON or YAML.
+	assignment = regexp.MustCompile(`(?i)([\w.-]*(?:token|passw(?:or)?d|secret|key)[\w.-]*)(["']?[ \t]*[:=][ \t]*["']?)([^\s"',;&]+)`)
+	// flagValue matches a flag named like a secret and its separate value.
+	flagValue = regexp.MustCompile(`(?i)(--?[\w-]*(?:token|passw(?:or)?d|secret|key)[\w-]*)([ \t]+)([^\s-]\S*)`)
+	// bearer matches HTTP authorization credentials.
+	bearer = regexp.MustCompile(`(?i)\b(bearer|basic)([ \t]+)(\S+)`)
+)
+
+// IsSecretName reports whether a variable or flag called name holds a
+// secret.
+func IsSecretName(name string) bool {
+	return secretName.MatchString(name)
+}
+
+// String masks every secret value in free text such as a command line or
+// a command's stderr.
+func String(s string) string {
+	for _, re := range []*regexp.Regexp{assignment, flagValue, bearer} {
+		s = re.ReplaceAllString(s, "${1}${2}"+Mask)
+	}
+	return s
+}
+
+// Args returns a copy of a command's arguments with secret values masked,
+// both in name=value arguments and in the argument after a flag named like
+// a secret.
+func Args(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		switch {
+		case i > 0 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "=") && IsSecretName(args[i-1]):
+			out[i] = Mask
+		default:
+			out[i] = String(a)
+		}
+	}
+	return out
+}
+
+// Env returns a copy of KEY=VALUE pairs with the values of secret keys
+// masked.
+func Env(env []string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		if k, _, ok := strings.Cut(kv, "="); ok && IsSecretName(k) {
+			kv = k + "=" + Mask
+		}
+		out[i] = kv
+	}
+	return out
+}
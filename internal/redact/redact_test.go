@@ -0,0 +1,38 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	for in, want := range map[string]string{
+		"API_TOKEN=abc123 ./scan":                       "API_TOKEN=*** ./scan",
+		"curl --api-key s3cr3t https://x":               "curl --api-key *** https://x",
+		"mysql --password=hunter2 -u root":              "mysql --password=*** -u root",
+		`error: {"client_secret": "xyz", "id": 7}`:      `error: {"client_secret": "***", "id": 7}`,
+		"Authorization: Bearer eyJhbGciOi":              "Authorization: Bearer ***",
+		"db_passwd: letmein\nhost: db":                  "db_passwd: ***\nhost: db",
+		"connection refused to 10.0.0.1:22":             "connection refused to 10.0.0.1:22",
+		"nmap -p 22 --open 10.0.0.0/24 -oG results.txt": "nmap -p 22 --open 10.0.0.0/24 -oG results.txt",
+	} {
+		if got := String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	args := []string{"--token", "abc", "-u", "root", "--secret=x", "PASSWORD=y", "--verbose"}
+	got := strings.Join(Args(args), " ")
+	if want := "--token *** -u root --secret=*** PASSWORD=*** --verbose"; got != want {
+		t.Errorf("Args = %q, want %q", got, want)
+	}
+	if args[1] != "abc" {
+		t.Error("Args modified its input")
+	}
+	env := Env([]string{"GITHUB_TOKEN=ghp_x", "HOME=/root", "SSH_KEY_PATH=/k"})
+	if got := strings.Join(env, " "); got != "GITHUB_TOKEN=*** HOME=/root SSH_KEY_PATH=***" {
+		t.Errorf("Env = %q", got)
+	}
+}
@@ -1,10 +1,13 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	xssh "golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -47,8 +50,34 @@ func AppendKnownHost(path, host, authorizedKey string) error {
 
 // LoadKnownHostsCallback returns a strict host key callback using the given file.
 func LoadKnownHostsCallback(path string) (xssh.HostKeyCallback, error) {
-    if err := EnsureKnownHostsFile(path); err != nil {
-        return nil, err
-    }
-    return knownhosts.New(path)
+	if err := EnsureKnownHostsFile(path); err != nil {
+		return nil, err
+	}
+	return knownhosts.New(path)
+}
+
+var knownHostsMu sync.Mutex
+
+// TrustOnFirstUseCallback returns a host key callback that verifies hosts
+// against the given file and records the key of any host not seen before.
+// A host whose key has changed is still rejected.
+func TrustOnFirstUseCallback(path string) (xssh.HostKeyCallback, error) {
+	if err := EnsureKnownHostsFile(path); err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key xssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		// Reload on every call so keys recorded by concurrent dials are seen.
+		cb, err := knownhosts.New(path)
+		if err != nil {
+			return err
+		}
+		err = cb(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return AppendKnownHost(path, hostname, string(xssh.MarshalAuthorizedKey(key)))
+		}
+		return err
+	}, nil
 }
@@ -1,18 +1,36 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/sftp"
 	xssh "golang.org/x/crypto/ssh"
 )
 
+// resumeCheckBytes is how much of a partial file's tail is compared with the
+// other side before a transfer resumes from it.
+const resumeCheckBytes = 64 << 10
+
 // PushFile uploads a local file to a remote path via SFTP.
 func PushFile(ctx context.Context, client *xssh.Client, localPath, remotePath string) error {
+	return pushFile(ctx, client, localPath, remotePath, false)
+}
+
+// ResumePushFile is PushFile, but continues a partial remote file when its
+// size fits and its tail matches the local file.
+func ResumePushFile(ctx context.Context, client *xssh.Client, localPath, remotePath string) error {
+	return pushFile(ctx, client, localPath, remotePath, true)
+}
+
+func pushFile(ctx context.Context, client *xssh.Client, localPath, remotePath string, resume bool) error {
 	sf, err := sftp.NewClient(client)
 	if err != nil {
 		return fmt.Errorf("sftp client: %w", err)
@@ -27,40 +45,238 @@ func PushFile(ctx context.Context, client *xssh.Client, localPath, remotePath st
 		return fmt.Errorf("open local: %w", err)
 	}
 	defer src.Close()
-	dst, err := sf.Create(remotePath)
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local: %w", err)
+	}
+
+	var offset int64
+	if resume {
+		if existing, err := sf.Open(remotePath); err == nil {
+			if st, err := existing.Stat(); err == nil {
+				offset = resumeOffset(src, existing, st.Size(), info.Size())
+			}
+			existing.Close()
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := sf.OpenFile(remotePath, flags)
 	if err != nil {
 		return fmt.Errorf("create remote: %w", err)
 	}
 	defer dst.Close()
-	if _, err := io.Copy(dst, src); err != nil {
+	if err := seekBoth(offset, src, dst); err != nil {
+		return err
+	}
+	if err := copyContext(ctx, dst, src); err != nil {
 		return fmt.Errorf("copy: %w", err)
 	}
-	return nil
+	return dst.Close()
 }
 
 // PullFile downloads a remote file to a local path via SFTP.
 func PullFile(ctx context.Context, client *xssh.Client, remotePath, localPath string) error {
-    sf, err := sftp.NewClient(client)
-    if err != nil {
-        return fmt.Errorf("sftp client: %w", err)
-    }
-    defer sf.Close()
-    // Ensure local directory exists
-    if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
-        return fmt.Errorf("mkdir local: %w", err)
-    }
-    src, err := sf.Open(remotePath)
-    if err != nil {
-        return fmt.Errorf("open remote: %w", err)
-    }
-    defer src.Close()
-    dst, err := os.Create(localPath)
-    if err != nil {
-        return fmt.Errorf("create local: %w", err)
-    }
-    defer dst.Close()
-    if _, err := io.Copy(dst, src); err != nil {
-        return fmt.Errorf("copy: %w", err)
-    }
-    return nil
+	return pullFileVia(ctx, client, remotePath, localPath, false)
+}
+
+// ResumePullFile is PullFile, but continues a partial local file when its
+// size fits and its tail matches the remote file.
+func ResumePullFile(ctx context.Context, client *xssh.Client, remotePath, localPath string) error {
+	return pullFileVia(ctx, client, remotePath, localPath, true)
+}
+
+func pullFileVia(ctx context.Context, client *xssh.Client, remotePath, localPath string, resume bool) error {
+	sf, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("sftp client: %w", err)
+	}
+	defer sf.Close()
+	return pullOne(ctx, sf, remotePath, localPath, resume)
+}
+
+// PullDir downloads everything matching remotePattern into localDir via
+// SFTP and returns the local paths written. The pattern may name a file, a
+// directory (copied recursively) or contain glob meta characters; paths under
+// localDir are kept relative to the pattern's last non-glob directory. With
+// resume, partial local files are continued and complete ones are kept.
+func PullDir(ctx context.Context, client *xssh.Client, remotePattern, localDir string, resume bool) ([]string, error) {
+	sf, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("sftp client: %w", err)
+	}
+	defer sf.Close()
+	return pullMatches(ctx, sf, remotePattern, localDir, resume)
+}
+
+func pullMatches(ctx context.Context, sf *sftp.Client, remotePattern, localDir string, resume bool) ([]string, error) {
+	root, matches, err := expandRemote(sf, remotePattern)
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, match := range matches {
+		walker := sf.Walk(match)
+		for walker.Step() {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			if err := walker.Err(); err != nil {
+				return written, fmt.Errorf("walk remote: %w", err)
+			}
+			if !walker.Stat().Mode().IsRegular() {
+				continue
+			}
+			localPath, err := localPathFor(localDir, root, walker.Path())
+			if err != nil {
+				return written, err
+			}
+			if err := pullOne(ctx, sf, walker.Path(), localPath, resume); err != nil {
+				return written, err
+			}
+			written = append(written, localPath)
+		}
+	}
+	return written, nil
+}
+
+// localPathFor maps a remote file found under root to its path under
+// localDir. The names come from the node, so one that would land outside
+// localDir, through ".." or by not being under root at all, is refused.
+func localPathFor(localDir, root, remote string) (string, error) {
+	rel, ok := remote, true
+	if root != "." {
+		rel, ok = strings.CutPrefix(remote, strings.TrimSuffix(root, "/")+"/")
+	}
+	rel = filepath.FromSlash(rel)
+	if !ok || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("remote path %q is not under %s", remote, root)
+	}
+	return filepath.Join(localDir, rel), nil
+}
+
+// expandRemote resolves a remote pattern to its matches and the directory
+// their local paths are made relative to.
+func expandRemote(sf *sftp.Client, pattern string) (string, []string, error) {
+	pattern = path.Clean(pattern)
+	if !hasMeta(pattern) {
+		info, err := sf.Stat(pattern)
+		if err != nil {
+			return "", nil, fmt.Errorf("stat remote: %w", err)
+		}
+		if info.IsDir() {
+			return pattern, []string{pattern}, nil
+		}
+		return path.Dir(pattern), []string{pattern}, nil
+	}
+	root := pattern
+	for hasMeta(root) {
+		root = path.Dir(root)
+	}
+	matches, err := sf.Glob(pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("glob remote: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", nil, fmt.Errorf("no remote files match %s: %w", pattern, fs.ErrNotExist)
+	}
+	return root, matches, nil
+}
+
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
+
+func pullOne(ctx context.Context, sf *sftp.Client, remotePath, localPath string, resume bool) error {
+	// Ensure local directory exists
+	if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
+		return fmt.Errorf("mkdir local: %w", err)
+	}
+	src, err := sf.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("open remote: %w", err)
+	}
+	defer src.Close()
+
+	var offset int64
+	if resume {
+		if existing, err := os.Open(localPath); err == nil {
+			st, serr := existing.Stat()
+			rst, rerr := src.Stat()
+			if serr == nil && rerr == nil {
+				offset = resumeOffset(src, existing, st.Size(), rst.Size())
+			}
+			existing.Close()
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := os.OpenFile(localPath, flags, 0666)
+	if err != nil {
+		return fmt.Errorf("create local: %w", err)
+	}
+	defer dst.Close()
+	if err := seekBoth(offset, src, dst); err != nil {
+		return err
+	}
+	if err := copyContext(ctx, dst, src); err != nil {
+		return fmt.Errorf("copy %s: %w", remotePath, err)
+	}
+	return dst.Close()
+}
+
+// resumeOffset returns where a transfer from src can resume given a partial
+// copy of partialSize bytes in dst, or 0 to start over. The copy must not be
+// longer than the source and its last resumeCheckBytes must match.
+func resumeOffset(src, dst io.ReaderAt, partialSize, srcSize int64) int64 {
+	if partialSize <= 0 || partialSize > srcSize {
+		return 0
+	}
+	n := int64(resumeCheckBytes)
+	if partialSize < n {
+		n = partialSize
+	}
+	a := make([]byte, n)
+	b := make([]byte, n)
+	if _, err := src.ReadAt(a, partialSize-n); err != nil && err != io.EOF {
+		return 0
+	}
+	if _, err := dst.ReadAt(b, partialSize-n); err != nil && err != io.EOF {
+		return 0
+	}
+	if !bytes.Equal(a, b) {
+		return 0
+	}
+	return partialSize
+}
+
+func seekBoth(offset int64, files ...io.Seeker) error {
+	if offset == 0 {
+		return nil
+	}
+	for _, f := range files {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seek to resume offset: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyContext copies src to dst, closing both if ctx ends so a stalled
+// transfer aborts promptly.
+func copyContext(ctx context.Context, dst io.WriteCloser, src io.ReadCloser) error {
+	stop := context.AfterFunc(ctx, func() {
+		src.Close()
+		dst.Close()
+	})
+	defer stop()
+	_, err := io.Copy(dst, src)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
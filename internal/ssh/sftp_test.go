@@ -0,0 +1,161 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeSFTP serves the local filesystem over an in-memory SFTP connection.
+func pipeSFTP(t *testing.T) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cw.Close(); cr.Close(); client.Close() })
+	return client
+}
+
+func TestPullMatches(t *testing.T) {
+	remote := t.TempDir()
+	for name, body := range map[string]string{
+		"out/a.txt":     "a",
+		"out/b.json":    "b",
+		"out/sub/c.txt": "c",
+	} {
+		p := filepath.Join(remote, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sf := pipeSFTP(t)
+
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"out", []string{"a.txt", "b.json", "sub/c.txt"}},
+		{"out/*.txt", []string{"a.txt"}},
+		{"out/sub/c.txt", []string{"c.txt"}},
+	} {
+		local := t.TempDir()
+		got, err := pullMatches(context.Background(), sf, filepath.Join(remote, tc.pattern), local, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		var rels []string
+		for _, p := range got {
+			rel, _ := filepath.Rel(local, p)
+			rels = append(rels, filepath.ToSlash(rel))
+		}
+		sort.Strings(rels)
+		if len(rels) != len(tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.pattern, rels, tc.want)
+		}
+		for i := range rels {
+			if rels[i] != tc.want[i] {
+				t.Fatalf("%s: got %v, want %v", tc.pattern, rels, tc.want)
+			}
+		}
+	}
+
+	if _, err := pullMatches(context.Background(), sf, filepath.Join(remote, "out/*.csv"), t.TempDir(), false); err == nil {
+		t.Fatal("expected error for a pattern with no matches")
+	}
+}
+
+func TestLocalPathFor(t *testing.T) {
+	local := filepath.Join("dl", "node-1")
+	for _, tc := range []struct {
+		root, remote, want string
+	}{
+		{"/out", "/out/a.txt", filepath.Join(local, "a.txt")},
+		{"/out/", "/out/sub/c.txt", filepath.Join(local, "sub", "c.txt")},
+		{"/", "/etc/hosts", filepath.Join(local, "etc", "hosts")},
+		{".", "a.txt", filepath.Join(local, "a.txt")},
+		{"/out", "/out/../../home/user/.bashrc", ""},
+		{"/out", "/out/sub/../../x", ""},
+		{"/out", "/outer/a.txt", ""},
+		{"/out", "/etc/passwd", ""},
+		{".", "../a.txt", ""},
+		{".", "/etc/passwd", ""},
+	} {
+		got, err := localPathFor(local, tc.root, tc.remote)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("localPathFor(%q, %q) = %s, want an error", tc.root, tc.remote, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("localPathFor(%q, %q) = %s, %v; want %s", tc.root, tc.remote, got, err, tc.want)
+		}
+	}
+}
+
+func TestPullResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	remote := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(remote, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sf := pipeSFTP(t)
+	local := filepath.Join(t.TempDir(), "big.bin")
+
+	for _, tc := range []struct {
+		name    string
+		partial []byte
+	}{
+		{"matching prefix", data[:100000]},
+		{"corrupt tail", append(append([]byte{}, data[:99999]...), 'X')},
+		{"longer file", append(append([]byte{}, data...), 'Z')},
+	} {
+		name, partial := tc.name, tc.partial
+		if err := os.WriteFile(local, partial, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := pullOne(context.Background(), sf, remote, local, true); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := os.ReadFile(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: local file differs after resume (%d bytes, want %d)", name, len(got), len(data))
+		}
+	}
+
+	src := bytes.NewReader(data)
+	if got := resumeOffset(src, bytes.NewReader(data[:100000]), 100000, int64(len(data))); got != 100000 {
+		t.Fatalf("resumeOffset for a matching prefix = %d, want 100000", got)
+	}
+	if got := resumeOffset(src, bytes.NewReader(data[:100]), 100, 50); got != 0 {
+		t.Fatalf("resumeOffset for a longer copy = %d, want 0", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pullOne(ctx, sf, remote, local, false); err == nil {
+		t.Fatal("expected error from a cancelled pull")
+	}
+}
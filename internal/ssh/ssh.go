@@ -1,9 +1,11 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"time"
 
@@ -47,13 +49,92 @@ func (c *Client) makeConfig() (*xssh.ClientConfig, error) {
 	}, nil
 }
 
-// RunCommand executes a remote command with retries and basic backoff.
+// sessionRetries is how many more times NewSession asks for a session the
+// server refused, sessionBackoff apart (growing linearly), before giving up
+// on the connection. Servers refuse sessions past their MaxSessions, which
+// clears as other sessions close, so redialing would not help.
+const sessionRetries = 2
+
+var sessionBackoff = 200 * time.Millisecond
+
+// NewSession opens a session on cli, retrying on the same connection when
+// the server refuses the channel. Other errors mean the connection is gone
+// and are returned at once.
+func NewSession(ctx context.Context, cli *xssh.Client) (*xssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		session, err := cli.NewSession()
+		if err == nil {
+			return session, nil
+		}
+		var refused *xssh.OpenChannelError
+		if !errors.As(err, &refused) || attempt >= sessionRetries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sessionBackoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+// RunCommand runs a remote command and returns its stdout and stderr.
+// Connections are retried as in Do and sessions as in NewSession; a command
+// that runs and exits non-zero is not retried.
 func (c *Client) RunCommand(ctx context.Context, command string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := c.Do(ctx, func(cli *xssh.Client, attempt int) error {
+		session, err := NewSession(ctx, cli)
+		if err != nil {
+			return fmt.Errorf("new session: %w", err)
+		}
+		defer session.Close()
+		stdout.Reset()
+		stderr.Reset()
+		session.Stdout = &stdout
+		session.Stderr = &stderr
+		stop := context.AfterFunc(ctx, func() { cli.Close() })
+		defer stop()
+		if err := session.Run(command); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("run command: %w", err)
+		}
+		return nil
+	})
+	return stdout.String(), stderr.String(), err
+}
+
+// Dial establishes an SSH connection using the provided client configuration.
+// The caller is responsible for closing the returned client.
+func Dial(ctx context.Context, c *Client) (*xssh.Client, error) {
 	cfg, err := c.makeConfig()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	var lastErr error
+	type res struct {
+		cli *xssh.Client
+		err error
+	}
+	ch := make(chan res, 1)
+	go func() {
+		cli, err := xssh.Dial("tcp", c.Addr, cfg)
+		ch <- res{cli: cli, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.cli, r.err
+	}
+}
+
+// Do dials the client and calls fn with the connection, redialing and
+// retrying with backoff up to Retries times while fn fails. attempt counts
+// from 0, so fn can resume work a failed attempt left behind. Missing files,
+// permission errors and commands that exited non-zero are not retried.
+func (c *Client) Do(ctx context.Context, fn func(cli *xssh.Client, attempt int) error) error {
 	retries := c.Retries
 	if retries < 0 {
 		retries = 0
@@ -62,65 +143,28 @@ func (c *Client) RunCommand(ctx context.Context, command string) (string, string
 	if backoff <= 0 {
 		backoff = 500 * time.Millisecond
 	}
+	var lastErr error
 	for attempt := 0; attempt <= retries; attempt++ {
-		select {
-		case <-ctx.Done():
-			return "", "", ctx.Err()
-		default:
-		}
-		cli, err := xssh.Dial("tcp", c.Addr, cfg)
-		if err != nil {
-			lastErr = err
-		} else {
-			session, err := cli.NewSession()
-			if err == nil {
-				defer session.Close()
-				stdout, err := session.Output(command)
-				if err == nil {
-					return string(stdout), "", nil
-				}
-				// If Output fails, try CombinedOutput for broader error context
-				combined, cErr := session.CombinedOutput(command)
-				if cErr == nil {
-					return string(combined), "", nil
-				}
-				lastErr = fmt.Errorf("run command: %w", err)
-			} else {
-				lastErr = fmt.Errorf("new session: %w", err)
-			}
+		cli, err := Dial(ctx, c)
+		if err == nil {
+			err = fn(cli, attempt)
 			_ = cli.Close()
 		}
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		var exit *xssh.ExitError
+		if ctx.Err() != nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.As(err, &exit) {
+			return err
+		}
 		if attempt < retries {
 			select {
 			case <-ctx.Done():
-				return "", "", ctx.Err()
+				return ctx.Err()
 			case <-time.After(backoff * time.Duration(attempt+1)):
 			}
 		}
 	}
-	return "", "", lastErr
-}
-
-// Dial establishes an SSH connection using the provided client configuration.
-// The caller is responsible for closing the returned client.
-func Dial(ctx context.Context, c *Client) (*xssh.Client, error) {
-    cfg, err := c.makeConfig()
-    if err != nil {
-        return nil, err
-    }
-    type res struct {
-        cli *xssh.Client
-        err error
-    }
-    ch := make(chan res, 1)
-    go func() {
-        cli, err := xssh.Dial("tcp", c.Addr, cfg)
-        ch <- res{cli: cli, err: err}
-    }()
-    select {
-    case <-ctx.Done():
-        return nil, ctx.Err()
-    case r := <-ch:
-        return r.cli, r.err
-    }
+	return lastErr
 }
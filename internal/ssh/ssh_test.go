@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// fakeServer is an SSH server that refuses the first refuse session
+// channels on every connection and answers exec requests by echoing the
+// command. The command "fail" exits 3.
+type fakeServer struct {
+	addr    string
+	refuse  int
+	dials   atomic.Int32
+	refused atomic.Int32
+}
+
+func newFakeServer(t *testing.T, refuse int) *fakeServer {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := xssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &xssh.ServerConfig{
+		PublicKeyCallback: func(xssh.ConnMetadata, xssh.PublicKey) (*xssh.Permissions, error) { return nil, nil },
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeServer{addr: ln.Addr().String(), refuse: refuse}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.dials.Add(1)
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn, cfg *xssh.ServerConfig) {
+	_, chans, reqs, err := xssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go xssh.DiscardRequests(reqs)
+	refused := 0
+	for nc := range chans {
+		if refused < s.refuse {
+			refused++
+			s.refused.Add(1)
+			_ = nc.Reject(xssh.ResourceShortage, "too many sessions")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				_ = xssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+				_, _ = ch.Write([]byte("ran " + payload.Command + "\n"))
+				status := uint32(0)
+				if payload.Command == "fail" {
+					status = 3
+				}
+				_, _ = ch.SendRequest("exit-status", false, xssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func testClient(t *testing.T, addr string) *Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := xssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{Addr: addr, User: "gx", Signer: signer, Timeout: 5 * time.Second, Retries: 1, Backoff: time.Millisecond}
+}
+
+func TestRunCommandSessionRetry(t *testing.T) {
+	defer func(d time.Duration) { sessionBackoff = d }(sessionBackoff)
+	sessionBackoff = time.Millisecond
+	ctx := context.Background()
+
+	t.Run("refused session retried on the same connection", func(t *testing.T) {
+		srv := newFakeServer(t, 1)
+		stdout, _, err := testClient(t, srv.addr).RunCommand(ctx, "hostname")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout != "ran hostname\n" {
+			t.Errorf("stdout = %q", stdout)
+		}
+		if srv.dials.Load() != 1 || srv.refused.Load() != 1 {
+			t.Errorf("dials = %d, refused = %d; want 1, 1", srv.dials.Load(), srv.refused.Load())
+		}
+	})
+
+	t.Run("redials once session retries run out", func(t *testing.T) {
+		srv := newFakeServer(t, sessionRetries+1)
+		_, _, err := testClient(t, srv.addr).RunCommand(ctx, "hostname")
+		var refused *xssh.OpenChannelError
+		if !errors.As(err, &refused) {
+			t.Fatalf("err = %v, want a refused channel", err)
+		}
+		if srv.dials.Load() != 2 || srv.refused.Load() != 2*(sessionRetries+1) {
+			t.Errorf("dials = %d, refused = %d", srv.dials.Load(), srv.refused.Load())
+		}
+	})
+
+	t.Run("failed command not retried", func(t *testing.T) {
+		srv := newFakeServer(t, 0)
+		stdout, _, err := testClient(t, srv.addr).RunCommand(ctx, "fail")
+		var exit *xssh.ExitError
+		if !errors.As(err, &exit) || exit.ExitStatus() != 3 {
+			t.Fatalf("err = %v, want exit status 3", err)
+		}
+		if !strings.Contains(stdout, "ran fail") || srv.dials.Load() != 1 {
+			t.Errorf("stdout = %q, dials = %d", stdout, srv.dials.Load())
+		}
+	})
+}
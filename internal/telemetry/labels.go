@@ -0,0 +1,152 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Error classes reported in the "error" label instead of raw error text,
+// which is unbounded and may carry hostnames, paths or addresses.
+const (
+	ErrorTimeout           = "timeout"
+	ErrorCanceled          = "canceled"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorAddressInUse      = "address_in_use"
+	ErrorDNS               = "dns"
+	ErrorTLS               = "tls"
+	ErrorPermission        = "permission_denied"
+	ErrorNotFound          = "not_found"
+	ErrorEOF               = "eof"
+	ErrorOther             = "other"
+)
+
+// ErrorClass buckets err into one of the Error* classes for use as a metric
+// label value.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, os.ErrPermission):
+		return ErrorPermission
+	case errors.Is(err, os.ErrNotExist):
+		return ErrorNotFound
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorEOF
+	}
+	return classifyErrorText(err.Error())
+}
+
+// classifyErrorText buckets an error message by the phrases the standard
+// library and common servers use.
+func classifyErrorText(msg string) string {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "timed out"), strings.Contains(msg, "deadline exceeded"):
+		return ErrorTimeout
+	case strings.Contains(msg, "canceled"), strings.Contains(msg, "cancelled"):
+		return ErrorCanceled
+	case strings.Contains(msg, "connection refused"):
+		return ErrorConnectionRefused
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "broken pipe"):
+		return ErrorConnectionReset
+	case strings.Contains(msg, "address already in use"):
+		return ErrorAddressInUse
+	case strings.Contains(msg, "no such host"), strings.Contains(msg, "lookup "):
+		return ErrorDNS
+	case strings.Contains(msg, "tls"), strings.Contains(msg, "x509"), strings.Contains(msg, "certificate"):
+		return ErrorTLS
+	case strings.Contains(msg, "permission denied"), strings.Contains(msg, "forbidden"):
+		return ErrorPermission
+	case strings.Contains(msg, "no such file"), strings.Contains(msg, "not found"):
+		return ErrorNotFound
+	case strings.Contains(msg, "eof"):
+		return ErrorEOF
+	}
+	return ErrorOther
+}
+
+// sanitizeLabels rewrites label values that would otherwise make every
+// series unique: addresses are hashed, commands reduced to their base name
+// and error text bucketed into a class. Values already in those forms are
+// kept, so sanitizing twice is harmless. The caller's map is never modified.
+func sanitizeLabels(labels map[string]string) map[string]string {
+	var out map[string]string
+	for k, v := range labels {
+		s := sanitizeLabel(k, v)
+		if s == v {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(labels))
+			for k2, v2 := range labels {
+				out[k2] = v2
+			}
+		}
+		out[k] = s
+	}
+	if out == nil {
+		return labels
+	}
+	return out
+}
+
+func sanitizeLabel(key, value string) string {
+	switch key {
+	case "error":
+		if isLabelToken(value) {
+			return value
+		}
+		return classifyErrorText(value)
+	case "command":
+		return path.Base(value)
+	case "node_ip", "ip", "remote_addr", "client_ip":
+		return hashAddr(value)
+	}
+	return value
+}
+
+// isLabelToken reports whether v looks like an error class or code, such as
+// "decode_request", rather than free-form error text.
+func isLabelToken(v string) bool {
+	if v == "" || len(v) > 32 {
+		return false
+	}
+	for _, r := range v {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// hashAddr replaces an IP address, with or without a port, by a short
+// stable hash so series stay distinguishable without exposing addresses.
+// Anything else is returned unchanged.
+func hashAddr(v string) string {
+	host := v
+	if h, _, err := net.SplitHostPort(v); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return v
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(host))
+	return "ip-" + strconv.FormatUint(uint64(h.Sum32()), 16)
+}
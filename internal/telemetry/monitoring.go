@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"runtime"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -32,12 +33,18 @@ type HealthCheck struct {
 	Details     map[string]string `json:"details,omitempty"`
 }
 
+// healthCheckTimeout bounds each health check; a check still running after
+// it is reported unhealthy.
+var healthCheckTimeout = 5 * time.Second
+
 // MonitoringServer provides HTTP endpoints for monitoring and metrics
 type MonitoringServer struct {
 	collector          *Collector
 	performanceMonitor *PerformanceMonitor
+	checksMu           sync.RWMutex
 	healthChecks       map[string]func() HealthCheck
 	server             *http.Server
+	started            time.Time
 }
 
 // NewMonitoringServer creates a new monitoring server
@@ -46,6 +53,7 @@ func NewMonitoringServer(addr string, collector *Collector, perfMon *Performance
 		collector:          collector,
 		performanceMonitor: perfMon,
 		healthChecks:       make(map[string]func() HealthCheck),
+		started:            time.Now(),
 	}
 
 	mux := http.NewServeMux()
@@ -65,6 +73,7 @@ func (ms *MonitoringServer) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/metrics", ms.metricsHandler)
 	mux.HandleFunc("/dashboard", ms.dashboardHandler)
 	mux.HandleFunc("/api/metrics", ms.apiMetricsHandler)
+	mux.HandleFunc("/api/summaries", ms.apiSummariesHandler)
 	mux.HandleFunc("/api/health", ms.apiHealthHandler)
 }
 
@@ -83,9 +92,10 @@ func (ms *MonitoringServer) healthHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	response := map[string]interface{}{
-		"status":    overallStatus,
-		"timestamp": time.Now(),
-		"checks":    checks,
+		"status":         overallStatus,
+		"timestamp":      time.Now(),
+		"uptime_seconds": int64(time.Since(ms.started).Seconds()),
+		"checks":         checks,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -116,6 +126,12 @@ func (ms *MonitoringServer) metricsHandler(w http.ResponseWriter, r *http.Reques
 		fmt.Fprintf(w, "# TYPE %s %s\n", metric.Name, metric.Type)
 		fmt.Fprintf(w, "%s%s %f %d\n", metric.Name, labelStr, metric.Value, metric.Timestamp.Unix())
 	}
+
+	typed := make(map[string]bool)
+	for _, s := range ms.collector.Summaries() {
+		writePromSummary(w, s, !typed[s.Name])
+		typed[s.Name] = true
+	}
 }
 
 //go:embed static
@@ -140,6 +156,12 @@ func (ms *MonitoringServer) apiMetricsHandler(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(metrics)
 }
 
+// apiSummariesHandler provides summary quantiles as JSON
+func (ms *MonitoringServer) apiSummariesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ms.collector.Summaries())
+}
+
 // apiHealthHandler provides JSON health API
 func (ms *MonitoringServer) apiHealthHandler(w http.ResponseWriter, r *http.Request) {
 	checks := ms.runHealthChecks()
@@ -155,9 +177,10 @@ func (ms *MonitoringServer) apiHealthHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	response := map[string]interface{}{
-		"status":    overallStatus,
-		"timestamp": time.Now(),
-		"checks":    checks,
+		"status":         overallStatus,
+		"timestamp":      time.Now(),
+		"uptime_seconds": int64(time.Since(ms.started).Seconds()),
+		"checks":         checks,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -166,24 +189,66 @@ func (ms *MonitoringServer) apiHealthHandler(w http.ResponseWriter, r *http.Requ
 
 // RegisterHealthCheck registers a health check function
 func (ms *MonitoringServer) RegisterHealthCheck(name string, checkFn func() HealthCheck) {
+	ms.checksMu.Lock()
+	defer ms.checksMu.Unlock()
 	ms.healthChecks[name] = checkFn
 }
 
-// runHealthChecks executes all registered health checks
+// runHealthChecks executes all registered health checks concurrently, each
+// bounded by healthCheckTimeout, and returns the results sorted by name. A
+// check that times out is reported unhealthy; it keeps running in the
+// background since checks take no context.
 func (ms *MonitoringServer) runHealthChecks() []HealthCheck {
-	var checks []HealthCheck
-
-	for _, checkFn := range ms.healthChecks {
-		start := time.Now()
-		check := checkFn()
-		check.Duration = time.Since(start)
-		check.LastChecked = time.Now()
-		checks = append(checks, check)
+	ms.checksMu.RLock()
+	names := make([]string, 0, len(ms.healthChecks))
+	for name := range ms.healthChecks {
+		names = append(names, name)
 	}
-
+	sort.Strings(names)
+	fns := make([]func() HealthCheck, len(names))
+	for i, name := range names {
+		fns[i] = ms.healthChecks[name]
+	}
+	ms.checksMu.RUnlock()
+
+	checks := make([]HealthCheck, len(names))
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checks[i] = runHealthCheck(names[i], fns[i], healthCheckTimeout)
+		}(i)
+	}
+	wg.Wait()
 	return checks
 }
 
+// runHealthCheck runs one check, giving up after timeout.
+func runHealthCheck(name string, checkFn func() HealthCheck, timeout time.Duration) HealthCheck {
+	start := time.Now()
+	done := make(chan HealthCheck, 1)
+	go func() { done <- checkFn() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var check HealthCheck
+	select {
+	case check = <-done:
+	case <-timer.C:
+		check = HealthCheck{
+			Status:  HealthStatusUnhealthy,
+			Message: fmt.Sprintf("check timed out after %v", timeout),
+		}
+	}
+	if check.Name == "" {
+		check.Name = name
+	}
+	check.Duration = time.Since(start)
+	check.LastChecked = time.Now()
+	return check
+}
+
 // Start starts the monitoring server
 func (ms *MonitoringServer) Start() error {
 	log.Info().Str("addr", ms.server.Addr).Msg("Starting monitoring server")
@@ -0,0 +1,57 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunHealthChecksConcurrent(t *testing.T) {
+	defer func(d time.Duration) { healthCheckTimeout = d }(healthCheckTimeout)
+	healthCheckTimeout = 200 * time.Millisecond
+
+	ms := NewMonitoringServer("127.0.0.1:0", nil, nil)
+	release := make(chan struct{})
+	defer close(release)
+	ms.RegisterHealthCheck("stuck", func() HealthCheck {
+		<-release
+		return HealthCheck{Status: HealthStatusHealthy}
+	})
+	for _, name := range []string{"b-slow", "a-slow"} {
+		ms.RegisterHealthCheck(name, func() HealthCheck {
+			time.Sleep(100 * time.Millisecond)
+			return HealthCheck{Status: HealthStatusHealthy, Message: "ok"}
+		})
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	ms.healthHandler(rec, httptest.NewRequest("GET", "/health", nil))
+	// Serially this would take the stuck check's timeout plus 200ms.
+	if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+		t.Errorf("health took %v", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d", rec.Code)
+	}
+	var body struct {
+		Checks []HealthCheck `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name   string
+		status HealthStatus
+	}{{"a-slow", HealthStatusHealthy}, {"b-slow", HealthStatusHealthy}, {"stuck", HealthStatusUnhealthy}}
+	if len(body.Checks) != len(want) {
+		t.Fatalf("checks = %+v", body.Checks)
+	}
+	for i, w := range want {
+		if c := body.Checks[i]; c.Name != w.name || c.Status != w.status {
+			t.Errorf("check %d = %s %s, want %s %s", i, c.Name, c.Status, w.name, w.status)
+		}
+	}
+}
@@ -173,7 +173,7 @@ func (e *OTLPExporter) convertToOTLP(metrics []Metric) otlpMetricsPayload {
 			otlpMetric.Gauge = &otlpGauge{
 				DataPoints: []otlpNumberDataPoint{dataPoint},
 			}
-		case Histogram:
+		case Histogram, Summary:
 			// For histograms, create simple single-bucket histogram
 			histDataPoint := otlpHistogramDataPoint{
 				Attributes:     attributes,
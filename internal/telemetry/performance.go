@@ -97,6 +97,7 @@ func (pm *PerformanceMonitor) RecordTaskMetrics(taskName string, nodeCount int,
 	}
 
 	pm.collector.Timer("gaxx_task_duration", duration, labels)
+	pm.collector.Summary("gaxx_task_latency_ms", float64(duration.Milliseconds()), labels)
 	pm.collector.Gauge("gaxx_task_nodes", float64(nodeCount), labels)
 	pm.collector.Counter("gaxx_task_executions_successful", float64(successful), labels)
 	pm.collector.Counter("gaxx_task_executions_failed", float64(failed), labels)
@@ -144,6 +145,10 @@ func (pm *PerformanceMonitor) RecordAgentMetrics(nodeIP, operation string, durat
 	}
 
 	pm.collector.Timer("gaxx_agent_operation_duration", duration, labels)
+	pm.collector.Summary("gaxx_agent_operation_latency_ms", float64(duration.Milliseconds()), map[string]string{
+		"operation": operation,
+		"component": "agent",
+	})
 
 	if success {
 		pm.collector.Counter("gaxx_agent_operations_successful", 1, labels)
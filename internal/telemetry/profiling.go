@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	_ "net/http/pprof" // Import pprof for profiling
+	"net/http/pprof"
 	"runtime"
 	"time"
 
@@ -15,6 +15,7 @@ import (
 type ProfilingServer struct {
 	server *http.Server
 	addr   string
+	wrap   func(http.Handler) http.Handler
 }
 
 // NewProfilingServer creates a new profiling server
@@ -33,17 +34,31 @@ func (ps *ProfilingServer) Start() error {
 	mux.HandleFunc("/debug/gc", ps.gcHandler)
 	mux.HandleFunc("/debug/build", ps.buildInfoHandler)
 
-	// pprof endpoints are automatically registered at /debug/pprof/ when imported
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+	var handler http.Handler = mux
+	if ps.wrap != nil {
+		handler = ps.wrap(mux)
+	}
 	ps.server = &http.Server{
 		Addr:    ps.addr,
-		Handler: mux,
+		Handler: handler,
 	}
 
 	log.Info().Str("addr", ps.addr).Msg("Starting profiling server")
 	return ps.server.ListenAndServe()
 }
 
+// Protect wraps every profiling endpoint in mw, e.g. to require auth.
+// It must be called before Start.
+func (ps *ProfilingServer) Protect(mw func(http.Handler) http.Handler) {
+	ps.wrap = mw
+}
+
 // Shutdown gracefully shuts down the profiling server
 func (ps *ProfilingServer) Shutdown(ctx context.Context) error {
 	if ps.server != nil {
@@ -188,6 +203,13 @@ func NewPerformanceProfiler(enabled bool, addr string) *PerformanceProfiler {
 	}
 }
 
+// Protect wraps the profiling endpoints in mw; see ProfilingServer.Protect.
+func (pp *PerformanceProfiler) Protect(mw func(http.Handler) http.Handler) {
+	if pp.server != nil {
+		pp.server.Protect(mw)
+	}
+}
+
 // Start starts the performance profiler
 func (pp *PerformanceProfiler) Start() error {
 	if !pp.enabled || pp.server == nil {
@@ -0,0 +1,182 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// pushgatewayJob is the job label metrics are grouped under on the gateway.
+const pushgatewayJob = "gaxx"
+
+// PushgatewayExporter pushes metrics to a Prometheus pushgateway in the text
+// exposition format. The collector clears its metrics on every flush, so the
+// exporter keeps running totals and replaces the whole group on each push:
+// counters are summed, gauges keep their last value, summaries report
+// sliding-window quantiles, and histograms and timers are reported as
+// summaries with only _sum and _count.
+type PushgatewayExporter struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	series map[string]*pushSeries
+}
+
+// pushSeries is the running value of one metric name and label set.
+type pushSeries struct {
+	name   string
+	typ    MetricType
+	labels map[string]string
+	value  float64
+	sum    float64
+	count  uint64
+	window *quantileWindow
+}
+
+// NewPushgatewayExporter creates an exporter for the pushgateway at baseURL,
+// e.g. http://pushgateway:9091.
+func NewPushgatewayExporter(baseURL string) *PushgatewayExporter {
+	return &PushgatewayExporter{
+		url:    strings.TrimRight(baseURL, "/") + "/metrics/job/" + pushgatewayJob,
+		client: &http.Client{Timeout: 30 * time.Second},
+		series: make(map[string]*pushSeries),
+	}
+}
+
+// Export folds metrics into the running totals and pushes them
+func (e *PushgatewayExporter) Export(metrics []Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	for _, m := range metrics {
+		e.add(m)
+	}
+	body := e.render()
+	e.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodPut, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway returned status %d", resp.StatusCode)
+	}
+
+	log.Debug().
+		Str("url", e.url).
+		Int("metric_count", len(metrics)).
+		Int("status", resp.StatusCode).
+		Msg("Successfully pushed metrics to pushgateway")
+
+	return nil
+}
+
+// add merges one metric into its series. Callers hold e.mu.
+func (e *PushgatewayExporter) add(m Metric) {
+	name := promName(m.Name)
+	key := name + "{" + promLabels(m.Labels) + "}"
+	s, ok := e.series[key]
+	if !ok {
+		s = &pushSeries{name: name, typ: m.Type, labels: m.Labels}
+		e.series[key] = s
+	}
+	switch m.Type {
+	case Counter:
+		s.value += m.Value
+	case Gauge:
+		s.value = m.Value
+	case Summary:
+		if s.window == nil {
+			s.window = newQuantileWindow()
+		}
+		s.window.observe(m.Value, m.Timestamp)
+	default:
+		s.sum += m.Value
+		s.count++
+	}
+}
+
+// render writes every series in the text exposition format, grouped by
+// metric name so each name has a single TYPE line. Callers hold e.mu.
+func (e *PushgatewayExporter) render() []byte {
+	keys := make([]string, 0, len(e.series))
+	for k := range e.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	now := time.Now()
+	typed := make(map[string]bool)
+	for _, k := range keys {
+		s := e.series[k]
+		labels := promLabels(s.labels)
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		switch s.typ {
+		case Counter, Gauge:
+			if !typed[s.name] {
+				fmt.Fprintf(&buf, "# TYPE %s %s\n", s.name, s.typ)
+				typed[s.name] = true
+			}
+			fmt.Fprintf(&buf, "%s%s %g\n", s.name, labels, s.value)
+		case Summary:
+			snap := SummarySnapshot{Name: s.name, Labels: s.labels, Count: s.window.count, Sum: s.window.sum, Quantiles: s.window.quantiles(now)}
+			writePromSummary(&buf, snap, !typed[s.name])
+			typed[s.name] = true
+		default:
+			if !typed[s.name] {
+				fmt.Fprintf(&buf, "# TYPE %s summary\n", s.name)
+				typed[s.name] = true
+			}
+			fmt.Fprintf(&buf, "%s_sum%s %g\n", s.name, labels, s.sum)
+			fmt.Fprintf(&buf, "%s_count%s %d\n", s.name, labels, s.count)
+		}
+	}
+	return buf.Bytes()
+}
+
+// promName replaces characters Prometheus does not allow in metric names.
+func promName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == ':' || (i > 0 && r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// promLabels formats labels as sorted, escaped name="value" pairs.
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, promName(k), escape.Replace(v)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
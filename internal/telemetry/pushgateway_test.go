@@ -0,0 +1,67 @@
+package telemetry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushgatewayExporter(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	e := NewPushgatewayExporter(srv.URL + "/")
+	now := time.Now()
+	labels := map[string]string{"fleet": "web"}
+	if err := e.Export([]Metric{
+		{Name: "gaxx_tasks", Type: Counter, Value: 2, Labels: labels, Timestamp: now},
+		{Name: "gaxx_nodes", Type: Gauge, Value: 3, Timestamp: now},
+		{Name: "gaxx.exec", Type: Timer, Value: 100, Labels: labels, Timestamp: now},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A second flush carries only new metrics; totals must accumulate.
+	if err := e.Export([]Metric{
+		{Name: "gaxx_tasks", Type: Counter, Value: 1, Labels: labels, Timestamp: now},
+		{Name: "gaxx_nodes", Type: Gauge, Value: 5, Timestamp: now},
+		{Name: "gaxx.exec", Type: Timer, Value: 50, Labels: labels, Timestamp: now},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != http.MethodPut || path != "/metrics/job/gaxx" {
+		t.Errorf("got %s %s, want PUT /metrics/job/gaxx", method, path)
+	}
+	for _, want := range []string{
+		"# TYPE gaxx_tasks counter\n",
+		`gaxx_tasks{fleet="web"} 3` + "\n",
+		"gaxx_nodes 5\n",
+		"# TYPE gaxx_exec summary\n",
+		`gaxx_exec_sum{fleet="web"} 150` + "\n",
+		`gaxx_exec_count{fleet="web"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestPushgatewayExporterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := NewPushgatewayExporter(srv.URL).Export([]Metric{{Name: "x", Type: Counter, Value: 1}})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("expected status error, got %v", err)
+	}
+}
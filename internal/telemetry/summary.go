@@ -0,0 +1,129 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// summaryWindowSize is how many recent observations a summary keeps.
+	summaryWindowSize = 1024
+	// summaryMaxAge drops observations older than this from the quantiles.
+	summaryMaxAge = 10 * time.Minute
+)
+
+// SummaryQuantiles are the quantiles reported for every summary.
+var SummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// SummarySnapshot is the current state of one summary series. Count and Sum
+// cover every observation; Quantiles only the sliding window, keyed "p50",
+// "p90" and "p99", and is empty once every observation has aged out.
+type SummarySnapshot struct {
+	Name      string             `json:"name"`
+	Labels    map[string]string  `json:"labels"`
+	Count     uint64             `json:"count"`
+	Sum       float64            `json:"sum"`
+	Quantiles map[string]float64 `json:"quantiles"`
+}
+
+// summarySeries is one summary name and label set.
+type summarySeries struct {
+	name   string
+	labels map[string]string
+	window *quantileWindow
+}
+
+func (s *summarySeries) snapshot(now time.Time) SummarySnapshot {
+	return SummarySnapshot{
+		Name:      s.name,
+		Labels:    s.labels,
+		Count:     s.window.count,
+		Sum:       s.window.sum,
+		Quantiles: s.window.quantiles(now),
+	}
+}
+
+type observation struct {
+	value float64
+	at    time.Time
+}
+
+// quantileWindow estimates quantiles over the most recent observations,
+// bounded both by count and by age. It is not safe for concurrent use.
+type quantileWindow struct {
+	ring  []observation
+	next  int
+	count uint64
+	sum   float64
+}
+
+func newQuantileWindow() *quantileWindow {
+	return &quantileWindow{ring: make([]observation, 0, summaryWindowSize)}
+}
+
+func (w *quantileWindow) observe(v float64, at time.Time) {
+	w.count++
+	w.sum += v
+	if len(w.ring) < summaryWindowSize {
+		w.ring = append(w.ring, observation{v, at})
+		return
+	}
+	w.ring[w.next] = observation{v, at}
+	w.next = (w.next + 1) % summaryWindowSize
+}
+
+// quantiles returns SummaryQuantiles over observations newer than
+// summaryMaxAge, or an empty map when there are none.
+func (w *quantileWindow) quantiles(now time.Time) map[string]float64 {
+	values := make([]float64, 0, len(w.ring))
+	for _, o := range w.ring {
+		if now.Sub(o.at) <= summaryMaxAge {
+			values = append(values, o.value)
+		}
+	}
+	sort.Float64s(values)
+
+	out := make(map[string]float64, len(SummaryQuantiles))
+	if len(values) == 0 {
+		return out
+	}
+	for _, q := range SummaryQuantiles {
+		// Nearest-rank quantile.
+		out[quantileKey(q)] = values[int(math.Ceil(q*float64(len(values))))-1]
+	}
+	return out
+}
+
+// quantileKey names a quantile for JSON output, e.g. 0.99 -> "p99".
+func quantileKey(q float64) string {
+	return "p" + strconv.FormatFloat(math.Round(q*1000)/10, 'f', -1, 64)
+}
+
+// writePromSummary renders a snapshot in the Prometheus text format.
+func writePromSummary(w io.Writer, s SummarySnapshot, withType bool) {
+	name := promName(s.Name)
+	labels := promLabels(s.Labels)
+	if withType {
+		fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	}
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for _, q := range SummaryQuantiles {
+		v, ok := s.Quantiles[quantileKey(q)]
+		if !ok {
+			v = math.NaN()
+		}
+		fmt.Fprintf(w, "%s{%s%squantile=\"%g\"} %g\n", name, labels, sep, q, v)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, s.Sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, s.Count)
+}
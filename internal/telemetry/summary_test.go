@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuantileWindow(t *testing.T) {
+	now := time.Now()
+	w := newQuantileWindow()
+	for i := 1; i <= 100; i++ {
+		w.observe(float64(i), now)
+	}
+	q := w.quantiles(now)
+	if q["p50"] != 50 || q["p90"] != 90 || q["p99"] != 99 {
+		t.Errorf("quantiles = %v", q)
+	}
+	if w.count != 100 || w.sum != 5050 {
+		t.Errorf("count=%d sum=%g", w.count, w.sum)
+	}
+
+	// Once the window is full the oldest observations are replaced.
+	for i := 0; i < summaryWindowSize; i++ {
+		w.observe(1000, now)
+	}
+	if q := w.quantiles(now); q["p50"] != 1000 {
+		t.Errorf("after wrap p50 = %g", q["p50"])
+	}
+
+	// Observations older than summaryMaxAge drop out of the quantiles only.
+	if q := w.quantiles(now.Add(summaryMaxAge + time.Second)); len(q) != 0 {
+		t.Errorf("aged out quantiles = %v", q)
+	}
+}
+
+func TestCollectorSummaries(t *testing.T) {
+	c := NewCollector(true, "")
+	defer c.cancel()
+	labels := map[string]string{"task": "scan"}
+	for _, v := range []float64{10, 20, 30, 40} {
+		c.Summary("gaxx_task_latency_ms", v, labels)
+	}
+
+	snaps := c.Summaries()
+	if len(snaps) != 1 {
+		t.Fatalf("got %d summaries", len(snaps))
+	}
+	s := snaps[0]
+	if s.Count != 4 || s.Sum != 100 || s.Quantiles["p50"] != 20 || s.Quantiles["p99"] != 40 {
+		t.Errorf("snapshot = %+v", s)
+	}
+
+	var buf bytes.Buffer
+	writePromSummary(&buf, s, true)
+	for _, want := range []string{
+		"# TYPE gaxx_task_latency_ms summary\n",
+		`gaxx_task_latency_ms{task="scan",quantile="0.9"} 40` + "\n",
+		`gaxx_task_latency_ms_count{task="scan"} 4` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, buf.String())
+		}
+	}
+}
@@ -2,6 +2,8 @@ package telemetry
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,6 +18,7 @@ const (
 	Gauge     MetricType = "gauge"
 	Histogram MetricType = "histogram"
 	Timer     MetricType = "timer"
+	Summary   MetricType = "summary"
 )
 
 // Metric represents a telemetry metric
@@ -28,15 +31,42 @@ type Metric struct {
 	Unit      string            `json:"unit,omitempty"`
 }
 
+// DefaultMaxLabelValues is the default cap on distinct values per label key.
+const DefaultMaxLabelValues = 100
+
+// overflowLabelValue replaces label values beyond the cardinality cap.
+const overflowLabelValue = "other"
+
+const (
+	// maxBufferedMetrics bounds the metrics held between flushes, including
+	// ones kept for retry after a failed export; the oldest are dropped.
+	maxBufferedMetrics = 10000
+	// failureLogInterval rate-limits export failure warnings.
+	failureLogInterval = time.Minute
+)
+
 // Collector manages telemetry collection
 type Collector struct {
 	mu           sync.RWMutex
 	metrics      []Metric
 	enabled      bool
 	otlpEndpoint string
-	flushCh      chan struct{}
-	ctx          context.Context
-	cancel       context.CancelFunc
+	pushgateway  *PushgatewayExporter
+	summaries    map[string]*summarySeries
+	// labelValues tracks the distinct values seen per label key, up to
+	// maxLabelValues; later values are reported as overflowLabelValue.
+	labelValues    map[string]map[string]struct{}
+	maxLabelValues int
+	overflowed     map[string]bool
+	// otlpRetry holds metrics from failed OTLP exports for the next flush.
+	otlpRetry []Metric
+	// dropped counts metrics discarded because a buffer was full.
+	dropped        uint64
+	lastFailureLog time.Time
+	failures       int
+	flushCh        chan struct{}
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
 // NewCollector creates a new telemetry collector
@@ -44,12 +74,16 @@ func NewCollector(enabled bool, otlpEndpoint string) *Collector {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := &Collector{
-		metrics:      make([]Metric, 0),
-		enabled:      enabled,
-		otlpEndpoint: otlpEndpoint,
-		flushCh:      make(chan struct{}, 1),
-		ctx:          ctx,
-		cancel:       cancel,
+		metrics:        make([]Metric, 0),
+		enabled:        enabled,
+		otlpEndpoint:   otlpEndpoint,
+		summaries:      make(map[string]*summarySeries),
+		labelValues:    make(map[string]map[string]struct{}),
+		maxLabelValues: DefaultMaxLabelValues,
+		overflowed:     make(map[string]bool),
+		flushCh:        make(chan struct{}, 1),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 
 	if enabled {
@@ -120,6 +154,55 @@ func (c *Collector) Timer(name string, duration time.Duration, labels map[string
 	})
 }
 
+// Summary records an observation for quantile tracking. Besides being
+// flushed like any other metric, the observation feeds a sliding window
+// from which Summaries reports p50, p90 and p99.
+func (c *Collector) Summary(name string, value float64, labels map[string]string) {
+	if !c.enabled {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	labels = c.limitLabels(sanitizeLabels(labels))
+	key := promName(name) + "{" + promLabels(labels) + "}"
+	s, ok := c.summaries[key]
+	if !ok {
+		s = &summarySeries{name: name, labels: labels, window: newQuantileWindow()}
+		c.summaries[key] = s
+	}
+	s.window.observe(value, now)
+	c.mu.Unlock()
+
+	c.addMetric(Metric{
+		Name:      name,
+		Type:      Summary,
+		Value:     value,
+		Labels:    labels,
+		Timestamp: now,
+	})
+}
+
+// Summaries returns the current state of every summary series, sorted by
+// name and labels.
+func (c *Collector) Summaries() []SummarySnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.summaries))
+	for k := range c.summaries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	result := make([]SummarySnapshot, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, c.summaries[k].snapshot(now))
+	}
+	return result
+}
+
 // addMetric adds a metric to the collection
 func (c *Collector) addMetric(metric Metric) {
 	if !c.enabled {
@@ -129,7 +212,8 @@ func (c *Collector) addMetric(metric Metric) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.metrics = append(c.metrics, metric)
+	metric.Labels = c.limitLabels(sanitizeLabels(metric.Labels))
+	c.metrics = c.trimBuffer(append(c.metrics, metric))
 
 	// Trigger flush if we have too many metrics
 	if len(c.metrics) >= 100 {
@@ -156,6 +240,7 @@ func (c *Collector) FlushMetrics() error {
 	metrics := make([]Metric, len(c.metrics))
 	copy(metrics, c.metrics)
 	c.metrics = c.metrics[:0] // Clear the slice
+	pushgateway := c.pushgateway
 	c.mu.Unlock()
 
 	if len(metrics) == 0 {
@@ -164,8 +249,31 @@ func (c *Collector) FlushMetrics() error {
 
 	log.Debug().Int("count", len(metrics)).Msg("Flushing telemetry metrics")
 
-	if c.otlpEndpoint != "" {
-		return c.sendToOTLP(metrics)
+	if c.otlpEndpoint != "" || pushgateway != nil {
+		var errs []error
+		if c.otlpEndpoint != "" {
+			c.mu.Lock()
+			batch := append(c.otlpRetry, metrics...)
+			c.otlpRetry = nil
+			c.mu.Unlock()
+			if err := c.sendToOTLP(batch); err != nil {
+				// Keep the batch for the next flush.
+				c.mu.Lock()
+				c.otlpRetry = c.trimBuffer(append(batch, c.otlpRetry...))
+				c.mu.Unlock()
+				c.exportFailed("otlp", err)
+				errs = append(errs, err)
+			}
+		}
+		if pushgateway != nil {
+			// The exporter keeps running totals, so nothing is lost if a
+			// push fails; the next push carries it.
+			if err := pushgateway.Export(metrics); err != nil {
+				c.exportFailed("pushgateway", err)
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
 	}
 
 	// Fallback: log metrics
@@ -182,25 +290,129 @@ func (c *Collector) FlushMetrics() error {
 	return nil
 }
 
+// trimBuffer drops the oldest metrics beyond maxBufferedMetrics. Callers
+// hold c.mu.
+func (c *Collector) trimBuffer(metrics []Metric) []Metric {
+	if over := len(metrics) - maxBufferedMetrics; over > 0 {
+		c.dropped += uint64(over)
+		return append(metrics[:0], metrics[over:]...)
+	}
+	return metrics
+}
+
+// exportFailed counts a failed export and warns at most once per
+// failureLogInterval, so an unreachable endpoint does not flood the log.
+func (c *Collector) exportFailed(exporter string, err error) {
+	c.Counter("gaxx_telemetry_export_failures", 1, map[string]string{"exporter": exporter})
+
+	c.mu.Lock()
+	c.failures++
+	if time.Since(c.lastFailureLog) < failureLogInterval {
+		c.mu.Unlock()
+		return
+	}
+	failures, dropped, buffered := c.failures, c.dropped, len(c.metrics)+len(c.otlpRetry)
+	c.failures = 0
+	c.lastFailureLog = time.Now()
+	c.mu.Unlock()
+
+	log.Warn().
+		Err(err).
+		Str("exporter", exporter).
+		Int("failures", failures).
+		Uint64("dropped", dropped).
+		Int("buffered", buffered).
+		Msg("Telemetry export failed; buffering metrics")
+}
+
 // sendToOTLP sends metrics to OpenTelemetry endpoint using proper OTLP format
 func (c *Collector) sendToOTLP(metrics []Metric) error {
 	exporter := NewOTLPExporter(c.otlpEndpoint)
 	return exporter.Export(metrics)
 }
 
+// SetMaxLabelValues caps the distinct values kept per label key, so labels
+// such as node_ip or command cannot grow the collector and downstream
+// stores without bound. Values beyond the cap are reported as "other".
+// n <= 0 disables the cap.
+func (c *Collector) SetMaxLabelValues(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxLabelValues = n
+}
+
+// limitLabels returns labels with values past the cardinality cap replaced
+// by overflowLabelValue, warning the first time each key overflows. The
+// caller's map is never modified. Callers hold c.mu.
+func (c *Collector) limitLabels(labels map[string]string) map[string]string {
+	if c.maxLabelValues <= 0 || len(labels) == 0 {
+		return labels
+	}
+	var limited map[string]string
+	for k, v := range labels {
+		seen, ok := c.labelValues[k]
+		if !ok {
+			seen = make(map[string]struct{})
+			c.labelValues[k] = seen
+		}
+		if _, ok := seen[v]; ok || v == overflowLabelValue {
+			continue
+		}
+		if len(seen) < c.maxLabelValues {
+			seen[v] = struct{}{}
+			continue
+		}
+		if limited == nil {
+			limited = make(map[string]string, len(labels))
+			for k2, v2 := range labels {
+				limited[k2] = v2
+			}
+		}
+		limited[k] = overflowLabelValue
+		if !c.overflowed[k] {
+			c.overflowed[k] = true
+			log.Warn().
+				Str("label", k).
+				Int("max_values", c.maxLabelValues).
+				Msg("Telemetry label cardinality cap reached; further values reported as \"other\"")
+		}
+	}
+	if limited == nil {
+		return labels
+	}
+	return limited
+}
+
+// SetPushgatewayURL additionally pushes flushed metrics to a Prometheus
+// pushgateway. An empty URL disables the push.
+func (c *Collector) SetPushgatewayURL(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if url == "" {
+		c.pushgateway = nil
+		return
+	}
+	c.pushgateway = NewPushgatewayExporter(url)
+}
+
 // periodicFlush flushes metrics every 30 seconds
 func (c *Collector) periodicFlush() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	// After a failed export, size-triggered flushes wait for the next tick
+	// rather than retrying a down endpoint on every new metric.
+	failing := false
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		case <-ticker.C:
-			_ = c.FlushMetrics()
+			failing = c.FlushMetrics() != nil
 		case <-c.flushCh:
-			_ = c.FlushMetrics()
+			if !failing {
+				failing = c.FlushMetrics() != nil
+			}
 		}
 	}
 }
@@ -249,6 +461,11 @@ func TimerGlobal(name string, duration time.Duration, labels map[string]string)
 	GetGlobal().Timer(name, duration, labels)
 }
 
+// SummaryGlobal records a summary observation using the global collector
+func SummaryGlobal(name string, value float64, labels map[string]string) {
+	GetGlobal().Summary(name, value, labels)
+}
+
 // Shutdown shuts down the global collector
 func Shutdown() error {
 	if globalCollector != nil {
@@ -0,0 +1,161 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLabelCardinalityCap(t *testing.T) {
+	c := NewCollector(true, "")
+	defer c.cancel()
+	c.SetMaxLabelValues(3)
+
+	for i := 0; i < 5; i++ {
+		labels := map[string]string{"host": fmt.Sprintf("10.0.0.%d", i), "component": "cli"}
+		c.Counter("gaxx_agent_calls", 1, labels)
+		if labels["host"] != fmt.Sprintf("10.0.0.%d", i) {
+			t.Fatalf("caller's labels were modified: %v", labels)
+		}
+	}
+
+	metrics := c.GetMetrics()
+	var got []string
+	for _, m := range metrics {
+		got = append(got, m.Labels["host"])
+		if m.Labels["component"] != "cli" {
+			t.Errorf("low-cardinality label changed: %v", m.Labels)
+		}
+	}
+	want := []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "other", "other"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("host values = %v, want %v", got, want)
+	}
+
+	// Values seen before the cap was reached keep being reported.
+	c.Counter("gaxx_agent_calls", 1, map[string]string{"host": "10.0.0.1"})
+	if m := c.GetMetrics(); m[len(m)-1].Labels["host"] != "10.0.0.1" {
+		t.Errorf("known value bucketed: %v", m[len(m)-1].Labels)
+	}
+}
+
+func TestLabelSanitization(t *testing.T) {
+	c := NewCollector(true, "")
+	defer c.cancel()
+
+	labels := map[string]string{
+		"component": "agent",
+		"command":   "/usr/bin/nmap",
+		"node_ip":   "10.0.0.7",
+		"error":     "listen tcp :8088: bind: address already in use",
+	}
+	c.Counter("gaxx_agent_errors", 1, labels)
+	c.Counter("gaxx_agent_errors", 1, map[string]string{"node_ip": "10.0.0.7:8088", "error": "decode_request"})
+	if labels["node_ip"] != "10.0.0.7" {
+		t.Fatalf("caller's labels were modified: %v", labels)
+	}
+
+	m := c.GetMetrics()
+	got := m[0].Labels
+	if got["component"] != "agent" || got["command"] != "nmap" || got["error"] != ErrorAddressInUse {
+		t.Errorf("labels = %v", got)
+	}
+	if !strings.HasPrefix(got["node_ip"], "ip-") || strings.Contains(got["node_ip"], "10.0") {
+		t.Errorf("node_ip not hashed: %q", got["node_ip"])
+	}
+	if m[1].Labels["node_ip"] != got["node_ip"] {
+		t.Errorf("same address hashed differently: %q vs %q", m[1].Labels["node_ip"], got["node_ip"])
+	}
+	if m[1].Labels["error"] != "decode_request" {
+		t.Errorf("error class rewritten: %q", m[1].Labels["error"])
+	}
+	if again := sanitizeLabels(got); fmt.Sprint(again) != fmt.Sprint(got) {
+		t.Errorf("sanitizing twice changed labels: %v -> %v", got, again)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.DeadlineExceeded, ErrorTimeout},
+		{fmt.Errorf("exec: %w", context.Canceled), ErrorCanceled},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, ErrorDNS},
+		{fmt.Errorf("open /etc/shadow: %w", os.ErrPermission), ErrorPermission},
+		{errors.New("dial tcp 10.0.0.1:8088: connect: connection refused"), ErrorConnectionRefused},
+		{errors.New("tls: bad certificate"), ErrorTLS},
+		{errors.New("something odd"), ErrorOther},
+	}
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFlushBuffersFailedOTLPExports(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	var names [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p otlpMetricsPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		defer mu.Unlock()
+		var got []string
+		for _, m := range p.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			got = append(got, m.Name)
+		}
+		names = append(names, got)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewCollector(true, srv.URL)
+	defer c.cancel()
+
+	c.Counter("first", 1, nil)
+	if err := c.FlushMetrics(); err == nil {
+		t.Fatal("expected export error")
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	c.Counter("second", 1, nil)
+	if err := c.FlushMetrics(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 {
+		t.Fatalf("got %d exports", len(names))
+	}
+	got := fmt.Sprint(names[1])
+	for _, want := range []string{"first", "second", "gaxx_telemetry_export_failures"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("retry export %v missing %s", got, want)
+		}
+	}
+}
+
+func TestBufferDropsOldest(t *testing.T) {
+	c := NewCollector(false, "")
+	c.enabled = true // without the background flusher
+	for i := 0; i < maxBufferedMetrics+5; i++ {
+		c.Gauge("g", float64(i), nil)
+	}
+	m := c.GetMetrics()
+	if len(m) != maxBufferedMetrics || m[0].Value != 5 || c.dropped != 5 {
+		t.Errorf("len=%d first=%g dropped=%d", len(m), m[0].Value, c.dropped)
+	}
+}
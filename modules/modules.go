@@ -0,0 +1,10 @@
+// Package modules holds the task modules bundled into gaxx, so they can be
+// run by name without a copy on disk.
+package modules
+
+import "embed"
+
+// Bundled holds the *.yaml modules in this directory.
+//
+//go:embed *.yaml
+var Bundled embed.FS
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// ErrAgentBusy is returned by executions on a node whose agent answered 429
+// because it is at capacity. With ConcurrencyAuto the chunk is retried.
+var ErrAgentBusy = core.ErrAgentBusy
+
+// ConcurrencyAuto, set as Client.Concurrency or --concurrency auto, starts
+// with a few parallel executions and adapts to how the nodes cope: the
+// limit grows while executions succeed at steady latency, up to
+// MaxConcurrency, and halves when they fail or agents answer busy.
+const ConcurrencyAuto = -1
+
+const (
+	// DefaultConcurrency is the fixed concurrency when none is set.
+	DefaultConcurrency = 10
+	// DefaultMaxConcurrency caps ConcurrencyAuto when MaxConcurrency is 0.
+	DefaultMaxConcurrency = 64
+	// adaptiveStart is where ConcurrencyAuto begins.
+	adaptiveStart = 2
+	// adaptiveSlowdown is how much slower than the fastest execution seen
+	// one may be before the limit stops growing.
+	adaptiveSlowdown = 2.0
+	// adaptiveBusyRetries is how often a chunk refused by a busy agent is
+	// retried at a lower limit before it counts as failed.
+	adaptiveBusyRetries = 3
+)
+
+// ParseConcurrency parses a concurrency: "auto" or a positive number.
+func ParseConcurrency(s string) (int, error) {
+	if s == "auto" {
+		return ConcurrencyAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("concurrency %q must be a positive number or auto", s)
+	}
+	return n, nil
+}
+
+// concurrencyLimiter bounds how many executions run at once. A fixed
+// limiter never changes its limit; an adaptive one moves it with each
+// execution's outcome.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inflight int
+
+	adaptive bool
+	max      int
+	task     string
+	// rampUp doubles the limit each window until the first backoff; the
+	// limit then grows by one per window.
+	rampUp bool
+	// window counts good executions since the limit last changed, and
+	// cooldown the executions still running from before the last backoff,
+	// whose failures are ignored so one burst halves the limit once.
+	window   int
+	cooldown int
+	fastest  time.Duration
+}
+
+func newConcurrencyLimiter(concurrency, ceiling int, task string) *concurrencyLimiter {
+	l := &concurrencyLimiter{limit: concurrency, task: task}
+	if concurrency == ConcurrencyAuto {
+		if ceiling <= 0 {
+			ceiling = DefaultMaxConcurrency
+		}
+		l.adaptive, l.max, l.rampUp = true, ceiling, true
+		l.limit = min(adaptiveStart, ceiling)
+	} else if concurrency <= 0 {
+		l.limit = DefaultConcurrency
+	}
+	l.cond = sync.NewCond(&l.mu)
+	l.report()
+	return l
+}
+
+// acquire waits for a free slot.
+func (l *concurrencyLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inflight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inflight++
+}
+
+// release frees a slot after an execution that took took; failed is set
+// when the node could not run it, including when its agent was busy.
+func (l *concurrencyLimiter) release(took time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	defer l.cond.Broadcast()
+	if !l.adaptive {
+		return
+	}
+	if l.cooldown > 0 {
+		// Started before the last backoff.
+		l.cooldown--
+		if failed {
+			return
+		}
+	}
+	switch {
+	case failed:
+		l.limit = max(1, l.limit/2)
+		l.rampUp, l.window, l.cooldown = false, 0, l.inflight
+	case l.fastest == 0 || took < l.fastest:
+		l.fastest = took
+		l.grow()
+	case float64(took) <= adaptiveSlowdown*float64(l.fastest):
+		l.grow()
+	default:
+		// Slower than the nodes can manage at this limit: hold it.
+		l.window = 0
+		return
+	}
+	l.report()
+}
+
+// grow counts a good execution and raises the limit once a window's
+// worth have finished.
+func (l *concurrencyLimiter) grow() {
+	l.window++
+	if l.window < l.limit || l.limit >= l.max {
+		return
+	}
+	l.window = 0
+	if l.rampUp {
+		l.limit = min(2*l.limit, l.max)
+	} else {
+		l.limit++
+	}
+}
+
+// current returns the limit.
+func (l *concurrencyLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+func (l *concurrencyLimiter) report() {
+	telemetry.GaugeGlobal("gaxx_concurrency_limit", float64(l.limit), map[string]string{"task": l.task, "component": "cli"})
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseConcurrency(t *testing.T) {
+	if n, err := ParseConcurrency("auto"); err != nil || n != ConcurrencyAuto {
+		t.Errorf("auto = %d, %v", n, err)
+	}
+	if n, err := ParseConcurrency("8"); err != nil || n != 8 {
+		t.Errorf("8 = %d, %v", n, err)
+	}
+	for _, s := range []string{"0", "-2", "lots"} {
+		if _, err := ParseConcurrency(s); err == nil {
+			t.Errorf("%q should not parse", s)
+		}
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newConcurrencyLimiter(ConcurrencyAuto, 16, "t")
+	run := func(n int, took time.Duration, failed bool) {
+		for i := 0; i < n; i++ {
+			l.acquire()
+			l.release(took, failed)
+		}
+	}
+	if l.current() != adaptiveStart {
+		t.Fatalf("start = %d", l.current())
+	}
+	// Steady latency doubles the limit each window up to the cap.
+	run(2+4+8, time.Second, false)
+	if l.current() != 16 {
+		t.Fatalf("after ramp-up = %d, want 16", l.current())
+	}
+	run(50, time.Second, false)
+	if l.current() != 16 {
+		t.Fatalf("limit passed the cap: %d", l.current())
+	}
+
+	// Executions still in flight when the limit halves don't halve it again.
+	for i := 0; i < 4; i++ {
+		l.acquire()
+	}
+	for i := 0; i < 4; i++ {
+		l.release(time.Second, true)
+	}
+	if l.current() != 8 {
+		t.Fatalf("after a burst of failures = %d, want 8", l.current())
+	}
+
+	// Slow executions hold the limit; fast ones then grow it by one.
+	run(20, 5*time.Second, false)
+	if l.current() != 8 {
+		t.Fatalf("slow executions changed the limit to %d", l.current())
+	}
+	run(8, time.Second, false)
+	if l.current() != 9 {
+		t.Fatalf("after a window = %d, want 9", l.current())
+	}
+
+	fixed := newConcurrencyLimiter(0, 0, "t")
+	fixed.acquire()
+	fixed.release(time.Second, true)
+	if fixed.current() != DefaultConcurrency {
+		t.Errorf("fixed limit moved to %d", fixed.current())
+	}
+}
+
+// busyExecutor answers busy while more than limit executions overlap.
+type busyExecutor struct {
+	mu             sync.Mutex
+	running, limit int
+	busy           int
+}
+
+func (e *busyExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	e.mu.Lock()
+	if e.running >= e.limit {
+		e.busy++
+		e.mu.Unlock()
+		return ExecResponse{}, ErrAgentBusy
+	}
+	e.running++
+	e.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+	return ExecResponse{Stdout: "ok\n"}, nil
+}
+
+func TestRunNodesAdaptive(t *testing.T) {
+	exec := &busyExecutor{limit: 3}
+	client := NewClient(Config{})
+	client.Executor = exec
+	client.Concurrency = ConcurrencyAuto
+	nodes := make([]Node, 40)
+	for i := range nodes {
+		nodes[i] = Node{Name: "w"}
+	}
+
+	results, err := client.RunNodes(context.Background(), nodes, &TaskSpec{Command: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if !r.OK() {
+			t.Errorf("chunk %d failed: %v", i+1, r.Err)
+		}
+	}
+	if exec.busy == 0 {
+		t.Error("the limit never reached the agent's capacity")
+	}
+}
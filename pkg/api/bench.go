@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+)
+
+// Benchmark workloads, the same on every node so scores compare: hashing
+// benchCPUMB of zeros, then writing benchIOMB to a temporary file and
+// syncing it.
+const (
+	benchCPUMB = 256
+	benchIOMB  = 128
+)
+
+var (
+	benchCPUScript = fmt.Sprintf(`dd if=/dev/zero bs=1048576 count=%d 2>/dev/null | sha256sum >/dev/null`, benchCPUMB)
+	benchIOScript  = fmt.Sprintf(`f=$(mktemp) && dd if=/dev/zero of="$f" bs=1048576 count=%d conv=fsync 2>/dev/null; s=$?; rm -f "$f"; exit $s`, benchIOMB)
+)
+
+// BenchResult is a node's benchmark: CPU (hashing) and IO (synced write)
+// throughput in MB/s, and Score, their geometric mean. Higher is faster.
+type BenchResult struct {
+	Node  Node
+	CPU   float64
+	IO    float64
+	Score float64
+	Err   error
+}
+
+// Bench runs the benchmark on every node concurrently and returns the
+// results in node order. Each step is timed by the agent, so network
+// latency to the node doesn't count.
+func (c *Client) Bench(ctx context.Context, nodes []Node) []BenchResult {
+	exec := c.executor()
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	out := make([]BenchResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			r := BenchResult{Node: n}
+			r.CPU, r.Err = benchStep(ctx, exec, n, benchCPUScript, benchCPUMB, timeout)
+			if r.Err == nil {
+				r.IO, r.Err = benchStep(ctx, exec, n, benchIOScript, benchIOMB, timeout)
+			}
+			if r.Err == nil {
+				r.Score = math.Sqrt(r.CPU * r.IO)
+			}
+			out[i] = r
+		}(i, n)
+	}
+	wg.Wait()
+	return out
+}
+
+// benchStep runs script on node and returns mb divided by its duration.
+func benchStep(ctx context.Context, exec Executor, node Node, script string, mb int, timeout time.Duration) (float64, error) {
+	resp, err := exec.Exec(ctx, node, agent.ExecRequest{Command: "sh", Args: []string{"-c", script}, Timeout: int(timeout.Seconds())})
+	if err != nil {
+		return 0, err
+	}
+	if resp.ExitCode != 0 {
+		return 0, fmt.Errorf("benchmark exited with code %d: %s", resp.ExitCode, strings.TrimSpace(resp.Stderr+resp.Stdout))
+	}
+	ms := resp.Duration
+	if ms < 1 {
+		ms = 1
+	}
+	return float64(mb) * 1000 / float64(ms), nil
+}
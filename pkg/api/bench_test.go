@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// benchExecutor takes 256ms per benchmark step on "fast", 1024ms on "slow"
+// and fails the IO step on "full".
+type benchExecutor struct{}
+
+func (benchExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	io := strings.Contains(req.Args[1], "fsync")
+	switch {
+	case node.Name == "full" && io:
+		return ExecResponse{ExitCode: 1, Stderr: "No space left on device"}, nil
+	case node.Name == "slow":
+		return ExecResponse{Duration: 1024}, nil
+	}
+	return ExecResponse{Duration: 256}, nil
+}
+
+func TestBench(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = benchExecutor{}
+	results := client.Bench(context.Background(), []Node{{Name: "fast"}, {Name: "slow"}, {Name: "full"}})
+
+	fast, slow, full := results[0], results[1], results[2]
+	if fast.Err != nil || fast.CPU != 1000 || fast.IO != 500 {
+		t.Errorf("fast = %+v", fast)
+	}
+	if slow.Err != nil || fast.Score != 4*slow.Score {
+		t.Errorf("slow = %+v, fast score %v", slow, fast.Score)
+	}
+	if full.Err == nil || !strings.Contains(full.Err.Error(), "No space left") || full.Score != 0 {
+		t.Errorf("full = %+v", full)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"gopkg.in/yaml.v3"
+)
+
+// ChunkSize is how many input items go in each chunk of a task. Zero
+// chunks one item at a time, and ChunkAuto sizes chunks when the task runs.
+type ChunkSize int
+
+// ChunkAuto, written chunk_size: auto, spreads the inputs over
+// ChunksPerNode chunks per node (DefaultChunksPerNode if unset).
+const ChunkAuto ChunkSize = -1
+
+// DefaultChunksPerNode is the ChunkAuto target: a few chunks per node, so
+// nodes that finish early pick up the slack of slow ones.
+const DefaultChunksPerNode = 4
+
+// ParseChunkSize parses a chunk size: "auto" or a non-negative number.
+func ParseChunkSize(s string) (ChunkSize, error) {
+	if s == "auto" {
+		return ChunkAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("chunk size %q must be a non-negative number or auto", s)
+	}
+	return ChunkSize(n), nil
+}
+
+func (c ChunkSize) String() string {
+	if c == ChunkAuto {
+		return "auto"
+	}
+	return strconv.Itoa(int(c))
+}
+
+// resolve returns the number of items per chunk for items inputs spread
+// over nodes nodes.
+func (c ChunkSize) resolve(items, nodes, chunksPerNode int) int {
+	if c != ChunkAuto {
+		return int(c)
+	}
+	if chunksPerNode < 1 {
+		chunksPerNode = DefaultChunksPerNode
+	}
+	return core.AutoChunkSize(items, nodes*chunksPerNode)
+}
+
+func (c ChunkSize) MarshalJSON() ([]byte, error) {
+	if c == ChunkAuto {
+		return []byte(`"auto"`), nil
+	}
+	return json.Marshal(int(c))
+}
+
+func (c *ChunkSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		v, err := ParseChunkSize(s)
+		*c = v
+		return err
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("chunk_size must be a number or \"auto\"")
+	}
+	*c = ChunkSize(n)
+	return nil
+}
+
+func (c ChunkSize) MarshalYAML() (any, error) {
+	if c == ChunkAuto {
+		return "auto", nil
+	}
+	return int(c), nil
+}
+
+// UnmarshalYAML accepts auto or any number; ValidateModule reports
+// negative ones with their line.
+func (c *ChunkSize) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Value == "auto" {
+		*c = ChunkAuto
+		return nil
+	}
+	var n int
+	if err := node.Decode(&n); err != nil {
+		return fmt.Errorf("line %d: chunk_size must be a number or auto", node.Line)
+	}
+	*c = ChunkSize(n)
+	return nil
+}
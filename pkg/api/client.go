@@ -0,0 +1,863 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+	"github.com/3cpo-dev/gaxx/internal/providers/linode"
+	"github.com/3cpo-dev/gaxx/internal/providers/localssh"
+	"github.com/3cpo-dev/gaxx/internal/providers/vultr"
+	"github.com/3cpo-dev/gaxx/internal/redact"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// Aliases for the provider types the client accepts and returns, so programs
+// outside this module can name them.
+type (
+	Config             = providers.Config
+	Node               = providers.Node
+	Fleet              = providers.Fleet
+	CreateFleetRequest = providers.CreateFleetRequest
+	SpawnPolicy        = providers.SpawnPolicy
+	PartialFleetError  = providers.PartialFleetError
+	DeleteFleetError   = providers.DeleteFleetError
+	NodeNotFoundError  = providers.NodeNotFoundError
+	EmptyFleetError    = providers.EmptyFleetError
+	Provider           = providers.Provider
+
+	ExecRequest       = agent.ExecRequest
+	HeartbeatResponse = agent.HeartbeatResponse
+	ExecResponse      = agent.ExecResponse
+	ResourceLimits    = agent.ResourceLimits
+	SysInfoResponse   = agent.SysInfoResponse
+	RunningJob        = agent.RunningJob
+	Executor          = core.Executor
+	AgentExecutor     = core.AgentExecutor
+	AgentConn         = core.AgentConn
+	SSHExecutor       = core.SSHExecutor
+	FallbackExecutor  = core.FallbackExecutor
+	AutoExecutor      = core.AutoExecutor
+	ExecMode          = core.ExecMode
+	CircuitBreakers   = core.CircuitBreakers
+	NodeBreaker       = core.NodeBreaker
+	BreakerState      = core.BreakerState
+)
+
+const (
+	SpawnRefuse = providers.SpawnRefuse
+	SpawnTopUp  = providers.SpawnTopUp
+	SpawnForce  = providers.SpawnForce
+
+	ExecModeAgent = core.ExecModeAgent
+	ExecModeSSH   = core.ExecModeSSH
+	ExecModeAuto  = core.ExecModeAuto
+)
+
+var (
+	ErrFleetExists      = providers.ErrFleetExists
+	ErrSpotNotSupported = providers.ErrSpotNotSupported
+	ErrNodeNotFound     = providers.ErrNodeNotFound
+	ErrBreakerOpen      = core.ErrBreakerOpen
+)
+
+// ErrFailFast is wrapped by the errors of executions that Client.FailFast
+// stopped or never started.
+var ErrFailFast = errors.New("stopped by fail-fast")
+
+// Schedule is how Run, RunNodes and Scan spread chunks over nodes.
+type Schedule string
+
+const (
+	// ScheduleStatic assigns chunks to nodes round-robin before the run;
+	// every chunk starts at once, up to the concurrency limit.
+	ScheduleStatic Schedule = "static"
+	// ScheduleDynamic queues the chunks and has each node run one at a
+	// time, taking the next when it finishes, so nodes that are faster or
+	// get quicker chunks do more of the work. Tasks without inputs run
+	// once per node either way.
+	ScheduleDynamic Schedule = "dynamic"
+)
+
+// ParseSchedule parses static or dynamic (or empty, for static).
+func ParseSchedule(s string) (Schedule, error) {
+	switch sc := Schedule(s); sc {
+	case "":
+		return ScheduleStatic, nil
+	case ScheduleStatic, ScheduleDynamic:
+		return sc, nil
+	}
+	return "", fmt.Errorf("schedule %q must be static or dynamic", s)
+}
+
+// ParseExecMode parses agent, ssh or auto (or empty, for auto).
+func ParseExecMode(s string) (ExecMode, error) {
+	return core.ParseExecMode(s)
+}
+
+// LoadConfig reads a gaxx config file; see the CLI's --config flag.
+func LoadConfig(path string) (Config, error) {
+	return providers.LoadConfig(path)
+}
+
+// NodeRunResult is the outcome of one execution on one node. Err is set when
+// the command could not be run at all; a command that ran and failed has a
+// non-zero ExitCode instead.
+type NodeRunResult struct {
+	Node     Node
+	Chunk    int
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	// Truncated is set when the node cut the output at its size limit.
+	Truncated bool
+	// OutputFile is where the output was saved when the client has an
+	// OutputDir; Stdout and Stderr are then empty.
+	OutputFile string
+	// LimitExceeded names the resource limit that killed the command, if any.
+	LimitExceeded string
+	// Step is, for a task with Steps, the step the chain stopped at: the one
+	// that failed, or the last.
+	Step string
+	// Started and Finished are when the controller sent the execution and
+	// received its result.
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+// OK reports whether the command ran and exited zero.
+func (r NodeRunResult) OK() bool { return r.Err == nil && r.ExitCode == 0 }
+
+// NodePhase is the lifecycle point a NodeEvent reports.
+type NodePhase string
+
+const (
+	NodeStarted  NodePhase = "started"
+	NodeOutput   NodePhase = "output"
+	ChunkFailed  NodePhase = "failed"
+	NodeFinished NodePhase = "finished"
+)
+
+// NodeEvent reports progress of one execution. Output events carry a chunk
+// of Stream ("stdout" or "stderr") as the node sends it: agents stream what
+// the command writes, with stderr merged into stdout, and SSH passes each
+// stream on as it is read. Output of agents that predate streaming, of
+// executors that don't pass their context on to the built-in ones, of tasks
+// with Steps and of runs with Speculate arrives in one event per stream
+// once the command exits. Either way all output events precede
+// NodeFinished.
+// Failed and Finished events carry the Result; Failed precedes Finished when
+// the execution errored or exited non-zero. Straggler events carry
+// Straggler, and a chunk restarted on a backup node gets a second Started
+// event for that node.
+type NodeEvent struct {
+	Node      Node
+	Chunk     int
+	Phase     NodePhase
+	Stream    string
+	Output    string
+	Result    *NodeRunResult
+	Straggler *Straggler
+	// Chunks is how many executions the run has in all, for showing
+	// progress.
+	Chunks int
+}
+
+// nodeEventJSON is the wire form of a NodeEvent, as written by run
+// --output json and streamed by gaxx serve.
+type nodeEventJSON struct {
+	Event      string `json:"event"`
+	Node       string `json:"node,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Chunk      int    `json:"chunk,omitempty"`
+	Stream     string `json:"stream,omitempty"`
+	Output     string `json:"output,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	OutputFile string `json:"output_file,omitempty"`
+	Limit      string `json:"limit_exceeded,omitempty"`
+	Step       string `json:"step,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ElapsedMS  int64  `json:"elapsed_ms,omitempty"`
+	MedianMS   int64  `json:"median_ms,omitempty"`
+	Backup     string `json:"backup,omitempty"`
+}
+
+// MarshalJSON encodes the event as a flat object whose "event" field is the
+// phase; the result's fields are present on failed and finished events.
+// Secrets in stderr and errors are masked (see internal/redact).
+func (ev NodeEvent) MarshalJSON() ([]byte, error) {
+	line := nodeEventJSON{
+		Event:  string(ev.Phase),
+		Node:   ev.Node.Name,
+		IP:     ev.Node.DialIP(),
+		Chunk:  ev.Chunk,
+		Stream: ev.Stream,
+		Output: ev.Output,
+	}
+	if ev.Stream == "stderr" {
+		line.Output = redact.String(line.Output)
+	}
+	if r := ev.Result; r != nil {
+		code := r.ExitCode
+		line.ExitCode = &code
+		line.DurationMS = r.Duration.Milliseconds()
+		line.Truncated = r.Truncated
+		line.OutputFile = r.OutputFile
+		line.Limit = r.LimitExceeded
+		line.Step = r.Step
+		if r.Err != nil {
+			line.Error = redact.String(r.Err.Error())
+		}
+	}
+	if st := ev.Straggler; st != nil {
+		line.ElapsedMS = st.Elapsed.Milliseconds()
+		line.MedianMS = st.Median.Milliseconds()
+		line.Backup = st.Backup
+	}
+	return json.Marshal(line)
+}
+
+// Observer receives run lifecycle events. Embed NopObserver to implement
+// only some of the methods.
+type Observer interface {
+	NodeStarted(NodeEvent)
+	NodeOutput(NodeEvent)
+	ChunkFailed(NodeEvent)
+	NodeFinished(NodeEvent)
+}
+
+// NopObserver ignores every event.
+type NopObserver struct{}
+
+func (NopObserver) NodeStarted(NodeEvent)  {}
+func (NopObserver) NodeOutput(NodeEvent)   {}
+func (NopObserver) ChunkFailed(NodeEvent)  {}
+func (NopObserver) NodeFinished(NodeEvent) {}
+
+// notify dispatches an event to the observer method for its phase.
+func notify(o Observer, ev NodeEvent) {
+	switch ev.Phase {
+	case NodeStarted:
+		o.NodeStarted(ev)
+	case NodeOutput:
+		o.NodeOutput(ev)
+	case ChunkFailed:
+		o.ChunkFailed(ev)
+	case NodeFinished:
+		o.NodeFinished(ev)
+	case NodeStraggler:
+		if so, ok := o.(StragglerObserver); ok {
+			so.NodeStraggler(ev)
+		}
+	}
+}
+
+// Client drives fleets programmatically: the same operations as the CLI,
+// backed by a providers.Registry holding every built-in provider.
+type Client struct {
+	cfg   Config
+	reg   *providers.Registry
+	agent *core.AgentConn
+
+	// Provider selects the provider by name; empty uses providers.default.
+	Provider string
+	// Concurrency bounds parallel executions in Run; 0 means 10 and
+	// ConcurrencyAuto adapts it, up to MaxConcurrency (0 means 64).
+	Concurrency    int
+	MaxConcurrency int
+	// Timeout is the per-execution timeout; 0 means defaults.timeout_seconds.
+	Timeout time.Duration
+	// StragglerThreshold, if set, flags chunks running longer than this
+	// many times the median of the finished ones with NodeStraggler
+	// events. With Speculate a straggler is also started on the fastest
+	// idle node, and whichever finishes first is used.
+	StragglerThreshold float64
+	Speculate          bool
+	// FailFast stops a run at the first execution that fails or exits
+	// non-zero: executions in flight are cancelled and the rest are not
+	// started. Their results carry an error naming the failed chunk.
+	FailFast bool
+	// Schedule decides which node runs each chunk of inputs: ScheduleStatic
+	// (the default) assigns them round-robin up front, ScheduleDynamic has
+	// each node take the next chunk whenever it finishes one.
+	Schedule Schedule
+	// Executor runs each command; nil means the agent with SSH fallback.
+	Executor Executor
+	// OutputDir, if set, saves each chunk's output to
+	// OutputDir/<node>-<chunk>.out instead of returning it in the result
+	// and NodeOutput events. Agents spool it to disk and it is fetched in
+	// ranges, so output far beyond the agent's in-memory limit is kept.
+	OutputDir string
+	// ResumeTransfers makes Upload and Collect continue partial files left
+	// by an earlier run instead of starting over.
+	ResumeTransfers bool
+	// Observer and OnEvent, if set, receive progress events from Run and
+	// Scan. Calls are serialized.
+	Observer Observer
+	OnEvent  func(NodeEvent)
+	// Breakers, if set, stops sending work to nodes that keep failing to
+	// run it (see defaults.breaker_threshold). Keep one client to share
+	// them across runs.
+	Breakers *CircuitBreakers
+	// NodeCache, if set, keeps ListNodes results for NodeCacheTTL so that
+	// commands run in quick succession don't each call the provider.
+	NodeCache    NodeCache
+	NodeCacheTTL time.Duration
+}
+
+// NodeCache stores recent node listings by provider and fleet; a
+// *core.Store (the local SQLite store) is one. Spawn and Delete invalidate
+// the listings they change.
+type NodeCache interface {
+	CachedNodes(ctx context.Context, provider, fleet string, maxAge time.Duration) ([]Node, bool, error)
+	CacheNodes(ctx context.Context, provider, fleet string, nodes []Node) error
+	InvalidateNodes(ctx context.Context, provider, fleet string) error
+}
+
+// NewClient creates a client with the linode, vultr and localssh providers,
+// plus any added with RegisterProvider, registered against cfg. It reaches
+// agents as cfg.Agent.TLS says (see AgentConn).
+func NewClient(cfg Config) *Client {
+	reg := providers.NewRegistry()
+	reg.Register(linode.New(cfg))
+	reg.Register(vultr.New(cfg))
+	reg.Register(localssh.New(cfg))
+	reg.RegisterFactories(cfg)
+	c := &Client{cfg: cfg, reg: reg, agent: core.NewAgentConn(cfg)}
+	if cfg.Defaults.BreakerThreshold > 0 {
+		c.Breakers = core.NewCircuitBreakers(cfg.Defaults.BreakerThreshold, time.Duration(cfg.Defaults.BreakerCooldownSeconds)*time.Second)
+	}
+	return c
+}
+
+// builtinProviders are always registered by NewClient.
+var builtinProviders = map[string]bool{"linode": true, "vultr": true, "localssh": true}
+
+// RegisterProvider adds an out-of-tree provider that clients created
+// afterwards can select by name. factory receives the client's config; use
+// Config.ProviderConfig to read the provider's own providers.<name> section.
+// Call it from an init function, for example in a plugin listed under
+// providers.plugins. It panics if name is empty, built in, or already taken.
+func RegisterProvider(name string, factory func(Config) Provider) {
+	if builtinProviders[name] {
+		panic("api: RegisterProvider cannot replace built-in provider " + name)
+	}
+	providers.RegisterProvider(name, factory)
+}
+
+// LoadProviderPlugins opens each Go plugin in paths so that its init
+// functions can call RegisterProvider. Plugins must be built with the same
+// Go version and module versions as the binary loading them.
+func LoadProviderPlugins(paths []string) error {
+	for _, p := range paths {
+		if _, err := plugin.Open(providers.ExpandHome(p)); err != nil {
+			return fmt.Errorf("load provider plugin %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// AgentConn returns how the client reaches agents: over https when its
+// config's agent.tls is enabled, otherwise plain http. Clients don't share
+// it. Its Err reports TLS certificates that could not be loaded, which
+// every agent request of the client then fails with.
+func (c *Client) AgentConn() *AgentConn { return c.agent }
+
+// Config returns the configuration the client was created with.
+func (c *Client) Config() Config { return c.cfg }
+
+// addressNodes sets each node's IPv6 preference from the client's
+// defaults.prefer_ipv6, for nodes from the cache or from providers that
+// don't set it, and returns nodes.
+func (c *Client) addressNodes(nodes []Node) []Node {
+	for i := range nodes {
+		nodes[i].PreferIPv6 = c.cfg.Defaults.PreferIPv6
+	}
+	return nodes
+}
+
+// provider resolves the client's selected provider.
+func (c *Client) provider() (providers.Provider, error) {
+	name := c.Provider
+	if name == "" {
+		name = c.cfg.Providers.Default
+	}
+	return c.reg.Get(name)
+}
+
+// ProviderName returns the name of the provider the client will use.
+func (c *Client) ProviderName() string {
+	if c.Provider != "" {
+		return c.Provider
+	}
+	return c.cfg.Providers.Default
+}
+
+// Spawn creates req.Count nodes in fleet req.Name, treating existing members
+// according to policy. Node labels continue after the highest existing index.
+// The returned fleet holds only the newly created nodes, so it is empty when
+// the fleet was already at size.
+func (c *Client) Spawn(ctx context.Context, req CreateFleetRequest, policy SpawnPolicy) (*Fleet, error) {
+	p, err := c.provider()
+	if err != nil {
+		return nil, err
+	}
+	// Ask the provider rather than the node cache or the store: a stale
+	// record could hide nodes created since, which is what this check is
+	// for, or count ones deleted outside gaxx and refuse a valid spawn.
+	listed, err := p.ListNodes(ctx, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("list existing nodes: %w", err)
+	}
+	existing := providers.FleetNodes(listed, req.Name)
+	toCreate, err := providers.PlanSpawn(len(existing), req.Count, policy)
+	if err != nil {
+		return nil, fmt.Errorf("fleet '%s' has %d nodes: %w", req.Name, len(existing), err)
+	}
+	if toCreate == 0 {
+		return &Fleet{Name: req.Name}, nil
+	}
+	req.Count = toCreate
+	req.StartIndex = providers.NextFleetIndex(existing, req.Name)
+	// Even a failed create may have left nodes behind.
+	defer c.invalidateNodes(ctx, p, req.Name)
+	fleet, err := p.CreateFleet(ctx, req)
+	if fleet != nil {
+		c.addressNodes(fleet.Nodes)
+	}
+	return fleet, err
+}
+
+// ListNodes lists the nodes of a fleet, or every node if fleet is empty,
+// from the node cache when it has a recent enough listing. Failures aren't
+// cached, so retrying asks the provider again.
+func (c *Client) ListNodes(ctx context.Context, fleet string) ([]Node, error) {
+	p, err := c.provider()
+	if err != nil {
+		return nil, err
+	}
+	caching := c.NodeCache != nil && c.NodeCacheTTL > 0
+	if caching {
+		// The cache only saves API calls, so its errors fall through to
+		// the provider.
+		if nodes, ok, err := c.NodeCache.CachedNodes(ctx, p.Name(), fleet, c.NodeCacheTTL); err == nil && ok {
+			return c.addressNodes(nodes), nil
+		}
+	}
+	nodes, err := p.ListNodes(ctx, fleet)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	if caching {
+		_ = c.NodeCache.CacheNodes(ctx, p.Name(), fleet, nodes)
+	}
+	return c.addressNodes(nodes), nil
+}
+
+// RequireNodes is ListNodes for commands that need the fleet to have nodes:
+// when it has none it returns the *EmptyFleetError from ExplainEmptyFleet.
+func (c *Client) RequireNodes(ctx context.Context, fleet string) ([]Node, error) {
+	nodes, err := c.ListNodes(ctx, fleet)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, c.ExplainEmptyFleet(ctx, fleet)
+	}
+	return nodes, nil
+}
+
+// ExplainEmptyFleet returns an *EmptyFleetError for fleet, listing every
+// node of the provider to say which fleets it does have.
+func (c *Client) ExplainEmptyFleet(ctx context.Context, fleet string) error {
+	e := &EmptyFleetError{Fleet: fleet, Provider: c.ProviderName(), Total: -1}
+	if all, err := c.ListNodes(ctx, ""); err == nil {
+		e.Total, e.Fleets = len(all), providers.FleetNames(all)
+	}
+	return e
+}
+
+// GetNode returns the node called name in fleet, or in any fleet when
+// empty, without listing the fleet when the provider can look nodes up
+// directly. A cached listing of fleet that has the node is used first. It
+// returns a *NodeNotFoundError when there is no such node.
+func (c *Client) GetNode(ctx context.Context, fleet, name string) (Node, error) {
+	p, err := c.provider()
+	if err != nil {
+		return Node{}, err
+	}
+	if c.NodeCache != nil && c.NodeCacheTTL > 0 {
+		if nodes, ok, err := c.NodeCache.CachedNodes(ctx, p.Name(), fleet, c.NodeCacheTTL); err == nil && ok {
+			if n, err := providers.FindNode(nodes, fleet, name); err == nil {
+				n.PreferIPv6 = c.cfg.Defaults.PreferIPv6
+				return n, nil
+			}
+		}
+	}
+	n, err := providers.GetNode(ctx, p, fleet, name)
+	if err != nil && !errors.Is(err, ErrNodeNotFound) {
+		return Node{}, fmt.Errorf("get node: %w", err)
+	}
+	n.PreferIPv6 = c.cfg.Defaults.PreferIPv6
+	return n, err
+}
+
+// invalidateNodes drops cached listings of fleet, or of every fleet when
+// empty, after nodes were created or deleted.
+func (c *Client) invalidateNodes(ctx context.Context, p providers.Provider, fleet string) {
+	if c.NodeCache != nil {
+		_ = c.NodeCache.InvalidateNodes(context.WithoutCancel(ctx), p.Name(), fleet)
+	}
+}
+
+// Delete deletes every node in a fleet. Use DeleteAll to delete every node
+// the provider can see.
+func (c *Client) Delete(ctx context.Context, fleet string) error {
+	if fleet == "" {
+		return fmt.Errorf("fleet name is required")
+	}
+	return c.deleteFleet(ctx, fleet)
+}
+
+// DeleteAll deletes every node the provider can see, in any fleet.
+func (c *Client) DeleteAll(ctx context.Context) error {
+	return c.deleteFleet(ctx, "")
+}
+
+func (c *Client) deleteFleet(ctx context.Context, fleet string) error {
+	p, err := c.provider()
+	if err != nil {
+		return err
+	}
+	defer c.invalidateNodes(ctx, p, fleet)
+	if err := p.DeleteFleet(ctx, fleet); err != nil {
+		return fmt.Errorf("delete fleet: %w", err)
+	}
+	return nil
+}
+
+// Run executes task on every node of fleet, or on every chunk of its inputs,
+// and returns one result per execution in request order.
+func (c *Client) Run(ctx context.Context, fleet string, task *TaskSpec) ([]NodeRunResult, error) {
+	nodes, err := c.RequireNodes(ctx, fleet)
+	if err != nil {
+		return nil, err
+	}
+	return c.RunNodes(ctx, nodes, task)
+}
+
+// Scan uploads files into RemoteFilesDir on every node of fleet, skipping
+// copies that are already up to date, then runs task as Run does. ${files}
+// in the task refers to RemoteFilesDir.
+func (c *Client) Scan(ctx context.Context, fleet string, task *TaskSpec, files []string) ([]NodeRunResult, error) {
+	nodes, err := c.RequireNodes(ctx, fleet)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := c.PlanUploads(ctx, nodes, files)
+	if err != nil {
+		return nil, err
+	}
+	return c.ScanPlanned(ctx, plan, task)
+}
+
+// PlanUploads works out which files each node needs for a scan. Files the
+// node already has with the same size and SHA-256 are marked UpToDate.
+func (c *Client) PlanUploads(ctx context.Context, nodes []Node, files []string) (*UploadPlan, error) {
+	return planUploads(ctx, c.executor(), nodes, files, RemoteFilesDir)
+}
+
+// ScanPlanned performs plan's pending uploads, then runs task on plan's
+// nodes as Scan does. It lets callers inspect the plan in between.
+func (c *Client) ScanPlanned(ctx context.Context, plan *UploadPlan, task *TaskSpec) ([]NodeRunResult, error) {
+	if err := uploadFilesToFleet(ctx, c.transfer().Upload, plan); err != nil {
+		return nil, fmt.Errorf("upload files: %w", err)
+	}
+	rendered := task.Render(map[string]string{"files": RemoteFilesDir})
+	return c.RunNodes(ctx, plan.Nodes, &rendered)
+}
+
+// Upload copies a local file to remotePath on one node over SFTP.
+func (c *Client) Upload(ctx context.Context, node Node, localPath, remotePath string) error {
+	return c.transfer().Upload(ctx, node, localPath, remotePath)
+}
+
+// Collect downloads a remote file, directory or glob pattern from each node
+// into localDir/<node name>. Results are in node order; a failure on one node
+// does not stop the others.
+func (c *Client) Collect(ctx context.Context, nodes []Node, remotePattern, localDir string) []CollectResult {
+	return collectFromFleet(ctx, c.transfer(), nodes, remotePattern, localDir)
+}
+
+func (c *Client) transfer() *FileTransfer {
+	ft := NewFileTransfer(c.cfg)
+	ft.Resume = c.ResumeTransfers
+	return ft
+}
+
+// Exec runs a single request on one node with the client's executor.
+func (c *Client) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	return c.executor().Exec(ctx, node, req)
+}
+
+func (c *Client) executor() Executor {
+	exec := c.Executor
+	if exec == nil {
+		exec = core.NewExecutor(c.cfg, c.agent)
+	}
+	if c.Breakers != nil {
+		exec = core.BreakerExecutor{Executor: exec, Breakers: c.Breakers}
+	}
+	return exec
+}
+
+// RunNodes is Run against an explicit set of nodes.
+func (c *Client) RunNodes(ctx context.Context, nodes []Node, task *TaskSpec) ([]NodeRunResult, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes to run on")
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(c.cfg.Defaults.TimeoutSeconds) * time.Second
+	}
+	var reqNodes []Node
+	var reqs []ExecRequest
+	// chains holds each chunk's steps when the task has Steps; reqs then
+	// holds their first steps.
+	var chains [][]stepRequest
+	var err error
+	if len(task.Steps) > 0 {
+		reqNodes, chains, err = buildStepRequests(task, nodes, timeout)
+		for _, chain := range chains {
+			reqs = append(reqs, chain[0].req)
+		}
+	} else {
+		reqNodes, reqs, err = buildExecRequests(task, nodes, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.OutputDir != "" {
+		if err := os.MkdirAll(c.OutputDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create output dir: %w", err)
+		}
+		// Only the last step's output is saved.
+		for i := range reqs {
+			reqs[i].Spool = true
+		}
+		for _, chain := range chains {
+			chain[len(chain)-1].req.Spool = true
+		}
+	}
+	limiter := newConcurrencyLimiter(c.Concurrency, c.MaxConcurrency, task.Name)
+
+	exec := c.executor()
+	start := time.Now()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	emit := func(ev NodeEvent) {
+		if c.Observer == nil && c.OnEvent == nil {
+			return
+		}
+		ev.Chunks = len(reqs)
+		mu.Lock()
+		defer mu.Unlock()
+		if c.Observer != nil {
+			notify(c.Observer, ev)
+		}
+		if c.OnEvent != nil {
+			c.OnEvent(ev)
+		}
+	}
+	results := make([]NodeRunResult, len(reqs))
+
+	var stragglers *stragglerTracker
+	if c.StragglerThreshold > 0 {
+		stragglers = newStragglerTracker(c.StragglerThreshold, c.Speculate, reqNodes)
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		watched := make(chan struct{})
+		defer func() { stopWatch(); <-watched }()
+		go func() {
+			defer close(watched)
+			stragglers.watch(watchCtx, task.Name, emit)
+		}()
+	}
+
+	// runChunk runs the i-th request on node and reports it.
+	runChunk := func(i int, node Node) {
+		chunk := i + 1
+		exec := exec
+		var chain *chainExecutor
+		if chains != nil {
+			chain = newChainExecutor(exec, chains[i])
+			exec = chain
+		}
+		// Output is passed on as the node sends it, except from chains,
+		// whose earlier steps' output isn't shown, from chunks that may
+		// run twice, and when it is saved instead.
+		execCtx := ctx
+		var streamedOut, streamedErr atomic.Bool
+		if (c.Observer != nil || c.OnEvent != nil) && chain == nil && !c.Speculate && c.OutputDir == "" {
+			node := node
+			execCtx = core.WithOutput(ctx, func(stream, data string) {
+				if stream == "stderr" {
+					streamedErr.Store(true)
+				} else {
+					streamedOut.Store(true)
+				}
+				emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeOutput, Stream: stream, Output: data})
+			})
+		}
+		limiter.acquire()
+		started := time.Now()
+		attempt := started
+		var resp ExecResponse
+		var err error
+		switch {
+		case ctx.Err() != nil:
+			// Stopped by FailFast or the caller before this chunk
+			// started.
+			err = context.Cause(ctx)
+		case stragglers != nil:
+			emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeStarted})
+			o := raceExec(execCtx, exec, node, reqs[i], stragglers.start(chunk, node))
+			node, resp, err = o.node, o.resp, o.err
+		default:
+			emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeStarted})
+			resp, err = exec.Exec(execCtx, node, reqs[i])
+		}
+		if cause := context.Cause(ctx); err != nil && errors.Is(cause, ErrFailFast) {
+			err = cause
+		}
+		// A busy agent ran nothing, so the chunk waits for a slot at
+		// the lowered limit and tries again.
+		for retry := 0; limiter.adaptive && errors.Is(err, ErrAgentBusy) && retry < adaptiveBusyRetries && ctx.Err() == nil; retry++ {
+			limiter.release(time.Since(attempt), true)
+			limiter.acquire()
+			attempt = time.Now()
+			resp, err = exec.Exec(execCtx, node, reqs[i])
+		}
+		limiter.release(time.Since(attempt), err != nil && !errors.Is(err, ErrBreakerOpen))
+		res := NodeRunResult{
+			Node:          node,
+			Chunk:         chunk,
+			ExitCode:      resp.ExitCode,
+			Stdout:        resp.Stdout,
+			Stderr:        resp.Stderr,
+			Duration:      time.Duration(resp.Duration) * time.Millisecond,
+			Truncated:     resp.Truncated,
+			LimitExceeded: resp.LimitExceeded,
+			Started:       started,
+			Finished:      time.Now(),
+			Err:           err,
+		}
+		if chain != nil {
+			res.Step = chain.lastStep(node.Name)
+		}
+		if c.OutputDir != "" && err == nil {
+			res.OutputFile, err = c.saveOutput(ctx, node, chunk, resp)
+			res.Stdout, res.Stderr, res.Err = "", "", err
+			// Saved output isn't also sent in NodeOutput events.
+			resp.Stdout, resp.Stderr = "", ""
+		}
+		results[i] = res
+		if c.FailFast && !res.OK() && ctx.Err() == nil {
+			cancel(fmt.Errorf("%w: chunk %d failed on %s", ErrFailFast, chunk, node.Name))
+		}
+		if stragglers != nil {
+			stragglers.finish(chunk, node, res.Finished.Sub(started), err == nil)
+		}
+		if err == nil {
+			telemetry.SummaryGlobal("gaxx_task_latency_ms", float64(resp.Duration), map[string]string{"task": task.Name, "component": "cli"})
+		}
+		if resp.Stdout != "" && !streamedOut.Load() {
+			emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeOutput, Stream: "stdout", Output: resp.Stdout})
+		}
+		if resp.Stderr != "" && !streamedErr.Load() {
+			emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeOutput, Stream: "stderr", Output: resp.Stderr})
+		}
+		if !res.OK() {
+			emit(NodeEvent{Node: node, Chunk: chunk, Phase: ChunkFailed, Result: &res})
+		}
+		emit(NodeEvent{Node: node, Chunk: chunk, Phase: NodeFinished, Result: &res})
+	}
+	if c.Schedule == ScheduleDynamic && len(reqs) > len(nodes) {
+		// Each node runs one chunk at a time and takes the next when it
+		// finishes, so fast nodes get through more of them.
+		queue := make(chan int, len(reqs))
+		for i := range reqs {
+			queue <- i
+		}
+		close(queue)
+		for _, n := range nodes {
+			wg.Add(1)
+			go func(n Node) {
+				defer wg.Done()
+				for i := range queue {
+					runChunk(i, n)
+				}
+			}(n)
+		}
+	} else {
+		for i := range reqs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runChunk(i, reqNodes[i])
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	succeeded, failed := 0, 0
+	for _, r := range results {
+		if r.OK() {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	telemetry.TimerGlobal("gaxx_task_duration", time.Since(start), map[string]string{"task": task.Name, "component": "cli"})
+	telemetry.CounterGlobal("gaxx_task_executions_successful", float64(succeeded), map[string]string{"task": task.Name, "component": "cli"})
+	telemetry.CounterGlobal("gaxx_task_executions_failed", float64(failed), map[string]string{"task": task.Name, "component": "cli"})
+	return results, nil
+}
+
+// saveOutput writes a chunk's output to the client's OutputDir, fetching it
+// from the node when the agent spooled it, and returns the file's path.
+func (c *Client) saveOutput(ctx context.Context, node Node, chunk int, resp ExecResponse) (string, error) {
+	path := filepath.Join(c.OutputDir, fmt.Sprintf("%s-%d.out", node.Name, chunk))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("save output: %w", err)
+	}
+	if resp.OutputID != "" {
+		err = c.agent.FetchOutput(ctx, node, resp.OutputID, resp.OutputSize, f)
+	} else {
+		_, err = io.WriteString(f, resp.Stdout+resp.Stderr)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("save output: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,568 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+func TestBuildExecRequests(t *testing.T) {
+	nodes := []Node{{Name: "w-1"}, {Name: "w-2"}}
+
+	t.Run("no inputs runs once per node", func(t *testing.T) {
+		task := &TaskSpec{Command: "uptime", Env: map[string]string{"B": "2", "A": "1"}}
+		reqNodes, reqs, err := buildExecRequests(task, nodes, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 2 || reqNodes[0].Name != "w-1" || reqNodes[1].Name != "w-2" {
+			t.Fatalf("got %d requests for %v", len(reqs), reqNodes)
+		}
+		if reqs[0].Command != "uptime" || reqs[0].Timeout != 60 {
+			t.Errorf("request = %+v", reqs[0])
+		}
+		if strings.Join(reqs[0].Env, ",") != "A=1,B=2" {
+			t.Errorf("env = %v, want sorted", reqs[0].Env)
+		}
+	})
+
+	t.Run("inputs are chunked round-robin", func(t *testing.T) {
+		task := &TaskSpec{
+			Name:      "probe",
+			Command:   "httpx",
+			Args:      []string{"-l", "{{ item }}"},
+			Inputs:    []string{"a.example", "b.example", "c.example"},
+			ChunkSize: 1,
+		}
+		reqNodes, reqs, err := buildExecRequests(task, nodes, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 3 {
+			t.Fatalf("got %d requests, want 3", len(reqs))
+		}
+		wantNodes := []string{"w-1", "w-2", "w-1"}
+		for i, n := range reqNodes {
+			if n.Name != wantNodes[i] {
+				t.Errorf("chunk %d on %s, want %s", i+1, n.Name, wantNodes[i])
+			}
+		}
+		last := reqs[2].Args[len(reqs[2].Args)-1]
+		if last != remoteChunkDir+"/probe-3.txt" {
+			t.Errorf("item placeholder rendered as %q", last)
+		}
+		if reqs[2].Input != "c.example\n" {
+			t.Errorf("chunk input = %q", reqs[2].Input)
+		}
+	})
+
+	t.Run("auto chunk size spreads inputs over the nodes", func(t *testing.T) {
+		task := &TaskSpec{Command: "httpx", ChunkSize: ChunkAuto, ChunksPerNode: 2}
+		for i := 0; i < 10; i++ {
+			task.Inputs = append(task.Inputs, fmt.Sprintf("h%d.example", i))
+		}
+		// 10 items over 2 nodes x 2 chunks: chunks of 3, the last one short.
+		_, reqs, err := buildExecRequests(task, nodes, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 4 || strings.Count(reqs[0].Input, "\n") != 3 || strings.Count(reqs[3].Input, "\n") != 1 {
+			t.Errorf("got %d chunks, first %q, last %q", len(reqs), reqs[0].Input, reqs[len(reqs)-1].Input)
+		}
+	})
+
+	t.Run("shell runs the command line through sh -c", func(t *testing.T) {
+		task := &TaskSpec{Command: "echo", Args: []string{"a b", "|", "tr", "ab", "xy"}, Shell: true}
+		_, reqs, err := buildExecRequests(task, nodes, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reqs[0].Command != "sh" || len(reqs[0].Args) != 2 || reqs[0].Args[1] != "echo a b | tr ab xy" {
+			t.Fatalf("request = %+v", reqs[0])
+		}
+		out, err := exec.Command(reqs[0].Command, reqs[0].Args...).Output()
+		if err != nil || string(out) != "x y\n" {
+			t.Errorf("pipeline output = %q, %v", out, err)
+		}
+
+		task.Inputs, task.Args = []string{"a.example"}, []string{"{{ item }}", "|", "wc", "-l"}
+		_, reqs, err = buildExecRequests(task, nodes, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := reqs[0].Args
+		if got := strings.Join(args[len(args)-3:], " "); got != "sh -c echo "+remoteChunkDir+"/task-1.txt | wc -l" {
+			t.Errorf("chunk args end with %q", got)
+		}
+	})
+}
+
+func TestClientLocalSSH(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yml := "providers:\n  localssh:\n    hosts:\n      - name: box-1\n        ip: 10.0.0.1\n"
+	if err := os.WriteFile(path, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(cfg)
+	client.Provider = "localssh"
+
+	nodes, err := client.ListNodes(context.Background(), "box")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].SSHUser != "gx" || nodes[0].SSHPort != 22 {
+		t.Fatalf("nodes = %+v", nodes)
+	}
+
+	if err := client.Delete(context.Background(), ""); err == nil {
+		t.Error("Delete with an empty fleet name should fail")
+	}
+
+	client.Provider = "nope"
+	if _, err := client.ListNodes(context.Background(), "box"); err == nil {
+		t.Error("unknown provider should fail")
+	}
+}
+
+// staticProvider is an out-of-tree provider serving nodes from its own
+// providers.<name> config section.
+type staticProvider struct {
+	Hosts []string `yaml:"hosts"`
+}
+
+func (p *staticProvider) Name() string { return "static" }
+func (p *staticProvider) CreateFleet(ctx context.Context, req CreateFleetRequest) (*Fleet, error) {
+	return nil, ErrSpotNotSupported
+}
+func (p *staticProvider) ListNodes(ctx context.Context, name string) ([]Node, error) {
+	var nodes []Node
+	for i, ip := range p.Hosts {
+		nodes = append(nodes, Node{Name: fmt.Sprintf("%s-%d", name, i+1), IP: ip})
+	}
+	return nodes, nil
+}
+func (p *staticProvider) DeleteFleet(ctx context.Context, name string) error { return nil }
+
+func TestRegisterProvider(t *testing.T) {
+	RegisterProvider("mycloud", func(cfg Config) Provider {
+		p := &staticProvider{}
+		if err := cfg.ProviderConfig("mycloud", p); err != nil {
+			t.Error(err)
+		}
+		return p
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yml := "providers:\n  default: mycloud\n  mycloud:\n    hosts: [10.0.0.7, 10.0.0.8]\n"
+	if err := os.WriteFile(path, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := NewClient(cfg).ListNodes(context.Background(), "w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[1].IP != "10.0.0.8" {
+		t.Fatalf("nodes = %+v", nodes)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering over a built-in provider should panic")
+		}
+	}()
+	RegisterProvider("linode", func(Config) Provider { return &staticProvider{} })
+}
+
+type echoExecutor struct{}
+
+func (echoExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	if node.Name == "bad" {
+		return ExecResponse{Stderr: "boom\n", ExitCode: 1}, nil
+	}
+	return ExecResponse{Stdout: node.Name + "\n"}, nil
+}
+
+func TestRunNodesEvents(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = echoExecutor{}
+	phases := map[string][]NodePhase{}
+	client.OnEvent = func(ev NodeEvent) {
+		phases[ev.Node.Name] = append(phases[ev.Node.Name], ev.Phase)
+	}
+
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "good"}, {Name: "bad"}}, &TaskSpec{Command: "hostname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].OK() || results[0].Stdout != "good\n" {
+		t.Errorf("good result = %+v", results[0])
+	}
+	if results[1].OK() || results[1].ExitCode != 1 {
+		t.Errorf("bad result = %+v", results[1])
+	}
+	want := map[string][]NodePhase{
+		"good": {NodeStarted, NodeOutput, NodeFinished},
+		"bad":  {NodeStarted, NodeOutput, ChunkFailed, NodeFinished},
+	}
+	for name, w := range want {
+		if strings.Join(phaseNames(phases[name]), ",") != strings.Join(phaseNames(w), ",") {
+			t.Errorf("%s events = %v, want %v", name, phases[name], w)
+		}
+	}
+}
+
+func TestRunNodesStreamedOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", agent.StreamContentType)
+		enc := json.NewEncoder(w)
+		_ = enc.Encode(agent.ExecFrame{Output: "found a\n"})
+		_ = enc.Encode(agent.ExecFrame{Output: "found b\n"})
+		_ = enc.Encode(agent.ExecFrame{Result: &agent.ExecResponse{}})
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	client := NewClient(Config{})
+	client.Executor = AgentExecutor{}
+	var events []string
+	client.OnEvent = func(ev NodeEvent) {
+		events = append(events, string(ev.Phase)+" "+ev.Output)
+	}
+
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "w-1", IP: "127.0.0.1", AgentPort: port}}, &TaskSpec{Command: "scan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each piece is its own event, and the whole isn't sent again at the end.
+	want := "started |output found a\n|output found b\n|finished "
+	if got := strings.Join(events, "|"); got != want || results[0].Stdout != "found a\nfound b\n" {
+		t.Errorf("events = %q, stdout = %q", got, results[0].Stdout)
+	}
+}
+
+func phaseNames(ps []NodePhase) []string {
+	out := make([]string, len(ps))
+	for i, p := range ps {
+		out[i] = string(p)
+	}
+	return out
+}
+
+type failureCounter struct {
+	NopObserver
+	failed []string
+}
+
+func (f *failureCounter) ChunkFailed(ev NodeEvent) { f.failed = append(f.failed, ev.Node.Name) }
+
+func TestRunNodesObserver(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = echoExecutor{}
+	obs := &failureCounter{}
+	client.Observer = obs
+
+	if _, err := client.RunNodes(context.Background(), []Node{{Name: "good"}, {Name: "bad"}}, &TaskSpec{Command: "hostname"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(obs.failed) != 1 || obs.failed[0] != "bad" {
+		t.Errorf("ChunkFailed calls = %v, want [bad]", obs.failed)
+	}
+}
+
+func TestRunNodesOutputDir(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = echoExecutor{}
+	client.OutputDir = filepath.Join(t.TempDir(), "out")
+	var outputs int
+	client.OnEvent = func(ev NodeEvent) {
+		if ev.Phase == NodeOutput {
+			outputs++
+		}
+	}
+
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "good"}, {Name: "bad"}}, &TaskSpec{Command: "hostname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs != 0 {
+		t.Errorf("%d NodeOutput events, want none", outputs)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Stdout != "" || r.Stderr != "" || r.OutputFile != filepath.Join(client.OutputDir, r.Node.Name+"-"+strconv.Itoa(r.Chunk)+".out") {
+			t.Errorf("result = %+v", r)
+		}
+	}
+	if b, err := os.ReadFile(results[1].OutputFile); err != nil || string(b) != "boom\n" {
+		t.Errorf("bad output = %q, %v", b, err)
+	}
+}
+
+// countingProvider counts ListNodes calls and fails them while down.
+type countingProvider struct {
+	staticProvider
+	lists int
+	down  bool
+}
+
+func (p *countingProvider) ListNodes(ctx context.Context, name string) ([]Node, error) {
+	p.lists++
+	if p.down {
+		return nil, fmt.Errorf("429 too many requests")
+	}
+	return p.staticProvider.ListNodes(ctx, name)
+}
+
+// failingExecutor fails node "bad" at once and runs others until cancelled.
+type failingExecutor struct{}
+
+func (failingExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	if node.Name == "bad" {
+		return ExecResponse{ExitCode: 2}, nil
+	}
+	select {
+	case <-ctx.Done():
+		return ExecResponse{}, ctx.Err()
+	case <-time.After(10 * time.Second):
+		return ExecResponse{}, nil
+	}
+}
+
+func TestRunNodesFailFast(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = failingExecutor{}
+	client.FailFast = true
+	start := time.Now()
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "w-1"}, {Name: "bad"}, {Name: "w-2"}}, &TaskSpec{Command: "scan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("run took %v; fail-fast did not cancel it", took)
+	}
+	if results[1].ExitCode != 2 || results[1].Err != nil {
+		t.Errorf("bad result = %+v", results[1])
+	}
+	for _, r := range []NodeRunResult{results[0], results[2]} {
+		if !errors.Is(r.Err, ErrFailFast) || !strings.Contains(r.Err.Error(), "chunk 2 failed on bad") {
+			t.Errorf("%s err = %v, want fail-fast", r.Node.Name, r.Err)
+		}
+	}
+}
+
+// unevenExecutor takes 200ms to run anything on the node named slow.
+type unevenExecutor struct{}
+
+func (unevenExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	if node.Name == "slow" {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return ExecResponse{}, nil
+}
+
+func TestRunNodesDynamicSchedule(t *testing.T) {
+	client := NewClient(Config{})
+	client.Executor = unevenExecutor{}
+	client.Schedule = ScheduleDynamic
+	task := &TaskSpec{Command: "scan", Args: []string{"{{ item }}"}, ChunkSize: 1}
+	for i := 0; i < 8; i++ {
+		task.Inputs = append(task.Inputs, fmt.Sprintf("host-%d", i))
+	}
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "fast"}, {Name: "slow"}}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := map[string]int{}
+	for _, r := range results {
+		if !r.OK() {
+			t.Errorf("chunk %d: %+v", r.Chunk, r)
+		}
+		ran[r.Node.Name]++
+	}
+	if len(results) != 8 || ran["slow"] > 2 {
+		t.Errorf("chunks per node = %v, want the fast node to take most of 8", ran)
+	}
+}
+
+// stepExecutor echoes its args, and exits 1 for false, recording each
+// request.
+type stepExecutor struct {
+	mu   sync.Mutex
+	reqs []ExecRequest
+}
+
+func (e *stepExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	e.mu.Lock()
+	e.reqs = append(e.reqs, req)
+	e.mu.Unlock()
+	if req.Command == "false" {
+		return ExecResponse{ExitCode: 1, Duration: 5}, nil
+	}
+	return ExecResponse{Stdout: strings.Join(req.Args, " ") + "\n", Duration: 5}, nil
+}
+
+func TestRunNodesSteps(t *testing.T) {
+	exec := &stepExecutor{}
+	client := NewClient(Config{})
+	client.Executor = exec
+	task := &TaskSpec{Env: map[string]string{"MODE": "fast"}, Steps: []TaskSpec{
+		{Name: "resolve", Command: "echo", Args: []string{"example.com"}},
+		{Name: "probe", Command: "echo", Args: []string{"${step_resolve}"}, Env: map[string]string{"TARGET": "${step_resolve}"}},
+	}}
+	results, err := client.RunNodes(context.Background(), []Node{{Name: "w-1"}}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; !r.OK() || r.Step != "probe" || r.Stdout != "example.com\n" || r.Duration != 10*time.Millisecond {
+		t.Errorf("result = %+v", r)
+	}
+	probe := exec.reqs[1]
+	if want := []string{"MODE=fast", "TARGET=example.com"}; !reflect.DeepEqual(probe.Env, want) {
+		t.Errorf("probe env = %v, want %v", probe.Env, want)
+	}
+
+	exec.reqs = nil
+	task.Steps = append(task.Steps[:1], TaskSpec{Name: "check", Command: "false"}, TaskSpec{Name: "never", Command: "echo"})
+	results, err = client.RunNodes(context.Background(), []Node{{Name: "w-1"}}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; r.ExitCode != 1 || r.Step != "check" {
+		t.Errorf("result = %+v, want check to fail", r)
+	}
+	if len(exec.reqs) != 2 {
+		t.Errorf("ran %d steps, want the chain to stop at check", len(exec.reqs))
+	}
+}
+
+// labelProvider lists fixed node labels, filtered by fleet like the cloud
+// providers.
+type labelProvider struct {
+	staticProvider
+	labels []string
+}
+
+func (p *labelProvider) ListNodes(ctx context.Context, name string) ([]Node, error) {
+	var nodes []Node
+	for _, l := range p.labels {
+		nodes = append(nodes, Node{Name: l})
+	}
+	if name == "" {
+		return nodes, nil
+	}
+	return providers.FleetNodes(nodes, name), nil
+}
+
+func TestRequireNodes(t *testing.T) {
+	p := &labelProvider{}
+	RegisterProvider("labelcloud", func(Config) Provider { return p })
+	client := NewClient(Config{})
+	client.Provider = "labelcloud"
+	ctx := context.Background()
+
+	_, err := client.RequireNodes(ctx, "web")
+	var empty *EmptyFleetError
+	if !errors.As(err, &empty) || empty.Total != 0 || !strings.Contains(err.Error(), "lists no instances") {
+		t.Errorf("no instances: %v", err)
+	}
+	p.labels = []string{"scan-1", "scan-2", "webserver-1"}
+	if _, err := client.Run(ctx, "web", &TaskSpec{Command: "true"}); !errors.As(err, &empty) || strings.Join(empty.Fleets, ",") != "scan,webserver" {
+		t.Errorf("other fleets: %v", err)
+	}
+	p.labels = append(p.labels, "web-1")
+	if nodes, err := client.RequireNodes(ctx, "web"); err != nil || len(nodes) != 1 {
+		t.Errorf("nodes = %v, %v", nodes, err)
+	}
+}
+
+// dualStackProvider lists one node with both address families.
+type dualStackProvider struct{ staticProvider }
+
+func (p *dualStackProvider) ListNodes(ctx context.Context, name string) ([]Node, error) {
+	return []Node{{Name: name + "-1", IP: "198.51.100.7", IPv6: "2001:db8::7"}}, nil
+}
+
+func TestPreferIPv6PerClient(t *testing.T) {
+	RegisterProvider("dualcloud", func(Config) Provider { return &dualStackProvider{} })
+	v4 := NewClient(Config{})
+	var cfg Config
+	cfg.Defaults.PreferIPv6 = true
+	v6 := NewClient(cfg)
+	ctx := context.Background()
+
+	// Two clients in one process each dial by their own preference.
+	for _, tc := range []struct {
+		client *Client
+		want   string
+	}{{v4, "198.51.100.7"}, {v6, "2001:db8::7"}, {v4, "198.51.100.7"}} {
+		tc.client.Provider = "dualcloud"
+		nodes, err := tc.client.ListNodes(ctx, "w")
+		if err != nil || len(nodes) != 1 || nodes[0].DialIP() != tc.want {
+			t.Fatalf("nodes = %+v, %v; want dialled on %s", nodes, err, tc.want)
+		}
+		if n, err := tc.client.GetNode(ctx, "w", "w-1"); err != nil || n.DialIP() != tc.want {
+			t.Fatalf("GetNode = %+v, %v; want dialled on %s", n, err, tc.want)
+		}
+	}
+}
+
+func TestListNodesCache(t *testing.T) {
+	p := &countingProvider{staticProvider: staticProvider{Hosts: []string{"10.0.0.7"}}}
+	RegisterProvider("countcloud", func(Config) Provider { return p })
+	store, err := core.OpenStore(filepath.Join(t.TempDir(), "gaxx.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	client := NewClient(Config{})
+	client.Provider = "countcloud"
+	client.NodeCache, client.NodeCacheTTL = store, time.Minute
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if nodes, err := client.ListNodes(ctx, "w"); err != nil || len(nodes) != 1 || nodes[0].IP != "10.0.0.7" {
+			t.Fatalf("nodes = %+v, %v", nodes, err)
+		}
+	}
+	if p.lists != 1 {
+		t.Errorf("provider listed %d times, want 1", p.lists)
+	}
+
+	// Deleting the fleet drops its listing; failures aren't cached.
+	if err := client.Delete(ctx, "w"); err != nil {
+		t.Fatal(err)
+	}
+	p.down = true
+	if _, err := client.ListNodes(ctx, "w"); err == nil {
+		t.Fatal("listing served from the cache after Delete")
+	}
+	p.down = false
+	if _, err := client.ListNodes(ctx, "w"); err != nil || p.lists != 3 {
+		t.Errorf("after a failure: %v, %d lists", err, p.lists)
+	}
+}
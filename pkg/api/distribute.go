@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+)
+
+// DefaultFanout is how many peers each node holding a copy serves per round
+// in Distribute.
+const DefaultFanout = 4
+
+// DistributeResult is the outcome of copying a file to one node. Source is
+// the node it was copied from, or empty for the seed, which received it from
+// the controller.
+type DistributeResult struct {
+	Node   Node
+	Source string
+	Err    error
+}
+
+// peerFetchScript downloads $3 to $1 from a peer agent, passing curl the
+// extra options in $2, and reading the agent token from stdin so it never
+// appears in a command line or access log.
+const peerFetchScript = `IFS= read -r tok; mkdir -p "$(dirname "$1")" && ` +
+	`curl -fsS $2 --retry 3 -C - -H "X-Auth-Token: $tok" -o "$1.part" "$3" && mv "$1.part" "$1"`
+
+// Distribute uploads localPath to remotePath on the seed node (nodes[seed])
+// and then has nodes copy it from each other through their agents: in each
+// round every node that has the file serves up to fanout others. The
+// controller uploads once, however large the fleet. remotePath must lie under
+// the agents' share directory (agent.DefaultShareDir). Results are in node
+// order.
+func (c *Client) Distribute(ctx context.Context, nodes []Node, seed int, localPath, remotePath string, fanout int) ([]DistributeResult, error) {
+	if seed < 0 || seed >= len(nodes) {
+		return nil, fmt.Errorf("seed index %d out of range for %d nodes", seed, len(nodes))
+	}
+	if !strings.HasPrefix(path.Clean(remotePath), agent.DefaultShareDir+"/") {
+		return nil, fmt.Errorf("remote path %s must be under %s so peers can serve it", remotePath, agent.DefaultShareDir)
+	}
+	upload := func(ctx context.Context, n Node) error {
+		return c.transfer().Upload(ctx, n, localPath, remotePath)
+	}
+	return distribute(ctx, c.executor(), c.agent, upload, nodes, seed, path.Clean(remotePath), fanout), nil
+}
+
+func distribute(ctx context.Context, exec Executor, conn *core.AgentConn, upload func(context.Context, Node) error, nodes []Node, seed int, remotePath string, fanout int) []DistributeResult {
+	if fanout <= 0 {
+		fanout = DefaultFanout
+	}
+	results := make([]DistributeResult, len(nodes))
+	for i, n := range nodes {
+		results[i].Node = n
+	}
+	if err := upload(ctx, nodes[seed]); err != nil {
+		for i := range results {
+			results[i].Err = fmt.Errorf("seed %s: %w", nodes[seed].Name, err)
+		}
+		return results
+	}
+
+	sources := []Node{nodes[seed]}
+	var pending []int
+	for i := range nodes {
+		if i != seed {
+			pending = append(pending, i)
+		}
+	}
+	token := os.Getenv("GAXX_AGENT_TOKEN")
+	for len(pending) > 0 && ctx.Err() == nil {
+		n := len(sources) * fanout
+		if n > len(pending) {
+			n = len(pending)
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		var wg sync.WaitGroup
+		for j, idx := range batch {
+			wg.Add(1)
+			go func(idx int, src Node) {
+				defer wg.Done()
+				results[idx].Source = src.Name
+				results[idx].Err = fetchFromPeer(ctx, exec, conn, nodes[idx], src, remotePath, token)
+			}(idx, sources[j%len(sources)])
+		}
+		wg.Wait()
+		for _, idx := range batch {
+			if results[idx].Err == nil {
+				sources = append(sources, nodes[idx])
+			}
+		}
+	}
+	for _, idx := range pending {
+		results[idx].Err = ctx.Err()
+	}
+	return results
+}
+
+// fetchFromPeer has node download remotePath from src's agent, at the URL
+// conn reaches it on.
+func fetchFromPeer(ctx context.Context, exec Executor, conn *core.AgentConn, node, src Node, remotePath, token string) error {
+	u := conn.URL(src, "/v0/files?path="+url.QueryEscape(remotePath))
+	// Nodes don't hold the controller's CA bundle, so over TLS they skip
+	// verifying their peers; the agent token still authenticates them.
+	var opts string
+	if strings.HasPrefix(u, "https://") {
+		opts = "--insecure"
+	}
+	resp, err := exec.Exec(ctx, node, ExecRequest{
+		Command: "sh",
+		Args:    []string{"-c", peerFetchScript, "sh", remotePath, opts, u},
+		Input:   token + "\n",
+	})
+	if err != nil {
+		return err
+	}
+	if resp.ExitCode != 0 {
+		msg := strings.TrimSpace(resp.Stderr + resp.Stdout)
+		return fmt.Errorf("fetch from %s: exit code %d: %s", src.Name, resp.ExitCode, msg)
+	}
+	return nil
+}
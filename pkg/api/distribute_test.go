@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// peerExecutor fakes nodes fetching from peers, failing for "bad".
+type peerExecutor struct {
+	mu   sync.Mutex
+	from map[string]string
+}
+
+func (e *peerExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	url := req.Args[len(req.Args)-1]
+	e.from[node.Name] = url
+	if node.Name == "bad" {
+		return ExecResponse{ExitCode: 22, Stderr: "curl: (22) 404"}, nil
+	}
+	return ExecResponse{}, nil
+}
+
+func TestDistribute(t *testing.T) {
+	var nodes []Node
+	for i, name := range []string{"n0", "n1", "bad", "n3", "n4", "n5"} {
+		nodes = append(nodes, Node{Name: name, IP: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	exec := &peerExecutor{from: map[string]string{}}
+	var uploads []string
+	upload := func(ctx context.Context, n Node) error {
+		uploads = append(uploads, n.Name)
+		return nil
+	}
+
+	results := distribute(context.Background(), exec, nil, upload, nodes, 0, "/tmp/gaxx/files/big.bin", 1)
+
+	if len(uploads) != 1 || uploads[0] != "n0" {
+		t.Fatalf("controller uploads = %v, want only the seed", uploads)
+	}
+	// Round 1: n0 serves n1. Round 2: n0 and n1 serve bad and n3.
+	// Round 3: n0, n1, n3 serve n4 and n5; the failed node serves nobody.
+	want := map[string]string{"n0": "", "n1": "n0", "bad": "n0", "n3": "n1", "n4": "n0", "n5": "n1"}
+	for _, r := range results {
+		if r.Source != want[r.Node.Name] {
+			t.Errorf("%s copied from %q, want %q", r.Node.Name, r.Source, want[r.Node.Name])
+		}
+		if (r.Err != nil) != (r.Node.Name == "bad") {
+			t.Errorf("%s: err = %v", r.Node.Name, r.Err)
+		}
+	}
+	if u := exec.from["n3"]; !strings.HasPrefix(u, "http://10.0.0.1:8088/v0/files?path=%2Ftmp%2Fgaxx") {
+		t.Errorf("n3 fetched %s", u)
+	}
+
+	failed := distribute(context.Background(), exec, nil, func(context.Context, Node) error { return fmt.Errorf("no route") }, nodes, 0, "/tmp/gaxx/x", 1)
+	for _, r := range failed {
+		if r.Err == nil {
+			t.Errorf("%s: expected error when the seed upload fails", r.Node.Name)
+		}
+	}
+}
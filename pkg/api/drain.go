@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+)
+
+// drainPollInterval is how often Drain checks for commands still running.
+const drainPollInterval = 2 * time.Second
+
+// ErrAgentDraining is returned by executions on a node whose agent is being
+// drained.
+var ErrAgentDraining = core.ErrAgentDraining
+
+// DrainResult is the outcome of draining one node. Jobs counts the commands
+// still running when Drain stopped waiting, so non-zero means the timeout
+// cut them off. Err is set when the agent could not be reached; such a node
+// has nothing to wait for.
+type DrainResult struct {
+	Node Node
+	Jobs int
+	Err  error
+}
+
+// Drain tells every node's agent to stop accepting new work, then waits up
+// to timeout for the commands already running to finish. Call it before
+// Delete so long scans can write their output before teardown.
+func (c *Client) Drain(ctx context.Context, nodes []Node, timeout time.Duration) []DrainResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out := make([]DrainResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			out[i] = drainNode(ctx, c.agent, n)
+		}(i, n)
+	}
+	wg.Wait()
+	return out
+}
+
+func drainNode(ctx context.Context, conn *core.AgentConn, node Node) DrainResult {
+	d, err := conn.Drain(ctx, node)
+	if err != nil {
+		return DrainResult{Node: node, Err: err}
+	}
+	for d.Jobs > 0 {
+		select {
+		case <-ctx.Done():
+			return DrainResult{Node: node, Jobs: d.Jobs}
+		case <-time.After(drainPollInterval):
+		}
+		next, err := conn.Drain(ctx, node)
+		if err != nil {
+			// Keep the last count; the wait ends at the deadline.
+			continue
+		}
+		d = next
+	}
+	return DrainResult{Node: node}
+}
@@ -0,0 +1,38 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/3cpo-dev/gaxx/pkg/api"
+)
+
+// Spawn a fleet, run a command on every node, and tear it down again.
+func ExampleClient() {
+	cfg, err := api.LoadConfig("") // $GAXX_CONFIG or ~/.config/gaxx/config.yaml
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := api.NewClient(cfg)
+	client.Provider = "linode"
+	client.Concurrency = 5
+
+	ctx := context.Background()
+	if _, err := client.Spawn(ctx, api.CreateFleetRequest{Name: "workers", Count: 3}, api.SpawnTopUp); err != nil {
+		log.Fatal(err)
+	}
+	defer client.Delete(ctx, "workers")
+
+	results, err := client.Run(ctx, "workers", &api.TaskSpec{Name: "uptime", Command: "uptime"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range results {
+		if !r.OK() {
+			fmt.Printf("%s failed: exit %d, %v\n", r.Node.Name, r.ExitCode, r.Err)
+			continue
+		}
+		fmt.Printf("%s: %s", r.Node.Name, r.Stdout)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/3cpo-dev/gaxx/modules"
+	"gopkg.in/yaml.v3"
+)
+
+// BundledSource is the Source of modules compiled into gaxx.
+const BundledSource = "bundled"
+
+// ErrModuleNotFound is returned for a module name not on the search path.
+var ErrModuleNotFound = errors.New("module not found")
+
+// ModuleInfo describes a module on the search path.
+type ModuleInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Source is the module's file, or BundledSource.
+	Source string `json:"source"`
+}
+
+// isModulePath reports whether ref names a file rather than a module.
+func isModulePath(ref string) bool {
+	ext := filepath.Ext(ref)
+	return strings.ContainsRune(ref, '/') || strings.ContainsRune(ref, filepath.Separator) || ext == ".yaml" || ext == ".yml"
+}
+
+// ReadModule returns the YAML of module ref and where it came from. An
+// https:// or repo:// ref is fetched, optionally pinned with
+// #sha256=<hex>; a ref with a directory or a .yaml/.yml extension is read
+// as a file; otherwise ref is looked up as <ref>.yaml or <ref>.yml in each
+// of cfg.Modules.Path, then among the bundled modules.
+func ReadModule(cfg Config, ref string) ([]byte, string, error) {
+	if isRemoteModule(ref) {
+		return fetchModule(cfg, ref)
+	}
+	if isModulePath(ref) {
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return nil, "", fmt.Errorf("read module: %w", err)
+		}
+		return data, ref, nil
+	}
+	for _, dir := range cfg.Modules.Path {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(dir, ref+ext)
+			data, err := os.ReadFile(path)
+			if err == nil {
+				return data, path, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, "", fmt.Errorf("read module: %w", err)
+			}
+		}
+	}
+	if data, err := modules.Bundled.ReadFile(ref + ".yaml"); err == nil {
+		return data, BundledSource, nil
+	}
+	return nil, "", fmt.Errorf("%s: %w (see gaxx module list)", ref, ErrModuleNotFound)
+}
+
+// FindTaskModule loads module ref, found as described for ReadModule.
+func FindTaskModule(cfg Config, ref string) (TaskSpec, error) {
+	data, source, err := ReadModule(cfg, ref)
+	if err != nil {
+		return TaskSpec{}, err
+	}
+	if source == BundledSource {
+		source = ref
+	}
+	return parseTaskModule(data, source)
+}
+
+// ListModules lists the modules on cfg.Modules.Path and the bundled ones,
+// sorted by name. A module shadows any of the same name later on the path.
+func ListModules(cfg Config) ([]ModuleInfo, error) {
+	seen := map[string]bool{}
+	var out []ModuleInfo
+	// add lists the modules in fsys, which is dir, or the bundled modules
+	// when dir is empty.
+	add := func(fsys fs.FS, dir string) error {
+		entries, err := fs.ReadDir(fsys, ".")
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("list modules in %s: %w", dir, err)
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			name := strings.TrimSuffix(e.Name(), ext)
+			if e.IsDir() || (ext != ".yaml" && ext != ".yml") || seen[name] {
+				continue
+			}
+			data, err := fs.ReadFile(fsys, e.Name())
+			if err != nil {
+				return err
+			}
+			info := ModuleInfo{Name: name, Source: BundledSource}
+			if dir != "" {
+				info.Source = filepath.Join(dir, e.Name())
+			}
+			var spec TaskSpec
+			if err := yaml.Unmarshal(data, &spec); err != nil {
+				info.Description = fmt.Sprintf("(invalid: %v)", err)
+			} else {
+				info.Description = spec.Description
+			}
+			seen[name] = true
+			out = append(out, info)
+		}
+		return nil
+	}
+	for _, dir := range cfg.Modules.Path {
+		if err := add(os.DirFS(dir), dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(modules.Bundled, ""); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModuleSearchPath(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("http_probe.yaml", "name: http_probe\ndescription: local probe\ncommand: curl\n")
+	write("whois.yml", "name: whois\ndescription: look up owners\ncommand: whois\n")
+	write("notes.txt", "not a module")
+	var cfg Config
+	cfg.Modules.Path = []string{filepath.Join(dir, "missing"), dir}
+
+	mods, err := ListModules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]ModuleInfo{}
+	for _, m := range mods {
+		got[m.Name] = m
+	}
+	if len(mods) != 4 || got["port_scan"].Source != BundledSource || got["whois"].Description != "look up owners" {
+		t.Errorf("modules = %+v", mods)
+	}
+	// An installed module shadows the bundled one of the same name.
+	if got["http_probe"].Source != filepath.Join(dir, "http_probe.yaml") {
+		t.Errorf("http_probe from %s", got["http_probe"].Source)
+	}
+
+	for ref, want := range map[string]string{
+		"http_probe":                    "curl",
+		"whois":                         "whois",
+		"port_scan":                     "sh",
+		filepath.Join(dir, "whois.yml"): "whois",
+	} {
+		spec, err := FindTaskModule(cfg, ref)
+		if err != nil || spec.Command != want {
+			t.Errorf("%s: command %q, %v", ref, spec.Command, err)
+		}
+	}
+	if _, err := FindTaskModule(cfg, "nmap"); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("nmap: err = %v", err)
+	}
+	if _, _, err := ReadModule(cfg, "nmap.yaml"); err == nil || errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestRemoteModules(t *testing.T) {
+	const module = "name: whois\ncommand: whois\n"
+	fetches := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/team/whois.yaml":
+			io.WriteString(w, module)
+		case "/team/broken.yaml":
+			io.WriteString(w, "name: broken\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { moduleClient = c }(moduleClient)
+	moduleClient = srv.Client()
+
+	var cfg Config
+	cfg.Modules.Repo = srv.URL + "/team/"
+	cfg.Modules.Cache = t.TempDir()
+	sum := sha256Hex([]byte(module))
+
+	_, source, err := ReadModule(cfg, "repo://whois")
+	if want := srv.URL + "/team/whois.yaml#sha256=" + sum; err != nil || source != want {
+		t.Fatalf("source = %q, %v; want %q", source, err, want)
+	}
+	// A pinned module is served from the cache once fetched.
+	spec, err := FindTaskModule(cfg, source)
+	if err != nil || spec.Command != "whois" || fetches != 1 {
+		t.Errorf("pinned: %+v, %v after %d fetches", spec, err, fetches)
+	}
+
+	wrong := srv.URL + "/team/whois.yaml#sha256=" + sha256Hex([]byte("other"))
+	if _, _, err := ReadModule(cfg, wrong); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("wrong pin: err = %v", err)
+	}
+	var merr *ModuleError
+	if _, err := FindTaskModule(cfg, "repo://broken"); !errors.As(err, &merr) {
+		t.Errorf("invalid module: err = %v", err)
+	}
+	for _, ref := range []string{"repo://missing", "http://example.com/m.yaml", srv.URL + "/team/whois.yaml#md5=abc"} {
+		if _, _, err := ReadModule(cfg, ref); err == nil {
+			t.Errorf("%s: no error", ref)
+		}
+	}
+	cfg.Modules.Repo = ""
+	if _, _, err := ReadModule(cfg, "repo://whois"); err == nil {
+		t.Error("repo:// without modules.repo: no error")
+	}
+}
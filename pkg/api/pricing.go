@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// ErrNoPrice is returned when no hourly price is known for a size.
+var ErrNoPrice = providers.ErrNoPrice
+
+// Prices fetches the provider's current hourly USD prices by size. Providers
+// that can't list prices return an error.
+func (c *Client) Prices(ctx context.Context) (map[string]float64, error) {
+	p, err := c.provider()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := p.(providers.PriceLister)
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not list prices", p.Name())
+	}
+	prices, err := lister.Prices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s prices: %w", p.Name(), err)
+	}
+	return prices, nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RepoScheme prefixes module references resolved against cfg.Modules.Repo.
+const RepoScheme = "repo://"
+
+// maxModuleSize bounds a fetched module; real ones are a few hundred bytes.
+const maxModuleSize = 1 << 20
+
+// moduleClient fetches remote modules.
+var moduleClient = &http.Client{Timeout: 30 * time.Second}
+
+// isRemoteModule reports whether ref is fetched rather than read locally.
+func isRemoteModule(ref string) bool {
+	return strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, RepoScheme)
+}
+
+// remoteModuleURL splits ref into the URL to fetch and the pinned sha256,
+// if any. repo://path is resolved against cfg.Modules.Repo, adding .yaml
+// when path has no extension.
+func remoteModuleURL(cfg Config, ref string) (string, string, error) {
+	url, pin, _ := strings.Cut(ref, "#")
+	if pin != "" {
+		sum, ok := strings.CutPrefix(pin, "sha256=")
+		if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != sha256.Size*2 {
+			return "", "", fmt.Errorf("module %s: pin must be #sha256=<64 hex digits>", ref)
+		}
+		pin = strings.ToLower(sum)
+	}
+	if p, ok := strings.CutPrefix(url, RepoScheme); ok {
+		if cfg.Modules.Repo == "" {
+			return "", "", fmt.Errorf("module %s: modules.repo is not set in the config", ref)
+		}
+		if ext := path.Ext(p); ext != ".yaml" && ext != ".yml" {
+			p += ".yaml"
+		}
+		url = strings.TrimSuffix(cfg.Modules.Repo, "/") + "/" + strings.TrimPrefix(p, "/")
+	}
+	if !strings.HasPrefix(url, "https://") {
+		return "", "", fmt.Errorf("module %s: remote modules must be fetched over https", ref)
+	}
+	return url, pin, nil
+}
+
+// fetchModule returns remote module ref, from the cache when it is pinned
+// and already fetched. The source returned is the URL pinned to the
+// module's sha256, ready to paste into a workflow for reproducible runs.
+func fetchModule(cfg Config, ref string) ([]byte, string, error) {
+	url, pin, err := remoteModuleURL(cfg, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if pin != "" && cfg.Modules.Cache != "" {
+		data, err := os.ReadFile(filepath.Join(cfg.Modules.Cache, pin+".yaml"))
+		if err == nil && sha256Hex(data) == pin {
+			return data, url + "#sha256=" + pin, nil
+		}
+	}
+
+	resp, err := moduleClient.Get(url)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch module: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch module %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxModuleSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch module %s: %w", url, err)
+	}
+	if len(data) > maxModuleSize {
+		return nil, "", fmt.Errorf("fetch module %s: larger than %d bytes", url, maxModuleSize)
+	}
+	sum := sha256Hex(data)
+	if pin != "" && sum != pin {
+		return nil, "", fmt.Errorf("fetch module %s: checksum mismatch: got sha256=%s, want %s", url, sum, pin)
+	}
+	// The cache only saves refetching pinned modules, so failing to write
+	// it doesn't fail the fetch.
+	_ = cacheModule(cfg.Modules.Cache, sum, data)
+	return data, url + "#sha256=" + sum, nil
+}
+
+// cacheModule stores data in dir under its checksum.
+func cacheModule(dir, sum string, data []byte) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, sum+".yaml"))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
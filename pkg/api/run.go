@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/agent"
+	"github.com/3cpo-dev/gaxx/internal/core"
+)
+
+// remoteChunkDir is where input chunks are written on each node.
+const remoteChunkDir = agent.ChunkDir
+
+// readInputs resolves task inputs into items. An input naming a readable
+// file contributes its non-empty lines; anything else is taken literally.
+func readInputs(inputs []string) ([]string, error) {
+	var items []string
+	for _, in := range inputs {
+		f, err := os.Open(in)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				items = append(items, in)
+				continue
+			}
+			return nil, fmt.Errorf("open input %s: %w", in, err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				items = append(items, line)
+			}
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("read input %s: %w", in, err)
+		}
+	}
+	return items, nil
+}
+
+// commandLine returns the command and args to execute for the task: as
+// given, or wrapped in sh -c when the task asks for a shell.
+func (t *TaskSpec) commandLine() (string, []string) {
+	if !t.Shell {
+		return t.Command, t.Args
+	}
+	return "sh", []string{"-c", strings.Join(append([]string{t.Command}, t.Args...), " ")}
+}
+
+// buildExecRequests turns a task into one request per node, or one per chunk
+// of inputs when the task has any. Chunks are assigned to nodes round-robin;
+// each chunk is sent as stdin, written to a file on the node, and substituted
+// for {{ item }} in the command arguments.
+func buildExecRequests(task *TaskSpec, nodes []Node, timeout time.Duration) ([]Node, []agent.ExecRequest, error) {
+	var env []string
+	for k, v := range task.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	command, cmdArgs := task.commandLine()
+	base := agent.ExecRequest{
+		Command: command,
+		Args:    cmdArgs,
+		Env:     env,
+		Timeout: int(timeout.Seconds()),
+		Limits:  task.Limits,
+	}
+
+	items, err := readInputs(task.Inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		reqNodes := make([]Node, len(nodes))
+		reqs := make([]agent.ExecRequest, len(nodes))
+		copy(reqNodes, nodes)
+		for i := range nodes {
+			reqs[i] = base
+		}
+		return reqNodes, reqs, nil
+	}
+
+	name := task.Name
+	if name == "" {
+		name = "task"
+	}
+	chunks := core.ChunkInputs(items, task.ChunkSize.resolve(len(items), len(nodes), task.ChunksPerNode))
+	reqNodes := make([]Node, 0, len(chunks))
+	reqs := make([]agent.ExecRequest, 0, len(chunks))
+	for i, chunk := range chunks {
+		path := fmt.Sprintf("%s/%s-%d.txt", remoteChunkDir, name, i+1)
+		args := make([]string, len(cmdArgs))
+		for j, a := range cmdArgs {
+			args[j] = strings.ReplaceAll(a, "{{ item }}", path)
+		}
+		// This sh only stages the chunk file from stdin; the task's command
+		// and args reach exec "$@" unparsed, so no shell sees them unless
+		// the task asks for one with Shell. Agents check their command
+		// policy against the command, not this sh.
+		req := base
+		req.Command = "sh"
+		req.Args = append([]string{"-c", agent.StageScript, path, command}, args...)
+		req.Input = strings.Join(chunk, "\n") + "\n"
+		reqNodes = append(reqNodes, nodes[i%len(nodes)])
+		reqs = append(reqs, req)
+	}
+	return reqNodes, reqs, nil
+}
+
+// stepTask returns the task a step runs as: the step's command, args and
+// shell, the task's env overlaid with the step's, the step's limits or else
+// the task's, and the task's name and inputs so every step gets the same
+// chunks on the same nodes.
+func (t *TaskSpec) stepTask(step TaskSpec) TaskSpec {
+	st := *t
+	st.Command, st.Args, st.Steps = step.Command, step.Args, nil
+	st.Shell = t.Shell || step.Shell
+	if step.Limits != nil {
+		st.Limits = step.Limits
+	}
+	st.Env = make(map[string]string, len(t.Env)+len(step.Env))
+	for k, v := range t.Env {
+		st.Env[k] = v
+	}
+	for k, v := range step.Env {
+		st.Env[k] = v
+	}
+	return st
+}
+
+// stepRequest is one step of a chain, as sent for one chunk.
+type stepRequest struct {
+	name string
+	req  agent.ExecRequest
+}
+
+// buildStepRequests is buildExecRequests for a task with Steps: chains[i]
+// holds, in order, the requests of each step for the i-th node or chunk.
+func buildStepRequests(task *TaskSpec, nodes []Node, timeout time.Duration) ([]Node, [][]stepRequest, error) {
+	var reqNodes []Node
+	var chains [][]stepRequest
+	for _, step := range task.Steps {
+		st := task.stepTask(step)
+		stepNodes, reqs, err := buildExecRequests(&st, nodes, timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		if chains == nil {
+			reqNodes, chains = stepNodes, make([][]stepRequest, len(reqs))
+		}
+		for i, req := range reqs {
+			chains[i] = append(chains[i], stepRequest{name: step.Name, req: req})
+		}
+	}
+	return reqNodes, chains, nil
+}
+
+// chainExecutor runs one chunk's steps in order, ignoring the request it is
+// given, and stops at the first step that fails or exits non-zero. Each
+// step's trimmed stdout replaces ${step_<name>} in the steps after it. The
+// response is that of the step it stopped at, with the steps' durations
+// summed.
+type chainExecutor struct {
+	Executor
+	steps []stepRequest
+
+	mu   sync.Mutex
+	last map[string]string // node name -> step run last there
+}
+
+func newChainExecutor(exec Executor, steps []stepRequest) *chainExecutor {
+	return &chainExecutor{Executor: exec, steps: steps, last: map[string]string{}}
+}
+
+func (c *chainExecutor) Exec(ctx context.Context, node Node, _ agent.ExecRequest) (agent.ExecResponse, error) {
+	outputs := map[string]string{}
+	var resp agent.ExecResponse
+	var took int64
+	for _, s := range c.steps {
+		c.mu.Lock()
+		c.last[node.Name] = s.name
+		c.mu.Unlock()
+		var err error
+		resp, err = c.Executor.Exec(ctx, node, expandRequest(s.req, outputs))
+		took += resp.Duration
+		if err != nil {
+			return resp, fmt.Errorf("step %s: %w", s.name, err)
+		}
+		if resp.ExitCode != 0 {
+			break
+		}
+		outputs[stepVarPrefix+s.name] = strings.TrimRight(resp.Stdout, "\r\n")
+	}
+	resp.Duration = took
+	return resp, nil
+}
+
+// lastStep returns the step the chain last ran on node: the failing one, or
+// the final one when all succeeded.
+func (c *chainExecutor) lastStep(node string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last[node]
+}
+
+// expandRequest returns req with ${name} references in its command, args
+// and env values replaced from vars.
+func expandRequest(req agent.ExecRequest, vars map[string]string) agent.ExecRequest {
+	if len(vars) == 0 {
+		return req
+	}
+	req.Command = expandVars(req.Command, vars)
+	args := make([]string, len(req.Args))
+	for i, a := range req.Args {
+		args[i] = expandVars(a, vars)
+	}
+	env := make([]string, len(req.Env))
+	for i, kv := range req.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[i] = k + "=" + expandVars(v, vars)
+	}
+	req.Args, req.Env = args, env
+	return req
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Selector is a parsed node selector expression such as
+//
+//	tag.role==scanner && index<5
+//
+// Fields are name, ip, ipv6, index (the number ending the node name,
+// "scan-3" is 3, or 0 without one) and tag.<key>, the value of a "key:value"
+// or "key=value" tag. ip is the IPv4 address and ipv6 the IPv6 one, empty
+// when the node has none. index also compares with <, <=, > and >=; the
+// rest compare with ==, != and =~ (a glob such as 'scan-*'). A tag on its
+// own, like tag.gpu, matches nodes that have it. Combine with &&, ||, ! and
+// parentheses; values with spaces or operators go in quotes.
+type Selector struct {
+	pred func(Node) bool
+}
+
+// ParseSelector parses a selector expression.
+func ParseSelector(expr string) (*Selector, error) {
+	toks, err := tokenizeSelector(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", expr, err)
+	}
+	p := &selectorParser{toks: toks}
+	pred, err := p.parseOr()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %w", expr, err)
+	}
+	return &Selector{pred: pred}, nil
+}
+
+// Match reports whether the node satisfies the selector.
+func (s *Selector) Match(n Node) bool { return s.pred(n) }
+
+// SelectNodes returns the nodes matching the selector expression, in order.
+// An empty expression selects every node.
+func SelectNodes(nodes []Node, expr string) ([]Node, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nodes, nil
+	}
+	sel, err := ParseSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+	var out []Node
+	for _, n := range nodes {
+		if sel.Match(n) {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// nodeIndex returns the number ending a node name ("scan-3" is 3), or 0.
+func nodeIndex(name string) int {
+	i := strings.LastIndexByte(name, '-')
+	n, err := strconv.Atoi(name[i+1:])
+	if i < 0 || err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// nodeTag returns the value of the node's key tag and whether it has one.
+// A plain tag equal to key has an empty value.
+func nodeTag(n Node, key string) (string, bool) {
+	for _, t := range n.Tags {
+		if t == key {
+			return "", true
+		}
+		if k, v, ok := strings.Cut(t, ":"); ok && k == key {
+			return v, true
+		}
+		if k, v, ok := strings.Cut(t, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+type selectorToken struct {
+	text   string
+	quoted bool
+}
+
+// selectorOps are the operator tokens, longest first.
+var selectorOps = []string{"&&", "||", "==", "!=", "=~", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func tokenizeSelector(s string) ([]selectorToken, error) {
+	var toks []selectorToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			toks = append(toks, selectorToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+			continue
+		}
+		op := ""
+		for _, o := range selectorOps {
+			if strings.HasPrefix(s[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op != "" {
+			toks = append(toks, selectorToken{text: op})
+			i += len(op)
+			continue
+		}
+		j := i
+		for j < len(s) && !strings.ContainsRune(" \t\"'&|=!<>()", rune(s[j])) {
+			j++
+		}
+		if j == i {
+			return nil, fmt.Errorf("unexpected %q", s[i:i+1])
+		}
+		toks = append(toks, selectorToken{text: s[i:j]})
+		i = j
+	}
+	return toks, nil
+}
+
+type selectorParser struct {
+	toks []selectorToken
+	pos  int
+}
+
+// accept consumes the next token if it is the unquoted operator op.
+func (p *selectorParser) accept(op string) bool {
+	if p.pos < len(p.toks) && !p.toks[p.pos].quoted && p.toks[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) next() (selectorToken, bool) {
+	if p.pos >= len(p.toks) {
+		return selectorToken{}, false
+	}
+	p.pos++
+	return p.toks[p.pos-1], true
+}
+
+func (p *selectorParser) parseOr() (func(Node) bool, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right func(Node) bool
+		if right, err = p.parseAnd(); err == nil {
+			l := left
+			left = func(n Node) bool { return l(n) || right(n) }
+		}
+	}
+	return left, err
+}
+
+func (p *selectorParser) parseAnd() (func(Node) bool, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right func(Node) bool
+		if right, err = p.parseUnary(); err == nil {
+			l := left
+			left = func(n Node) bool { return l(n) && right(n) }
+		}
+	}
+	return left, err
+}
+
+func (p *selectorParser) parseUnary() (func(Node) bool, error) {
+	if p.accept("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n Node) bool { return !inner(n) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *selectorParser) parseComparison() (func(Node) bool, error) {
+	field, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if field.quoted || strings.ContainsAny(field.text, "&|=!<>()") {
+		return nil, fmt.Errorf("expected a field, got %q", field.text)
+	}
+
+	var get func(Node) (string, bool)
+	switch {
+	case field.text == "name":
+		get = func(n Node) (string, bool) { return n.Name, true }
+	case field.text == "ip":
+		get = func(n Node) (string, bool) { return n.IP, true }
+	case field.text == "ipv6":
+		get = func(n Node) (string, bool) { return n.IPv6, true }
+	case field.text == "index":
+		return p.parseIndex()
+	case strings.HasPrefix(field.text, "tag.") && len(field.text) > len("tag."):
+		key := strings.TrimPrefix(field.text, "tag.")
+		get = func(n Node) (string, bool) { return nodeTag(n, key) }
+	default:
+		return nil, fmt.Errorf("unknown field %q (want name, ip, ipv6, index or tag.<key>)", field.text)
+	}
+
+	op, ok := p.next()
+	if !ok || op.quoted || !isComparison(op.text) {
+		if strings.HasPrefix(field.text, "tag.") {
+			if ok {
+				p.pos--
+			}
+			return func(n Node) bool { _, has := get(n); return has }, nil
+		}
+		return nil, fmt.Errorf("expected an operator after %s", field.text)
+	}
+	value, ok := p.next()
+	if !ok || (!value.quoted && isOperator(value.text)) {
+		return nil, fmt.Errorf("expected a value after %s%s", field.text, op.text)
+	}
+	want := value.text
+	switch op.text {
+	case "==":
+		return func(n Node) bool { v, has := get(n); return has && v == want }, nil
+	case "!=":
+		return func(n Node) bool { v, has := get(n); return !has || v != want }, nil
+	case "=~":
+		if _, err := path.Match(want, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", want, err)
+		}
+		return func(n Node) bool {
+			v, has := get(n)
+			matched, _ := path.Match(want, v)
+			return has && matched
+		}, nil
+	}
+	return nil, fmt.Errorf("%s only supports ==, != and =~", field.text)
+}
+
+func (p *selectorParser) parseIndex() (func(Node) bool, error) {
+	op, ok := p.next()
+	if !ok || op.quoted || !isComparison(op.text) || op.text == "=~" {
+		return nil, fmt.Errorf("expected ==, !=, <, <=, > or >= after index")
+	}
+	value, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("expected a number after index%s", op.text)
+	}
+	want, err := strconv.Atoi(value.text)
+	if err != nil {
+		return nil, fmt.Errorf("index compares with a number, got %q", value.text)
+	}
+	cmp := map[string]func(a, b int) bool{
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		"<":  func(a, b int) bool { return a < b },
+		"<=": func(a, b int) bool { return a <= b },
+		">":  func(a, b int) bool { return a > b },
+		">=": func(a, b int) bool { return a >= b },
+	}[op.text]
+	return func(n Node) bool { return cmp(nodeIndex(n.Name), want) }, nil
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "=~", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func isOperator(tok string) bool {
+	for _, o := range selectorOps {
+		if tok == o {
+			return true
+		}
+	}
+	return false
+}
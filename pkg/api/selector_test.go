@@ -0,0 +1,61 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectNodes(t *testing.T) {
+	nodes := []Node{
+		{Name: "scan-1", IP: "10.0.0.1", Tags: []string{"gaxx", "role:scanner"}},
+		{Name: "scan-2", IP: "10.0.0.2", Tags: []string{"gaxx", "role:scanner", "gpu"}},
+		{Name: "scan-7", IP: "10.0.1.7", Tags: []string{"gaxx", "role=resolver"}},
+		{Name: "lab", IP: "192.0.2.11", IPv6: "2001:db8::11"},
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "scan-1 scan-2 scan-7 lab"},
+		{"tag.role==scanner && index<2", "scan-1"},
+		{"tag.role==resolver || name==lab", "scan-7 lab"},
+		{"tag.role!=scanner", "scan-7 lab"},
+		{"tag.gpu", "scan-2"},
+		{"!tag.gpu && tag.gaxx", "scan-1 scan-7"},
+		{"ip=~'10.0.0.*'", "scan-1 scan-2"},
+		{"ipv6=~'2001:db8::*'", "lab"},
+		{"index>=2 && (tag.role==scanner || tag.role==resolver)", "scan-2 scan-7"},
+		{"index==0", "lab"},
+		{`name == "scan-2"`, "scan-2"},
+	}
+	for _, tt := range tests {
+		got, err := SelectNodes(nodes, tt.expr)
+		if err != nil {
+			t.Errorf("SelectNodes(%q): %v", tt.expr, err)
+			continue
+		}
+		var names []string
+		for _, n := range got {
+			names = append(names, n.Name)
+		}
+		if strings.Join(names, " ") != tt.want {
+			t.Errorf("SelectNodes(%q) = %v, want %s", tt.expr, names, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"role==scanner",
+		"index<five",
+		"name<scan-3",
+		"tag.role==",
+		"(index<2",
+		"index<2 name==x",
+		"name=='unterminated",
+		"ip=~'['",
+	} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("ParseSelector(%q) succeeded, want error", expr)
+		}
+	}
+}
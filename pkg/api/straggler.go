@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+)
+
+// NodeStraggler events report a chunk running much longer than the fleet's
+// median; see Client.StragglerThreshold.
+const NodeStraggler NodePhase = "straggler"
+
+// Straggler details a NodeStraggler event. Backup names the idle node the
+// chunk was also started on, if it was.
+type Straggler struct {
+	Elapsed time.Duration
+	Median  time.Duration
+	Backup  string
+}
+
+// StragglerObserver is implemented by observers that want NodeStraggler
+// events as well.
+type StragglerObserver interface {
+	NodeStraggler(NodeEvent)
+}
+
+const (
+	// minStragglerSamples is how many chunks must finish before the median
+	// is trusted.
+	minStragglerSamples = 3
+)
+
+// stragglerInterval is how often running chunks are compared with the median.
+var stragglerInterval = time.Second
+
+// stragglerTracker watches the chunks of a run for ones that take more than
+// threshold times the median of those finished, and with speculate starts
+// them again on an idle node that has only finished chunks successfully.
+type stragglerTracker struct {
+	threshold float64
+	speculate bool
+
+	mu          sync.Mutex
+	durations   []time.Duration
+	running     map[int]*runningChunk
+	outstanding map[string]int
+	// speed is the mean duration of each node's finished chunks; failed
+	// marks nodes with a failed chunk, which never take backups.
+	speed  map[string]time.Duration
+	counts map[string]int
+	failed map[string]bool
+	nodes  map[string]Node
+}
+
+// runningChunk is a chunk being executed; a backup node to also run it on
+// is sent on backup.
+type runningChunk struct {
+	node    Node
+	started time.Time
+	flagged bool
+	backup  chan Node
+	backups []string
+}
+
+func newStragglerTracker(threshold float64, speculate bool, assigned []Node) *stragglerTracker {
+	t := &stragglerTracker{
+		threshold:   threshold,
+		speculate:   speculate,
+		running:     map[int]*runningChunk{},
+		outstanding: map[string]int{},
+		speed:       map[string]time.Duration{},
+		counts:      map[string]int{},
+		failed:      map[string]bool{},
+		nodes:       map[string]Node{},
+	}
+	for _, n := range assigned {
+		t.outstanding[n.Name]++
+		t.nodes[n.Name] = n
+	}
+	return t
+}
+
+// start notes that chunk began on node and returns the channel a backup
+// node arrives on.
+func (t *stragglerTracker) start(chunk int, node Node) <-chan Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rc := &runningChunk{node: node, started: time.Now(), backup: make(chan Node, 1)}
+	t.running[chunk] = rc
+	return rc.backup
+}
+
+// finish records the chunk's outcome on winner, the node whose result
+// was used.
+func (t *stragglerTracker) finish(chunk int, winner Node, took time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rc := t.running[chunk]
+	delete(t.running, chunk)
+	if rc == nil {
+		return
+	}
+	t.outstanding[rc.node.Name]--
+	for _, b := range rc.backups {
+		t.outstanding[b]--
+	}
+	if !ok {
+		t.failed[winner.Name] = true
+		return
+	}
+	t.durations = append(t.durations, took)
+	n := t.counts[winner.Name]
+	t.speed[winner.Name] = (t.speed[winner.Name]*time.Duration(n) + took) / time.Duration(n+1)
+	t.counts[winner.Name] = n + 1
+}
+
+// check flags the chunks that have become stragglers, starting backups
+// where it can, and returns their events.
+func (t *stragglerTracker) check(now time.Time) []NodeEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.durations) < minStragglerSamples {
+		return nil
+	}
+	sorted := append([]time.Duration(nil), t.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	limit := time.Duration(t.threshold * float64(median))
+
+	chunks := make([]int, 0, len(t.running))
+	for chunk := range t.running {
+		chunks = append(chunks, chunk)
+	}
+	sort.Ints(chunks)
+	var events []NodeEvent
+	for _, chunk := range chunks {
+		rc := t.running[chunk]
+		elapsed := now.Sub(rc.started)
+		if rc.flagged || elapsed <= limit {
+			continue
+		}
+		rc.flagged = true
+		info := &Straggler{Elapsed: elapsed, Median: median}
+		if backup, ok := t.idleNode(); ok && t.speculate {
+			t.outstanding[backup.Name]++
+			rc.backups = append(rc.backups, backup.Name)
+			rc.backup <- backup
+			info.Backup = backup.Name
+		}
+		events = append(events, NodeEvent{Node: rc.node, Chunk: chunk, Phase: NodeStraggler, Straggler: info})
+	}
+	return events
+}
+
+// idleNode returns the fastest node with nothing left to run and no
+// failures.
+func (t *stragglerTracker) idleNode() (Node, bool) {
+	var best Node
+	found := false
+	for name, n := range t.nodes {
+		if t.outstanding[name] > 0 || t.failed[name] || t.counts[name] == 0 {
+			continue
+		}
+		if !found || t.speed[name] < t.speed[best.Name] || (t.speed[name] == t.speed[best.Name] && name < best.Name) {
+			best, found = n, true
+		}
+	}
+	return best, found
+}
+
+// watch checks for stragglers every stragglerInterval until ctx is done.
+func (t *stragglerTracker) watch(ctx context.Context, task string, emit func(NodeEvent)) {
+	ticker := time.NewTicker(stragglerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, ev := range t.check(now) {
+				telemetry.CounterGlobal("gaxx_task_stragglers", 1, map[string]string{"task": task, "component": "cli"})
+				emit(ev)
+				if ev.Straggler.Backup != "" {
+					emit(NodeEvent{Node: t.nodes[ev.Straggler.Backup], Chunk: ev.Chunk, Phase: NodeStarted})
+				}
+			}
+		}
+	}
+}
+
+// execOutcome is the result of running a chunk on one node.
+type execOutcome struct {
+	node Node
+	resp ExecResponse
+	err  error
+}
+
+// raceExec runs req on node and also on any backup node that arrives
+// before it finishes. The first successful result wins and the other
+// execution is cancelled; if none succeeds the last result is returned.
+func raceExec(ctx context.Context, exec Executor, node Node, req ExecRequest, backup <-chan Node) execOutcome {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan execOutcome, 2)
+	run := func(n Node) {
+		resp, err := exec.Exec(ctx, n, req)
+		done <- execOutcome{n, resp, err}
+	}
+	go run(node)
+	for pending := 1; ; {
+		select {
+		case b := <-backup:
+			backup = nil
+			pending++
+			go run(b)
+		case o := <-done:
+			pending--
+			if (o.err == nil && o.resp.ExitCode == 0) || pending == 0 {
+				return o
+			}
+		}
+	}
+}
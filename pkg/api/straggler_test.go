@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStragglerTracker(t *testing.T) {
+	nodes := []Node{{Name: "w-1"}, {Name: "w-2"}, {Name: "w-3"}, {Name: "w-4"}, {Name: "w-5"}}
+	tr := newStragglerTracker(3, true, nodes)
+	backups := make([]<-chan Node, len(nodes))
+	for i, n := range nodes {
+		backups[i] = tr.start(i+1, n)
+	}
+	if evs := tr.check(time.Now().Add(time.Hour)); len(evs) != 0 {
+		t.Fatalf("flagged %d chunks before any finished", len(evs))
+	}
+	tr.finish(1, nodes[0], 2*time.Second, true)
+	tr.finish(2, nodes[1], time.Second, true)
+	tr.finish(3, nodes[2], 3*time.Second, true)
+	tr.finish(4, nodes[3], time.Millisecond, false)
+
+	tr.mu.Lock()
+	tr.running[5].started = time.Now().Add(-2 * time.Second)
+	tr.mu.Unlock()
+	if evs := tr.check(time.Now()); len(evs) != 0 {
+		t.Fatalf("chunk under the threshold flagged: %+v", evs)
+	}
+	evs := tr.check(time.Now().Add(5 * time.Second))
+	if len(evs) != 1 || evs[0].Chunk != 5 || evs[0].Phase != NodeStraggler {
+		t.Fatalf("events = %+v", evs)
+	}
+	// w-2 is the fastest idle node; w-4 failed.
+	if st := evs[0].Straggler; st.Median != 2*time.Second || st.Backup != "w-2" {
+		t.Errorf("straggler = %+v", st)
+	}
+	if b := <-backups[4]; b.Name != "w-2" {
+		t.Errorf("backup = %s", b.Name)
+	}
+	if evs := tr.check(time.Now().Add(time.Hour)); len(evs) != 0 {
+		t.Errorf("chunk flagged twice: %+v", evs)
+	}
+}
+
+// slowExecutor takes its time on node "slow" until cancelled.
+type slowExecutor struct{}
+
+func (slowExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	wait := 10 * time.Millisecond
+	if node.Name == "slow" {
+		wait = 10 * time.Second
+	}
+	select {
+	case <-time.After(wait):
+		return ExecResponse{Stdout: node.Name + "\n"}, nil
+	case <-ctx.Done():
+		return ExecResponse{}, ctx.Err()
+	}
+}
+
+func TestRunNodesSpeculate(t *testing.T) {
+	defer func(d time.Duration) { stragglerInterval = d }(stragglerInterval)
+	stragglerInterval = 10 * time.Millisecond
+
+	client := NewClient(Config{})
+	client.Executor = slowExecutor{}
+	client.StragglerThreshold = 3
+	client.Speculate = true
+	var mu sync.Mutex
+	var stragglers []NodeEvent
+	client.OnEvent = func(ev NodeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ev.Phase == NodeStraggler {
+			stragglers = append(stragglers, ev)
+		}
+	}
+	nodes := []Node{{Name: "fast-1"}, {Name: "fast-2"}, {Name: "fast-3"}, {Name: "slow"}}
+
+	start := time.Now()
+	results, err := client.RunNodes(context.Background(), nodes, &TaskSpec{Command: "hostname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("run waited for the straggler")
+	}
+	r := results[3]
+	if !r.OK() || !strings.HasPrefix(r.Node.Name, "fast-") || r.Stdout != r.Node.Name+"\n" {
+		t.Errorf("slow chunk result = %+v", r)
+	}
+	if len(stragglers) != 1 || stragglers[0].Node.Name != "slow" || stragglers[0].Straggler.Backup != r.Node.Name {
+		t.Errorf("straggler events = %+v", stragglers)
+	}
+}
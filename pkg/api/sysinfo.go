@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// NodeSysInfo is a node's resource usage as reported by its agent. Err is
+// set when the agent could not be queried.
+type NodeSysInfo struct {
+	Node Node
+	Info SysInfoResponse
+	Err  error
+}
+
+// SysInfo queries every node's agent concurrently and returns their
+// resource usage in node order.
+func (c *Client) SysInfo(ctx context.Context, nodes []Node) []NodeSysInfo {
+	out := make([]NodeSysInfo, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			info, err := c.agent.SysInfo(ctx, n)
+			out[i] = NodeSysInfo{Node: n, Info: info, Err: err}
+		}(i, n)
+	}
+	wg.Wait()
+	return out
+}
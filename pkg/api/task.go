@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// LoadTaskModule reads a task module YAML file. Use FindTaskModule to also
+// accept module names.
+func LoadTaskModule(path string) (TaskSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TaskSpec{}, fmt.Errorf("read module: %w", err)
+	}
+	return parseTaskModule(data, path)
+}
+
+// parseTaskModule parses and validates module YAML read from path.
+func parseTaskModule(data []byte, path string) (TaskSpec, error) {
+	return ValidateModule(data, path)
+}
+
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandVars substitutes ${name} for known vars, leaving unknown references
+// in place so the remote shell can still expand them from the environment.
+func expandVars(s string, vars map[string]string) string {
+	return varPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := vars[m[2:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// Render returns a copy of the task with ${name} references in its command,
+// args, inputs and env values, and those of its steps, replaced from vars. Unknown references are
+// left for the remote shell.
+func (t TaskSpec) Render(vars map[string]string) TaskSpec {
+	out := t
+	out.Command = expandVars(t.Command, vars)
+	out.Args = make([]string, len(t.Args))
+	for i, a := range t.Args {
+		out.Args[i] = expandVars(a, vars)
+	}
+	out.Inputs = make([]string, len(t.Inputs))
+	for i, in := range t.Inputs {
+		out.Inputs[i] = expandVars(in, vars)
+	}
+	out.Env = make(map[string]string, len(t.Env))
+	for k, v := range t.Env {
+		out.Env[k] = expandVars(v, vars)
+	}
+	if t.Steps != nil {
+		out.Steps = make([]TaskSpec, len(t.Steps))
+		for i, s := range t.Steps {
+			out.Steps[i] = s.Render(vars)
+		}
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTaskRender(t *testing.T) {
+	task := TaskSpec{
+		Command: "nuclei",
+		Args:    []string{"-t", "${templates}", "-l", "{{ item }}", "-o", "${HOME}/out"},
+		Inputs:  []string{"${targets}"},
+		Env:     map[string]string{"WORDLIST": "${files}/words.txt"},
+	}
+	got := task.Render(map[string]string{"templates": "cves/", "targets": "hosts.txt", "files": RemoteFilesDir})
+
+	if got.Args[1] != "cves/" || got.Inputs[0] != "hosts.txt" {
+		t.Errorf("vars not substituted: args=%v inputs=%v", got.Args, got.Inputs)
+	}
+	if got.Args[5] != "${HOME}/out" {
+		t.Errorf("unknown var should be left for the shell, got %q", got.Args[5])
+	}
+	if got.Env["WORDLIST"] != RemoteFilesDir+"/words.txt" {
+		t.Errorf("env = %v", got.Env)
+	}
+	if task.Args[1] != "${templates}" {
+		t.Error("Render modified the original task")
+	}
+}
+
+func TestChunkSizeDecode(t *testing.T) {
+	spec, err := ValidateModule([]byte("name: probe\ncommand: httpx\nchunk_size: auto\n"), "probe.yaml")
+	if err != nil || spec.ChunkSize != ChunkAuto {
+		t.Fatalf("chunk_size = %v, %v", spec.ChunkSize, err)
+	}
+	if _, err := ValidateModule([]byte("name: probe\ncommand: httpx\nchunk_size: lots\n"), "probe.yaml"); err == nil {
+		t.Error("chunk_size: lots accepted")
+	}
+	var decoded TaskSpec
+	if err := json.Unmarshal([]byte(`{"chunk_size":"auto"}`), &decoded); err != nil || decoded.ChunkSize != ChunkAuto {
+		t.Errorf("json chunk_size = %v, %v", decoded.ChunkSize, err)
+	}
+	if out, _ := json.Marshal(TaskSpec{ChunkSize: 25}); !strings.Contains(string(out), `"chunk_size":25`) {
+		t.Errorf("json = %s", out)
+	}
+	for in, want := range map[string]ChunkSize{"auto": ChunkAuto, "0": 0, "50": 50} {
+		if got, err := ParseChunkSize(in); err != nil || got != want {
+			t.Errorf("ParseChunkSize(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseChunkSize("-3"); err == nil {
+		t.Error("ParseChunkSize(-3) accepted")
+	}
+}
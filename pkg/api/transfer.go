@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/core"
+	gssh "github.com/3cpo-dev/gaxx/internal/ssh"
+	"github.com/3cpo-dev/gaxx/internal/telemetry"
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// RemoteFilesDir is where Scan uploads support files on each node.
+const RemoteFilesDir = "/tmp/gaxx/files"
+
+// FileTransfer moves files between the controller and fleet nodes over SFTP.
+// Failed transfers are retried per the config's retry default, continuing
+// from what the failed attempt wrote.
+type FileTransfer struct {
+	cfg Config
+	// Resume continues partial files left by an earlier run instead of
+	// starting over.
+	Resume bool
+}
+
+// NewFileTransfer creates a FileTransfer using the controller's SSH settings.
+func NewFileTransfer(cfg Config) *FileTransfer {
+	return &FileTransfer{cfg: cfg}
+}
+
+// withSSH runs fn over an SSH connection to a node, redialing and retrying
+// on failure. resume is set when fn should continue partial files.
+func (ft *FileTransfer) withSSH(ctx context.Context, node Node, fn func(cli *xssh.Client, resume bool) error) error {
+	c, err := core.SSHClientFor(ft.cfg, node)
+	if err != nil {
+		return err
+	}
+	return c.Do(ctx, func(cli *xssh.Client, attempt int) error {
+		return fn(cli, ft.Resume || attempt > 0)
+	})
+}
+
+// Upload copies a local file to remotePath on a node.
+func (ft *FileTransfer) Upload(ctx context.Context, node Node, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", localPath, err)
+	}
+	start := time.Now()
+	err = ft.withSSH(ctx, node, func(cli *xssh.Client, resume bool) error {
+		if resume {
+			return gssh.ResumePushFile(ctx, cli, localPath, remotePath)
+		}
+		return gssh.PushFile(ctx, cli, localPath, remotePath)
+	})
+	telemetry.GetGlobal().Timer("gaxx_file_transfer_duration", time.Since(start), map[string]string{
+		"component": "file_transfer",
+		"direction": "upload",
+	})
+	if err != nil {
+		return fmt.Errorf("upload %s to %s: %w", localPath, node.Name, err)
+	}
+	telemetry.HistogramGlobal("gaxx_file_transfer_size_bytes", float64(info.Size()), map[string]string{
+		"component": "file_transfer",
+		"direction": "upload",
+	})
+	return nil
+}
+
+// Download copies the remote file, directory or glob pattern into localDir
+// on the controller and returns the local paths written.
+func (ft *FileTransfer) Download(ctx context.Context, node Node, remotePattern, localDir string) ([]string, error) {
+	var files []string
+	start := time.Now()
+	err := ft.withSSH(ctx, node, func(cli *xssh.Client, resume bool) error {
+		var err error
+		files, err = gssh.PullDir(ctx, cli, remotePattern, localDir, resume)
+		return err
+	})
+	telemetry.GetGlobal().Timer("gaxx_file_transfer_duration", time.Since(start), map[string]string{
+		"component": "file_transfer",
+		"direction": "download",
+	})
+	if err != nil {
+		return files, fmt.Errorf("download %s from %s: %w", remotePattern, node.Name, err)
+	}
+	var size int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			size += info.Size()
+		}
+	}
+	telemetry.HistogramGlobal("gaxx_file_transfer_size_bytes", float64(size), map[string]string{
+		"component": "file_transfer",
+		"direction": "download",
+	})
+	return files, nil
+}
+
+// CollectResult is the outcome of collecting files from one node.
+type CollectResult struct {
+	Node  Node
+	Files []string
+	Err   error
+}
+
+// transferConcurrency is how many nodes file transfers and upload checks
+// run against at once.
+const transferConcurrency = 10
+
+// collectFromFleet downloads remotePattern from every node into
+// localDir/<node name>, a bounded number of nodes at a time.
+func collectFromFleet(ctx context.Context, ft *FileTransfer, nodes []Node, remotePattern, localDir string) []CollectResult {
+	results := make([]CollectResult, len(nodes))
+	items := make([]*CollectResult, len(nodes))
+	for i, n := range nodes {
+		results[i].Node, items[i] = n, &results[i]
+	}
+	errs := core.FanOut(ctx, items, transferConcurrency, func(ctx context.Context, r *CollectResult) error {
+		var err error
+		r.Files, err = ft.Download(ctx, r.Node, remotePattern, filepath.Join(localDir, r.Node.Name))
+		return err
+	})
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	return results
+}
+
+// FileUpload is one file destined for one node.
+type FileUpload struct {
+	Node   Node
+	Local  string
+	Remote string
+	Size   int64
+	// UpToDate is set when the node already has a file with the same size
+	// and SHA-256 at Remote, so the upload can be skipped.
+	UpToDate bool
+}
+
+// UploadPlan lists the uploads needed before a scan.
+type UploadPlan struct {
+	Nodes   []Node
+	Uploads []FileUpload
+}
+
+// Pending returns the uploads that still need to be transferred.
+func (p *UploadPlan) Pending() []FileUpload {
+	var pending []FileUpload
+	for _, u := range p.Uploads {
+		if !u.UpToDate {
+			pending = append(pending, u)
+		}
+	}
+	return pending
+}
+
+// Bytes returns the total size of the pending uploads.
+func (p *UploadPlan) Bytes() int64 {
+	var n int64
+	for _, u := range p.Pending() {
+		n += u.Size
+	}
+	return n
+}
+
+// remoteMatchScript prints the SHA-256 of $0 if its size is $1, and fails
+// otherwise, so a missing or resized file is never hashed.
+const remoteMatchScript = `[ "$(wc -c < "$0" 2>/dev/null)" = "$1" ] && sha256sum "$0"`
+
+// fileDigest returns the size and hex SHA-256 of a local file.
+func fileDigest(localPath string) (int64, string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("read %s: %w", localPath, err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// planUploads checks every file against every node through exec and marks
+// the copies that are already in place. A node that cannot be checked is
+// treated as needing the upload, which then reports the real error.
+func planUploads(ctx context.Context, exec Executor, nodes []Node, files []string, remoteDir string) (*UploadPlan, error) {
+	type digest struct {
+		size int64
+		sum  string
+	}
+	digests := make([]digest, len(files))
+	for i, f := range files {
+		size, sum, err := fileDigest(f)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = digest{size, sum}
+	}
+
+	plan := &UploadPlan{Nodes: nodes, Uploads: make([]FileUpload, 0, len(nodes)*len(files))}
+	for _, node := range nodes {
+		for i, f := range files {
+			plan.Uploads = append(plan.Uploads, FileUpload{
+				Node:   node,
+				Local:  f,
+				Remote: path.Join(remoteDir, filepath.Base(f)),
+				Size:   digests[i].size,
+			})
+		}
+	}
+
+	items := make([]int, len(plan.Uploads))
+	for i := range items {
+		items[i] = i
+	}
+	core.FanOut(ctx, items, transferConcurrency, func(ctx context.Context, i int) error {
+		u, d := &plan.Uploads[i], digests[i%len(files)]
+		resp, err := exec.Exec(ctx, u.Node, ExecRequest{
+			Command: "sh",
+			Args:    []string{"-c", remoteMatchScript, u.Remote, strconv.FormatInt(d.size, 10)},
+			Timeout: 60,
+		})
+		if err != nil || resp.ExitCode != 0 {
+			return nil
+		}
+		fields := strings.Fields(resp.Stdout)
+		u.UpToDate = len(fields) > 0 && fields[0] == d.sum
+		return nil
+	})
+	return plan, nil
+}
+
+// UploadError is returned by Scan when some nodes did not receive all of
+// their files. Failed lists those nodes in plan order and Errs the upload
+// that stopped each; Total is how many nodes had uploads pending.
+type UploadError struct {
+	Total  int
+	Failed []Node
+	Errs   []error
+}
+
+func (e *UploadError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d nodes failed uploads: %s", len(e.Failed), e.Total, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns every node's error, so errors.Is and errors.As see them
+// all as they would in an errors.Join.
+func (e *UploadError) Unwrap() []error { return e.Errs }
+
+// uploadFilesToFleet performs the pending uploads of a plan with upload, one
+// node at a time per connection slot. A node stops at its first failed
+// upload; every node that failed is reported in an *UploadError, and nil is
+// returned only when all uploads succeeded.
+func uploadFilesToFleet(ctx context.Context, upload func(ctx context.Context, node Node, localPath, remotePath string) error, plan *UploadPlan) error {
+	byNode := make(map[string][]FileUpload)
+	for _, u := range plan.Pending() {
+		byNode[u.Node.Name] = append(byNode[u.Node.Name], u)
+	}
+	var groups [][]FileUpload
+	for _, n := range plan.Nodes {
+		if uploads := byNode[n.Name]; len(uploads) > 0 {
+			groups = append(groups, uploads)
+		}
+	}
+	errs := core.FanOut(ctx, groups, transferConcurrency, func(ctx context.Context, uploads []FileUpload) error {
+		for _, u := range uploads {
+			if err := upload(ctx, u.Node, u.Local, u.Remote); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	uerr := &UploadError{Total: len(groups)}
+	for i, err := range errs {
+		if err != nil {
+			uerr.Failed = append(uerr.Failed, groups[i][0].Node)
+			uerr.Errs = append(uerr.Errs, err)
+		}
+	}
+	if len(uerr.Failed) == 0 {
+		return nil
+	}
+	return uerr
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// localExecutor runs requests on this machine, or fails for node "down".
+type localExecutor struct{}
+
+func (localExecutor) Exec(ctx context.Context, node Node, req ExecRequest) (ExecResponse, error) {
+	if node.Name == "down" {
+		return ExecResponse{}, errors.New("connection refused")
+	}
+	cmd := exec.CommandContext(ctx, req.Command, req.Args...)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ExecResponse{Stdout: string(out), ExitCode: exitErr.ExitCode()}, nil
+	}
+	return ExecResponse{Stdout: string(out)}, err
+}
+
+func TestPlanUploads(t *testing.T) {
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	local, remote := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	same := write(local, "same.txt", "admin\nroot\n")
+	changed := write(local, "changed.txt", "alpha\nbeta\n")
+	missing := write(local, "missing.txt", "x\n")
+	write(remote, "same.txt", "admin\nroot\n")
+	write(remote, "changed.txt", "alpha\nbetb\n")
+
+	plan, err := planUploads(context.Background(), localExecutor{}, []Node{{Name: "w-1"}, {Name: "down"}}, []string{same, changed, missing}, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Uploads) != 6 {
+		t.Fatalf("got %d uploads, want 6", len(plan.Uploads))
+	}
+	for _, u := range plan.Uploads {
+		want := u.Node.Name == "w-1" && u.Local == same
+		if u.UpToDate != want {
+			t.Errorf("%s %s: UpToDate = %v, want %v", u.Node.Name, filepath.Base(u.Local), u.UpToDate, want)
+		}
+	}
+	if len(plan.Pending()) != 5 {
+		t.Errorf("pending = %d, want 5", len(plan.Pending()))
+	}
+	if want := int64(2*11 + 2*2 + 11); plan.Bytes() != want {
+		t.Errorf("bytes = %d, want %d", plan.Bytes(), want)
+	}
+}
+
+func TestUploadFilesToFleet(t *testing.T) {
+	nodes := []Node{{Name: "w-1"}, {Name: "down-1"}, {Name: "w-2"}, {Name: "down-2"}}
+	plan := &UploadPlan{Nodes: nodes}
+	for _, n := range nodes {
+		for _, f := range []string{"a.txt", "b.txt"} {
+			plan.Uploads = append(plan.Uploads, FileUpload{Node: n, Local: f, Remote: "/tmp/" + f})
+		}
+	}
+	errRefused := errors.New("connection refused")
+	var mu sync.Mutex
+	uploaded := map[string]int{}
+	upload := func(_ context.Context, node Node, local, _ string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(node.Name, "down") {
+			return fmt.Errorf("upload %s to %s: %w", local, node.Name, errRefused)
+		}
+		uploaded[node.Name]++
+		return nil
+	}
+
+	err := uploadFilesToFleet(context.Background(), upload, plan)
+	var uerr *UploadError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("err = %v, want *UploadError", err)
+	}
+	if uerr.Total != 4 || len(uerr.Failed) != 2 || uerr.Failed[0].Name != "down-1" || uerr.Failed[1].Name != "down-2" {
+		t.Errorf("failed = %+v of %d", uerr.Failed, uerr.Total)
+	}
+	if !errors.Is(err, errRefused) || !strings.Contains(err.Error(), "down-2") {
+		t.Errorf("err = %v", err)
+	}
+	if uploaded["w-1"] != 2 || uploaded["w-2"] != 2 {
+		t.Errorf("uploaded = %v, want both files on each healthy node", uploaded)
+	}
+
+	plan.Nodes = []Node{nodes[0], nodes[2]}
+	plan.Uploads = plan.Uploads[:2]
+	if err := uploadFilesToFleet(context.Background(), upload, plan); err != nil {
+		t.Errorf("all succeeding: err = %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/3cpo-dev/gaxx/internal/providers"
+)
+
+// ExpiresTag is the tag key marking when a node should be reaped.
+const ExpiresTag = providers.ExpiresTag
+
+// ExpiryTag returns the tag to add to CreateFleetRequest.Tags so nodes
+// expire at t.
+func ExpiryTag(t time.Time) string { return providers.ExpiryTag(t) }
+
+// NodeExpiry returns when n expires, if it was spawned with a TTL.
+func NodeExpiry(n Node) (time.Time, bool) { return providers.NodeExpiry(n) }
+
+// Expired lists every node the provider manages whose expiry is at or
+// before now.
+func (c *Client) Expired(ctx context.Context, now time.Time) ([]Node, error) {
+	nodes, err := c.ListNodes(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return providers.ExpiredNodes(nodes, now), nil
+}
+
+// DeleteNodes deletes individual nodes, retrying failures like Delete.
+// Providers that can only delete whole fleets return an error.
+func (c *Client) DeleteNodes(ctx context.Context, nodes []Node) error {
+	p, err := c.provider()
+	if err != nil {
+		return err
+	}
+	deleter, ok := p.(providers.NodeDeleter)
+	if !ok {
+		return fmt.Errorf("provider %s cannot delete single nodes", p.Name())
+	}
+	// The nodes may come from several fleets.
+	defer c.invalidateNodes(ctx, p, "")
+	if err := providers.DeleteNodes(ctx, nodes, deleter.DeleteNode); err != nil {
+		return fmt.Errorf("delete nodes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// expiringProvider holds nodes in memory and deletes them one by one.
+type expiringProvider struct {
+	mu    sync.Mutex
+	nodes []Node
+}
+
+func (p *expiringProvider) Name() string { return "expiring" }
+func (p *expiringProvider) CreateFleet(ctx context.Context, req CreateFleetRequest) (*Fleet, error) {
+	return nil, ErrSpotNotSupported
+}
+func (p *expiringProvider) ListNodes(ctx context.Context, name string) ([]Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Node(nil), p.nodes...), nil
+}
+func (p *expiringProvider) DeleteFleet(ctx context.Context, name string) error { return nil }
+func (p *expiringProvider) DeleteNode(ctx context.Context, n Node) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, m := range p.nodes {
+		if m.ID == n.ID {
+			p.nodes = append(p.nodes[:i], p.nodes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestReapExpired(t *testing.T) {
+	now := time.Now()
+	prov := &expiringProvider{nodes: []Node{
+		{ID: "1", Name: "old-1", Tags: []string{"gaxx", ExpiryTag(now.Add(-time.Hour))}},
+		{ID: "2", Name: "new-1", Tags: []string{ExpiryTag(now.Add(time.Hour))}},
+		{ID: "3", Name: "pet-1", Tags: []string{"gaxx"}},
+	}}
+	RegisterProvider("expiring", func(Config) Provider { return prov })
+	var cfg Config
+	cfg.Providers.Default = "expiring"
+	client := NewClient(cfg)
+	ctx := context.Background()
+
+	expired, err := client.Expired(ctx, now)
+	if err != nil || len(expired) != 1 || expired[0].Name != "old-1" {
+		t.Fatalf("expired = %+v, %v", expired, err)
+	}
+	if at, ok := NodeExpiry(expired[0]); !ok || at.After(now) {
+		t.Errorf("expiry = %v, %v", at, ok)
+	}
+	if err := client.DeleteNodes(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	left, _ := client.ListNodes(ctx, "")
+	if len(left) != 2 || left[0].Name != "new-1" {
+		t.Errorf("left = %+v", left)
+	}
+
+	client.Provider = "localssh"
+	if err := client.DeleteNodes(ctx, left); err == nil {
+		t.Error("localssh deleted single nodes")
+	}
+}
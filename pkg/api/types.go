@@ -9,8 +9,51 @@ type TaskSpec struct {
 	Args        []string          `json:"args" yaml:"args"`
 	Env         map[string]string `json:"env" yaml:"env"`
 	// Inputs can be file paths or inline lists to be chunked across nodes.
-	Inputs    []string `json:"inputs" yaml:"inputs"`
-	ChunkSize int      `json:"chunk_size" yaml:"chunk_size"`
+	Inputs []string `json:"inputs" yaml:"inputs"`
+	// ChunkSize is the items per chunk, or ChunkAuto (chunk_size: auto) to
+	// size chunks from the number of inputs and nodes.
+	ChunkSize ChunkSize `json:"chunk_size" yaml:"chunk_size"`
+	// ChunksPerNode is how many chunks each node gets with ChunkAuto;
+	// 0 uses DefaultChunksPerNode.
+	ChunksPerNode int `json:"chunks_per_node,omitempty" yaml:"chunks_per_node"`
+	// Limits caps each command's nice level, memory and CPU time on the node.
+	Limits *ResourceLimits `json:"limits,omitempty" yaml:"limits"`
+	// Shell runs the command and args, joined with spaces, as one sh -c
+	// script so pipes, redirects and globs work. Without it they are passed
+	// to the command literally.
+	Shell bool `json:"shell,omitempty" yaml:"shell"`
+	// Requires lists the commands the task needs on each node, as names or
+	// absolute paths, for gaxx module validate and for readers.
+	Requires []string `json:"requires,omitempty" yaml:"requires"`
+	// Steps, given instead of Command, are run in order for each node or
+	// chunk, stopping at the first that fails or exits non-zero. Each has a
+	// name, a command and args, and may set env, limits and shell; the
+	// task's env (overridden by the step's), limits, shell and inputs
+	// apply to every step. ${step_<name>} in a step is replaced with the
+	// stdout, trailing newlines trimmed, of the earlier step called name.
+	Steps []TaskSpec `json:"steps,omitempty" yaml:"steps"`
+}
+
+// SpawnRequest is the body of POST /v0/fleets on gaxx serve.
+type SpawnRequest struct {
+	CreateFleetRequest
+	Provider string `json:"provider,omitempty"`
+	// Policy is refuse (the default), top-up or force.
+	Policy SpawnPolicy `json:"policy,omitempty"`
+	// TTL, a duration such as "6h", tags the nodes to expire so gaxx reap
+	// deletes them.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// RunRequest is the body of POST /v0/runs on gaxx serve. Uploads are paths
+// on the server, copied to every node before the task runs as with scan.
+type RunRequest struct {
+	Fleet    string            `json:"fleet"`
+	Provider string            `json:"provider,omitempty"`
+	Task     TaskSpec          `json:"task"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Select   string            `json:"select,omitempty"`
+	Uploads  []string          `json:"uploads,omitempty"`
 }
 
 type FleetSpec struct {
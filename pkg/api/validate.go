@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ModuleIssue is one problem found in a module. Line is 1-based, or 0 when
+// the problem is a missing field.
+type ModuleIssue struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (i ModuleIssue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.Field, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Field, i.Message)
+}
+
+// ModuleError lists every problem found in a module.
+type ModuleError struct {
+	Path   string
+	Issues []ModuleIssue
+}
+
+func (e *ModuleError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue.String()
+	}
+	return fmt.Sprintf("module %s: %s", e.Path, strings.Join(msgs, "; "))
+}
+
+var (
+	// templatePattern matches {{ ... }} templates; only {{ item }} exists.
+	templatePattern = regexp.MustCompile(`\{\{[^}]*\}\}`)
+	// unclosedVar matches a ${ with no closing brace.
+	unclosedVar = regexp.MustCompile(`\$\{[^}]*$`)
+	// requirePattern is a command name or absolute path.
+	requirePattern = regexp.MustCompile(`^(/[^\s]+|[A-Za-z0-9][A-Za-z0-9._+-]*)$`)
+	// stepNamePattern is a step name, usable in ${step_<name>}.
+	stepNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// stepVarPrefix starts the ${...} references to an earlier step's output.
+const stepVarPrefix = "step_"
+
+// itemTemplate is substituted with the path of each input chunk.
+const itemTemplate = "{{ item }}"
+
+// ValidateModule parses module YAML read from path and checks it: name and
+// command (or steps) are required, chunk_size must be auto or not negative,
+// templates must be {{ item }} in command or args, ${var} references must
+// be closed, requires must name commands, and each step needs a unique
+// name and a command and may only refer to earlier steps. Unknown fields are errors, so typos don't
+// silently fall back to defaults. A *ModuleError lists every problem with
+// its line.
+func ValidateModule(data []byte, path string) (TaskSpec, error) {
+	var spec TaskSpec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return spec, fmt.Errorf("parse module %s: %w", path, err)
+	}
+	var doc yaml.Node
+	_ = yaml.Unmarshal(data, &doc)
+	fields := topLevelFields(&doc)
+	// line returns the line of field, or of its element (a list index or
+	// map key) when elem is given and found.
+	line := func(field string, elem any) int {
+		f, ok := fields[field]
+		if !ok {
+			return 0
+		}
+		switch e := elem.(type) {
+		case int:
+			if f.value.Kind == yaml.SequenceNode && e < len(f.value.Content) {
+				return f.value.Content[e].Line
+			}
+		case string:
+			for i := 0; f.value.Kind == yaml.MappingNode && i+1 < len(f.value.Content); i += 2 {
+				if f.value.Content[i].Value == e {
+					return f.value.Content[i].Line
+				}
+			}
+		}
+		return f.key.Line
+	}
+
+	var issues []ModuleIssue
+	add := func(field string, elem any, format string, args ...any) {
+		issues = append(issues, ModuleIssue{Line: line(field, elem), Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if spec.Name == "" {
+		add("name", nil, "is required")
+	}
+	switch {
+	case spec.Command == "" && len(spec.Steps) == 0:
+		add("command", nil, "is required (or give steps)")
+	case spec.Command != "" && len(spec.Steps) > 0:
+		add("command", nil, "cannot be combined with steps; make it a step")
+	}
+	if spec.ChunkSize < 0 && fields["chunk_size"].value.Value != "auto" {
+		add("chunk_size", nil, "must be auto or not negative (0 uses the default)")
+	}
+	if spec.ChunksPerNode < 0 {
+		add("chunks_per_node", nil, "must not be negative")
+	}
+
+	checkRefs := func(field string, elem any, s string, itemAllowed bool) {
+		for _, t := range templatePattern.FindAllString(s, -1) {
+			switch {
+			case t != itemTemplate:
+				add(field, elem, "unknown template %s (the only template is %s)", t, itemTemplate)
+			case !itemAllowed:
+				add(field, elem, "%s is only substituted in command and args", itemTemplate)
+			}
+		}
+		if ref := unclosedVar.FindString(s); ref != "" {
+			add(field, elem, "unclosed variable reference %q", ref)
+		}
+	}
+	checkRefs("command", nil, spec.Command, true)
+	for i, a := range spec.Args {
+		checkRefs("args", i, a, true)
+	}
+	for i, in := range spec.Inputs {
+		checkRefs("inputs", i, in, false)
+	}
+	for _, k := range sortedKeys(spec.Env) {
+		checkRefs("env", k, spec.Env[k], false)
+	}
+	stepsSeen := map[string]bool{}
+	for i, s := range spec.Steps {
+		switch {
+		case s.Name == "":
+			add("steps", i, "step %d: name is required", i+1)
+		case !stepNamePattern.MatchString(s.Name):
+			add("steps", i, "step %q: name must be letters, digits and underscores", s.Name)
+		case stepsSeen[s.Name]:
+			add("steps", i, "step %q: name is used twice", s.Name)
+		}
+		if s.Command == "" {
+			add("steps", i, "step %d: command is required", i+1)
+		}
+		if len(s.Inputs) > 0 || s.ChunkSize != 0 || s.ChunksPerNode != 0 || len(s.Requires) > 0 || len(s.Steps) > 0 {
+			add("steps", i, "step %d: inputs, chunk_size, chunks_per_node, requires and steps belong to the module, not its steps", i+1)
+		}
+		refs := append([]string{s.Command}, s.Args...)
+		for _, k := range sortedKeys(s.Env) {
+			refs = append(refs, s.Env[k])
+		}
+		for _, r := range refs {
+			checkRefs("steps", i, r, true)
+			for _, m := range varPattern.FindAllStringSubmatch(r, -1) {
+				if name, ok := strings.CutPrefix(m[1], stepVarPrefix); ok && !stepsSeen[name] {
+					add("steps", i, "step %d: ${%s} does not name an earlier step", i+1, m[1])
+				}
+			}
+		}
+		stepsSeen[s.Name] = true
+	}
+	for i, r := range spec.Requires {
+		if !requirePattern.MatchString(r) {
+			add("requires", i, "%q is not a command name or absolute path", r)
+		}
+	}
+
+	if len(issues) > 0 {
+		sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+		return spec, &ModuleError{Path: path, Issues: issues}
+	}
+	return spec, nil
+}
+
+// Variables returns the ${name} references in the task and its steps,
+// sorted, leaving out the ${step_<name>} outputs of steps. Those in Inputs
+// must be set with vars since the inputs are read locally; the rest fall
+// back to the node's environment.
+func (t TaskSpec) Variables() []string {
+	seen := map[string]bool{}
+	var collect func(t TaskSpec)
+	collect = func(t TaskSpec) {
+		for _, s := range append(append([]string{t.Command}, t.Args...), t.Inputs...) {
+			for _, m := range varPattern.FindAllStringSubmatch(s, -1) {
+				seen[m[1]] = true
+			}
+		}
+		for _, v := range t.Env {
+			for _, m := range varPattern.FindAllStringSubmatch(v, -1) {
+				seen[m[1]] = true
+			}
+		}
+		for _, s := range t.Steps {
+			collect(s)
+		}
+	}
+	collect(t)
+	for _, s := range t.Steps {
+		delete(seen, stepVarPrefix+s.Name)
+	}
+	return sortedKeys(seen)
+}
+
+// CheckInputs reports ${name} references left in a rendered task's inputs,
+// which would otherwise be read as literal file names.
+func (t TaskSpec) CheckInputs() error {
+	var missing []string
+	for _, in := range t.Inputs {
+		for _, m := range varPattern.FindAllStringSubmatch(in, -1) {
+			missing = append(missing, m[1])
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("inputs use unset variables %s (set them with --var %s=...)", strings.Join(missing, ", "), missing[0])
+}
+
+// yamlField is a top-level key of a YAML document and its value.
+type yamlField struct{ key, value *yaml.Node }
+
+func topLevelFields(doc *yaml.Node) map[string]yamlField {
+	fields := map[string]yamlField{}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fields
+	}
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		fields[m.Content[i].Value] = yamlField{m.Content[i], m.Content[i+1]}
+	}
+	return fields
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
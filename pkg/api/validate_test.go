@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/3cpo-dev/gaxx/modules"
+)
+
+func TestValidateModule(t *testing.T) {
+	data := `name: probe
+command: httpx
+args:
+  - -l
+  - "{{ items }}"
+inputs:
+  - "{{ item }}"
+chunk_size: -1
+requires:
+  - httpx
+  - "rm -rf"
+env:
+  TOKEN: "${token"
+`
+	_, err := ValidateModule([]byte(data), "probe.yaml")
+	var merr *ModuleError
+	if !errors.As(err, &merr) {
+		t.Fatalf("err = %v, want *ModuleError", err)
+	}
+	var lines []int
+	for _, issue := range merr.Issues {
+		lines = append(lines, issue.Line)
+	}
+	if want := []int{5, 7, 8, 11, 13}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("issue lines = %v, want %v (%v)", lines, want, err)
+	}
+
+	if _, err := ValidateModule([]byte("name: x\ncomand: ls\n"), "typo.yaml"); err == nil {
+		t.Error("unknown field accepted")
+	}
+	_, err = ValidateModule([]byte("description: no name\n"), "empty.yaml")
+	if !errors.As(err, &merr) || len(merr.Issues) != 2 || merr.Issues[0].Line != 0 {
+		t.Errorf("missing fields: %v", err)
+	}
+}
+
+func TestValidateModuleSteps(t *testing.T) {
+	data := `name: chain
+steps:
+  - name: resolve
+    command: dnsx
+  - name: probe
+    command: httpx
+    args: ["${step_resolve}", "${step_later}"]
+  - name: resolve
+    command: echo
+  - name: later
+    command: echo
+    inputs: [hosts.txt]
+`
+	_, err := ValidateModule([]byte(data), "chain.yaml")
+	var merr *ModuleError
+	if !errors.As(err, &merr) {
+		t.Fatalf("err = %v, want *ModuleError", err)
+	}
+	var lines []int
+	for _, issue := range merr.Issues {
+		lines = append(lines, issue.Line)
+	}
+	if want := []int{5, 8, 10}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("issue lines = %v, want %v (%v)", lines, want, err)
+	}
+
+	spec, err := ValidateModule([]byte("name: ok\nsteps:\n  - {name: a, command: id}\n  - {name: b, command: echo, args: [\"${step_a}\", \"${user}\"]}\n"), "ok.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Variables(); !reflect.DeepEqual(got, []string{"user"}) {
+		t.Errorf("variables = %v, want [user]", got)
+	}
+	if _, err := ValidateModule([]byte("name: both\ncommand: id\nsteps:\n  - {name: a, command: id}\n"), "both.yaml"); err == nil {
+		t.Error("command and steps accepted together")
+	}
+}
+
+func TestBundledModulesValidate(t *testing.T) {
+	entries, err := modules.Bundled.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := modules.Bundled.ReadFile(e.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateModule(data, e.Name()); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTaskVariables(t *testing.T) {
+	spec := TaskSpec{Command: "nmap", Args: []string{"-p", "${ports}"}, Inputs: []string{"${targets}"}, Env: map[string]string{"K": "${key}"}}
+	if got := spec.Variables(); !reflect.DeepEqual(got, []string{"key", "ports", "targets"}) {
+		t.Errorf("Variables = %v", got)
+	}
+	if err := spec.Render(map[string]string{"targets": "hosts.txt"}).CheckInputs(); err != nil {
+		t.Error(err)
+	}
+	if err := spec.Render(nil).CheckInputs(); err == nil {
+		t.Error("unset input variable not reported")
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// AgentVersion is the gaxx-agent version a node reported in its heartbeat,
+// with the rest of the heartbeat (uptime, Go version, goroutines and
+// active jobs). Err is set when the agent could not be reached.
+type AgentVersion struct {
+	Node      Node
+	Version   string
+	Heartbeat HeartbeatResponse
+	Err       error
+}
+
+// AgentVersions heartbeats the agent on every node concurrently and returns
+// their versions in node order.
+func (c *Client) AgentVersions(ctx context.Context, nodes []Node) []AgentVersion {
+	out := make([]AgentVersion, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			hb, err := c.agent.Heartbeat(ctx, n)
+			out[i] = AgentVersion{Node: n, Version: hb.Version, Heartbeat: hb, Err: err}
+		}(i, n)
+	}
+	wg.Wait()
+	return out
+}
+
+// VersionSkew compares reachable agents against expected and returns the
+// version compared against and the agents that differ from it. An empty
+// expected means the version most agents run, ties going to the greater
+// string. Unreachable agents are neither counted nor reported.
+func VersionSkew(versions []AgentVersion, expected string) (string, []AgentVersion) {
+	if expected == "" {
+		counts := make(map[string]int)
+		for _, v := range versions {
+			if v.Err != nil {
+				continue
+			}
+			counts[v.Version]++
+			if n := counts[v.Version]; n > counts[expected] || (n == counts[expected] && v.Version > expected) {
+				expected = v.Version
+			}
+		}
+	}
+	var skewed []AgentVersion
+	for _, v := range versions {
+		if v.Err == nil && v.Version != expected {
+			skewed = append(skewed, v)
+		}
+	}
+	return expected, skewed
+}
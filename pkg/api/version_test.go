@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVersionSkew(t *testing.T) {
+	down := errors.New("connection refused")
+	versions := []AgentVersion{
+		{Node: Node{Name: "a"}, Version: "2.0.0"},
+		{Node: Node{Name: "b"}, Version: "1.9.0"},
+		{Node: Node{Name: "c"}, Version: "2.0.0"},
+		{Node: Node{Name: "d"}, Err: down},
+	}
+
+	tests := []struct {
+		name     string
+		versions []AgentVersion
+		expected string
+		want     string
+		skewed   []string
+	}{
+		{"majority", versions, "", "2.0.0", []string{"b"}},
+		{"expected", versions, "1.9.0", "1.9.0", []string{"a", "c"}},
+		{"tie goes to greater", versions[:2], "", "2.0.0", []string{"b"}},
+		{"all down", versions[3:], "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, skewed := VersionSkew(tt.versions, tt.expected)
+			if want != tt.want {
+				t.Errorf("expected version = %q, want %q", want, tt.want)
+			}
+			var names []string
+			for _, v := range skewed {
+				names = append(names, v.Node.Name)
+			}
+			if len(names) != len(tt.skewed) {
+				t.Fatalf("skewed = %v, want %v", names, tt.skewed)
+			}
+			for i := range names {
+				if names[i] != tt.skewed[i] {
+					t.Errorf("skewed = %v, want %v", names, tt.skewed)
+				}
+			}
+		})
+	}
+}